@@ -59,7 +59,7 @@ func main() {
 
 	log.Printf("Connected to %v\n", pk)
 
-	client, err := therealproxy.NewClient(conn)
+	client, err := therealproxy.NewClient(conn, nil)
 	if err != nil {
 		log.Fatal("Failed to create a new client: ", err)
 	}
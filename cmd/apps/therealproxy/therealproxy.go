@@ -30,7 +30,7 @@ func main() {
 		}
 	}()
 
-	srv, err := therealproxy.NewServer(*passcode, log)
+	srv, err := therealproxy.NewServer(*passcode, log, nil)
 	if err != nil {
 		log.Fatal("Failed to create a new server: ", err)
 	}
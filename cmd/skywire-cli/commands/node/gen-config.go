@@ -87,9 +87,9 @@ func defaultConfig() *visor.Config {
 	conf.Node.StaticSecKey = sk
 
 	if testenv {
-		conf.Messaging.Discovery = skyenv.TestDmsgDiscAddr
+		conf.Messaging.Discovery = visor.Addrs{skyenv.TestDmsgDiscAddr}
 	} else {
-		conf.Messaging.Discovery = skyenv.DefaultDmsgDiscAddr
+		conf.Messaging.Discovery = visor.Addrs{skyenv.DefaultDmsgDiscAddr}
 	}
 
 	conf.Messaging.ServerCount = 1
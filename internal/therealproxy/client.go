@@ -12,20 +12,37 @@ import (
 // Log is therealproxy package level logger, it can be replaced with a different one from outside the package
 var Log = logging.MustGetLogger("therealproxy")
 
+// DefaultMaxStreamWindowSize is the maximum yamux stream window of DefaultYamuxConfig.
+// Loops often span several hops, so it is larger than the yamux default to keep throughput up on long round trips.
+const DefaultMaxStreamWindowSize = 1024 * 1024
+
+// DefaultYamuxConfig returns the yamux session config used by Client and Server if none is given.
+func DefaultYamuxConfig() *yamux.Config {
+	conf := yamux.DefaultConfig()
+	conf.MaxStreamWindowSize = DefaultMaxStreamWindowSize
+	return conf
+}
+
 // Client implement multiplexing proxy client using yamux.
 type Client struct {
 	session  *yamux.Session
+	conf     *yamux.Config
 	listener net.Listener
 }
 
 // NewClient constructs a new Client.
-func NewClient(conn net.Conn) (*Client, error) {
-	session, err := yamux.Client(conn, nil)
+// If 'conf' is nil, DefaultYamuxConfig is used.
+func NewClient(conn net.Conn, conf *yamux.Config) (*Client, error) {
+	if conf == nil {
+		conf = DefaultYamuxConfig()
+	}
+
+	session, err := yamux.Client(conn, conf)
 	if err != nil {
 		return nil, fmt.Errorf("yamux: %s", err)
 	}
 
-	return &Client{session: session}, nil
+	return &Client{session: session, conf: conf}, nil
 }
 
 // ListenAndServe start tcp listener on addr and proxies incoming
@@ -12,12 +12,21 @@ import (
 // Server implements multiplexing proxy server using yamux.
 type Server struct {
 	socks    *socks5.Server
+	conf     *yamux.Config
 	listener net.Listener
 	log      *logging.MasterLogger
 }
 
 // NewServer constructs a new Server.
-func NewServer(passcode string, l *logging.MasterLogger) (*Server, error) {
+// If 'conf' is nil, DefaultYamuxConfig is used.
+func NewServer(passcode string, l *logging.MasterLogger, conf *yamux.Config) (*Server, error) {
+	if conf == nil {
+		conf = DefaultYamuxConfig()
+	}
+	if err := yamux.VerifyConfig(conf); err != nil {
+		return nil, fmt.Errorf("yamux: %s", err)
+	}
+
 	var credentials socks5.CredentialStore
 	if passcode != "" {
 		credentials = passcodeCredentials(passcode)
@@ -28,7 +37,7 @@ func NewServer(passcode string, l *logging.MasterLogger) (*Server, error) {
 		return nil, fmt.Errorf("socks5: %s", err)
 	}
 
-	return &Server{socks: s, log: l}, nil
+	return &Server{socks: s, conf: conf, log: l}, nil
 }
 
 // Serve accept connections from listener and serves socks5 proxy for
@@ -41,7 +50,7 @@ func (s *Server) Serve(l net.Listener) error {
 			return fmt.Errorf("accept: %s", err)
 		}
 
-		session, err := yamux.Server(conn, nil)
+		session, err := yamux.Server(conn, s.conf)
 		if err != nil {
 			return fmt.Errorf("yamux: %s", err)
 		}
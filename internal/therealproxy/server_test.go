@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"github.com/SkycoinProject/skycoin/src/util/logging"
+	"github.com/hashicorp/yamux"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/net/nettest"
@@ -33,7 +34,7 @@ func TestMain(m *testing.M) {
 }
 
 func TestProxy(t *testing.T) {
-	srv, err := NewServer("", logging.NewMasterLogger())
+	srv, err := NewServer("", logging.NewMasterLogger(), nil)
 	require.NoError(t, err)
 
 	l, err := nettest.NewLocalListener("tcp")
@@ -49,7 +50,7 @@ func TestProxy(t *testing.T) {
 	conn, err := net.Dial("tcp", l.Addr().String())
 	require.NoError(t, err)
 
-	client, err := NewClient(conn)
+	client, err := NewClient(conn, nil)
 	require.NoError(t, err)
 
 	errChan2 := make(chan error)
@@ -83,3 +84,45 @@ func TestProxy(t *testing.T) {
 	<-errChan2
 	<-errChan
 }
+
+func TestYamuxConfig(t *testing.T) {
+	conn1, conn2 := net.Pipe()
+	defer func() {
+		require.NoError(t, conn1.Close())
+		require.NoError(t, conn2.Close())
+	}()
+
+	t.Run("default", func(t *testing.T) {
+		client, err := NewClient(conn1, nil)
+		require.NoError(t, err)
+		assert.Equal(t, uint32(DefaultMaxStreamWindowSize), client.conf.MaxStreamWindowSize)
+
+		srv, err := NewServer("", logging.NewMasterLogger(), nil)
+		require.NoError(t, err)
+		assert.Equal(t, uint32(DefaultMaxStreamWindowSize), srv.conf.MaxStreamWindowSize)
+	})
+
+	t.Run("custom", func(t *testing.T) {
+		conf := yamux.DefaultConfig()
+		conf.MaxStreamWindowSize = 4 * 1024 * 1024
+
+		client, err := NewClient(conn1, conf)
+		require.NoError(t, err)
+		assert.Equal(t, conf.MaxStreamWindowSize, client.conf.MaxStreamWindowSize)
+
+		srv, err := NewServer("", logging.NewMasterLogger(), conf)
+		require.NoError(t, err)
+		assert.Equal(t, conf.MaxStreamWindowSize, srv.conf.MaxStreamWindowSize)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		conf := yamux.DefaultConfig()
+		conf.MaxStreamWindowSize = 1024 // below the yamux minimum
+
+		_, err := NewClient(conn1, conf)
+		assert.Error(t, err)
+
+		_, err = NewServer("", logging.NewMasterLogger(), conf)
+		assert.Error(t, err)
+	})
+}
@@ -4,6 +4,7 @@ Package app implements app to node communication interface.
 package app
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -12,7 +13,9 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/SkycoinProject/skycoin/src/util/logging"
 
@@ -25,10 +28,34 @@ const (
 
 	// DefaultOut holds value of outFd for Apps setup via Node
 	DefaultOut = uintptr(4)
+
+	// MaxPayloadSize is the maximum number of bytes of a connection that is sent to the Node in a single packet.
+	MaxPayloadSize = 32 * 1024
+
+	// DialMaxRetry is the maximum delay between attempts of DialContext to create a loop.
+	DialMaxRetry = 10 * time.Second
+
+	// DefaultCloseTimeout is the default time Close of a loop conn waits for written data to be sent.
+	DefaultCloseTimeout = 5 * time.Second
 )
 
 var (
 	log = logging.MustGetLogger("app")
+
+	// ErrTooManyConns occurs when opening a loop would exceed Config.MaxConns.
+	ErrTooManyConns = errors.New("too many open loops")
+
+	// ErrLoopClosed is returned by operations on a loop conn that was closed by the app.
+	ErrLoopClosed = errors.New("loop is closed")
+
+	// ErrAppClosed is returned by App.Rebind once the App is closed.
+	ErrAppClosed = errors.New("app is closed")
+
+	// ErrNotAccepting is returned by App.Accept once App.StopAccepting is called.
+	ErrNotAccepting = errors.New("app stopped accepting loops")
+
+	// ErrCloseTimeout is returned by Close of a loop conn if written data was not sent to the Node in time.
+	ErrCloseTimeout = errors.New("timed out sending written data of closed loop")
 )
 
 // Config defines configuration parameters for App
@@ -36,19 +63,80 @@ type Config struct {
 	AppName         string `json:"app-name"`
 	AppVersion      string `json:"app-version"`
 	ProtocolVersion string `json:"protocol-version"`
+	MaxConns        int    `json:"-"` // maximum number of concurrently open loops, 0 means no limit
+
+	// DialRetry is the delay before DialContext retries a failed attempt to create a loop, e.g. while the route
+	// to the remote is not set up yet. It is doubled on every retry up to DialMaxRetry. 0 disables retries.
+	DialRetry time.Duration `json:"-"`
+
+	// CloseTimeout is how long Close of a loop conn waits for the data written before to be sent to the Node.
+	// 0 means DefaultCloseTimeout.
+	CloseTimeout time.Duration `json:"-"`
 }
 
 // App represents client side in app's client-server communication
 // interface.
 type App struct {
-	config Config
-	proto  *Protocol
+	config  Config
+	proto   *Protocol
+	protoMx sync.RWMutex // guards proto, which is replaced by Rebind
 
 	acceptChan chan [2]routing.Addr
+	acceptStop chan struct{} // closed by StopAccepting, created on first use
 	doneChan   chan struct{}
+	closeOnce  sync.Once
+
+	conns   map[routing.Loop]io.ReadWriteCloser
+	closes  map[routing.Loop]*loopClose // close codes of open loops, created on first use
+	flows   map[routing.Loop]*loopFlow  // pause states of open loops, created on first use
+	dialing int                         // loops being created by Dial, counted against config.MaxConns
+	mu      sync.Mutex
+}
+
+// LoopConn is implemented by the net.Conn of loops returned by App.Dial and App.Accept.
+//
+// A loop carries a stream of bytes in each direction: the bytes written to one end are read from the other
+// in the order they are written. The data of each Write is contiguous in the stream, even if Writes are called
+// concurrently, which are performed one at a time in the order they are called. Reads may be called concurrently
+// with Writes, concurrent Reads are performed one at a time.
+type LoopConn interface {
+	net.Conn
+
+	// CloseWithCode is like Close, but tells the remote app why the loop is closed.
+	CloseWithCode(code routing.CloseCode) error
+
+	// PeerCloseCode returns the code the remote app closed the loop with.
+	// It returns false unless the loop was closed by the remote app.
+	PeerCloseCode() (routing.CloseCode, bool)
+
+	// Pause stops delivering data of the loop to Read and asks the remote app to stop sending.
+	// Data received while paused is buffered up to a bound and delivered in order once the loop is resumed.
+	// Unlike Close, the loop stays open. The loop is paused even if the remote app cannot be told.
+	Pause() error
 
-	conns map[routing.Loop]io.ReadWriteCloser
-	mu    sync.Mutex
+	// Resume reverses Pause.
+	Resume() error
+
+	// State returns a snapshot of the state of the loop, for example to diagnose a stuck loop.
+	State() LoopState
+}
+
+// LoopState is a snapshot of the state of a loop, see LoopConn.State.
+// The transports and rules the loop is routed over are reported by the Node, see router.LoopInfo.
+type LoopState struct {
+	Local  routing.Addr `json:"local"`
+	Remote routing.Addr `json:"remote"`
+	Closed bool         `json:"closed"` // closed locally or by the remote app
+
+	PeerCloseCode *routing.CloseCode `json:"peer_close_code,omitempty"` // set once closed by the remote app
+	Paused        bool               `json:"paused"`                    // by Pause
+	PeerPaused    bool               `json:"peer_paused"`               // by the remote app, Write waits
+
+	Unread int `json:"unread"` // bytes received from the remote app which were not read yet
+	Unsent int `json:"unsent"` // bytes written which were not sent to the Node yet
+
+	ReadDeadline  time.Time `json:"read_deadline,omitempty"` // zero if not set
+	WriteDeadline time.Time `json:"write_deadline,omitempty"`
 }
 
 // Command setups pipe connection and returns *exec.Cmd for an App
@@ -84,7 +172,7 @@ func SetupFromPipe(config *Config, inFD, outFD uintptr) (*App, error) {
 
 	go app.handleProto()
 
-	if err := app.proto.Send(FrameInit, config, nil); err != nil {
+	if err := app.protocol().Send(FrameInit, config, nil); err != nil {
 		if err := app.Close(); err != nil {
 			log.WithError(err).Warn("Failed to close app")
 		}
@@ -106,7 +194,7 @@ func New(conn net.Conn, conf *Config) (*App, error) {
 
 	go app.handleProto()
 
-	if err := app.proto.Send(FrameInit, conf, nil); err != nil {
+	if err := app.protocol().Send(FrameInit, conf, nil); err != nil {
 		if err := app.Close(); err != nil {
 			log.WithError(err).Warn("Failed to close app")
 		}
@@ -121,65 +209,243 @@ func Setup(config *Config) (*App, error) {
 	return SetupFromPipe(config, DefaultIn, DefaultOut)
 }
 
-// Close implements io.Closer for an App.
+// Close implements io.Closer for an App. It closes all loops of the App and its connection to the Node.
+// It is safe to call concurrently, such as by SetContext and by the app itself, calls after the first one return nil.
 func (app *App) Close() error {
 	if app == nil {
 		return nil
 	}
 
-	select {
-	case <-app.doneChan: // already closed
-	default:
+	var err error
+	app.closeOnce.Do(func() {
+		app.mu.Lock()
 		close(app.doneChan)
-	}
-
-	app.mu.Lock()
-	for addr, conn := range app.conns {
-		connAddr := addr
-		if err := app.proto.Send(FrameClose, &connAddr, nil); err != nil {
-			log.WithError(err).Warn("Failed to send command frame")
-		}
-		if err := conn.Close(); err != nil {
-			log.WithError(err).Warn("Failed to close connection")
+		conns := app.conns
+		app.conns = make(map[routing.Loop]io.ReadWriteCloser)
+		app.closes = nil
+		app.endFlows()
+		app.mu.Unlock()
+
+		proto := app.protocol()
+		for loop, conn := range conns {
+			if err := proto.Send(FrameClose, &LoopClose{Loop: loop}, nil); err != nil {
+				log.WithError(err).Warn("Failed to send command frame")
+			}
+			if err := conn.Close(); err != nil {
+				log.WithError(err).Warn("Failed to close connection")
+			}
 		}
+
+		err = proto.Close()
+	})
+	return err
+}
+
+// SetContext ties the lifetime of the App to ctx. Once ctx is done the App is closed,
+// which closes all of its loops and unblocks their pending Reads and Writes.
+func (app *App) SetContext(ctx context.Context) {
+	if ctx.Done() == nil {
+		return
 	}
-	app.mu.Unlock()
 
-	return app.proto.Close()
+	go func() {
+		select {
+		case <-ctx.Done():
+			if err := app.Close(); err != nil {
+				log.WithError(err).Warn("Failed to close app")
+			}
+		case <-app.doneChan:
+		}
+	}()
 }
 
 // Accept awaits for incoming loop confirmation request from a Node and
-// returns net.Conn for received loop.
+// returns net.Conn for received loop. Loops that would exceed Config.MaxConns are rejected.
 func (app *App) Accept() (net.Conn, error) {
 	fmt.Println("!!! [ACCEPT] start !!!")
-	addrs := <-app.acceptChan
+	app.mu.Lock()
+	stop := app.acceptStopChan()
+	app.mu.Unlock()
+
+	var addrs [2]routing.Addr
+	select {
+	case addrs = <-app.acceptChan:
+	case <-stop:
+		return nil, ErrNotAccepting
+	}
 	fmt.Println("!!! [ACCEPT] read from ch !!!")
 	laddr := addrs[0]
 	raddr := addrs[1]
 
 	loop := routing.Loop{Local: routing.Addr{Port: laddr.Port}, Remote: raddr}
-	conn, out := net.Pipe()
+	conn, out := loopPipe()
 	app.mu.Lock()
 	app.conns[loop] = conn
+	lc := app.trackClose(loop)
+	fl := app.trackFlow(loop)
 	app.mu.Unlock()
-	go app.serveConn(loop, conn)
-	return newAppConn(out, laddr, raddr), nil
+	drained := app.serve(loop, conn)
+	return newAppConn(out, laddr, raddr).withClose(lc).withFlow(fl).withDrain(drained, app.config.CloseTimeout), nil
+}
+
+// StopAccepting stops the App from accepting loops, while Dial and open loops keep working.
+// Pending and later calls to Accept return ErrNotAccepting, and the App refuses loops which the Node confirms afterwards
+// with ErrNotAccepting instead of dropping them. Calls after the first one do nothing.
+func (app *App) StopAccepting() {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+
+	stop := app.acceptStopChan()
+	select {
+	case <-stop:
+	default:
+		close(stop)
+	}
+}
+
+// acceptStopChan returns the channel closed by StopAccepting. app.mu must be held.
+func (app *App) acceptStopChan() chan struct{} {
+	if app.acceptStop == nil {
+		app.acceptStop = make(chan struct{})
+	}
+	return app.acceptStop
 }
 
 // Dial sends create loop request to a Node and returns net.Conn for created loop.
+// It returns ErrTooManyConns if Config.MaxConns loops are already open.
 func (app *App) Dial(raddr routing.Addr) (net.Conn, error) {
+	app.mu.Lock()
+	if app.atConnLimit() {
+		app.mu.Unlock()
+		return nil, ErrTooManyConns
+	}
+	app.dialing++
+	app.mu.Unlock()
+
 	var laddr routing.Addr
-	err := app.proto.Send(FrameCreateLoop, raddr, &laddr)
+	err := app.protocol().Send(FrameCreateLoop, raddr, &laddr)
+
+	app.mu.Lock()
+	app.dialing--
 	if err != nil {
+		app.mu.Unlock()
 		return nil, err
 	}
 	loop := routing.Loop{Local: routing.Addr{Port: laddr.Port}, Remote: raddr}
-	conn, out := net.Pipe()
-	app.mu.Lock()
+	conn, out := loopPipe()
 	app.conns[loop] = conn
+	lc := app.trackClose(loop)
+	fl := app.trackFlow(loop)
 	app.mu.Unlock()
-	go app.serveConn(loop, conn)
-	return newAppConn(out, laddr, raddr), nil
+	drained := app.serve(loop, conn)
+	return newAppConn(out, laddr, raddr).withClose(lc).withFlow(fl).withDrain(drained, app.config.CloseTimeout), nil
+}
+
+// trackClose records the close codes of loop. app.mu must be held.
+func (app *App) trackClose(loop routing.Loop) *loopClose {
+	if app.closes == nil {
+		app.closes = make(map[routing.Loop]*loopClose)
+	}
+	lc := new(loopClose)
+	app.closes[loop] = lc
+	return lc
+}
+
+// trackFlow records the pause state of loop. app.mu must be held.
+func (app *App) trackFlow(loop routing.Loop) *loopFlow {
+	if app.flows == nil {
+		app.flows = make(map[routing.Loop]*loopFlow)
+	}
+	fl := newLoopFlow(func(paused bool) error {
+		return app.protocol().Send(FramePause, &LoopPause{Loop: loop, Paused: paused}, nil)
+	})
+	app.flows[loop] = fl
+	return fl
+}
+
+// endFlows releases the pause states of all loops. app.mu must be held.
+func (app *App) endFlows() {
+	for _, fl := range app.flows {
+		fl.end()
+	}
+	app.flows = nil
+}
+
+// DialContext is like Dial, but the returned net.Conn is closed once ctx is done.
+// If Config.DialRetry is set, failed attempts are retried with exponential backoff
+// until one succeeds, ctx is done or the App is closed. ErrTooManyConns is not retried.
+func (app *App) DialContext(ctx context.Context, raddr routing.Addr) (net.Conn, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	conn, err := app.dialWithRetry(ctx, raddr)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		if err := conn.Close(); err != nil {
+			log.WithError(err).Warn("Failed to close connection")
+		}
+		return nil, err
+	}
+
+	if ctx.Done() != nil {
+		c := conn.(*appConn)
+		go func() {
+			select {
+			case <-ctx.Done():
+				if err := c.Close(); err != nil {
+					log.WithError(err).Warn("Failed to close connection")
+				}
+			case <-c.done:
+			}
+		}()
+	}
+
+	return conn, nil
+}
+
+func (app *App) dialWithRetry(ctx context.Context, raddr routing.Addr) (net.Conn, error) {
+	retry := app.config.DialRetry
+	for attempt := 1; ; attempt++ {
+		conn, err := app.Dial(raddr)
+		if err == nil || err == ErrTooManyConns || retry <= 0 {
+			return conn, err
+		}
+		log.WithError(err).Warnf("failed to dial %s (attempt %d): trying again in %v...", raddr, attempt, retry)
+
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-app.doneChan:
+			return nil, ErrAppClosed
+		case <-time.After(retry):
+		}
+
+		if retry *= 2; retry > DialMaxRetry {
+			retry = DialMaxRetry
+		}
+	}
+}
+
+// Loops returns the loops the app currently has open, ordered by local port and then by remote address.
+// Local addresses of the loops only hold the port.
+func (app *App) Loops() []routing.Loop {
+	app.mu.Lock()
+	loops := make([]routing.Loop, 0, len(app.conns))
+	for loop := range app.conns {
+		loops = append(loops, loop)
+	}
+	app.mu.Unlock()
+
+	sort.Slice(loops, func(i, j int) bool {
+		if loops[i].Local.Port != loops[j].Local.Port {
+			return loops[i].Local.Port < loops[j].Local.Port
+		}
+		return loops[i].Remote.String() < loops[j].Remote.String()
+	})
+	return loops
 }
 
 // Addr returns empty Addr, implements net.Listener.
@@ -187,8 +453,79 @@ func (app *App) Addr() net.Addr {
 	return routing.Addr{}
 }
 
+// Rebind moves the App to a new connection to the Node, such as after the Node moved its socket.
+// The App performs the initialization request over conn and then closes its previous connection.
+// Loops of the previous connection are closed as if closed by the remote with routing.CloseError,
+// pending and future Accepts receive loops confirmed over conn.
+// On failure conn is closed and the App keeps its previous connection.
+func (app *App) Rebind(conn net.Conn) error {
+	select {
+	case <-app.doneChan:
+		if err := conn.Close(); err != nil {
+			log.WithError(err).Warn("Failed to close connection")
+		}
+		return ErrAppClosed
+	default:
+	}
+
+	proto := NewProtocol(conn)
+	go app.serveProto(proto)
+
+	if err := proto.Send(FrameInit, &app.config, nil); err != nil {
+		if err := proto.Close(); err != nil {
+			log.WithError(err).Warn("Failed to close protocol")
+		}
+		return fmt.Errorf("INIT handshake failed: %s", err)
+	}
+
+	// Close reads the connection after closing doneChan, so either it closes conn or Rebind sees the App closed.
+	app.protoMx.Lock()
+	select {
+	case <-app.doneChan:
+		app.protoMx.Unlock()
+		if err := proto.Close(); err != nil {
+			log.WithError(err).Warn("Failed to close protocol")
+		}
+		return ErrAppClosed
+	default:
+	}
+	old := app.proto
+	app.proto = proto
+	app.protoMx.Unlock()
+
+	app.mu.Lock()
+	conns := app.conns
+	for loop := range conns {
+		app.closes[loop].setPeer(routing.CloseError)
+	}
+	app.conns = make(map[routing.Loop]io.ReadWriteCloser)
+	app.closes = nil
+	app.endFlows()
+	app.mu.Unlock()
+
+	for _, conn := range conns {
+		if err := conn.Close(); err != nil {
+			log.WithError(err).Warn("Failed to close connection")
+		}
+	}
+	if err := old.Close(); err != nil {
+		log.WithError(err).Warn("Failed to close previous connection")
+	}
+	return nil
+}
+
+func (app *App) protocol() *Protocol {
+	app.protoMx.RLock()
+	defer app.protoMx.RUnlock()
+	return app.proto
+}
+
 func (app *App) handleProto() {
-	err := app.proto.Serve(func(frame Frame, payload []byte) (res interface{}, err error) {
+	app.serveProto(app.protocol())
+}
+
+func (app *App) serveProto(proto *Protocol) {
+	err := proto.Serve(func(frame Frame, payload []byte) (res interface{}, err error) {
 		fmt.Printf("!!! app received frame: %s\n", frame)
 		switch frame {
 		case FrameConfirmLoop:
@@ -197,6 +534,8 @@ func (app *App) handleProto() {
 			err = app.forwardPacket(payload)
 		case FrameClose:
 			err = app.closeConn(payload)
+		case FramePause:
+			err = app.pauseConn(payload)
 		default:
 			err = errors.New("unexpected frame")
 		}
@@ -209,6 +548,17 @@ func (app *App) handleProto() {
 	}
 }
 
+// serve runs serveConn for loop. The returned channel is closed once it returns.
+func (app *App) serve(loop routing.Loop, conn io.ReadWriteCloser) <-chan struct{} {
+	drained := make(chan struct{})
+	go func() {
+		app.serveConn(loop, conn)
+		close(drained)
+	}()
+	return drained
+}
+
+// serveConn sends the data written to conn to the Node until conn is closed and its data is read.
 func (app *App) serveConn(loop routing.Loop, conn io.ReadWriteCloser) {
 	defer func() {
 		if err := conn.Close(); err != nil {
@@ -217,25 +567,33 @@ func (app *App) serveConn(loop routing.Loop, conn io.ReadWriteCloser) {
 	}()
 
 	for {
-		buf := make([]byte, 32*1024)
+		buf := make([]byte, MaxPayloadSize)
 		n, err := conn.Read(buf)
 		if err != nil {
 			break
 		}
+		if n == 0 {
+			// Empty writes to the loop conn are not sent.
+			continue
+		}
 
 		packet := &Packet{Loop: loop, Payload: buf[:n]}
-		if err := app.proto.Send(FrameSend, packet, nil); err != nil {
+		if err := app.protocol().Send(FrameSend, packet, nil); err != nil {
 			break
 		}
 	}
 
 	app.mu.Lock()
 	if _, ok := app.conns[loop]; ok {
-		if err := app.proto.Send(FrameClose, &loop, nil); err != nil {
+		closing := &LoopClose{Loop: loop, Code: app.closes[loop].local()}
+		if err := app.protocol().Send(FrameClose, closing, nil); err != nil {
 			log.WithError(err).Warn("Failed to send command frame")
 		}
 	}
 	delete(app.conns, loop)
+	delete(app.closes, loop)
+	app.flows[loop].end()
+	delete(app.flows, loop)
 	app.mu.Unlock()
 }
 
@@ -249,25 +607,46 @@ func (app *App) forwardPacket(data []byte) error {
 
 	app.mu.Lock()
 	conn := app.conns[packet.Loop]
+	fl := app.flows[packet.Loop]
 	app.mu.Unlock()
 
 	if conn == nil {
 		return errors.New("no listeners")
 	}
 
-	_, err := conn.Write(packet.Payload)
-	return err
+	// An empty write to a pipe blocks until it is read, so empty payloads are dropped.
+	if len(packet.Payload) == 0 {
+		return nil
+	}
+
+	if _, err := conn.Write(packet.Payload); err != nil {
+		return err
+	}
+	if pc, ok := conn.(*loopPipeConn); ok {
+		if err := fl.updateUnread(pc.unreadByPeer); err != nil {
+			log.WithError(err).Warn("Failed to pause loop")
+		}
+	}
+	return nil
 }
 
 func (app *App) closeConn(data []byte) error {
-	var loop routing.Loop
-	if err := json.Unmarshal(data, &loop); err != nil {
+	var closing LoopClose
+	if err := json.Unmarshal(data, &closing); err != nil {
 		return err
 	}
+	loop := closing.Loop
 
 	app.mu.Lock()
 	conn := app.conns[loop]
+	if conn != nil {
+		// The code is recorded before the conn is closed, so that it is known once reads of the loop return io.EOF.
+		app.closes[loop].setPeer(closing.Code)
+	}
 	delete(app.conns, loop)
+	delete(app.closes, loop)
+	app.flows[loop].end()
+	delete(app.flows, loop)
 	app.mu.Unlock()
 
 	if conn != nil {
@@ -276,6 +655,26 @@ func (app *App) closeConn(data []byte) error {
 	return nil
 }
 
+// pauseConn records that the remote app paused or resumed a loop, writes to a paused loop wait.
+func (app *App) pauseConn(data []byte) error {
+	var pausing LoopPause
+	if err := json.Unmarshal(data, &pausing); err != nil {
+		return err
+	}
+	// Loops are tracked by local port, the Node may send the local address in full.
+	loop := routing.Loop{Local: routing.Addr{Port: pausing.Local.Port}, Remote: pausing.Remote}
+
+	app.mu.Lock()
+	fl := app.flows[loop]
+	app.mu.Unlock()
+
+	if fl == nil {
+		return errors.New("no listeners")
+	}
+	fl.setPeerPaused(pausing.Paused)
+	return nil
+}
+
 func (app *App) confirmLoop(data []byte) error {
 	fmt.Println("!!! [confirmLoop] !!!")
 	var addrs [2]routing.Addr
@@ -288,11 +687,21 @@ func (app *App) confirmLoop(data []byte) error {
 
 	app.mu.Lock()
 	conn := app.conns[routing.Loop{Local: laddr, Remote: raddr}]
+	atLimit := app.atConnLimit()
+	stop := app.acceptStopChan()
 	app.mu.Unlock()
 
 	if conn != nil {
 		return errors.New("loop is already created")
 	}
+	if atLimit {
+		return ErrTooManyConns
+	}
+	select {
+	case <-stop:
+		return ErrNotAccepting
+	default:
+	}
 
 	fmt.Println("!!! [confirmLoop] selecting !!!")
 	select {
@@ -303,14 +712,305 @@ func (app *App) confirmLoop(data []byte) error {
 	return nil
 }
 
+// atConnLimit reports whether no more loops may be opened. app.mu must be held.
+func (app *App) atConnLimit() bool {
+	return app.config.MaxConns > 0 && len(app.conns)+app.dialing >= app.config.MaxConns
+}
+
+// loopClose records the codes a loop is closed with. Its methods are safe to call on nil.
+type loopClose struct {
+	mu         sync.Mutex
+	localCode  routing.CloseCode // sent to the remote app once the loop is closed locally
+	peerCode   routing.CloseCode
+	peerClosed bool
+}
+
+func (lc *loopClose) local() routing.CloseCode {
+	if lc == nil {
+		return routing.CloseNormal
+	}
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	return lc.localCode
+}
+
+func (lc *loopClose) setLocal(code routing.CloseCode) {
+	if lc == nil {
+		return
+	}
+	lc.mu.Lock()
+	lc.localCode = code
+	lc.mu.Unlock()
+}
+
+func (lc *loopClose) peer() (routing.CloseCode, bool) {
+	if lc == nil {
+		return 0, false
+	}
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	return lc.peerCode, lc.peerClosed
+}
+
+func (lc *loopClose) setPeer(code routing.CloseCode) {
+	if lc == nil {
+		return
+	}
+	lc.mu.Lock()
+	lc.peerCode, lc.peerClosed = code, true
+	lc.mu.Unlock()
+}
+
 type appConn struct {
 	net.Conn
 	laddr routing.Addr
 	raddr routing.Addr
+	close *loopClose
+	flow  *loopFlow
+
+	drained      <-chan struct{} // closed once written data is sent, nil if not tracked
+	closeTimeout time.Duration
+
+	done chan struct{}
+	once sync.Once
 }
 
+var _ LoopConn = (*appConn)(nil)
+
 func newAppConn(conn net.Conn, laddr, raddr routing.Addr) *appConn {
-	return &appConn{conn, laddr, raddr}
+	return &appConn{Conn: conn, laddr: laddr, raddr: raddr, done: make(chan struct{})}
+}
+
+func (conn *appConn) withClose(lc *loopClose) *appConn {
+	conn.close = lc
+	return conn
+}
+
+func (conn *appConn) withFlow(fl *loopFlow) *appConn {
+	conn.flow = fl
+	return conn
+}
+
+func (conn *appConn) withDrain(drained <-chan struct{}, timeout time.Duration) *appConn {
+	if timeout <= 0 {
+		timeout = DefaultCloseTimeout
+	}
+	conn.drained, conn.closeTimeout = drained, timeout
+	return conn
+}
+
+func (conn *appConn) isClosed() bool {
+	select {
+	case <-conn.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Read implements io.Reader. An empty Read returns immediately without consuming data.
+// It returns ErrLoopClosed once conn is closed, and io.EOF once the loop is closed by the remote.
+// While the loop is paused Read waits, see Pause.
+// While too much data of the loop is unread, the remote app is asked to stop sending until Read catches up.
+func (conn *appConn) Read(b []byte) (int, error) {
+	if conn.isClosed() {
+		return 0, ErrLoopClosed
+	}
+	if len(b) == 0 {
+		return 0, nil
+	}
+	if !conn.flow.awaitResumed(conn.done) {
+		return 0, ErrLoopClosed
+	}
+	n, err := conn.Conn.Read(b)
+	if err != nil && conn.isClosed() {
+		err = ErrLoopClosed
+	}
+	if pc, ok := conn.Conn.(*loopPipeConn); ok && n > 0 {
+		if err := conn.flow.updateUnread(pc.unread); err != nil {
+			log.WithError(err).Warn("Failed to resume loop")
+		}
+	}
+	// Data of a Read that was pending when the loop got paused is held back until the loop is resumed.
+	if n > 0 && !conn.flow.awaitResumed(conn.done) {
+		return 0, ErrLoopClosed
+	}
+	return n, err
+}
+
+// Write implements io.Writer. An empty Write is a no-op and sends no packet.
+// It returns ErrLoopClosed once conn is closed. While the remote app has paused the loop Write waits.
+// Concurrent Writes are performed one at a time in the order they are called, see loopPipe.
+func (conn *appConn) Write(b []byte) (int, error) {
+	if conn.isClosed() {
+		return 0, ErrLoopClosed
+	}
+	if len(b) == 0 {
+		return 0, nil
+	}
+	if !conn.flow.awaitPeerResumed(conn.done) {
+		return 0, ErrLoopClosed
+	}
+	n, err := conn.Conn.Write(b)
+	if err != nil && conn.isClosed() {
+		err = ErrLoopClosed
+	}
+	return n, err
+}
+
+// payloadBufs holds MaxPayloadSize buffers for appConn.ReadFrom.
+var payloadBufs = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, MaxPayloadSize)
+		return &b
+	},
+}
+
+// ReadFrom implements io.ReaderFrom, so io.Copy to conn reads r in chunks of MaxPayloadSize,
+// the most a single packet carries, into a pooled buffer instead of allocating one per copy.
+// It reads until io.EOF and, like Write, honors the write deadline and waits while the remote app has paused the loop.
+// Use CopyChunked to copy until a context is done.
+func (conn *appConn) ReadFrom(r io.Reader) (int64, error) {
+	bp := payloadBufs.Get().(*[]byte)
+	defer payloadBufs.Put(bp)
+	buf := *bp
+
+	var written int64
+	for {
+		nr, rErr := r.Read(buf)
+		if nr > 0 {
+			nw, wErr := conn.Write(buf[:nr])
+			written += int64(nw)
+			if wErr != nil {
+				return written, wErr
+			}
+		}
+		if rErr == io.EOF {
+			return written, nil
+		}
+		if rErr != nil {
+			return written, rErr
+		}
+	}
+}
+
+// WriteTo implements io.WriterTo, so io.Copy from conn writes the data queued for the loop to w as it is,
+// without copying it into an intermediate buffer first.
+// It writes until the remote closes the loop and, like Read, honors the read deadline and waits while the loop is paused.
+// Use CopyChunked to copy until a context is done.
+func (conn *appConn) WriteTo(w io.Writer) (int64, error) {
+	pc, ok := conn.Conn.(*loopPipeConn)
+	if !ok {
+		// Hide WriteTo from io.Copy, which would call it again.
+		return io.Copy(w, struct{ io.Reader }{conn})
+	}
+
+	var written int64
+	for {
+		if conn.isClosed() || !conn.flow.awaitResumed(conn.done) {
+			return written, ErrLoopClosed
+		}
+		b, err := pc.readQueued()
+		if err == io.EOF {
+			return written, nil
+		}
+		if err != nil {
+			if conn.isClosed() {
+				err = ErrLoopClosed
+			}
+			return written, err
+		}
+		if err := conn.flow.updateUnread(pc.unread); err != nil {
+			log.WithError(err).Warn("Failed to resume loop")
+		}
+		// As with Read, data that was pending when the loop got paused is held back until the loop is resumed.
+		if !conn.flow.awaitResumed(conn.done) {
+			return written, ErrLoopClosed
+		}
+
+		n, err := w.Write(b)
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+		if n != len(b) {
+			return written, io.ErrShortWrite
+		}
+	}
+}
+
+// Close implements io.Closer. Closing conn again is a no-op and returns nil.
+// Close returns once the data of all Writes that returned before has been sent to the Node, and the loop is closed.
+// If that takes longer than Config.CloseTimeout, Close returns ErrCloseTimeout.
+func (conn *appConn) Close() error {
+	var err error
+	conn.once.Do(func() {
+		close(conn.done)
+		if err = conn.Conn.Close(); err != nil {
+			return
+		}
+		err = conn.awaitDrained()
+	})
+	return err
+}
+
+func (conn *appConn) awaitDrained() error {
+	if conn.drained == nil {
+		return nil
+	}
+	t := time.NewTimer(conn.closeTimeout)
+	defer t.Stop()
+
+	select {
+	case <-conn.drained:
+		return nil
+	case <-t.C:
+		return ErrCloseTimeout
+	}
+}
+
+// CloseWithCode implements LoopConn.
+func (conn *appConn) CloseWithCode(code routing.CloseCode) error {
+	if !conn.isClosed() {
+		conn.close.setLocal(code)
+	}
+	return conn.Close()
+}
+
+// PeerCloseCode implements LoopConn.
+func (conn *appConn) PeerCloseCode() (routing.CloseCode, bool) {
+	return conn.close.peer()
+}
+
+// State implements LoopConn.
+func (conn *appConn) State() LoopState {
+	s := LoopState{Local: conn.laddr, Remote: conn.raddr, Closed: conn.isClosed()}
+	if code, ok := conn.close.peer(); ok {
+		s.Closed, s.PeerCloseCode = true, &code
+	}
+	s.Paused, s.PeerPaused = conn.flow.state()
+	if pc, ok := conn.Conn.(*loopPipeConn); ok {
+		s.Unread, s.Unsent = pc.unread(), pc.unreadByPeer()
+		s.ReadDeadline, s.WriteDeadline = pc.rDeadline.get(), pc.wDeadline.get()
+		s.Closed = s.Closed || pc.isClosed()
+	}
+	return s
+}
+
+// Pause implements LoopConn.
+func (conn *appConn) Pause() error {
+	if conn.isClosed() {
+		return ErrLoopClosed
+	}
+	return conn.flow.setPaused(true)
+}
+
+// Resume implements LoopConn.
+func (conn *appConn) Resume() error {
+	if conn.isClosed() {
+		return ErrLoopClosed
+	}
+	return conn.flow.setPaused(false)
 }
 
 func (conn *appConn) LocalAddr() net.Addr {
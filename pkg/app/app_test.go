@@ -1,11 +1,21 @@
 package app
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
+	"math"
+	"math/rand"
 	"net"
 	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -84,6 +94,228 @@ func TestAppDial(t *testing.T) {
 	require.NoError(t, testhelpers.WithinTimeout(serveErrCh))
 }
 
+func TestAppDial_MaxConns(t *testing.T) {
+	const maxConns = 2
+	rpk, _ := cipher.GenerateKeyPair()
+
+	in, out := net.Pipe()
+	proto := NewProtocol(out)
+	app := &App{config: Config{MaxConns: maxConns}, proto: NewProtocol(in), conns: make(map[routing.Loop]io.ReadWriteCloser)}
+	go app.handleProto()
+
+	serveErrCh := make(chan error, 1)
+	go func() {
+		var port uint32
+		f := func(f Frame, p []byte) (interface{}, error) {
+			switch f {
+			case FrameCreateLoop:
+				return &routing.Addr{Port: routing.Port(atomic.AddUint32(&port, 1))}, nil
+			case FrameClose:
+				return nil, nil
+			default:
+				return nil, errors.New("unexpected frame")
+			}
+		}
+		serveErrCh <- proto.Serve(f)
+	}()
+
+	var conns []net.Conn
+	for i := 0; i < maxConns; i++ {
+		conn, err := app.Dial(routing.Addr{PubKey: rpk, Port: 3})
+		require.NoError(t, err)
+		conns = append(conns, conn)
+	}
+
+	_, err := app.Dial(routing.Addr{PubKey: rpk, Port: 3})
+	assert.Equal(t, ErrTooManyConns, err)
+
+	// Incoming loops are rejected too.
+	assert.Error(t, proto.Send(FrameConfirmLoop, [2]routing.Addr{{Port: 10}, {PubKey: rpk, Port: 4}}, nil))
+
+	// Closing a loop releases its slot.
+	require.NoError(t, conns[0].Close())
+	for deadline := time.Now().Add(time.Second); ; {
+		app.mu.Lock()
+		n := len(app.conns)
+		app.mu.Unlock()
+		if n < maxConns || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	conn, err := app.Dial(routing.Addr{PubKey: rpk, Port: 3})
+	require.NoError(t, err)
+
+	require.NoError(t, conn.Close())
+	require.NoError(t, conns[1].Close())
+	require.NoError(t, proto.Close())
+	require.NoError(t, testhelpers.WithinTimeout(serveErrCh))
+}
+
+func TestAppDialContext(t *testing.T) {
+	lpk, _ := cipher.GenerateKeyPair()
+	rpk, _ := cipher.GenerateKeyPair()
+
+	in, out := net.Pipe()
+	proto := NewProtocol(out)
+	app := &App{proto: NewProtocol(in), conns: make(map[routing.Loop]io.ReadWriteCloser)}
+	go app.handleProto()
+
+	serveErrCh := make(chan error, 1)
+	go func() {
+		f := func(f Frame, p []byte) (interface{}, error) {
+			switch f {
+			case FrameCreateLoop:
+				return &routing.Addr{PubKey: lpk, Port: 2}, nil
+			case FrameClose:
+				return nil, nil
+			default:
+				return nil, errors.New("unexpected frame")
+			}
+		}
+		serveErrCh <- proto.Serve(f)
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	conn, err := app.DialContext(ctx, routing.Addr{PubKey: rpk, Port: 3})
+	require.NoError(t, err)
+
+	readErrCh := make(chan error, 1)
+	go func() {
+		_, err := conn.Read(make([]byte, 1))
+		readErrCh <- err
+	}()
+
+	cancel()
+	assert.Error(t, testhelpers.WithinTimeout(readErrCh))
+
+	// Dialing with a cancelled context fails.
+	_, err = app.DialContext(ctx, routing.Addr{PubKey: rpk, Port: 3})
+	assert.Equal(t, context.Canceled, err)
+
+	require.NoError(t, proto.Close())
+	require.NoError(t, testhelpers.WithinTimeout(serveErrCh))
+}
+
+func TestAppDialContext_Retry(t *testing.T) {
+	lpk, _ := cipher.GenerateKeyPair()
+	rpk, _ := cipher.GenerateKeyPair()
+
+	in, out := net.Pipe()
+	proto := NewProtocol(out)
+	app := &App{
+		config: Config{DialRetry: 10 * time.Millisecond},
+		proto:  NewProtocol(in),
+		conns:  make(map[routing.Loop]io.ReadWriteCloser),
+	}
+	go app.handleProto()
+
+	// The route to the remote is only set up on attempt routeAt.
+	var attempts, routeAt int32 = 0, 3
+	serveErrCh := make(chan error, 1)
+	go func() {
+		f := func(f Frame, p []byte) (interface{}, error) {
+			switch f {
+			case FrameCreateLoop:
+				if atomic.AddInt32(&attempts, 1) < atomic.LoadInt32(&routeAt) {
+					return nil, errors.New("no route")
+				}
+				return &routing.Addr{PubKey: lpk, Port: 2}, nil
+			case FrameClose:
+				return nil, nil
+			default:
+				return nil, errors.New("unexpected frame")
+			}
+		}
+		serveErrCh <- proto.Serve(f)
+	}()
+
+	conn, err := app.DialContext(context.Background(), routing.Addr{PubKey: rpk, Port: 3})
+	require.NoError(t, err)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&attempts))
+	require.NoError(t, conn.Close())
+
+	// Retries stop once ctx is done, with the error of the last attempt.
+	atomic.StoreInt32(&attempts, 0)
+	atomic.StoreInt32(&routeAt, math.MaxInt32)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = app.DialContext(ctx, routing.Addr{PubKey: rpk, Port: 3})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "no route")
+	assert.True(t, atomic.LoadInt32(&attempts) > 1)
+
+	// Without DialRetry a failed attempt is not retried.
+	app.config.DialRetry = 0
+	atomic.StoreInt32(&attempts, 0)
+	_, err = app.DialContext(context.Background(), routing.Addr{PubKey: rpk, Port: 3})
+	require.Error(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&attempts))
+
+	require.NoError(t, proto.Close())
+	require.NoError(t, testhelpers.WithinTimeout(serveErrCh))
+}
+
+func TestAppSetContext(t *testing.T) {
+	lpk, _ := cipher.GenerateKeyPair()
+	rpk, _ := cipher.GenerateKeyPair()
+
+	in, out := net.Pipe()
+	proto := NewProtocol(out)
+	app := &App{proto: NewProtocol(in), doneChan: make(chan struct{}), conns: make(map[routing.Loop]io.ReadWriteCloser)}
+	go app.handleProto()
+
+	serveErrCh := make(chan error, 1)
+	go func() {
+		var port uint16
+		f := func(f Frame, p []byte) (interface{}, error) {
+			switch f {
+			case FrameCreateLoop:
+				port++
+				return &routing.Addr{PubKey: lpk, Port: routing.Port(port)}, nil
+			case FrameClose:
+				return nil, nil
+			default:
+				return nil, errors.New("unexpected frame")
+			}
+		}
+		serveErrCh <- proto.Serve(f)
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	app.SetContext(ctx)
+
+	// Reads are pending on all loops of the app when the context is cancelled.
+	var conns []net.Conn
+	readErrCh := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		conn, err := app.Dial(routing.Addr{PubKey: rpk, Port: 3})
+		require.NoError(t, err)
+		conns = append(conns, conn)
+		go func() {
+			_, err := conn.Read(make([]byte, 1))
+			readErrCh <- err
+		}()
+	}
+
+	cancel()
+	for range conns {
+		assert.Equal(t, io.EOF, testhelpers.WithinTimeout(readErrCh))
+	}
+	for _, conn := range conns {
+		_, err := conn.Write([]byte("foo"))
+		assert.Equal(t, io.ErrClosedPipe, err)
+	}
+
+	select {
+	case <-app.doneChan:
+	default:
+		t.Fatal("app was not closed")
+	}
+	require.NoError(t, testhelpers.WithinTimeout(serveErrCh))
+}
+
 func TestAppAccept(t *testing.T) {
 	lpk, _ := cipher.GenerateKeyPair()
 	rpk, _ := cipher.GenerateKeyPair()
@@ -132,6 +364,51 @@ func TestAppAccept(t *testing.T) {
 	require.NoError(t, testhelpers.WithinTimeout(serveErrCh))
 }
 
+func TestAppStopAccepting(t *testing.T) {
+	lpk, _ := cipher.GenerateKeyPair()
+	rpk, _ := cipher.GenerateKeyPair()
+	in, out := net.Pipe()
+	app := &App{proto: NewProtocol(in), acceptChan: make(chan [2]routing.Addr), conns: make(map[routing.Loop]io.ReadWriteCloser)}
+	go app.handleProto()
+
+	proto := NewProtocol(out)
+	serveErrCh := make(chan error, 1)
+	go func() {
+		serveErrCh <- proto.Serve(func(f Frame, p []byte) (interface{}, error) {
+			if f == FrameCreateLoop {
+				return &routing.Addr{PubKey: lpk, Port: 2}, nil
+			}
+			return nil, nil
+		})
+	}()
+
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := app.Accept()
+		errCh <- err
+	}()
+
+	app.StopAccepting()
+	app.StopAccepting()
+	require.Equal(t, ErrNotAccepting, testhelpers.WithinTimeout(errCh))
+	_, err := app.Accept()
+	assert.Equal(t, ErrNotAccepting, err)
+
+	// Loops confirmed afterwards are refused.
+	err = proto.Send(FrameConfirmLoop, [2]routing.Addr{{PubKey: lpk, Port: 2}, {PubKey: rpk, Port: 3}}, nil)
+	assert.EqualError(t, err, ErrNotAccepting.Error())
+
+	// Dialing still works.
+	conn, err := app.Dial(routing.Addr{PubKey: rpk, Port: 4})
+	require.NoError(t, err)
+	assert.Equal(t, rpk.Hex()+":4", conn.RemoteAddr().String())
+	assert.Len(t, app.Loops(), 1)
+
+	require.NoError(t, conn.Close())
+	require.NoError(t, proto.Close())
+	require.NoError(t, testhelpers.WithinTimeout(serveErrCh))
+}
+
 func TestAppWrite(t *testing.T) {
 	lpk, _ := cipher.GenerateKeyPair()
 	rpk, _ := cipher.GenerateKeyPair()
@@ -173,6 +450,141 @@ func TestAppWrite(t *testing.T) {
 	require.NoError(t, appOut.Close())
 }
 
+// Close returns once the data written before is sent to the Node, or fails after Config.CloseTimeout.
+func TestAppConnClose_Drain(t *testing.T) {
+	lpk, _ := cipher.GenerateKeyPair()
+	rpk, _ := cipher.GenerateKeyPair()
+
+	in, out := net.Pipe()
+	proto := NewProtocol(out)
+	app := &App{proto: NewProtocol(in), conns: make(map[routing.Loop]io.ReadWriteCloser)}
+	go app.handleProto()
+
+	var (
+		mu       sync.Mutex
+		received []byte
+		closed   bool
+	)
+	block := make(chan struct{})
+	serveErrCh := make(chan error, 1)
+	go func() {
+		f := func(f Frame, p []byte) (interface{}, error) {
+			switch f {
+			case FrameCreateLoop:
+				return &routing.Addr{PubKey: lpk, Port: 2}, nil
+			case FrameSend:
+				<-block
+				packet := &Packet{}
+				if err := json.Unmarshal(p, packet); err != nil {
+					return nil, err
+				}
+				mu.Lock()
+				received = append(received, packet.Payload...)
+				mu.Unlock()
+				return nil, nil
+			case FrameClose:
+				mu.Lock()
+				closed = true
+				mu.Unlock()
+				return nil, nil
+			default:
+				return nil, errors.New("unexpected frame")
+			}
+		}
+		serveErrCh <- proto.Serve(f)
+	}()
+
+	t.Run("timeout", func(t *testing.T) {
+		app.config.CloseTimeout = 50 * time.Millisecond
+		defer func() { app.config.CloseTimeout = 0 }()
+
+		conn, err := app.Dial(routing.Addr{PubKey: rpk, Port: 3})
+		require.NoError(t, err)
+		_, err = conn.Write([]byte("foo"))
+		require.NoError(t, err)
+
+		// The Node does not take the data.
+		assert.Equal(t, ErrCloseTimeout, conn.Close())
+
+		close(block)
+		time.Sleep(50 * time.Millisecond)
+		mu.Lock()
+		received, closed = nil, false
+		mu.Unlock()
+	})
+
+	t.Run("flush", func(t *testing.T) {
+		conn, err := app.Dial(routing.Addr{PubKey: rpk, Port: 3})
+		require.NoError(t, err)
+
+		data := make([]byte, 10*MaxPayloadSize+7)
+		for i := range data {
+			data[i] = byte(i)
+		}
+		n, err := conn.Write(data)
+		require.NoError(t, err)
+		require.Equal(t, len(data), n)
+		require.NoError(t, conn.Close())
+
+		mu.Lock()
+		assert.True(t, bytes.Equal(data, received), "received %d of %d bytes", len(received), len(data))
+		assert.True(t, closed)
+		mu.Unlock()
+	})
+
+	require.NoError(t, proto.Close())
+	require.NoError(t, testhelpers.WithinTimeout(serveErrCh))
+}
+
+func TestAppWrite_Empty(t *testing.T) {
+	lpk, _ := cipher.GenerateKeyPair()
+	rpk, _ := cipher.GenerateKeyPair()
+	in, out := net.Pipe()
+	appIn, appOut := net.Pipe()
+	app := &App{proto: NewProtocol(in)}
+	go app.handleProto()
+	go app.serveConn(routing.Loop{Local: routing.Addr{PubKey: lpk, Port: 2}, Remote: routing.Addr{PubKey: rpk, Port: 3}}, appIn)
+
+	proto := NewProtocol(out)
+	dataCh := make(chan []byte, 2)
+	serveErrCh := make(chan error, 1)
+	go func() {
+		f := func(f Frame, p []byte) (interface{}, error) {
+			if f != FrameSend {
+				return nil, errors.New("unexpected frame")
+			}
+
+			dataCh <- p
+			return nil, nil
+		}
+		serveErrCh <- proto.Serve(f)
+	}()
+
+	conn := newAppConn(appOut, routing.Addr{PubKey: lpk, Port: 2}, routing.Addr{PubKey: rpk, Port: 3})
+	n, err := conn.Write([]byte{})
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	// Empty writes to the underlying pipe are not sent either.
+	n, err = appOut.Write([]byte{})
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	n, err = conn.Write([]byte("foo"))
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+
+	// The first packet on the wire is the non-empty one.
+	packet := &Packet{}
+	require.NoError(t, json.Unmarshal(<-dataCh, packet))
+	assert.Equal(t, []byte("foo"), packet.Payload)
+	assert.Len(t, dataCh, 0)
+
+	require.NoError(t, proto.Close())
+	require.NoError(t, testhelpers.WithinTimeout(serveErrCh))
+	require.NoError(t, appOut.Close())
+}
+
 func TestAppRead(t *testing.T) {
 	lpk, _ := cipher.GenerateKeyPair()
 	pk, _ := cipher.GenerateKeyPair()
@@ -313,3 +725,972 @@ func TestAppCommand(t *testing.T) {
 	assert.NotNil(t, conn)
 	assert.NotNil(t, cmd)
 }
+
+func TestAppRead_Empty(t *testing.T) {
+	lpk, _ := cipher.GenerateKeyPair()
+	pk, _ := cipher.GenerateKeyPair()
+	loop := routing.Loop{Local: routing.Addr{PubKey: lpk, Port: 2}, Remote: routing.Addr{PubKey: pk, Port: 3}}
+	in, out := net.Pipe()
+	appIn, appOut := net.Pipe()
+	app := &App{proto: NewProtocol(in), conns: map[routing.Loop]io.ReadWriteCloser{loop: appIn}}
+	go app.handleProto()
+
+	proto := NewProtocol(out)
+	serveErrCh := make(chan error, 1)
+	go func() {
+		serveErrCh <- proto.Serve(nil)
+	}()
+
+	// An empty payload is acknowledged without writing to the loop conn.
+	require.NoError(t, proto.Send(FrameSend, &Packet{loop, []byte{}}, nil))
+
+	errCh := make(chan error)
+	go func() {
+		errCh <- proto.Send(FrameSend, &Packet{loop, []byte("foo")}, nil)
+	}()
+
+	conn := newAppConn(appOut, loop.Local, loop.Remote)
+
+	// An empty Read returns immediately and does not consume the queued payload.
+	n, err := conn.Read([]byte{})
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	buf := make([]byte, 3)
+	n, err = conn.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, []byte("foo"), buf)
+
+	require.NoError(t, <-errCh)
+
+	require.NoError(t, proto.Close())
+	require.NoError(t, testhelpers.WithinTimeout(serveErrCh))
+	require.NoError(t, appOut.Close())
+}
+
+func TestAppRead_Coalesced(t *testing.T) {
+	lpk, _ := cipher.GenerateKeyPair()
+	pk, _ := cipher.GenerateKeyPair()
+	loop := routing.Loop{Local: routing.Addr{PubKey: lpk, Port: 2}, Remote: routing.Addr{PubKey: pk, Port: 3}}
+	in, out := net.Pipe()
+	appIn, appOut := loopPipe()
+	app := &App{proto: NewProtocol(in), conns: map[routing.Loop]io.ReadWriteCloser{loop: appIn}}
+	go app.handleProto()
+
+	proto := NewProtocol(out)
+	serveErrCh := make(chan error, 1)
+	go func() {
+		serveErrCh <- proto.Serve(nil)
+	}()
+
+	// Payloads are queued without being read.
+	for _, msg := range []string{"foo", "bar", "baz"} {
+		require.NoError(t, proto.Send(FrameSend, &Packet{loop, []byte(msg)}, nil))
+	}
+
+	conn := newAppConn(appOut, loop.Local, loop.Remote)
+
+	buf := make([]byte, 1024)
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "foobarbaz", string(buf[:n]))
+
+	// Data that does not fit into the buffer is left for the next Read.
+	require.NoError(t, proto.Send(FrameSend, &Packet{loop, []byte("foo")}, nil))
+	require.NoError(t, proto.Send(FrameSend, &Packet{loop, []byte("bar")}, nil))
+	buf = make([]byte, 4)
+	n, err = conn.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "foob", string(buf[:n]))
+	n, err = conn.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "ar", string(buf[:n]))
+
+	require.NoError(t, proto.Close())
+	require.NoError(t, testhelpers.WithinTimeout(serveErrCh))
+	require.NoError(t, appOut.Close())
+}
+
+func TestAppConnClosed(t *testing.T) {
+	lpk, _ := cipher.GenerateKeyPair()
+	rpk, _ := cipher.GenerateKeyPair()
+
+	in, out := net.Pipe()
+	proto := NewProtocol(out)
+	app := &App{proto: NewProtocol(in), doneChan: make(chan struct{}), conns: make(map[routing.Loop]io.ReadWriteCloser)}
+	go app.handleProto()
+
+	serveErrCh := make(chan error, 1)
+	go func() {
+		f := func(f Frame, p []byte) (interface{}, error) {
+			switch f {
+			case FrameCreateLoop:
+				return &routing.Addr{PubKey: lpk, Port: 2}, nil
+			case FrameClose:
+				return nil, nil
+			default:
+				return nil, errors.New("unexpected frame")
+			}
+		}
+		serveErrCh <- proto.Serve(f)
+	}()
+
+	conn, err := app.Dial(routing.Addr{PubKey: rpk, Port: 3})
+	require.NoError(t, err)
+
+	// A pending Read returns once conn is closed.
+	readErrCh := make(chan error, 1)
+	go func() {
+		_, err := conn.Read(make([]byte, 1))
+		readErrCh <- err
+	}()
+
+	require.NoError(t, conn.Close())
+	assert.Equal(t, ErrLoopClosed, testhelpers.WithinTimeout(readErrCh))
+
+	_, err = conn.Write([]byte("foo"))
+	assert.Equal(t, ErrLoopClosed, err)
+	_, err = conn.Write(nil)
+	assert.Equal(t, ErrLoopClosed, err)
+	_, err = conn.Read(make([]byte, 1))
+	assert.Equal(t, ErrLoopClosed, err)
+
+	// Closing again is a no-op.
+	assert.NoError(t, conn.Close())
+
+	require.NoError(t, app.Close())
+	require.NoError(t, testhelpers.WithinTimeout(serveErrCh))
+}
+
+func TestAppLoops(t *testing.T) {
+	lpk, _ := cipher.GenerateKeyPair()
+	rpk, _ := cipher.GenerateKeyPair()
+
+	in, out := net.Pipe()
+	proto := NewProtocol(out)
+	app := &App{proto: NewProtocol(in), doneChan: make(chan struct{}), conns: make(map[routing.Loop]io.ReadWriteCloser)}
+	go app.handleProto()
+
+	serveErrCh := make(chan error, 1)
+	go func() {
+		var port uint16
+		f := func(f Frame, p []byte) (interface{}, error) {
+			switch f {
+			case FrameCreateLoop:
+				port++
+				return &routing.Addr{PubKey: lpk, Port: routing.Port(port)}, nil
+			case FrameClose:
+				return nil, nil
+			default:
+				return nil, errors.New("unexpected frame")
+			}
+		}
+		serveErrCh <- proto.Serve(f)
+	}()
+
+	assert.Empty(t, app.Loops())
+
+	var (
+		conns []net.Conn
+		want  []routing.Loop
+	)
+	for i := 0; i < 3; i++ {
+		raddr := routing.Addr{PubKey: rpk, Port: routing.Port(10 + i)}
+		conn, err := app.Dial(raddr)
+		require.NoError(t, err)
+		conns = append(conns, conn)
+		want = append(want, routing.Loop{Local: routing.Addr{Port: routing.Port(i + 1)}, Remote: raddr})
+	}
+	assert.Equal(t, want, app.Loops())
+
+	// Closed loops are no longer returned.
+	require.NoError(t, conns[1].Close())
+	for deadline := time.Now().Add(5 * time.Second); len(app.Loops()) != 2; time.Sleep(10 * time.Millisecond) {
+		require.True(t, time.Now().Before(deadline), "loop was not removed")
+	}
+	assert.Equal(t, []routing.Loop{want[0], want[2]}, app.Loops())
+
+	require.NoError(t, app.Close())
+	require.NoError(t, testhelpers.WithinTimeout(serveErrCh))
+}
+
+func TestAppConnCloseCode(t *testing.T) {
+	lpk, _ := cipher.GenerateKeyPair()
+	rpk, _ := cipher.GenerateKeyPair()
+
+	in, out := net.Pipe()
+	proto := NewProtocol(out)
+	app := &App{proto: NewProtocol(in), doneChan: make(chan struct{}), conns: make(map[routing.Loop]io.ReadWriteCloser)}
+	go app.handleProto()
+
+	closeCh := make(chan LoopClose, 1)
+	serveErrCh := make(chan error, 1)
+	go func() {
+		var port uint16
+		f := func(f Frame, p []byte) (interface{}, error) {
+			switch f {
+			case FrameCreateLoop:
+				port++
+				return &routing.Addr{PubKey: lpk, Port: routing.Port(port)}, nil
+			case FrameClose:
+				var closing LoopClose
+				if err := json.Unmarshal(p, &closing); err != nil {
+					return nil, err
+				}
+				closeCh <- closing
+				return nil, nil
+			default:
+				return nil, errors.New("unexpected frame")
+			}
+		}
+		serveErrCh <- proto.Serve(f)
+	}()
+
+	t.Run("local_close", func(t *testing.T) {
+		raddr := routing.Addr{PubKey: rpk, Port: 3}
+		conn, err := app.Dial(raddr)
+		require.NoError(t, err)
+		lConn, ok := conn.(LoopConn)
+		require.True(t, ok)
+
+		require.NoError(t, lConn.CloseWithCode(routing.CloseError))
+		closing := <-closeCh
+		assert.Equal(t, routing.Loop{Local: routing.Addr{Port: 1}, Remote: raddr}, closing.Loop)
+		assert.Equal(t, routing.CloseError, closing.Code)
+
+		// The loop was not closed by the remote app.
+		_, ok = lConn.PeerCloseCode()
+		assert.False(t, ok)
+	})
+
+	t.Run("peer_close", func(t *testing.T) {
+		raddr := routing.Addr{PubKey: rpk, Port: 4}
+		conn, err := app.Dial(raddr)
+		require.NoError(t, err)
+		defer func() { assert.NoError(t, conn.Close()) }()
+		lConn := conn.(LoopConn)
+
+		_, ok := lConn.PeerCloseCode()
+		assert.False(t, ok)
+
+		// The node relays the close of the remote app.
+		loop := routing.Loop{Local: routing.Addr{Port: 2}, Remote: raddr}
+		require.NoError(t, proto.Send(FrameClose, &LoopClose{Loop: loop, Code: 42}, nil))
+
+		_, err = conn.Read(make([]byte, 1))
+		assert.Equal(t, io.EOF, err)
+		code, ok := lConn.PeerCloseCode()
+		assert.True(t, ok)
+		assert.Equal(t, routing.CloseCode(42), code)
+	})
+
+	require.NoError(t, app.Close())
+	require.NoError(t, testhelpers.WithinTimeout(serveErrCh))
+}
+
+func TestAppRebind(t *testing.T) {
+	lpk, _ := cipher.GenerateKeyPair()
+	rpk, _ := cipher.GenerateKeyPair()
+
+	dir, err := ioutil.TempDir("", "app_rebind")
+	require.NoError(t, err)
+	defer func() { require.NoError(t, os.RemoveAll(dir)) }()
+
+	// serveNode listens on a unix socket at path and serves the app connecting to it like a Node.
+	serveNode := func(path string) (net.Conn, <-chan *Protocol) {
+		l, err := net.Listen("unix", path)
+		require.NoError(t, err)
+
+		protoCh := make(chan *Protocol, 1)
+		go func() {
+			defer func() { assert.NoError(t, l.Close()) }()
+			conn, err := l.Accept()
+			if !assert.NoError(t, err) {
+				return
+			}
+			proto := NewProtocol(conn)
+			protoCh <- proto
+			_ = proto.Serve(func(frame Frame, _ []byte) (interface{}, error) { //nolint:errcheck
+				if frame != FrameInit {
+					return nil, errors.New("unexpected frame")
+				}
+				return nil, nil
+			})
+		}()
+
+		conn, err := net.Dial("unix", path)
+		require.NoError(t, err)
+		return conn, protoCh
+	}
+
+	accept := func(app *App, proto *Protocol, remotePort routing.Port) net.Conn {
+		connCh := make(chan net.Conn, 1)
+		go func() {
+			conn, err := app.Accept()
+			assert.NoError(t, err)
+			connCh <- conn
+		}()
+
+		deadline := time.Now().Add(time.Second)
+		for {
+			require.NoError(t, proto.Send(FrameConfirmLoop, [2]routing.Addr{{PubKey: lpk, Port: 2}, {PubKey: rpk, Port: remotePort}}, nil))
+			select {
+			case conn := <-connCh:
+				return conn
+			case <-time.After(50 * time.Millisecond):
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("timed out waiting for accepted loop")
+			}
+		}
+	}
+
+	conn1, protoCh1 := serveNode(filepath.Join(dir, "node1.sock"))
+	app, err := New(conn1, &Config{AppName: "foo", AppVersion: "0.0.1", ProtocolVersion: "0.0.1"})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, app.Close()) }()
+	proto1 := <-protoCh1
+
+	oldLoop := accept(app, proto1, 3)
+	require.Len(t, app.Loops(), 1)
+
+	// The Node moves to a new socket.
+	require.NoError(t, proto1.Close())
+	conn2, protoCh2 := serveNode(filepath.Join(dir, "node2.sock"))
+	require.NoError(t, app.Rebind(conn2))
+	proto2 := <-protoCh2
+
+	// Loops of the previous connection are closed.
+	_, err = oldLoop.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err)
+	code, ok := oldLoop.(LoopConn).PeerCloseCode()
+	assert.True(t, ok)
+	assert.Equal(t, routing.CloseError, code)
+
+	// The app keeps accepting loops over the new connection.
+	newLoop := accept(app, proto2, 4)
+	assert.Equal(t, routing.Addr{PubKey: rpk, Port: 4}, newLoop.RemoteAddr())
+	assert.Equal(t, []routing.Loop{{Local: routing.Addr{Port: 2}, Remote: routing.Addr{PubKey: rpk, Port: 4}}}, app.Loops())
+
+	require.NoError(t, proto2.Close())
+}
+
+func TestAppClose_Concurrent(t *testing.T) {
+	rpk, _ := cipher.GenerateKeyPair()
+	in, out := net.Pipe()
+
+	proto := NewProtocol(out)
+	serveErrCh := make(chan error, 1)
+	go func() {
+		serveErrCh <- proto.Serve(nil)
+	}()
+
+	app, err := New(in, &Config{AppName: "foo", AppVersion: "0.0.1", ProtocolVersion: "0.0.1"})
+	require.NoError(t, err)
+
+	var loops []net.Conn
+	for port := routing.Port(3); port < 6; port++ {
+		conn, err := app.Dial(routing.Addr{PubKey: rpk, Port: port})
+		require.NoError(t, err)
+		loops = append(loops, conn)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	app.SetContext(ctx)
+
+	// The app is closed by many callers and by its context at once.
+	const closers = 50
+	errCh := make(chan error, closers)
+	start := make(chan struct{})
+	for i := 0; i < closers; i++ {
+		go func() {
+			<-start
+			errCh <- app.Close()
+		}()
+	}
+	close(start)
+	cancel()
+
+	for i := 0; i < closers; i++ {
+		assert.NoError(t, <-errCh)
+	}
+	assert.NoError(t, app.Close())
+	assert.Empty(t, app.Loops())
+
+	for _, conn := range loops {
+		_, err := conn.Read(make([]byte, 1))
+		assert.Equal(t, io.EOF, err)
+	}
+
+	// Sends over the closed connection fail instead of panicking or blocking.
+	assert.Error(t, app.protocol().Send(FrameCreateLoop, routing.Addr{PubKey: rpk, Port: 6}, nil))
+
+	require.NoError(t, proto.Close())
+	require.NoError(t, testhelpers.WithinTimeout(serveErrCh))
+}
+
+func TestAppConnPause(t *testing.T) {
+	lpk, _ := cipher.GenerateKeyPair()
+	rpk, _ := cipher.GenerateKeyPair()
+
+	in, out := net.Pipe()
+	proto := NewProtocol(out)
+	app := &App{proto: NewProtocol(in), doneChan: make(chan struct{}), conns: make(map[routing.Loop]io.ReadWriteCloser)}
+	go app.handleProto()
+
+	pauseCh := make(chan LoopPause, 1)
+	sendCh := make(chan []byte, 1)
+	serveErrCh := make(chan error, 1)
+	go func() {
+		var port uint16
+		f := func(f Frame, p []byte) (interface{}, error) {
+			switch f {
+			case FrameCreateLoop:
+				port++
+				return &routing.Addr{PubKey: lpk, Port: routing.Port(port)}, nil
+			case FramePause:
+				var pausing LoopPause
+				if err := json.Unmarshal(p, &pausing); err != nil {
+					return nil, err
+				}
+				pauseCh <- pausing
+				return nil, nil
+			case FrameSend:
+				var packet Packet
+				if err := json.Unmarshal(p, &packet); err != nil {
+					return nil, err
+				}
+				sendCh <- packet.Payload
+				return nil, nil
+			case FrameClose:
+				return nil, nil
+			default:
+				return nil, errors.New("unexpected frame")
+			}
+		}
+		serveErrCh <- proto.Serve(f)
+	}()
+
+	t.Run("local_pause", func(t *testing.T) {
+		raddr := routing.Addr{PubKey: rpk, Port: 3}
+		conn, err := app.Dial(raddr)
+		require.NoError(t, err)
+		defer func() { assert.NoError(t, conn.Close()) }()
+		lConn := conn.(LoopConn)
+		loop := routing.Loop{Local: routing.Addr{Port: 1}, Remote: raddr}
+
+		// A Read pending before the pause does not return data received while paused either.
+		readCh := make(chan []byte, 1)
+		go func() {
+			buf := make([]byte, 6)
+			_, err := io.ReadFull(conn, buf)
+			assert.NoError(t, err)
+			readCh <- buf
+		}()
+
+		require.NoError(t, lConn.Pause())
+		assert.Equal(t, LoopPause{Loop: loop, Paused: true}, <-pauseCh)
+		require.NoError(t, lConn.Pause()) // already paused, the node is not told again
+
+		for _, payload := range []string{"fo", "ob", "ar"} {
+			require.NoError(t, proto.Send(FrameSend, &Packet{Loop: loop, Payload: []byte(payload)}, nil))
+		}
+		select {
+		case <-readCh:
+			t.Fatal("data was delivered to Read while paused")
+		case <-time.After(100 * time.Millisecond):
+		}
+
+		require.NoError(t, lConn.Resume())
+		assert.Equal(t, LoopPause{Loop: loop, Paused: false}, <-pauseCh)
+		select {
+		case buf := <-readCh:
+			assert.Equal(t, "foobar", string(buf))
+		case <-time.After(time.Second):
+			t.Fatal("buffered data was not delivered after resume")
+		}
+	})
+
+	t.Run("peer_pause", func(t *testing.T) {
+		raddr := routing.Addr{PubKey: rpk, Port: 4}
+		conn, err := app.Dial(raddr)
+		require.NoError(t, err)
+
+		// The node relays the pause of the remote app, with the full local address.
+		loop := routing.Loop{Local: routing.Addr{PubKey: lpk, Port: 2}, Remote: raddr}
+		require.NoError(t, proto.Send(FramePause, &LoopPause{Loop: loop, Paused: true}, nil))
+
+		writeErrCh := make(chan error, 1)
+		go func() {
+			_, err := conn.Write([]byte("foo"))
+			writeErrCh <- err
+		}()
+		select {
+		case <-writeErrCh:
+			t.Fatal("write to a loop paused by the remote app should wait")
+		case <-sendCh:
+			t.Fatal("data was sent to a loop paused by the remote app")
+		case <-time.After(100 * time.Millisecond):
+		}
+
+		require.NoError(t, proto.Send(FramePause, &LoopPause{Loop: loop, Paused: false}, nil))
+		require.NoError(t, testhelpers.WithinTimeout(writeErrCh))
+		assert.Equal(t, []byte("foo"), <-sendCh)
+
+		// Closing the loop releases writes waiting for the remote app.
+		require.NoError(t, proto.Send(FramePause, &LoopPause{Loop: loop, Paused: true}, nil))
+		go func() {
+			_, err := conn.Write([]byte("bar"))
+			writeErrCh <- err
+		}()
+		require.NoError(t, conn.Close())
+		assert.Equal(t, ErrLoopClosed, <-writeErrCh)
+	})
+
+	t.Run("unread", func(t *testing.T) {
+		raddr := routing.Addr{PubKey: rpk, Port: 5}
+		conn, err := app.Dial(raddr)
+		require.NoError(t, err)
+		defer func() { assert.NoError(t, conn.Close()) }()
+		loop := routing.Loop{Local: routing.Addr{Port: 3}, Remote: raddr}
+		awaitPause := func() LoopPause {
+			select {
+			case pausing := <-pauseCh:
+				return pausing
+			case <-time.After(time.Second):
+				t.Fatal("the node was not told to pause or resume the loop")
+				return LoopPause{}
+			}
+		}
+
+		// The remote app is paused once loopPauseSize bytes are unread.
+		payload := make([]byte, MaxPayloadSize)
+		for unread := 0; unread < loopPauseSize; unread += len(payload) {
+			select {
+			case pausing := <-pauseCh:
+				t.Fatalf("loop was paused with %d bytes unread: %v", unread, pausing)
+			default:
+			}
+			require.NoError(t, proto.Send(FrameSend, &Packet{Loop: loop, Payload: payload}, nil))
+		}
+		assert.Equal(t, LoopPause{Loop: loop, Paused: true}, awaitPause())
+
+		// It is resumed once no more than loopResumeSize bytes are unread.
+		buf := make([]byte, loopPauseSize-loopResumeSize)
+		_, err = io.ReadFull(conn, buf)
+		require.NoError(t, err)
+		assert.Equal(t, LoopPause{Loop: loop, Paused: false}, awaitPause())
+	})
+
+	require.NoError(t, app.Close())
+	require.NoError(t, testhelpers.WithinTimeout(serveErrCh))
+}
+
+// A writer to a loop whose reader falls behind is paused instead of its data being buffered without bound,
+// and resumes once the reader catches up.
+// relayedLoop returns both ends of a loop between two apps, whose Node relays frames of one app to the other,
+// queueing any number of them. closeAll closes the loop and the apps.
+func relayedLoop(t *testing.T) (conn1, conn2 net.Conn, closeAll func()) {
+	pk1, _ := cipher.GenerateKeyPair()
+	pk2, _ := cipher.GenerateKeyPair()
+	addr1, addr2 := routing.Addr{PubKey: pk1, Port: 1}, routing.Addr{PubKey: pk2, Port: 2}
+
+	in1, out1 := net.Pipe()
+	in2, out2 := net.Pipe()
+	app1 := &App{proto: NewProtocol(in1), doneChan: make(chan struct{}), conns: make(map[routing.Loop]io.ReadWriteCloser)}
+	app2 := &App{proto: NewProtocol(in2), doneChan: make(chan struct{}), acceptChan: make(chan [2]routing.Addr, 1),
+		conns: make(map[routing.Loop]io.ReadWriteCloser)}
+	go app1.handleProto()
+	go app2.handleProto()
+	proto1, proto2 := NewProtocol(out1), NewProtocol(out2)
+
+	// relay is a Node which forwards frames of one app to the other, queueing any number of them.
+	relay := func(to *Protocol, laddr, raddr routing.Addr) func(Frame, []byte) (interface{}, error) {
+		// The loop as the receiving app tracks it.
+		loop := routing.Loop{Local: routing.Addr{Port: laddr.Port}, Remote: raddr}
+		queue := make(chan func(), 1<<16)
+		go func() {
+			for fn := range queue {
+				fn()
+			}
+		}()
+		return func(f Frame, p []byte) (interface{}, error) {
+			switch f {
+			case FrameCreateLoop:
+				return &raddr, nil
+			case FrameSend:
+				var packet Packet
+				if err := json.Unmarshal(p, &packet); err != nil {
+					return nil, err
+				}
+				queue <- func() { _ = to.Send(FrameSend, &Packet{Loop: loop, Payload: packet.Payload}, nil) } // nolint:errcheck
+				return nil, nil
+			case FramePause:
+				var pausing LoopPause
+				if err := json.Unmarshal(p, &pausing); err != nil {
+					return nil, err
+				}
+				queue <- func() { _ = to.Send(FramePause, &LoopPause{Loop: loop, Paused: pausing.Paused}, nil) } // nolint:errcheck
+				return nil, nil
+			case FrameClose:
+				return nil, nil
+			default:
+				return nil, errors.New("unexpected frame")
+			}
+		}
+	}
+	serveErrCh := make(chan error, 2)
+	go func() { serveErrCh <- proto1.Serve(relay(proto2, addr2, addr1)) }()
+	go func() { serveErrCh <- proto2.Serve(relay(proto1, addr1, addr2)) }()
+
+	acceptCh := make(chan net.Conn, 1)
+	go func() {
+		conn, err := app2.Accept()
+		assert.NoError(t, err)
+		acceptCh <- conn
+	}()
+	require.NoError(t, proto2.Send(FrameConfirmLoop, [2]routing.Addr{addr2, addr1}, nil))
+	conn2 = <-acceptCh
+	conn1, err := app1.Dial(addr2)
+	require.NoError(t, err)
+
+	return conn1, conn2, func() {
+		require.NoError(t, conn1.Close())
+		require.NoError(t, conn2.Close())
+		require.NoError(t, app1.Close())
+		require.NoError(t, app2.Close())
+		require.NoError(t, testhelpers.WithinTimeout(serveErrCh))
+		require.NoError(t, testhelpers.WithinTimeout(serveErrCh))
+	}
+}
+
+func TestAppConnFlowControl(t *testing.T) {
+	conn1, conn2, closeAll := relayedLoop(t)
+
+	const total = 64 * MaxPayloadSize
+	var written int64
+	writeErrCh := make(chan error, 1)
+	go func() {
+		payload := make([]byte, MaxPayloadSize)
+		for atomic.LoadInt64(&written) < total {
+			n, err := conn1.Write(payload)
+			atomic.AddInt64(&written, int64(n))
+			if err != nil {
+				writeErrCh <- err
+				return
+			}
+		}
+		writeErrCh <- nil
+	}()
+
+	// The reader does not read, the writer stops.
+	var last int64 = -1
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		time.Sleep(200 * time.Millisecond)
+		n := atomic.LoadInt64(&written)
+		if n == last {
+			break
+		}
+		require.True(t, time.Now().Before(deadline), "writer did not stop, %d bytes written", n)
+		last = n
+	}
+	require.True(t, last < total, "all %d bytes were buffered", last)
+
+	// The reader catches up, the writer resumes.
+	buf := make([]byte, total)
+	_, err := io.ReadFull(conn2, buf)
+	require.NoError(t, err)
+	require.NoError(t, testhelpers.WithinTimeout(writeErrCh))
+
+	closeAll()
+}
+
+func TestAppConnState(t *testing.T) {
+	lpk, _ := cipher.GenerateKeyPair()
+	rpk, _ := cipher.GenerateKeyPair()
+
+	in, out := net.Pipe()
+	proto := NewProtocol(out)
+	app := &App{proto: NewProtocol(in), doneChan: make(chan struct{}), conns: make(map[routing.Loop]io.ReadWriteCloser)}
+	go app.handleProto()
+
+	serveErrCh := make(chan error, 1)
+	go func() {
+		f := func(f Frame, p []byte) (interface{}, error) {
+			switch f {
+			case FrameCreateLoop:
+				return &routing.Addr{PubKey: lpk, Port: 1}, nil
+			case FramePause, FrameClose:
+				return nil, nil
+			default:
+				return nil, errors.New("unexpected frame")
+			}
+		}
+		serveErrCh <- proto.Serve(f)
+	}()
+
+	raddr := routing.Addr{PubKey: rpk, Port: 3}
+	conn, err := app.Dial(raddr)
+	require.NoError(t, err)
+	lConn := conn.(LoopConn)
+	laddr := routing.Addr{PubKey: lpk, Port: 1}
+	assert.Equal(t, LoopState{Local: laddr, Remote: raddr}, lConn.State())
+
+	loop := routing.Loop{Local: routing.Addr{Port: 1}, Remote: raddr}
+	require.NoError(t, proto.Send(FrameSend, &Packet{Loop: loop, Payload: []byte("foo")}, nil))
+	require.NoError(t, proto.Send(FramePause, &LoopPause{Loop: loop, Paused: true}, nil))
+	require.NoError(t, lConn.Pause())
+	deadline := time.Now().Add(time.Hour)
+	require.NoError(t, conn.SetReadDeadline(deadline))
+
+	want := LoopState{
+		Local:        laddr,
+		Remote:       raddr,
+		Paused:       true,
+		PeerPaused:   true,
+		Unread:       3,
+		ReadDeadline: deadline,
+	}
+	assert.Equal(t, want, lConn.State())
+
+	require.NoError(t, conn.Close())
+	assert.True(t, lConn.State().Closed)
+
+	require.NoError(t, app.Close())
+	require.NoError(t, testhelpers.WithinTimeout(serveErrCh))
+}
+
+// Ensure that bytes written to one end of a loop are read from the other in the order they are written,
+// with the data of each Write kept contiguous, while both ends are written and read concurrently.
+func TestAppConnOrdering(t *testing.T) {
+	conn1, conn2, closeAll := relayedLoop(t)
+	defer closeAll()
+
+	const (
+		writers = 4
+		records = 25
+		header  = 7 // writer, sequence number and length of a record
+	)
+
+	// write writes records of each writer concurrently. The payload of a record repeats its writer and sequence number.
+	write := func(conn net.Conn, rnd *rand.Rand) <-chan error {
+		sizes := make([][]int, writers)
+		for w := range sizes {
+			for i := 0; i < records; i++ {
+				sizes[w] = append(sizes[w], 1+rnd.Intn(2*MaxPayloadSize))
+			}
+		}
+		errCh := make(chan error, writers)
+		for w := 0; w < writers; w++ {
+			go func(w int) {
+				for seq, size := range sizes[w] {
+					record := make([]byte, header+size)
+					record[0] = byte(w)
+					binary.BigEndian.PutUint16(record[1:], uint16(seq))
+					binary.BigEndian.PutUint32(record[3:], uint32(size))
+					for i := header; i < len(record); i++ {
+						record[i] = byte(w)<<4 | byte(seq)&0xf
+					}
+					if _, err := conn.Write(record); err != nil {
+						errCh <- err
+						return
+					}
+				}
+				errCh <- nil
+			}(w)
+		}
+		return errCh
+	}
+
+	// read reads the records of all writers and checks that each is contiguous and in the order of its writer.
+	read := func(conn net.Conn) <-chan error {
+		errCh := make(chan error, 1)
+		go func() {
+			next := make([]int, writers)
+			for n := 0; n < writers*records; n++ {
+				head := make([]byte, header)
+				if _, err := io.ReadFull(conn, head); err != nil {
+					errCh <- err
+					return
+				}
+				w, seq := int(head[0]), int(binary.BigEndian.Uint16(head[1:]))
+				if w >= writers || seq != next[w] {
+					errCh <- fmt.Errorf("record %d: got writer %d record %d out of order", n, w, seq)
+					return
+				}
+				next[w]++
+				payload := make([]byte, binary.BigEndian.Uint32(head[3:]))
+				if _, err := io.ReadFull(conn, payload); err != nil {
+					errCh <- err
+					return
+				}
+				want := bytes.Repeat([]byte{byte(w)<<4 | byte(seq)&0xf}, len(payload))
+				if !bytes.Equal(want, payload) {
+					errCh <- fmt.Errorf("record %d of writer %d is interleaved with other data", seq, w)
+					return
+				}
+			}
+			errCh <- nil
+		}()
+		return errCh
+	}
+
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	write1, write2 := write(conn1, rnd), write(conn2, rnd)
+	read1, read2 := read(conn1), read(conn2)
+	for _, errCh := range []<-chan error{read1, read2} {
+		select {
+		case err := <-errCh:
+			require.NoError(t, err)
+		case <-time.After(30 * time.Second):
+			t.Fatal("timed out reading the records")
+		}
+	}
+	for i := 0; i < writers; i++ {
+		require.NoError(t, <-write1)
+		require.NoError(t, <-write2)
+	}
+}
+
+func TestAppConnCopy(t *testing.T) {
+	data := make([]byte, 10*MaxPayloadSize+1)
+	rand.Read(data) // nolint:gosec
+
+	t.Run("ReadFrom", func(t *testing.T) {
+		p1, p2 := loopPipe()
+		conn := newAppConn(p1, routing.Addr{Port: 1}, routing.Addr{Port: 2})
+
+		readCh := make(chan []byte, 1)
+		go func() {
+			b, err := ioutil.ReadAll(p2)
+			assert.NoError(t, err)
+			readCh <- b
+		}()
+
+		// chunkReader checks that conn reads in chunks of MaxPayloadSize.
+		n, err := io.Copy(conn, chunkReader{t, bytes.NewReader(data)})
+		require.NoError(t, err)
+		assert.Equal(t, int64(len(data)), n)
+		require.NoError(t, conn.Close())
+		assert.Equal(t, data, <-readCh)
+	})
+
+	t.Run("WriteTo", func(t *testing.T) {
+		p1, p2 := loopPipe()
+		conn := newAppConn(p1, routing.Addr{Port: 1}, routing.Addr{Port: 2})
+
+		go func() {
+			_, err := p2.Write(data)
+			assert.NoError(t, err)
+			assert.NoError(t, p2.Close())
+		}()
+
+		var buf bytes.Buffer
+		n, err := io.Copy(&buf, conn)
+		require.NoError(t, err)
+		assert.Equal(t, int64(len(data)), n)
+		assert.Equal(t, data, buf.Bytes())
+		require.NoError(t, conn.Close())
+	})
+
+	t.Run("Deadlines", func(t *testing.T) {
+		p1, p2 := loopPipe()
+		conn := newAppConn(p1, routing.Addr{Port: 1}, routing.Addr{Port: 2})
+
+		// Nothing is written by the other end.
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(100*time.Millisecond)))
+		_, err := conn.WriteTo(ioutil.Discard)
+		require.Error(t, err)
+		assert.True(t, err.(net.Error).Timeout())
+
+		// Nothing is read by the other end, so the pipe fills up.
+		require.NoError(t, conn.SetWriteDeadline(time.Now().Add(100*time.Millisecond)))
+		n, err := conn.ReadFrom(bytes.NewReader(data))
+		require.Error(t, err)
+		assert.True(t, err.(net.Error).Timeout())
+		assert.Equal(t, int64(loopPipeSize), n)
+
+		require.NoError(t, conn.Close())
+		require.NoError(t, p2.Close())
+	})
+}
+
+type chunkReader struct {
+	t *testing.T
+	r io.Reader
+}
+
+func (r chunkReader) Read(b []byte) (int, error) {
+	assert.Len(r.t, b, MaxPayloadSize)
+	return r.r.Read(b)
+}
+
+// Compare io.Copy through its own buffer with io.Copy using appConn.ReadFrom and appConn.WriteTo.
+func BenchmarkAppConnCopy(b *testing.B) {
+	data := make([]byte, 1<<20)
+
+	// plain hides io.ReaderFrom and io.WriterTo from io.Copy.
+	type plainReader struct{ io.Reader }
+	type plainWriter struct{ io.Writer }
+
+	readFrom := func(b *testing.B, optimized bool) {
+		b.SetBytes(int64(len(data)))
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			p1, p2 := loopPipe()
+			conn := newAppConn(p1, routing.Addr{Port: 1}, routing.Addr{Port: 2})
+			done := make(chan struct{})
+			go func() {
+				buf := make([]byte, loopPipeSize)
+				for {
+					if _, err := p2.Read(buf); err != nil {
+						close(done)
+						return
+					}
+				}
+			}()
+
+			var dst io.Writer = conn
+			if !optimized {
+				dst = plainWriter{conn}
+			}
+			if _, err := io.Copy(dst, plainReader{bytes.NewReader(data)}); err != nil {
+				b.Fatal(err)
+			}
+			if err := conn.Close(); err != nil {
+				b.Fatal(err)
+			}
+			<-done
+		}
+	}
+
+	writeTo := func(b *testing.B, optimized bool) {
+		b.SetBytes(int64(len(data)))
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			p1, p2 := loopPipe()
+			conn := newAppConn(p1, routing.Addr{Port: 1}, routing.Addr{Port: 2})
+			go func() {
+				_, _ = p2.Write(data) // nolint:errcheck
+				_ = p2.Close()        // nolint:errcheck
+			}()
+
+			var src io.Reader = conn
+			if !optimized {
+				src = plainReader{conn}
+			}
+			if _, err := io.Copy(plainWriter{ioutil.Discard}, src); err != nil {
+				b.Fatal(err)
+			}
+			if err := conn.Close(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+
+	b.Run("ReadFrom/io.Copy", func(b *testing.B) { readFrom(b, false) })
+	b.Run("ReadFrom/optimized", func(b *testing.B) { readFrom(b, true) })
+	b.Run("WriteTo/io.Copy", func(b *testing.B) { writeTo(b, false) })
+	b.Run("WriteTo/optimized", func(b *testing.B) { writeTo(b, true) })
+}
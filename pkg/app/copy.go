@@ -0,0 +1,49 @@
+package app
+
+import (
+	"context"
+	"io"
+)
+
+// CopyChunked copies from src to dst in chunks of at most chunkSize bytes until src returns io.EOF or ctx is done.
+// Chunk sizes that are not positive or exceed MaxPayloadSize are set to MaxPayloadSize,
+// so that every chunk written to an app connection is sent in a single packet.
+// If progress is not nil, it is called with the total number of bytes written after every chunk.
+// It returns the number of bytes written and the first error encountered, which is ctx.Err() on cancellation.
+func CopyChunked(ctx context.Context, dst io.Writer, src io.Reader, chunkSize int, progress func(written int64)) (int64, error) {
+	if chunkSize <= 0 || chunkSize > MaxPayloadSize {
+		chunkSize = MaxPayloadSize
+	}
+
+	buf := make([]byte, chunkSize)
+	var written int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return written, err
+		}
+
+		nr, rErr := src.Read(buf)
+		if nr > 0 {
+			if err := ctx.Err(); err != nil {
+				return written, err
+			}
+			nw, wErr := dst.Write(buf[:nr])
+			written += int64(nw)
+			if wErr != nil {
+				return written, wErr
+			}
+			if nw != nr {
+				return written, io.ErrShortWrite
+			}
+			if progress != nil {
+				progress(written)
+			}
+		}
+		if rErr == io.EOF {
+			return written, nil
+		}
+		if rErr != nil {
+			return written, rErr
+		}
+	}
+}
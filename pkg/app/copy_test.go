@@ -0,0 +1,74 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCopyChunked(t *testing.T) {
+	src := bytes.Repeat([]byte("chunked transfer "), 10000)
+
+	t.Run("copies_all", func(t *testing.T) {
+		var dst bytes.Buffer
+		var calls int
+		var last int64
+
+		n, err := CopyChunked(context.TODO(), &dst, bytes.NewReader(src), 1000, func(written int64) {
+			calls++
+			last = written
+		})
+		require.NoError(t, err)
+		assert.Equal(t, int64(len(src)), n)
+		assert.Equal(t, src, dst.Bytes())
+		assert.Equal(t, (len(src)+999)/1000, calls)
+		assert.Equal(t, n, last)
+	})
+
+	t.Run("caps_chunk_size", func(t *testing.T) {
+		w := &maxWriter{}
+		n, err := CopyChunked(context.TODO(), w, bytes.NewReader(src), 0, nil)
+		require.NoError(t, err)
+		assert.Equal(t, int64(len(src)), n)
+		assert.Equal(t, MaxPayloadSize, w.max)
+	})
+
+	t.Run("cancelled_mid_transfer", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		var dst bytes.Buffer
+		n, err := CopyChunked(ctx, &dst, bytes.NewReader(src), 1000, func(written int64) {
+			if written >= 5000 {
+				cancel()
+			}
+		})
+		assert.Equal(t, context.Canceled, err)
+		assert.Equal(t, int64(5000), n)
+		assert.Equal(t, 5000, dst.Len())
+	})
+
+	t.Run("write_error", func(t *testing.T) {
+		errWrite := errors.New("write failed")
+		n, err := CopyChunked(context.TODO(), errWriter{errWrite}, bytes.NewReader(src), 1000, nil)
+		assert.Equal(t, errWrite, err)
+		assert.Equal(t, int64(0), n)
+	})
+}
+
+type maxWriter struct{ max int }
+
+func (w *maxWriter) Write(p []byte) (int, error) {
+	if len(p) > w.max {
+		w.max = len(p)
+	}
+	return len(p), nil
+}
+
+type errWriter struct{ err error }
+
+func (w errWriter) Write([]byte) (int, error) { return 0, w.err }
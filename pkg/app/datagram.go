@@ -0,0 +1,221 @@
+package app
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/SkycoinProject/skywire-mainnet/pkg/routing"
+)
+
+// MaxDatagramSize is the maximum number of bytes of a datagram.
+const MaxDatagramSize = 16 * 1024
+
+// datagramHeaderSize is the size of the length prefix of a datagram on a loop.
+const datagramHeaderSize = 2
+
+var (
+	// ErrDatagramTooLarge is returned when a datagram exceeds MaxDatagramSize.
+	ErrDatagramTooLarge = errors.New("datagram is too large")
+
+	// ErrDatagramsClosed is returned by Datagrams once it is closed.
+	ErrDatagramsClosed = errors.New("datagrams are closed")
+)
+
+// DatagramHandler handles a datagram received from a remote app.
+// p is only valid until the handler returns.
+type DatagramHandler func(from routing.Addr, p []byte)
+
+// Datagrams exchanges small messages with remote apps without opening a loop per message.
+// All datagrams exchanged with a remote app are framed and multiplexed over a single loop,
+// which is dialed by the first SendTo to the remote or adopted with Serve.
+// Replies to a received datagram can thus be sent with SendTo over the loop the datagram was received on,
+// which suits request-response apps.
+type Datagrams struct {
+	dial    func(raddr routing.Addr) (net.Conn, error)
+	handler DatagramHandler
+
+	conns map[routing.Addr]*datagramConn
+	mu    sync.Mutex
+	wg    sync.WaitGroup
+
+	done chan struct{}
+	once sync.Once
+}
+
+// Datagrams returns Datagrams which dials loops of the app and delivers received datagrams to handler.
+// Loops accepted by the app which carry datagrams have to be passed to Datagrams.Serve.
+func (app *App) Datagrams(handler DatagramHandler) *Datagrams {
+	return newDatagrams(app.Dial, handler)
+}
+
+func newDatagrams(dial func(raddr routing.Addr) (net.Conn, error), handler DatagramHandler) *Datagrams {
+	return &Datagrams{
+		dial:    dial,
+		handler: handler,
+		conns:   make(map[routing.Addr]*datagramConn),
+		done:    make(chan struct{}),
+	}
+}
+
+// SendTo sends a datagram to the remote app at raddr, dialing a loop to it if there is none yet.
+// It returns ErrDatagramTooLarge if p exceeds MaxDatagramSize.
+func (d *Datagrams) SendTo(raddr routing.Addr, p []byte) error {
+	if len(p) > MaxDatagramSize {
+		return ErrDatagramTooLarge
+	}
+
+	conn, err := d.conn(raddr)
+	if err != nil {
+		return err
+	}
+	if err := conn.send(p); err != nil {
+		d.drop(conn)
+		return err
+	}
+	return nil
+}
+
+// Serve reads datagrams from a loop accepted by the app until the loop or d is closed.
+// Datagrams sent to the remote of the loop afterwards are sent over it.
+func (d *Datagrams) Serve(conn net.Conn) error {
+	raddr, ok := conn.RemoteAddr().(routing.Addr)
+	if !ok {
+		return fmt.Errorf("remote address of loop is not a routing.Addr: %v", conn.RemoteAddr())
+	}
+
+	dc := &datagramConn{Conn: conn, raddr: raddr}
+	d.mu.Lock()
+	if d.isClosed() {
+		d.mu.Unlock()
+		return ErrDatagramsClosed
+	}
+	old := d.conns[raddr]
+	d.conns[raddr] = dc
+	d.wg.Add(1)
+	d.mu.Unlock()
+
+	if old != nil {
+		d.drop(old)
+	}
+	go d.serve(dc)
+	return nil
+}
+
+// Close closes the loops of d.
+func (d *Datagrams) Close() error {
+	d.once.Do(func() {
+		d.mu.Lock()
+		close(d.done)
+		for _, conn := range d.conns {
+			if err := conn.Close(); err != nil {
+				log.WithError(err).Warn("Failed to close datagram loop")
+			}
+		}
+		d.conns = make(map[routing.Addr]*datagramConn)
+		d.mu.Unlock()
+
+		d.wg.Wait()
+	})
+	return nil
+}
+
+func (d *Datagrams) isClosed() bool {
+	select {
+	case <-d.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (d *Datagrams) conn(raddr routing.Addr) (*datagramConn, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.isClosed() {
+		return nil, ErrDatagramsClosed
+	}
+	if conn, ok := d.conns[raddr]; ok {
+		return conn, nil
+	}
+
+	conn, err := d.dial(raddr)
+	if err != nil {
+		return nil, err
+	}
+	dc := &datagramConn{Conn: conn, raddr: raddr}
+	d.conns[raddr] = dc
+	d.wg.Add(1)
+	go d.serve(dc)
+	return dc, nil
+}
+
+// drop closes conn and forgets it, so that the next SendTo to its remote dials a new loop.
+func (d *Datagrams) drop(conn *datagramConn) {
+	d.mu.Lock()
+	if d.conns[conn.raddr] == conn {
+		delete(d.conns, conn.raddr)
+	}
+	d.mu.Unlock()
+
+	if err := conn.Close(); err != nil {
+		log.WithError(err).Warn("Failed to close datagram loop")
+	}
+}
+
+func (d *Datagrams) serve(conn *datagramConn) {
+	defer d.wg.Done()
+	defer d.drop(conn)
+
+	buf := make([]byte, MaxDatagramSize)
+	for {
+		n, err := conn.recv(buf)
+		if err != nil {
+			if err != io.EOF && err != ErrLoopClosed && !d.isClosed() {
+				log.WithError(err).Warnf("Failed to read datagram from %s", conn.raddr)
+			}
+			return
+		}
+		d.handler(conn.raddr, buf[:n])
+	}
+}
+
+// datagramConn frames datagrams on a loop, each datagram is prefixed with its length.
+type datagramConn struct {
+	net.Conn
+	raddr routing.Addr
+	wMx   sync.Mutex
+}
+
+func (conn *datagramConn) send(p []byte) error {
+	frame := make([]byte, datagramHeaderSize+len(p))
+	binary.BigEndian.PutUint16(frame, uint16(len(p)))
+	copy(frame[datagramHeaderSize:], p)
+
+	conn.wMx.Lock()
+	defer conn.wMx.Unlock()
+	_, err := conn.Write(frame)
+	return err
+}
+
+func (conn *datagramConn) recv(buf []byte) (int, error) {
+	var hdr [datagramHeaderSize]byte
+	if _, err := io.ReadFull(conn, hdr[:]); err != nil {
+		return 0, err
+	}
+	n := int(binary.BigEndian.Uint16(hdr[:]))
+	if n > len(buf) {
+		return 0, ErrDatagramTooLarge
+	}
+	if _, err := io.ReadFull(conn, buf[:n]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, err
+	}
+	return n, nil
+}
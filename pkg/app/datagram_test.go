@@ -0,0 +1,69 @@
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/SkycoinProject/dmsg/cipher"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/SkycoinProject/skywire-mainnet/pkg/routing"
+)
+
+func TestDatagrams(t *testing.T) {
+	pk1, _ := cipher.GenerateKeyPair()
+	pk2, _ := cipher.GenerateKeyPair()
+	addr1 := routing.Addr{PubKey: pk1, Port: 10}
+	addr2 := routing.Addr{PubKey: pk2, Port: 20}
+
+	// Server echoes every datagram back in upper case.
+	var server *Datagrams
+	server = newDatagrams(nil, func(from routing.Addr, p []byte) {
+		assert.Equal(t, addr1, from)
+		assert.NoError(t, server.SendTo(from, bytes.ToUpper(p)))
+	})
+	defer func() { require.NoError(t, server.Close()) }()
+
+	// Client dials loops to the server over in-memory pipes.
+	var dials int32
+	replies := make(chan string, 10)
+	client := newDatagrams(func(raddr routing.Addr) (net.Conn, error) {
+		atomic.AddInt32(&dials, 1)
+		require.Equal(t, addr2, raddr)
+
+		c1, c2 := loopPipe()
+		require.NoError(t, server.Serve(newAppConn(c2, addr2, addr1)))
+		return newAppConn(c1, addr1, addr2), nil
+	}, func(from routing.Addr, p []byte) {
+		assert.Equal(t, addr2, from)
+		replies <- string(p)
+	})
+	defer func() { require.NoError(t, client.Close()) }()
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, client.SendTo(addr2, []byte(fmt.Sprintf("request %d", i))))
+	}
+	for i := 0; i < 5; i++ {
+		select {
+		case reply := <-replies:
+			assert.Equal(t, fmt.Sprintf("REQUEST %d", i), reply)
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for reply %d", i)
+		}
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&dials), "datagrams should share a single loop")
+
+	assert.Equal(t, ErrDatagramTooLarge, client.SendTo(addr2, make([]byte, MaxDatagramSize+1)))
+	require.NoError(t, client.SendTo(addr2, make([]byte, MaxDatagramSize)))
+	select {
+	case reply := <-replies:
+		assert.Len(t, reply, MaxDatagramSize)
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for reply to datagram of maximum size")
+	}
+}
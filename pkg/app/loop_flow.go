@@ -0,0 +1,188 @@
+package app
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// loopPauseSize is the number of unread bytes of a loop at which the remote app is asked to stop sending.
+	loopPauseSize = loopPipeSize / 2
+
+	// loopResumeSize is the number of unread bytes of a loop at which the remote app may send again.
+	loopResumeSize = loopPipeSize / 4
+
+	// loopSignalRetry is how long a loopFlow waits before it retries telling the remote app of its state.
+	loopSignalRetry = time.Second
+)
+
+// loopFlow is the pause state of a loop. Its methods are safe to call on nil, a nil loopFlow is never paused.
+//
+// The remote app is asked to pause the loop while it is paused locally or while loopPauseSize bytes of the loop
+// are unread, until no more than loopResumeSize bytes are unread. This bounds the data buffered for a slow reader.
+// If the remote app cannot be told, it is told again every retry until it is, or the loop is closed.
+type loopFlow struct {
+	sigMu sync.Mutex // serializes changes of the state signalled to the remote app
+
+	mu         sync.Mutex
+	paused     bool          // paused locally, data of the loop is not delivered to Read
+	full       bool          // too much data of the loop is unread
+	signalled  bool          // the remote app was last asked to pause
+	unsynced   bool          // telling the remote app failed, it may not know the state
+	retrying   bool          // a retry of telling the remote app is scheduled
+	peerPaused bool          // paused by the remote app, Write waits
+	ended      bool          // the loop is closed, nothing waits anymore
+	changed    chan struct{} // closed and replaced whenever the state changes
+
+	signal func(paused bool) error // tells the remote app that the loop is paused or resumed
+	retry  time.Duration
+}
+
+func newLoopFlow(signal func(paused bool) error) *loopFlow {
+	return &loopFlow{changed: make(chan struct{}), signal: signal, retry: loopSignalRetry}
+}
+
+// setPaused sets the local pause state and tells the remote app of it.
+// The state is changed even if the remote app cannot be told, telling it is then retried.
+func (f *loopFlow) setPaused(paused bool) error {
+	if f == nil {
+		return nil
+	}
+	return f.change(func() {
+		if f.paused != paused {
+			f.paused = paused
+			f.notify()
+		}
+	})
+}
+
+// updateUnread pauses or resumes the remote app depending on the number of unread bytes of the loop.
+// unread is called with the state locked, so that concurrent updates leave the state of the last one.
+func (f *loopFlow) updateUnread(unread func() int) error {
+	if f == nil {
+		return nil
+	}
+	return f.change(func() {
+		switch n := unread(); {
+		case n >= loopPauseSize:
+			f.full = true
+		case n <= loopResumeSize:
+			f.full = false
+		}
+	})
+}
+
+// change changes the state with fn and tells the remote app if that pauses or resumes the loop,
+// or if the remote app may not know the state since telling it failed before.
+// The remote app is only considered told once signal succeeds, otherwise a retry is scheduled.
+func (f *loopFlow) change(fn func()) error {
+	f.sigMu.Lock()
+	defer f.sigMu.Unlock()
+
+	f.mu.Lock()
+	if f.ended {
+		f.mu.Unlock()
+		return nil
+	}
+	fn()
+	pause := f.paused || f.full
+	if pause == f.signalled && !f.unsynced {
+		f.mu.Unlock()
+		return nil
+	}
+	f.mu.Unlock()
+
+	err := f.signal(pause)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err != nil {
+		f.unsynced = true
+		if !f.retrying && !f.ended {
+			f.retrying = true
+			time.AfterFunc(f.retry, f.retrySignal)
+		}
+		return err
+	}
+	f.signalled, f.unsynced = pause, false
+	return nil
+}
+
+// retrySignal tells the remote app of the state again after telling it failed.
+func (f *loopFlow) retrySignal() {
+	f.mu.Lock()
+	f.retrying = false
+	f.mu.Unlock()
+
+	if err := f.change(func() {}); err != nil {
+		log.WithError(err).Warn("Failed to signal loop state, retrying")
+	}
+}
+
+func (f *loopFlow) setPeerPaused(paused bool) {
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	f.peerPaused = paused
+	f.notify()
+	f.mu.Unlock()
+}
+
+// end releases everything waiting on f once the loop is closed.
+func (f *loopFlow) end() {
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	f.ended = true
+	f.notify()
+	f.mu.Unlock()
+}
+
+// state returns whether the loop is paused locally and by the remote app.
+func (f *loopFlow) state() (paused, peerPaused bool) {
+	if f == nil {
+		return false, false
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.paused, f.peerPaused
+}
+
+// notify wakes up waiters. f.mu must be held.
+func (f *loopFlow) notify() {
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+// awaitResumed blocks while the loop is paused locally. It returns false if done is closed first.
+func (f *loopFlow) awaitResumed(done <-chan struct{}) bool {
+	return f.await(done, func() bool { return f.paused })
+}
+
+// awaitPeerResumed blocks while the loop is paused by the remote app. It returns false if done is closed first.
+func (f *loopFlow) awaitPeerResumed(done <-chan struct{}) bool {
+	return f.await(done, func() bool { return f.peerPaused })
+}
+
+func (f *loopFlow) await(done <-chan struct{}, blocked func() bool) bool {
+	if f == nil {
+		return true
+	}
+	for {
+		f.mu.Lock()
+		if f.ended || !blocked() {
+			f.mu.Unlock()
+			return true
+		}
+		changed := f.changed
+		f.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-done:
+			return false
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package app
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyPeer records the pause state told to a remote app, failing the first fails signals.
+type flakyPeer struct {
+	mu      sync.Mutex
+	fails   int
+	calls   int
+	paused  bool
+	resumed chan struct{}
+}
+
+func (p *flakyPeer) signal(paused bool) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.calls++
+	if p.fails > 0 {
+		p.fails--
+		return errors.New("setup node unreachable")
+	}
+	p.paused = paused
+	if !paused && p.resumed != nil {
+		close(p.resumed)
+		p.resumed = nil
+	}
+	return nil
+}
+
+func (p *flakyPeer) state() (paused bool, calls int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.paused, p.calls
+}
+
+func TestLoopFlow_SignalFails(t *testing.T) {
+	t.Run("resume_is_retried", func(t *testing.T) {
+		peer := &flakyPeer{resumed: make(chan struct{})}
+		f := newLoopFlow(peer.signal)
+		f.retry = 10 * time.Millisecond
+
+		require.NoError(t, f.setPaused(true))
+		paused, _ := peer.state()
+		require.True(t, paused)
+
+		// Resuming fails twice. The remote app is told again without further changes of the state.
+		peer.mu.Lock()
+		peer.fails = 2
+		resumed := peer.resumed
+		peer.mu.Unlock()
+		assert.Error(t, f.setPaused(false))
+
+		select {
+		case <-resumed:
+		case <-time.After(time.Second):
+			t.Fatal("the failed resume was not retried")
+		}
+		paused, calls := peer.state()
+		assert.False(t, paused)
+		assert.Equal(t, 4, calls)
+
+		// Once the remote app knows the state, it is not told again.
+		time.Sleep(5 * f.retry)
+		_, after := peer.state()
+		assert.Equal(t, calls, after)
+	})
+
+	t.Run("state_resent_after_failure", func(t *testing.T) {
+		peer := &flakyPeer{fails: 1}
+		f := newLoopFlow(peer.signal)
+		f.retry = time.Hour
+
+		// A failed pause is not considered told: undoing it still tells the remote app,
+		// which may have received the pause.
+		assert.Error(t, f.setPaused(true))
+		require.NoError(t, f.setPaused(false))
+		paused, calls := peer.state()
+		assert.False(t, paused)
+		assert.Equal(t, 2, calls)
+
+		require.NoError(t, f.setPaused(false))
+		_, calls = peer.state()
+		assert.Equal(t, 2, calls)
+	})
+
+	t.Run("no_retry_after_end", func(t *testing.T) {
+		peer := &flakyPeer{fails: 1}
+		f := newLoopFlow(peer.signal)
+		f.retry = 10 * time.Millisecond
+
+		assert.Error(t, f.setPaused(true))
+		f.end()
+		time.Sleep(5 * f.retry)
+		_, calls := peer.state()
+		assert.Equal(t, 1, calls)
+	})
+}
@@ -0,0 +1,365 @@
+package app
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// loopPipeSize is the maximum number of unread bytes queued in each direction of a loop pipe.
+const loopPipeSize = 4 * MaxPayloadSize
+
+// loopPipe creates an in-memory, full duplex network connection like net.Pipe,
+// connecting a loop conn of an app to the app's end of the loop.
+//
+// Unlike net.Pipe, a Write returns once its data is queued, and a Read returns as much of the queued data
+// as fits into its buffer. Several payloads received for a loop can thus be read at once, and data that does not
+// fit is left for the next Read. Writes block while loopPipeSize bytes are queued.
+//
+// Concurrent Writes are performed one at a time and in the order they are called, so the data of a Write
+// is never interleaved with that of another and a goroutine which keeps writing does not starve the others.
+func loopPipe() (net.Conn, net.Conn) {
+	q1, q2 := newPipeQueue(), newPipeQueue()
+	return newLoopPipeConn(q1, q2), newLoopPipeConn(q2, q1)
+}
+
+// pipeQueue carries one direction of a loop pipe.
+type pipeQueue struct {
+	mu     sync.Mutex
+	buf    []byte
+	closed bool
+
+	readable chan struct{} // signalled when data is queued
+	writable chan struct{} // signalled when data is consumed
+	done     chan struct{} // closed once either end of the pipe is closed
+	once     sync.Once
+}
+
+func newPipeQueue() *pipeQueue {
+	return &pipeQueue{
+		readable: make(chan struct{}, 1),
+		writable: make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+}
+
+func (q *pipeQueue) read(b []byte) (n int, closed bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	n = copy(b, q.buf)
+	q.buf = q.buf[n:]
+	if len(q.buf) == 0 {
+		q.buf = nil
+	}
+	if n > 0 {
+		notify(q.writable)
+	}
+	return n, q.closed
+}
+
+func (q *pipeQueue) write(b []byte) (n int, closed bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return 0, true
+	}
+	n = len(b)
+	if free := loopPipeSize - len(q.buf); n > free {
+		n = free
+	}
+	q.buf = append(q.buf, b[:n]...)
+	if n > 0 {
+		notify(q.readable)
+	}
+	return n, false
+}
+
+// take removes all queued bytes and returns them without copying.
+func (q *pipeQueue) take() (b []byte, closed bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	// Later writes append to a new buffer, so b is not written to anymore.
+	b, q.buf = q.buf, nil
+	if len(b) > 0 {
+		notify(q.writable)
+	}
+	return b, q.closed
+}
+
+// len returns the number of unread bytes.
+func (q *pipeQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.buf)
+}
+
+func (q *pipeQueue) close() {
+	q.once.Do(func() {
+		q.mu.Lock()
+		q.closed = true
+		q.mu.Unlock()
+		close(q.done)
+	})
+}
+
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// pipeDeadline is a read or write deadline of a loop pipe conn.
+type pipeDeadline struct {
+	mu      sync.Mutex
+	t       time.Time
+	changed chan struct{} // signalled when the deadline is set
+}
+
+func (d *pipeDeadline) set(t time.Time) {
+	d.mu.Lock()
+	d.t = t
+	d.mu.Unlock()
+	notify(d.changed)
+}
+
+func (d *pipeDeadline) get() time.Time {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.t
+}
+
+func (d *pipeDeadline) exceeded() bool {
+	t := d.get()
+	return !t.IsZero() && !time.Now().Before(t)
+}
+
+// wait blocks until ready is signalled, either end of the pipe is closed, the deadline passes or is changed.
+func (d *pipeDeadline) wait(ready, localDone, remoteDone <-chan struct{}) {
+	var timeout <-chan time.Time
+	if t := d.get(); !t.IsZero() {
+		timer := time.NewTimer(time.Until(t))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case <-ready:
+	case <-localDone:
+	case <-remoteDone:
+	case <-d.changed:
+	case <-timeout:
+	}
+}
+
+// pipeTimeoutError is returned by operations on a loop pipe conn after its deadline passed.
+type pipeTimeoutError struct{}
+
+func (pipeTimeoutError) Error() string   { return "i/o timeout" }
+func (pipeTimeoutError) Timeout() bool   { return true }
+func (pipeTimeoutError) Temporary() bool { return true }
+
+// fifoMutex is a mutual exclusion lock which is granted in the order Lock is called.
+// Unlike with sync.Mutex, a goroutine which unlocks and locks again in a loop queues up behind the waiting ones.
+type fifoMutex struct {
+	mu      sync.Mutex
+	locked  bool
+	waiters []chan struct{}
+}
+
+func (m *fifoMutex) Lock() {
+	m.mu.Lock()
+	if !m.locked {
+		m.locked = true
+		m.mu.Unlock()
+		return
+	}
+	ch := make(chan struct{})
+	m.waiters = append(m.waiters, ch)
+	m.mu.Unlock()
+	<-ch
+}
+
+// Unlock passes the lock to the longest waiting goroutine, if any.
+func (m *fifoMutex) Unlock() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.waiters) == 0 {
+		m.locked = false
+		return
+	}
+	ch := m.waiters[0]
+	m.waiters = m.waiters[1:]
+	close(ch)
+}
+
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "pipe" }
+
+type loopPipeConn struct {
+	rx, tx *pipeQueue
+
+	rMu       sync.Mutex // serializes reads
+	wMu       fifoMutex  // serializes writes in the order they are called
+	rDeadline *pipeDeadline
+	wDeadline *pipeDeadline
+
+	done chan struct{} // closed once this end is closed
+	once sync.Once
+}
+
+func newLoopPipeConn(rx, tx *pipeQueue) *loopPipeConn {
+	return &loopPipeConn{
+		rx:        rx,
+		tx:        tx,
+		rDeadline: &pipeDeadline{changed: make(chan struct{}, 1)},
+		wDeadline: &pipeDeadline{changed: make(chan struct{}, 1)},
+		done:      make(chan struct{}),
+	}
+}
+
+func (c *loopPipeConn) isClosed() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Read implements io.Reader. It returns io.EOF once the other end is closed and all queued data is read.
+func (c *loopPipeConn) Read(b []byte) (int, error) {
+	c.rMu.Lock()
+	defer c.rMu.Unlock()
+
+	for {
+		if c.isClosed() {
+			return 0, io.ErrClosedPipe
+		}
+		if c.rDeadline.exceeded() {
+			return 0, pipeTimeoutError{}
+		}
+		if len(b) == 0 {
+			return 0, nil
+		}
+
+		n, closed := c.rx.read(b)
+		if n > 0 {
+			return n, nil
+		}
+		if closed {
+			return 0, io.EOF
+		}
+		c.rDeadline.wait(c.rx.readable, c.done, c.rx.done)
+	}
+}
+
+// readQueued blocks like Read, but returns all queued data instead of copying it into a buffer.
+// The returned slice is owned by the caller.
+func (c *loopPipeConn) readQueued() ([]byte, error) {
+	c.rMu.Lock()
+	defer c.rMu.Unlock()
+
+	for {
+		if c.isClosed() {
+			return nil, io.ErrClosedPipe
+		}
+		if c.rDeadline.exceeded() {
+			return nil, pipeTimeoutError{}
+		}
+
+		b, closed := c.rx.take()
+		if len(b) > 0 {
+			return b, nil
+		}
+		if closed {
+			return nil, io.EOF
+		}
+		c.rDeadline.wait(c.rx.readable, c.done, c.rx.done)
+	}
+}
+
+// Write implements io.Writer.
+func (c *loopPipeConn) Write(b []byte) (int, error) {
+	c.wMu.Lock()
+	defer c.wMu.Unlock()
+
+	var written int
+	for {
+		if c.isClosed() {
+			return written, io.ErrClosedPipe
+		}
+		if c.wDeadline.exceeded() {
+			return written, pipeTimeoutError{}
+		}
+
+		n, closed := c.tx.write(b[written:])
+		if closed {
+			return written, io.ErrClosedPipe
+		}
+		if written += n; written == len(b) {
+			return written, nil
+		}
+		c.wDeadline.wait(c.tx.writable, c.done, c.tx.done)
+	}
+}
+
+// unread returns the number of bytes written to c which were not read yet.
+func (c *loopPipeConn) unread() int {
+	return c.rx.len()
+}
+
+// unreadByPeer returns the number of bytes written by c which were not read yet.
+func (c *loopPipeConn) unreadByPeer() int {
+	return c.tx.len()
+}
+
+// Close implements io.Closer.
+func (c *loopPipeConn) Close() error {
+	c.once.Do(func() {
+		close(c.done)
+		c.rx.close()
+		c.tx.close()
+	})
+	return nil
+}
+
+func (c *loopPipeConn) LocalAddr() net.Addr {
+	return pipeAddr{}
+}
+
+func (c *loopPipeConn) RemoteAddr() net.Addr {
+	return pipeAddr{}
+}
+
+func (c *loopPipeConn) SetDeadline(t time.Time) error {
+	if c.isClosed() {
+		return io.ErrClosedPipe
+	}
+	c.rDeadline.set(t)
+	c.wDeadline.set(t)
+	return nil
+}
+
+func (c *loopPipeConn) SetReadDeadline(t time.Time) error {
+	if c.isClosed() {
+		return io.ErrClosedPipe
+	}
+	c.rDeadline.set(t)
+	return nil
+}
+
+func (c *loopPipeConn) SetWriteDeadline(t time.Time) error {
+	if c.isClosed() {
+		return io.ErrClosedPipe
+	}
+	c.wDeadline.set(t)
+	return nil
+}
@@ -0,0 +1,148 @@
+package app
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoopPipe(t *testing.T) {
+	t.Run("full_buffer", func(t *testing.T) {
+		c1, c2 := loopPipe()
+		defer func() {
+			assert.NoError(t, c1.Close())
+			assert.NoError(t, c2.Close())
+		}()
+
+		// Writes larger than the queue block until the data is read.
+		msg := bytes.Repeat([]byte("skywire"), loopPipeSize/3)
+		errCh := make(chan error, 1)
+		go func() {
+			_, err := c1.Write(msg)
+			errCh <- err
+		}()
+
+		got := make([]byte, len(msg))
+		_, err := io.ReadFull(c2, got)
+		require.NoError(t, err)
+		assert.Equal(t, msg, got)
+		require.NoError(t, <-errCh)
+	})
+
+	t.Run("close", func(t *testing.T) {
+		c1, c2 := loopPipe()
+
+		_, err := c1.Write([]byte("foo"))
+		require.NoError(t, err)
+		require.NoError(t, c1.Close())
+
+		// Queued data is still read after the other end is closed.
+		got, err := ioutil.ReadAll(c2)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("foo"), got)
+
+		_, err = c2.Write([]byte("bar"))
+		assert.Equal(t, io.ErrClosedPipe, err)
+		_, err = c1.Read(make([]byte, 1))
+		assert.Equal(t, io.ErrClosedPipe, err)
+		require.NoError(t, c2.Close())
+	})
+
+	t.Run("deadline", func(t *testing.T) {
+		c1, c2 := loopPipe()
+		defer func() {
+			assert.NoError(t, c1.Close())
+			assert.NoError(t, c2.Close())
+		}()
+
+		require.NoError(t, c2.SetReadDeadline(time.Now().Add(50*time.Millisecond)))
+		_, err := c2.Read(make([]byte, 1))
+		require.Error(t, err)
+		nErr, ok := err.(net.Error)
+		require.True(t, ok)
+		assert.True(t, nErr.Timeout())
+
+		require.NoError(t, c2.SetReadDeadline(time.Time{}))
+		_, err = c1.Write([]byte("foo"))
+		require.NoError(t, err)
+		got := make([]byte, 3)
+		_, err = io.ReadFull(c2, got)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("foo"), got)
+	})
+}
+
+// Concurrent writers to a full pipe are served in turn, none of them is starved.
+func TestLoopPipe_WriteFairness(t *testing.T) {
+	const (
+		writers = 8
+		writes  = 20
+		size    = MaxPayloadSize
+	)
+
+	c1, c2 := loopPipe()
+	defer func() {
+		assert.NoError(t, c1.Close())
+		assert.NoError(t, c2.Close())
+	}()
+
+	errCh := make(chan error, writers)
+	for w := 0; w < writers; w++ {
+		go func(w byte) {
+			payload := bytes.Repeat([]byte{w}, size)
+			for i := 0; i < writes; i++ {
+				if _, err := c1.Write(payload); err != nil {
+					errCh <- err
+					return
+				}
+			}
+			errCh <- nil
+		}(byte(w))
+	}
+
+	// Let the pipe fill up, so that all writers wait.
+	time.Sleep(100 * time.Millisecond)
+
+	counts := make([]int, writers)
+	var base []int // counts once all writers wait
+	buf := make([]byte, size)
+	for i := 0; i < writers*writes; i++ {
+		_, err := io.ReadFull(c2, buf)
+		require.NoError(t, err)
+		w := buf[0]
+		require.Equal(t, bytes.Repeat([]byte{w}, size), buf, "writes are interleaved")
+		counts[w]++
+
+		// Beyond the writes queued before all writers waited, no writer gets ahead of another by more than a turn.
+		if i < loopPipeSize/size+writers {
+			continue
+		}
+		if base == nil {
+			base = append([]int(nil), counts...)
+			continue
+		}
+		min, max := writes, 0
+		for w, c := range counts {
+			if c == writes {
+				continue // done writers no longer take turns
+			}
+			if d := c - base[w]; d < min {
+				min = d
+			}
+			if d := c - base[w]; d > max {
+				max = d
+			}
+		}
+		require.True(t, max-min <= 1 || min > max, "unfair writes: %v since %v", counts, base)
+	}
+
+	for w := 0; w < writers; w++ {
+		require.NoError(t, <-errCh)
+	}
+}
@@ -7,3 +7,15 @@ type Packet struct {
 	Loop    routing.Loop `json:"loop"`
 	Payload []byte       `json:"payload"`
 }
+
+// LoopClose is the payload of FrameClose. It is encoded like the embedded routing.Loop with the close code added.
+type LoopClose struct {
+	routing.Loop
+	Code routing.CloseCode `json:"code,omitempty"`
+}
+
+// LoopPause is the payload of FramePause. It is encoded like the embedded routing.Loop with the pause state added.
+type LoopPause struct {
+	routing.Loop
+	Paused bool `json:"paused"`
+}
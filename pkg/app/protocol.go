@@ -25,6 +25,8 @@ func (f Frame) String() string {
 		return "Send"
 	case FrameClose:
 		return "Close"
+	case FramePause:
+		return "Pause"
 	}
 
 	return fmt.Sprintf("Unknown(%d)", f)
@@ -41,6 +43,8 @@ const (
 	FrameSend
 	// FrameClose represents Close frame type
 	FrameClose
+	// FramePause represents Pause frame type, it pauses or resumes a loop.
+	FramePause
 
 	// FrameFailure  represents frame type for failed requests.
 	FrameFailure = 0xfe
@@ -52,17 +56,26 @@ const (
 type Protocol struct {
 	rw    io.ReadWriteCloser
 	chans *chanList
+
+	closeOnce sync.Once
 }
 
 // NewProtocol constructs a new Protocol.
 func NewProtocol(rw io.ReadWriteCloser) *Protocol {
-	return &Protocol{rw, &chanList{chans: map[byte]chan []byte{}}}
+	return &Protocol{rw: rw, chans: &chanList{chans: map[byte]chan []byte{}}}
 }
 
 // Send sends command Frame with payload and awaits for response.
 func (p *Protocol) Send(cmd Frame, payload, res interface{}) error {
-	id, resChan := p.chans.add()
+	id, resChan, ok := p.chans.add()
+	if !ok {
+		return io.EOF
+	}
 	if err := p.writeFrame(cmd, id, payload); err != nil {
+		// A missing channel means Close has already released it.
+		if p.chans.pull(id) == nil {
+			return io.EOF
+		}
 		return err
 	}
 
@@ -130,13 +143,18 @@ func (p *Protocol) Serve(handleFunc func(Frame, []byte) (interface{}, error)) er
 	}
 }
 
-// Close closes underlying ReadWriter.
+// Close closes underlying ReadWriter and fails pending Sends with io.EOF.
+// It is safe to call concurrently, calls after the first one return nil.
 func (p *Protocol) Close() error {
 	if p == nil {
 		return nil
 	}
-	p.chans.closeAll()
-	return p.rw.Close()
+	var err error
+	p.closeOnce.Do(func() {
+		p.chans.closeAll()
+		err = p.rw.Close()
+	})
+	return err
 }
 
 func (p *Protocol) writeFrame(frame Frame, id byte, payload interface{}) (err error) {
@@ -174,18 +192,23 @@ func (p *Protocol) readFrame() (frame []byte, err error) {
 type chanList struct {
 	sync.Mutex
 
-	chans map[byte]chan []byte
+	chans  map[byte]chan []byte
+	closed bool
 }
 
-func (c *chanList) add() (byte, chan []byte) {
+// add registers a channel for a response. It returns false once the list is closed.
+func (c *chanList) add() (byte, chan []byte, bool) {
 	c.Lock()
 	defer c.Unlock()
 
+	if c.closed {
+		return 0, nil, false
+	}
 	ch := make(chan []byte)
 	for i := byte(0); i < 255; i++ {
 		if c.chans[i] == nil {
 			c.chans[i] = ch
-			return i, ch
+			return i, ch, true
 		}
 	}
 
@@ -214,4 +237,5 @@ func (c *chanList) closeAll() {
 	}
 
 	c.chans = make(map[byte]chan []byte)
+	c.closed = true
 }
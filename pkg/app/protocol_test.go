@@ -2,10 +2,14 @@ package app
 
 import (
 	"errors"
+	"io"
+	"net"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"github.com/SkycoinProject/skywire-mainnet/internal/testhelpers"
 )
 
 func TestProtocol(t *testing.T) {
@@ -100,3 +104,37 @@ func TestProtocolParallel(t *testing.T) {
 	require.NoError(t, <-errCh1)
 	require.NoError(t, <-errCh2)
 }
+
+func TestProtocolClose_Concurrent(t *testing.T) {
+	rw1, rw2 := net.Pipe()
+	proto1 := NewProtocol(rw1)
+	proto2 := NewProtocol(rw2)
+
+	serveErrCh := make(chan error, 1)
+	go func() { serveErrCh <- proto1.Serve(nil) }()
+
+	// proto2 is not served, so its Sends stay pending until it is closed.
+	const senders = 10
+	sendErrCh := make(chan error, senders)
+	for i := 0; i < senders; i++ {
+		go func() { sendErrCh <- proto2.Send(FrameCreateLoop, nil, nil) }()
+	}
+
+	const closers = 50
+	closeErrCh := make(chan error, closers)
+	for i := 0; i < closers; i++ {
+		go func() { closeErrCh <- proto2.Close() }()
+	}
+
+	for i := 0; i < closers; i++ {
+		assert.NoError(t, <-closeErrCh)
+	}
+	for i := 0; i < senders; i++ {
+		assert.Equal(t, io.EOF, <-sendErrCh)
+	}
+	assert.Equal(t, io.EOF, proto2.Send(FrameCreateLoop, nil, nil))
+	assert.Empty(t, proto2.chans.chans)
+
+	require.NoError(t, proto1.Close())
+	require.NoError(t, testhelpers.WithinTimeout(serveErrCh))
+}
@@ -40,8 +40,8 @@ func (r *mockClient) PairedRoutes(src, dst cipher.PubKey, minHops, maxHops uint1
 		}, []routing.Route{
 			{
 				&routing.Hop{
-					From:      src,
-					To:        dst,
+					From:      dst,
+					To:        src,
 					Transport: transport.MakeTransportID(src, dst, ""),
 				},
 			},
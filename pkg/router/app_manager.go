@@ -17,7 +17,8 @@ const supportedProtocolVersion = "0.0.1"
 
 type appCallbacks struct {
 	CreateLoop func(ctx context.Context, conn *app.Protocol, raddr routing.Addr) (laddr routing.Addr, err error)
-	CloseLoop  func(ctx context.Context, conn *app.Protocol, loop routing.Loop) error
+	CloseLoop  func(ctx context.Context, conn *app.Protocol, loop routing.Loop, code routing.CloseCode) error
+	PauseLoop  func(ctx context.Context, conn *app.Protocol, loop routing.Loop, paused bool) error
 	Forward    func(ctx context.Context, conn *app.Protocol, packet *app.Packet) error
 }
 
@@ -45,6 +46,8 @@ func (am *appManager) Serve() error {
 			err = am.handleCloseLoop(ctx, payload)
 		case app.FrameSend:
 			err = am.forwardAppPacket(ctx, payload)
+		case app.FramePause:
+			err = am.handlePauseLoop(ctx, payload)
 		default:
 			err = errors.New("unexpected frame")
 		}
@@ -89,11 +92,22 @@ func (am *appManager) setupLoop(ctx context.Context, payload []byte) (routing.Ad
 }
 
 func (am *appManager) handleCloseLoop(ctx context.Context, payload []byte) error {
-	var loop routing.Loop
-	if err := json.Unmarshal(payload, &loop); err != nil {
+	var closing app.LoopClose
+	if err := json.Unmarshal(payload, &closing); err != nil {
 		return err
 	}
-	return am.callbacks.CloseLoop(ctx, am.proto, loop)
+	return am.callbacks.CloseLoop(ctx, am.proto, closing.Loop, closing.Code)
+}
+
+func (am *appManager) handlePauseLoop(ctx context.Context, payload []byte) error {
+	var pausing app.LoopPause
+	if err := json.Unmarshal(payload, &pausing); err != nil {
+		return err
+	}
+	if am.callbacks.PauseLoop == nil {
+		return errors.New("pausing loops is not supported")
+	}
+	return am.callbacks.PauseLoop(ctx, am.proto, pausing.Loop, pausing.Paused)
 }
 
 func (am *appManager) forwardAppPacket(ctx context.Context, payload []byte) error {
@@ -97,14 +97,18 @@ func TestAppManagerSetupLoop(t *testing.T) {
 
 func TestAppManagerCloseLoop(t *testing.T) {
 	in, out := net.Pipe()
-	var inLoop routing.Loop
+	var (
+		inLoop routing.Loop
+		inCode routing.CloseCode
+	)
 	am := &appManager{
 		logging.MustGetLogger("routesetup"),
 		app.NewProtocol(out),
 		&app.Config{AppName: "foo", AppVersion: "0.0.1"},
 		&appCallbacks{
-			CloseLoop: func(ctx context.Context, conn *app.Protocol, loop routing.Loop) error {
+			CloseLoop: func(ctx context.Context, conn *app.Protocol, loop routing.Loop, code routing.CloseCode) error {
 				inLoop = loop
+				inCode = code
 				return nil
 			},
 		},
@@ -122,9 +126,10 @@ func TestAppManagerCloseLoop(t *testing.T) {
 	lpk, _ := cipher.GenerateKeyPair()
 	rpk, _ := cipher.GenerateKeyPair()
 	loop := routing.Loop{Local: routing.Addr{PubKey: lpk, Port: 2}, Remote: routing.Addr{PubKey: rpk, Port: 3}}
-	err := proto.Send(app.FrameClose, loop, nil)
+	err := proto.Send(app.FrameClose, &app.LoopClose{Loop: loop, Code: routing.CloseError}, nil)
 	require.NoError(t, err)
 	assert.Equal(t, loop, inLoop)
+	assert.Equal(t, routing.CloseError, inCode)
 
 	require.NoError(t, in.Close())
 	require.NoError(t, <-srvCh)
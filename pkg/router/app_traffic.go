@@ -0,0 +1,60 @@
+package router
+
+import (
+	"sync/atomic"
+
+	"github.com/SkycoinProject/skywire-mainnet/pkg/app"
+	"github.com/SkycoinProject/skywire-mainnet/pkg/routing"
+)
+
+// AppTraffic is the amount of loop payload exchanged by an app.
+// It is counted by the Router as packets pass between the app and its loops, the app itself reports nothing.
+type AppTraffic struct {
+	Sent     uint64 `json:"sent"`     // payload bytes the app sent to its loops
+	Received uint64 `json:"received"` // payload bytes delivered to the app from its loops
+}
+
+type appTraffic struct {
+	port     routing.Port // static port the app is served on
+	sent     uint64
+	received uint64
+}
+
+func (t *appTraffic) addSent(n int) {
+	if t != nil {
+		atomic.AddUint64(&t.sent, uint64(n))
+	}
+}
+
+func (t *appTraffic) addReceived(n int) {
+	if t != nil {
+		atomic.AddUint64(&t.received, uint64(n))
+	}
+}
+
+func (t *appTraffic) snapshot() AppTraffic {
+	return AppTraffic{
+		Sent:     atomic.LoadUint64(&t.sent),
+		Received: atomic.LoadUint64(&t.received),
+	}
+}
+
+// appTraffic returns the traffic counter of the app served on appConn, or nil if the app is not served.
+func (r *Router) appTraffic(appConn *app.Protocol) *appTraffic {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	return r.traffic[appConn]
+}
+
+// AppTraffic returns the loop payload exchanged by each currently served app, keyed by the app's static port.
+// Totals cover all loops of an app since it started being served, including closed ones.
+func (r *Router) AppTraffic() map[routing.Port]AppTraffic {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	res := make(map[routing.Port]AppTraffic, len(r.traffic))
+	for _, t := range r.traffic {
+		res[t.port] = t.snapshot()
+	}
+	return res
+}
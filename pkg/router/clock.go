@@ -0,0 +1,13 @@
+package router
+
+import "time"
+
+// clock tells the time rule keep-alive timeouts are measured with, so that tests can control it.
+type clock interface {
+	Now() time.Time
+}
+
+// realClock is the clock of the system.
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
@@ -0,0 +1,81 @@
+package router
+
+import (
+	"sync"
+
+	"github.com/SkycoinProject/skywire-mainnet/pkg/routing"
+)
+
+// ConsumeStats is the payload delivered to a local app through one consume rule of a loop.
+// A loop fed by several consume rules (fan-in) has stats for each of them.
+type ConsumeStats struct {
+	Packets uint64 `json:"packets"`
+	Bytes   uint64 `json:"bytes"`
+}
+
+type consumeCounter struct {
+	loop  routing.Loop // local public key is not set
+	stats ConsumeStats
+}
+
+// consumeCounters counts the payload consumed through each consume rule.
+type consumeCounters struct {
+	mx    sync.Mutex
+	rules map[routing.RouteID]*consumeCounter
+}
+
+func newConsumeCounters() *consumeCounters {
+	return &consumeCounters{rules: make(map[routing.RouteID]*consumeCounter)}
+}
+
+// consumeLoop returns loop as it is keyed by consumeCounters.
+func consumeLoop(loop routing.Loop) routing.Loop {
+	return routing.Loop{Local: routing.Addr{Port: loop.Local.Port}, Remote: loop.Remote}
+}
+
+func (c *consumeCounters) add(routeID routing.RouteID, loop routing.Loop, n int) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	loop = consumeLoop(loop)
+	counter, ok := c.rules[routeID]
+	if !ok || counter.loop != loop {
+		// The route ID was reassigned to another loop.
+		counter = &consumeCounter{loop: loop}
+		c.rules[routeID] = counter
+	}
+	counter.stats.Packets++
+	counter.stats.Bytes += uint64(n)
+}
+
+// loop returns the stats of the consume rules of loop, or nil if nothing was consumed through it.
+func (c *consumeCounters) loop(loop routing.Loop) map[routing.RouteID]ConsumeStats {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	loop = consumeLoop(loop)
+	var res map[routing.RouteID]ConsumeStats
+	for routeID, counter := range c.rules {
+		if counter.loop != loop {
+			continue
+		}
+		if res == nil {
+			res = make(map[routing.RouteID]ConsumeStats)
+		}
+		res[routeID] = counter.stats
+	}
+	return res
+}
+
+// drop forgets the stats of the consume rules of loop.
+func (c *consumeCounters) drop(loop routing.Loop) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	loop = consumeLoop(loop)
+	for routeID, counter := range c.rules {
+		if counter.loop == loop {
+			delete(c.rules, routeID)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package router
+
+import (
+	"sync"
+
+	"github.com/SkycoinProject/skywire-mainnet/pkg/routing"
+)
+
+// seqWindow remembers which of the latest sequence numbers of a consume rule were delivered.
+type seqWindow struct {
+	loop routing.Loop // local public key is not set
+	max  uint32       // latest sequence number
+	seen []bool       // seen[seq%len(seen)] is set if seq within the window was delivered
+}
+
+// dup records seq and reports whether it was recorded before.
+// Sequence numbers older than the window can not be told apart from new ones, so they are never duplicates.
+func (w *seqWindow) dup(seq uint32) bool {
+	size := uint32(len(w.seen))
+	if d := int32(seq - w.max); d > 0 {
+		// Sequence numbers wrap around, so newer is decided by serial number arithmetic.
+		if uint32(d) >= size {
+			for i := range w.seen {
+				w.seen[i] = false
+			}
+		} else {
+			for s := w.max + 1; s != seq; s++ {
+				w.seen[s%size] = false
+			}
+		}
+		w.max = seq
+	} else if uint32(-d) >= size {
+		return false
+	} else if w.seen[seq%size] {
+		return true
+	}
+	w.seen[seq%size] = true
+	return false
+}
+
+// dedupWindows drops packets of consume rules whose sequence numbers were recently delivered,
+// as a packet may reach the router more than once, see Config.DedupWindow.
+type dedupWindows struct {
+	mx    sync.Mutex
+	size  int
+	rules map[routing.RouteID]*seqWindow
+}
+
+func newDedupWindows(size int) *dedupWindows {
+	return &dedupWindows{size: size, rules: make(map[routing.RouteID]*seqWindow)}
+}
+
+// dup records seq of a packet read with routeID for loop and reports whether it is a duplicate.
+// Packets without a sequence number (0) are never duplicates.
+func (d *dedupWindows) dup(routeID routing.RouteID, loop routing.Loop, seq uint32) bool {
+	if seq == 0 {
+		return false
+	}
+
+	d.mx.Lock()
+	defer d.mx.Unlock()
+
+	loop = consumeLoop(loop)
+	w, ok := d.rules[routeID]
+	if !ok || w.loop != loop {
+		// The route ID was reassigned to another loop.
+		w = &seqWindow{loop: loop, max: seq, seen: make([]bool, d.size)}
+		d.rules[routeID] = w
+	}
+	return w.dup(seq)
+}
+
+// drop forgets the windows of the consume rules of loop.
+func (d *dedupWindows) drop(loop routing.Loop) {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+
+	loop = consumeLoop(loop)
+	for routeID, w := range d.rules {
+		if w.loop == loop {
+			delete(d.rules, routeID)
+		}
+	}
+}
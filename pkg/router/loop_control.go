@@ -0,0 +1,181 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/SkycoinProject/skywire-mainnet/pkg/routing"
+	"github.com/SkycoinProject/skywire-mainnet/pkg/transport"
+)
+
+// DefaultLoopControlTimeout is how long the router waits for the remote router of a loop to acknowledge
+// an in-band pause or resume before it notifies the remote through a setup node instead.
+const DefaultLoopControlTimeout = 2 * time.Second
+
+var (
+	// ErrUnknownPacketType occurs when a packet of a consume rule has a type the router does not handle, it is dropped.
+	ErrUnknownPacketType = errors.New("unknown packet type")
+
+	// errLoopControlTimeout occurs when the remote router does not acknowledge an in-band pause or resume in time.
+	errLoopControlTimeout = errors.New("loop state was not acknowledged")
+
+	// errSetupLoopControl occurs when the pauses of a loop go through a setup node, as they could not go in-band before.
+	errSetupLoopControl = errors.New("loop state goes through a setup node")
+)
+
+// loopState is a pause state of the loop of a local port to a remote address.
+type loopState struct {
+	port   routing.Port
+	remote routing.Addr
+	paused bool
+}
+
+// ackWait is a wait for the acknowledgement of a loopState, shared by concurrent waiters.
+type ackWait struct {
+	acked   chan struct{}
+	waiters int
+}
+
+// loopAcks are the in-band pauses and resumes of loops awaiting acknowledgement by the remote router.
+type loopAcks struct {
+	mx      sync.Mutex
+	waiting map[loopState]*ackWait
+}
+
+func newLoopAcks() *loopAcks {
+	return &loopAcks{waiting: make(map[loopState]*ackWait)}
+}
+
+// wait returns a channel which is closed once s is acknowledged, and a func to stop waiting.
+// Concurrent waits for the same state share the acknowledgement.
+func (a *loopAcks) wait(s loopState) (<-chan struct{}, func()) {
+	a.mx.Lock()
+	defer a.mx.Unlock()
+
+	w, ok := a.waiting[s]
+	if !ok {
+		w = &ackWait{acked: make(chan struct{})}
+		a.waiting[s] = w
+	}
+	w.waiters++
+	return w.acked, func() {
+		a.mx.Lock()
+		if w.waiters--; w.waiters == 0 && a.waiting[s] == w {
+			delete(a.waiting, s)
+		}
+		a.mx.Unlock()
+	}
+}
+
+// ack releases the waits for s. It returns false if nothing waits for s.
+func (a *loopAcks) ack(s loopState) bool {
+	a.mx.Lock()
+	defer a.mx.Unlock()
+
+	w, ok := a.waiting[s]
+	if ok {
+		close(w.acked)
+		delete(a.waiting, s)
+	}
+	return ok
+}
+
+// pauseLoopInBand tells the remote router of loop that the local app paused or resumed it with a
+// routing.LoopStatePacket over the route of the loop, and waits for the remote router to acknowledge it.
+// Pauses only go in-band over transports which carry packet types, see snet.FeaturePacketType.
+func (r *Router) pauseLoopInBand(ctx context.Context, loop routing.Loop, paused bool) error {
+	l, err := r.pm.GetLoop(loop.Local.Port, loop.Remote)
+	if err != nil {
+		return err
+	}
+	if l.setupControl {
+		return errSetupLoopControl
+	}
+	tp := r.selectTransport(l.trID)
+	if tp == nil {
+		return ErrUnknownTransport
+	}
+
+	acked, stop := r.acks.wait(loopState{port: loop.Local.Port, remote: loop.Remote, paused: paused})
+	defer stop()
+
+	payload := routing.MakeLoopStatePayload(paused)
+	err = tp.WriteControlPacket(ctx, l.routeID, r.conf.PacketTTL, routing.LoopStatePacket, payload)
+	r.cooldowns.record(tp.ID(), err)
+	if err != nil {
+		return err
+	}
+	r.writeSizes.add(len(payload))
+
+	timer := time.NewTimer(r.conf.LoopControlTimeout)
+	defer timer.Stop()
+	select {
+	case <-acked:
+		return nil
+	case <-timer.C:
+		return errLoopControlTimeout
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// handleLoopControl handles a packet of a consume rule which is not a routing.DataPacket.
+// The pause or resume of a routing.LoopStatePacket is passed on to the local app and acknowledged over the loop.
+func (r *Router) handleLoopControl(ctx context.Context, typ routing.PacketType, payload []byte, rule routing.Rule) error {
+	if typ != routing.LoopStatePacket && typ != routing.LoopStateAckPacket {
+		return ErrUnknownPacketType
+	}
+	paused, err := routing.ParseLoopStatePayload(payload)
+	if err != nil {
+		return err
+	}
+
+	// The local app tracks the loop by the full local address, as with pauses relayed by setup nodes.
+	loop := routing.Loop{
+		Local:  routing.Addr{PubKey: r.conf.PubKey, Port: rule.LocalPort()},
+		Remote: routing.Addr{PubKey: rule.RemotePK(), Port: rule.RemotePort()},
+	}
+	if typ == routing.LoopStateAckPacket {
+		if !r.acks.ack(loopState{port: loop.Local.Port, remote: loop.Remote, paused: paused}) {
+			r.Logger.Debugf("Dropped late loop state acknowledgement of loop %s: paused=%t", loop, paused)
+		}
+		return nil
+	}
+
+	r.Logger.Debugf("Received in-band loop state of loop %s: paused=%t", loop, paused)
+	if err := r.loopPaused(loop, paused); err != nil {
+		return err
+	}
+
+	l, err := r.pm.GetLoop(loop.Local.Port, loop.Remote)
+	if err != nil {
+		return err
+	}
+	tp := r.selectTransport(l.trID)
+	if tp == nil {
+		return ErrUnknownTransport
+	}
+	err = tp.WriteControlPacket(ctx, l.routeID, r.conf.PacketTTL, routing.LoopStateAckPacket, payload)
+	r.cooldowns.record(tp.ID(), err)
+	if err != nil {
+		return fmt.Errorf("acknowledge loop state: %v", err)
+	}
+	r.writeSizes.add(len(payload))
+	return nil
+}
+
+// useSetupControl makes later pauses of loop go through a setup node right away.
+func (r *Router) useSetupControl(l routing.Loop) {
+	if err := r.pm.UpdateLoop(l.Local.Port, l.Remote, false, func(lp *loop) { lp.setupControl = true }); err != nil {
+		r.Logger.Warnf("Failed to update loop: %s", err)
+	}
+}
+
+// isInBandUnavailable reports whether err shows that pauses of a loop cannot go in-band,
+// so that later pauses go through a setup node right away.
+func isInBandUnavailable(err error) bool {
+	return err == transport.ErrPacketTypeUnsupported || err == errLoopControlTimeout
+}
@@ -0,0 +1,184 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/SkycoinProject/dmsg/cipher"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/SkycoinProject/skywire-mainnet/pkg/app"
+	"github.com/SkycoinProject/skywire-mainnet/pkg/routing"
+)
+
+// serveMockPauseApp opens port of r for an app, the returned channel receives the pauses sent to the app.
+func serveMockPauseApp(t *testing.T, r *Router, port routing.Port) (<-chan app.LoopPause, func()) {
+	rConn, appConn := net.Pipe()
+	rProto := app.NewProtocol(rConn)
+	go func() { _ = rProto.Serve(nil) }() //nolint:errcheck
+	require.NoError(t, r.pm.Open(port, rProto))
+
+	pauses := make(chan app.LoopPause, 10)
+	go func() {
+		_ = app.NewProtocol(appConn).Serve(func(f app.Frame, p []byte) (interface{}, error) { //nolint:errcheck
+			if f != app.FramePause {
+				return nil, nil
+			}
+			var pausing app.LoopPause
+			if err := json.Unmarshal(p, &pausing); err != nil {
+				return nil, err
+			}
+			pauses <- pausing
+			return nil, nil
+		})
+	}()
+	return pauses, func() {
+		assert.NoError(t, rProto.Close())
+		assert.NoError(t, appConn.Close())
+	}
+}
+
+func TestRouter_LoopControl(t *testing.T) {
+	pk, _ := cipher.GenerateKeyPair()
+	remotePK, _ := cipher.GenerateKeyPair()
+	tp := newMockTransport(remotePK, "mock")
+	tm := newMockTransportManager(tp)
+	r, mrt, stop := serveMockRouter(t, &Config{PubKey: pk, LoopControlTimeout: 100 * time.Millisecond}, tm)
+	defer stop()
+
+	const localPort = routing.Port(3)
+	pauses, closeApp := serveMockPauseApp(t, r, localPort)
+	defer closeApp()
+
+	// openLoop opens a loop to remotePort forwarded with route ID 9, and returns it with the route ID it is consumed with.
+	openLoop := func(remotePort routing.Port) (routing.Loop, routing.RouteID) {
+		l := routing.Loop{Local: routing.Addr{Port: localPort}, Remote: routing.Addr{PubKey: remotePK, Port: remotePort}}
+		require.NoError(t, r.pm.SetLoop(localPort, l.Remote, &loop{trID: tp.id, routeID: 9}))
+		rtID, err := mrt.AddRule(routing.AppRule(time.Hour, 0, 6, remotePK, localPort, remotePort))
+		require.NoError(t, err)
+		return l, rtID
+	}
+	pauseLoop := func(l routing.Loop, paused bool) <-chan error {
+		errCh := make(chan error, 1)
+		go func() { errCh <- r.pauseLoop(context.TODO(), nil, l, paused) }()
+		return errCh
+	}
+	usesSetup := func(l routing.Loop) bool {
+		lp, err := r.pm.GetLoop(l.Local.Port, l.Remote)
+		require.NoError(t, err)
+		return lp.setupControl
+	}
+
+	t.Run("in_band", func(t *testing.T) {
+		l, rtID := openLoop(7)
+
+		errCh := pauseLoop(l, true)
+		want := mockWrite{RouteID: 9, TTL: routing.DefaultPacketTTL, Type: routing.LoopStatePacket, Payload: "\x01"}
+		assert.Equal(t, want, tp.nextWrite(t))
+
+		// An acknowledgement of another state does not release the pause.
+		tm.readCh <- mockRead{packet: routing.MakePacket(rtID, routing.MakeLoopStatePayload(false)), from: remotePK, typ: routing.LoopStateAckPacket}
+		select {
+		case err := <-errCh:
+			t.Fatalf("pause returned before it was acknowledged: %v", err)
+		case <-time.After(20 * time.Millisecond):
+		}
+
+		tm.readCh <- mockRead{packet: routing.MakePacket(rtID, routing.MakeLoopStatePayload(true)), from: remotePK, typ: routing.LoopStateAckPacket}
+		require.NoError(t, <-errCh)
+		assert.False(t, usesSetup(l))
+	})
+
+	t.Run("receive", func(t *testing.T) {
+		l, rtID := openLoop(8)
+
+		tm.readCh <- mockRead{packet: routing.MakePacket(rtID, routing.MakeLoopStatePayload(true)), from: remotePK, typ: routing.LoopStatePacket}
+		select {
+		case pausing := <-pauses:
+			loop := routing.Loop{Local: routing.Addr{PubKey: pk, Port: localPort}, Remote: l.Remote}
+			assert.Equal(t, app.LoopPause{Loop: loop, Paused: true}, pausing)
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for the pause to be passed on to the app")
+		}
+		want := mockWrite{RouteID: 9, TTL: routing.DefaultPacketTTL, Type: routing.LoopStateAckPacket, Payload: "\x01"}
+		assert.Equal(t, want, tp.nextWrite(t))
+
+		packet := routing.MakePacket(rtID, routing.MakeLoopStatePayload(true))
+		assert.Equal(t, ErrUnknownPacketType, r.handlePacket(context.TODO(), packet, routing.DefaultPacketTTL, 0, 9, remotePK))
+		packet = routing.MakePacket(rtID, []byte{2})
+		assert.Equal(t, routing.ErrInvalidLoopState, r.handlePacket(context.TODO(), packet, routing.DefaultPacketTTL, 0, routing.LoopStatePacket, remotePK))
+	})
+
+	t.Run("forward", func(t *testing.T) {
+		rtID, err := mrt.AddRule(routing.ForwardRule(time.Hour, 5, tp.id, 0))
+		require.NoError(t, err)
+
+		tm.readCh <- mockRead{packet: routing.MakePacket(rtID, routing.MakeLoopStatePayload(true)), from: remotePK, ttl: 10, typ: routing.LoopStatePacket}
+		assert.Equal(t, mockWrite{RouteID: 5, TTL: 9, Type: routing.LoopStatePacket, Payload: "\x01"}, tp.nextWrite(t))
+	})
+
+	// Without setup nodes, pauses which do not go in-band fail.
+	t.Run("unsupported", func(t *testing.T) {
+		l, _ := openLoop(10)
+
+		atomic.StoreInt32(&tp.untyped, 1)
+		assert.Error(t, <-pauseLoop(l, true))
+		assert.True(t, usesSetup(l))
+
+		// Later pauses of the loop do not try in-band again.
+		atomic.StoreInt32(&tp.untyped, 0)
+		assert.Error(t, <-pauseLoop(l, false))
+		select {
+		case w := <-tp.writes:
+			t.Fatalf("unexpected write of a loop paused through a setup node: %v", w)
+		default:
+		}
+	})
+
+	t.Run("not_acknowledged", func(t *testing.T) {
+		l, _ := openLoop(11)
+
+		errCh := pauseLoop(l, true)
+		assert.Equal(t, routing.LoopStatePacket, tp.nextWrite(t).Type)
+		assert.Error(t, <-errCh)
+		assert.True(t, usesSetup(l))
+	})
+
+	// Failed writes may succeed later, so pauses keep going in-band.
+	t.Run("write_failed", func(t *testing.T) {
+		l, _ := openLoop(12)
+
+		atomic.StoreInt32(&tp.failing, 1)
+		defer atomic.StoreInt32(&tp.failing, 0)
+		assert.Error(t, <-pauseLoop(l, true))
+		assert.False(t, usesSetup(l))
+	})
+}
+
+func TestLoopAcks(t *testing.T) {
+	acks := newLoopAcks()
+	s := loopState{port: 3, paused: true}
+
+	// Concurrent waits share the acknowledgement, also once one of them stopped waiting.
+	acked1, stop1 := acks.wait(s)
+	acked2, stop2 := acks.wait(s)
+	stop1()
+	assert.False(t, acks.ack(loopState{port: 3}))
+	assert.True(t, acks.ack(s))
+	for _, acked := range []<-chan struct{}{acked1, acked2} {
+		select {
+		case <-acked:
+		default:
+			t.Fatal("wait was not acknowledged")
+		}
+	}
+	stop2()
+
+	assert.False(t, acks.ack(s))
+	assert.Empty(t, acks.waiting)
+}
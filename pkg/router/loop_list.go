@@ -2,6 +2,7 @@ package router
 
 import (
 	"sync"
+	"sync/atomic"
 
 	"github.com/google/uuid"
 
@@ -11,6 +12,23 @@ import (
 type loop struct {
 	trID    uuid.UUID
 	routeID routing.RouteID
+	weight  int     // share of the forwarding transport, 0 if not set, see Router.SetLoopWeight
+	seq     *uint32 // last sequence number sent over the loop, shared by copies, nil until the loop is confirmed
+
+	setupControl bool // pauses of the loop go through a setup node, as the remote router did not get them in-band
+}
+
+// nextSeq returns the sequence number of the next packet sent over the loop, 0 (none) if it is not confirmed.
+// Sequence numbers wrap around, skipping 0.
+func (l *loop) nextSeq() uint32 {
+	if l.seq == nil {
+		return 0
+	}
+	for {
+		if seq := atomic.AddUint32(l.seq, 1); seq != 0 {
+			return seq
+		}
+	}
 }
 
 type loopList struct {
@@ -37,6 +55,23 @@ func (ll *loopList) set(addr routing.Addr, l *loop) {
 	ll.Unlock()
 }
 
+// update replaces the loop to addr with a copy modified by fn, as loops are read without locking.
+// If there is no loop to addr, fn modifies a zero loop if create is set, otherwise false is returned.
+func (ll *loopList) update(addr routing.Addr, create bool, fn func(l *loop)) bool {
+	ll.Lock()
+	defer ll.Unlock()
+
+	var l loop
+	if prev, ok := ll.loops[addr]; ok {
+		l = *prev
+	} else if !create {
+		return false
+	}
+	fn(&l)
+	ll.loops[addr] = &l
+	return true
+}
+
 func (ll *loopList) remove(addr routing.Addr) {
 	ll.Lock()
 	delete(ll.loops, addr)
@@ -52,3 +87,13 @@ func (ll *loopList) dropAll() []routing.Addr {
 	ll.Unlock()
 	return r
 }
+
+func (ll *loopList) all() map[routing.Addr]loop {
+	ll.Lock()
+	r := make(map[routing.Addr]loop, len(ll.loops))
+	for addr, l := range ll.loops {
+		r[addr] = *l
+	}
+	ll.Unlock()
+	return r
+}
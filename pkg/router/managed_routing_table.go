@@ -17,6 +17,7 @@ type managedRoutingTable struct {
 	routing.Table
 
 	activity map[routing.RouteID]time.Time
+	clock    clock
 	mu       sync.Mutex
 }
 
@@ -24,6 +25,7 @@ func manageRoutingTable(rt routing.Table) *managedRoutingTable {
 	return &managedRoutingTable{
 		Table:    rt,
 		activity: make(map[routing.RouteID]time.Time),
+		clock:    realClock{},
 	}
 }
 
@@ -37,11 +39,32 @@ func (rt *managedRoutingTable) AddRule(rule routing.Rule) (routing.RouteID, erro
 	}
 
 	// set the initial activity for rule not to be timed out instantly
-	rt.activity[routeID] = time.Now()
+	rt.activity[routeID] = rt.clock.Now()
 
 	return routeID, nil
 }
 
+func (rt *managedRoutingTable) Transaction(fn func(tx routing.RuleTx) error) error {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	var added []routing.RouteID
+	err := rt.Table.Transaction(func(tx routing.RuleTx) error {
+		return fn(&managedRuleTx{RuleTx: tx, added: &added})
+	})
+	if err != nil {
+		return err
+	}
+
+	// set the initial activity for added rules not to be timed out instantly
+	now := rt.clock.Now()
+	for _, routeID := range added {
+		rt.activity[routeID] = now
+	}
+
+	return nil
+}
+
 func (rt *managedRoutingTable) Rule(routeID routing.RouteID) (routing.Rule, error) {
 	rt.mu.Lock()
 	defer rt.mu.Unlock()
@@ -55,7 +78,7 @@ func (rt *managedRoutingTable) Rule(routeID routing.RouteID) (routing.Rule, erro
 		return nil, ErrRuleTimedOut
 	}
 
-	rt.activity[routeID] = time.Now()
+	rt.activity[routeID] = rt.clock.Now()
 
 	return rule, nil
 }
@@ -85,10 +108,14 @@ func (rt *managedRoutingTable) Cleanup() error {
 }
 
 // ruleIsExpired checks whether rule's keep alive timeout is exceeded.
+// Rules with a zero keep alive timeout never expire.
 // NOTE: for internal use, is NOT thread-safe, object lock should be acquired outside
 func (rt *managedRoutingTable) ruleIsTimedOut(routeID routing.RouteID, rule routing.Rule) bool {
+	if rule.KeepAlive() == 0 {
+		return false
+	}
 	lastActivity, ok := rt.activity[routeID]
-	return !ok || time.Since(lastActivity) > rule.KeepAlive()
+	return !ok || rt.clock.Now().Sub(lastActivity) > rule.KeepAlive()
 }
 
 // deleteActivity removes activity records for the specified set of `routeIDs`.
@@ -98,3 +125,19 @@ func (rt *managedRoutingTable) deleteActivity(routeIDs ...routing.RouteID) {
 		delete(rt.activity, rID)
 	}
 }
+
+// managedRuleTx records RouteIDs added within a transaction so that their activity is only set once committed.
+type managedRuleTx struct {
+	routing.RuleTx
+	added *[]routing.RouteID
+}
+
+func (tx *managedRuleTx) AddRule(rule routing.Rule) (routing.RouteID, error) {
+	routeID, err := tx.RuleTx.AddRule(rule)
+	if err != nil {
+		return 0, err
+	}
+
+	*tx.added = append(*tx.added, routeID)
+	return routeID, nil
+}
@@ -1,9 +1,11 @@
 package router
 
 import (
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/SkycoinProject/dmsg/cipher"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -11,8 +13,32 @@ import (
 	"github.com/SkycoinProject/skywire-mainnet/pkg/routing"
 )
 
+// fakeClock is a clock which only advances when told to.
+type fakeClock struct {
+	now time.Time
+	mx  sync.Mutex
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mx.Lock()
+	c.now = c.now.Add(d)
+	c.mx.Unlock()
+}
+
 func TestManagedRoutingTableCleanup(t *testing.T) {
+	clock := newFakeClock()
 	rt := manageRoutingTable(routing.InMemoryRoutingTable())
+	rt.clock = clock
 
 	_, err := rt.AddRule(routing.ForwardRule(1*time.Hour, 3, uuid.New(), 1))
 	require.NoError(t, err)
@@ -23,9 +49,7 @@ func TestManagedRoutingTableCleanup(t *testing.T) {
 	id2, err := rt.AddRule(routing.ForwardRule(-1*time.Hour, 3, uuid.New(), 3))
 	require.NoError(t, err)
 
-	// rule should already be expired at this point due to the execution time.
-	// However, we'll just a bit to be sure
-	time.Sleep(1 * time.Millisecond)
+	clock.Advance(time.Millisecond)
 
 	assert.Equal(t, 3, rt.Count())
 
@@ -41,3 +65,58 @@ func TestManagedRoutingTableCleanup(t *testing.T) {
 	require.Error(t, err)
 	assert.Nil(t, rule)
 }
+
+func TestManagedRoutingTableCleanup_ZeroKeepAlive(t *testing.T) {
+	clock := newFakeClock()
+	rt := manageRoutingTable(routing.InMemoryRoutingTable())
+	rt.clock = clock
+
+	pinnedID, err := rt.AddRule(routing.ForwardRule(0, 3, uuid.New(), 1))
+	require.NoError(t, err)
+
+	shortID, err := rt.AddRule(routing.ForwardRule(time.Millisecond, 3, uuid.New(), 2))
+	require.NoError(t, err)
+
+	// Rule set without activity record should also not expire when pinned.
+	require.NoError(t, rt.SetRule(10, routing.AppRule(0, 10, 4, cipher.PubKey{}, 1, 2)))
+
+	clock.Advance(time.Hour)
+
+	require.NoError(t, rt.Cleanup())
+	assert.Equal(t, 2, rt.Count())
+
+	_, err = rt.Rule(shortID)
+	assert.Error(t, err)
+
+	rule, err := rt.Rule(pinnedID)
+	require.NoError(t, err)
+	assert.Equal(t, time.Duration(0), rule.KeepAlive())
+
+	_, err = rt.Rule(10)
+	require.NoError(t, err)
+}
+
+func TestManagedRoutingTable_KeepAliveTimeout(t *testing.T) {
+	const keepAlive = time.Minute
+
+	clock := newFakeClock()
+	rt := manageRoutingTable(routing.InMemoryRoutingTable())
+	rt.clock = clock
+
+	id, err := rt.AddRule(routing.ForwardRule(keepAlive, 3, uuid.New(), 1))
+	require.NoError(t, err)
+
+	// Accessing the rule keeps it alive.
+	for i := 0; i < 3; i++ {
+		clock.Advance(keepAlive)
+		_, err = rt.Rule(id)
+		require.NoError(t, err)
+	}
+
+	clock.Advance(keepAlive + time.Nanosecond)
+	_, err = rt.Rule(id)
+	assert.Equal(t, ErrRuleTimedOut, err)
+
+	require.NoError(t, rt.Cleanup())
+	assert.Equal(t, 0, rt.Count())
+}
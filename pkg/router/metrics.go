@@ -0,0 +1,56 @@
+package router
+
+import (
+	"sort"
+	"sync/atomic"
+)
+
+// DefaultPacketSizeBuckets are the packet size buckets used if Config.PacketSizeBuckets is not set.
+var DefaultPacketSizeBuckets = []int{64, 256, 1024, 4096}
+
+// PacketSizeCounts counts packets by payload size.
+// Counts[i] is the number of packets smaller than Buckets[i] that are not counted by a smaller bucket,
+// the last count is the number of the remaining, larger packets.
+type PacketSizeCounts struct {
+	Buckets []int    `json:"buckets"`
+	Counts  []uint64 `json:"counts"`
+}
+
+// Metrics summarizes the packets read from and written to transports by a Router.
+type Metrics struct {
+	Read    PacketSizeCounts `json:"read"`
+	Written PacketSizeCounts `json:"written"`
+
+	RejectedSources uint64 `json:"rejected_sources"`     // packets of consume rules dropped by Config.SourceGuard
+	Duplicates      uint64 `json:"duplicates,omitempty"` // packets of consume rules dropped by Config.DedupWindow
+}
+
+type sizeCounter struct {
+	buckets []int
+	counts  []uint64
+}
+
+func newSizeCounter(buckets []int) *sizeCounter {
+	buckets = append([]int(nil), buckets...)
+	sort.Ints(buckets)
+	return &sizeCounter{
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)+1),
+	}
+}
+
+func (c *sizeCounter) add(size int) {
+	i := sort.Search(len(c.buckets), func(i int) bool { return size < c.buckets[i] })
+	atomic.AddUint64(&c.counts[i], 1)
+}
+
+func (c *sizeCounter) snapshot() PacketSizeCounts {
+	counts := make([]uint64, len(c.counts))
+	for i := range c.counts {
+		counts[i] = atomic.LoadUint64(&c.counts[i])
+	}
+	return PacketSizeCounts{
+		Buckets: append([]int(nil), c.buckets...),
+		Counts:  counts,
+	}
+}
@@ -4,6 +4,9 @@ import (
 	"errors"
 	"fmt"
 
+	"github.com/SkycoinProject/dmsg/cipher"
+	"github.com/google/uuid"
+
 	"github.com/SkycoinProject/skywire-mainnet/pkg/app"
 	"github.com/SkycoinProject/skywire-mainnet/pkg/routing"
 )
@@ -21,9 +24,10 @@ func (pm *portManager) Alloc(conn *app.Protocol) routing.Port {
 	return pm.ports.add(b)
 }
 
+// Open binds port to proto. The port is bound for all network types, see routing.Port.
 func (pm *portManager) Open(port routing.Port, proto *app.Protocol) error {
 	if pm.ports.get(port) != nil {
-		return fmt.Errorf("port %d is already bound", port)
+		return fmt.Errorf("port %d: %v", port, ErrPortBound)
 	}
 
 	pm.ports.set(port, &portBind{proto, newLoopList()})
@@ -40,6 +44,40 @@ func (pm *portManager) SetLoop(port routing.Port, raddr routing.Addr, l *loop) e
 	return nil
 }
 
+// UpdateLoop modifies the loop to raddr of port with fn, see loopList.update.
+func (pm *portManager) UpdateLoop(port routing.Port, raddr routing.Addr, create bool, fn func(l *loop)) error {
+	b := pm.ports.get(port)
+	if b == nil {
+		return errors.New("port is not bound")
+	}
+
+	if !b.loops.update(raddr, create, fn) {
+		return errors.New("unknown loop")
+	}
+	return nil
+}
+
+// ReplaceTransport makes the loops forwarded over the transport oldID forward over newID instead.
+// It returns the number of replaced loops.
+func (pm *portManager) ReplaceTransport(oldID, newID uuid.UUID) int {
+	var n int
+	for _, bind := range pm.ports.all() {
+		for raddr, l := range bind.loops.all() {
+			if l.trID != oldID {
+				continue
+			}
+			// The loop may have been replaced in the meantime, so the transport is checked again.
+			bind.loops.update(raddr, false, func(l *loop) {
+				if l.trID == oldID {
+					l.trID = newID
+					n++
+				}
+			})
+		}
+	}
+	return n
+}
+
 func (pm *portManager) AppConns() []*app.Protocol {
 	res := make([]*app.Protocol, 0)
 	set := map[*app.Protocol]struct{}{}
@@ -62,6 +100,24 @@ func (pm *portManager) AppPorts(appConn *app.Protocol) []routing.Port {
 	return res
 }
 
+func (pm *portManager) Loops(lPK cipher.PubKey) []LoopInfo {
+	var loops []LoopInfo
+	for port, bind := range pm.ports.all() {
+		for raddr, l := range bind.loops.all() {
+			loops = append(loops, LoopInfo{
+				Loop: routing.Loop{
+					Local:  routing.Addr{PubKey: lPK, Port: port},
+					Remote: raddr,
+				},
+				TransportID: l.trID,
+				RouteID:     l.routeID,
+				Weight:      l.weight,
+			})
+		}
+	}
+	return loops
+}
+
 func (pm *portManager) Close(port routing.Port) []routing.Addr {
 	if pm == nil {
 		return nil
@@ -6,6 +6,7 @@ import (
 	"testing"
 
 	"github.com/SkycoinProject/dmsg/cipher"
+	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
@@ -65,3 +66,82 @@ func TestPortManager(t *testing.T) {
 	assert.Empty(t, pm.Close(7))
 	assert.Equal(t, []routing.Addr{raddr}, pm.Close(8))
 }
+
+func TestPortManager_Loops(t *testing.T) {
+	pm := newPortManager(10)
+
+	in, _ := net.Pipe()
+	proto := app.NewProtocol(in)
+
+	lPK, _ := cipher.GenerateKeyPair()
+	rPK1, _ := cipher.GenerateKeyPair()
+	rPK2, _ := cipher.GenerateKeyPair()
+
+	assert.Empty(t, pm.Loops(lPK))
+
+	p1 := pm.Alloc(proto)
+	p2 := pm.Alloc(proto)
+
+	l1 := &loop{trID: uuid.New(), routeID: 1}
+	l2 := &loop{trID: uuid.New(), routeID: 2}
+	require.NoError(t, pm.SetLoop(p1, routing.Addr{PubKey: rPK1, Port: 3}, l1))
+	require.NoError(t, pm.SetLoop(p2, routing.Addr{PubKey: rPK2, Port: 4}, l2))
+
+	assert.ElementsMatch(t, []LoopInfo{
+		{
+			Loop: routing.Loop{
+				Local:  routing.Addr{PubKey: lPK, Port: p1},
+				Remote: routing.Addr{PubKey: rPK1, Port: 3},
+			},
+			TransportID: l1.trID,
+			RouteID:     l1.routeID,
+		},
+		{
+			Loop: routing.Loop{
+				Local:  routing.Addr{PubKey: lPK, Port: p2},
+				Remote: routing.Addr{PubKey: rPK2, Port: 4},
+			},
+			TransportID: l2.trID,
+			RouteID:     l2.routeID,
+		},
+	}, pm.Loops(lPK))
+}
+
+// Ports are shared by all network types: loops over transports of different networks reach the app bound to a port,
+// and the port cannot be bound again by another app.
+func TestPortManager_NetworkAgnostic(t *testing.T) {
+	pm := newPortManager(10)
+
+	in1, _ := net.Pipe()
+	in2, _ := net.Pipe()
+	proto1, proto2 := app.NewProtocol(in1), app.NewProtocol(in2)
+
+	require.NoError(t, pm.Open(80, proto1))
+	err := pm.Open(80, proto2)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), ErrPortBound.Error())
+
+	pk1, _ := cipher.GenerateKeyPair()
+	pk2, _ := cipher.GenerateKeyPair()
+	raddr1 := routing.Addr{PubKey: pk1, Port: 3}
+	raddr2 := routing.Addr{PubKey: pk2, Port: 3}
+
+	// e.g. a dmsg and a stcp transport
+	trID1, trID2 := uuid.New(), uuid.New()
+	require.NoError(t, pm.SetLoop(80, raddr1, &loop{trID: trID1, routeID: 1}))
+	require.NoError(t, pm.SetLoop(80, raddr2, &loop{trID: trID2, routeID: 2}))
+
+	b, err := pm.Get(80)
+	require.NoError(t, err)
+	assert.Equal(t, proto1, b.conn)
+
+	l1, err := pm.GetLoop(80, raddr1)
+	require.NoError(t, err)
+	assert.Equal(t, trID1, l1.trID)
+	l2, err := pm.GetLoop(80, raddr2)
+	require.NoError(t, err)
+	assert.Equal(t, trID2, l2.trID)
+
+	assert.Equal(t, []routing.Port{80}, pm.AppPorts(proto1))
+	assert.Empty(t, pm.AppPorts(proto2))
+}
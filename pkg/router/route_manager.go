@@ -19,22 +19,32 @@ import (
 
 // RMConfig represents route manager configuration.
 type RMConfig struct {
-	SetupPKs               []cipher.PubKey // Trusted setup PKs.
+	Logger                 *logging.Logger   // defaults to a "route_manager" logger if nil
+	SetupPKs               []cipher.PubKey   // Trusted setup PKs.
+	FallbackSetupPKs       [][]cipher.PubKey // Trusted setup PKs in tiers, dialed in order once all SetupPKs fail.
 	GarbageCollectDuration time.Duration
 	OnConfirmLoop          func(loop routing.Loop, rule routing.Rule) (err error)
-	OnLoopClosed           func(loop routing.Loop) error
+	OnLoopClosed           func(loop routing.Loop, code routing.CloseCode) error
+	OnLoopPaused           func(loop routing.Loop, paused bool) error
 }
 
 // SetupIsTrusted checks if setup node is trusted.
 func (sc RMConfig) SetupIsTrusted(sPK cipher.PubKey) bool {
-	for _, pk := range sc.SetupPKs {
-		if sPK == pk {
-			return true
+	for _, tier := range sc.setupTiers() {
+		for _, pk := range tier {
+			if sPK == pk {
+				return true
+			}
 		}
 	}
 	return false
 }
 
+// setupTiers returns the setup PKs in the order of their tiers, starting with SetupPKs.
+func (sc RMConfig) setupTiers() [][]cipher.PubKey {
+	return append([][]cipher.PubKey{sc.SetupPKs}, sc.FallbackSetupPKs...)
+}
+
 // routeManager represents route manager.
 type routeManager struct {
 	Logger *logging.Logger
@@ -51,8 +61,11 @@ func newRouteManager(n *snet.Network, rt routing.Table, config RMConfig) (*route
 	if err != nil {
 		return nil, err
 	}
+	if config.Logger == nil {
+		config.Logger = logging.MustGetLogger("route_manager")
+	}
 	return &routeManager{
-		Logger: logging.MustGetLogger("route_manager"),
+		Logger: config.Logger,
 		conf:   config,
 		n:      n,
 		sl:     sl,
@@ -126,6 +139,8 @@ func (rm *routeManager) handleSetupConn(conn net.Conn) error {
 		err = rm.confirmLoop(body)
 	case setup.PacketLoopClosed:
 		err = rm.loopClosed(body)
+	case setup.PacketLoopPaused:
+		err = rm.loopPaused(body)
 	case setup.PacketRequestRouteID:
 		respBody, err = rm.occupyRouteID(body)
 	default:
@@ -160,14 +175,17 @@ func (rm *routeManager) rtGarbageCollectLoop() {
 	}
 }
 
+// dialSetupConn dials the setup nodes tier by tier, a tier is only dialed once all nodes of the previous ones failed.
 func (rm *routeManager) dialSetupConn(_ context.Context) (*snet.Conn, error) {
-	for _, sPK := range rm.conf.SetupPKs {
-		conn, err := rm.n.Dial(snet.DmsgType, sPK, snet.SetupPort)
-		if err != nil {
-			rm.Logger.WithError(err).Warnf("failed to dial to setup node: setupPK(%s)", sPK)
-			continue
+	for i, tier := range rm.conf.setupTiers() {
+		for _, sPK := range tier {
+			conn, err := rm.n.Dial(snet.DmsgType, sPK, snet.SetupPort)
+			if err != nil {
+				rm.Logger.WithError(err).Warnf("failed to dial to setup node: setupPK(%s) tier(%d)", sPK, i)
+				continue
+			}
+			return conn, nil
 		}
-		return conn, nil
 	}
 	return nil, errors.New("failed to dial to a setup node")
 }
@@ -192,31 +210,43 @@ func (rm *routeManager) GetRule(routeID routing.RouteID) (routing.Rule, error) {
 	return rule, nil
 }
 
-// RemoveLoopRule removes loop rule.
-func (rm *routeManager) RemoveLoopRule(loop routing.Loop) error {
-	var appRouteID routing.RouteID
-	var appRule routing.Rule
+// loopRules returns copies of the consume (app) rules of loop, keyed by route ID.
+// A loop may be fed by several consume rules when it aggregates traffic of multiple inbound routes.
+func (rm *routeManager) loopRules(loop routing.Loop) (map[routing.RouteID]routing.Rule, error) {
+	rules := make(map[routing.RouteID]routing.Rule)
 	err := rm.rt.RangeRules(func(routeID routing.RouteID, rule routing.Rule) bool {
 		if rule.Type() != routing.RuleApp || rule.RemotePK() != loop.Remote.PubKey ||
 			rule.RemotePort() != loop.Remote.Port || rule.LocalPort() != loop.Local.Port {
 			return true
 		}
 
-		appRouteID = routeID
-		appRule = make(routing.Rule, len(rule))
+		appRule := make(routing.Rule, len(rule))
 		copy(appRule, rule)
-
-		return false
+		rules[routeID] = appRule
+		return true
 	})
 	if err != nil {
-		return fmt.Errorf("routing table: %s", err)
+		return nil, fmt.Errorf("routing table: %s", err)
+	}
+	return rules, nil
+}
+
+// RemoveLoopRule removes all consume rules of loop.
+func (rm *routeManager) RemoveLoopRule(loop routing.Loop) error {
+	rules, err := rm.loopRules(loop)
+	if err != nil {
+		return err
 	}
 
-	if len(appRule) == 0 {
+	if len(rules) == 0 {
 		return nil
 	}
 
-	if err = rm.rt.DeleteRules(appRouteID); err != nil {
+	routeIDs := make([]routing.RouteID, 0, len(rules))
+	for routeID := range rules {
+		routeIDs = append(routeIDs, routeID)
+	}
+	if err = rm.rt.DeleteRules(routeIDs...); err != nil {
 		return fmt.Errorf("routing table: %s", err)
 	}
 
@@ -232,13 +262,20 @@ func (rm *routeManager) setRoutingRules(data []byte) error {
 	jb, _ := json.MarshalIndent(rules, "", "\t") //nolint:errcheck
 	rm.Logger.Infof("Adding rules: %s", string(jb))
 
-	for _, rule := range rules {
-		routeID := rule.RequestRouteID()
-		if err := rm.rt.SetRule(routeID, rule); err != nil {
-			return fmt.Errorf("routing table: %s", err)
+	err := rm.rt.Transaction(func(tx routing.RuleTx) error {
+		for _, rule := range rules {
+			if err := tx.SetRule(rule.RequestRouteID(), rule); err != nil {
+				return err
+			}
 		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("routing table: %s", err)
+	}
 
-		rm.Logger.Infof("Set new Routing Rule with ID %d %s", routeID, rule)
+	for _, rule := range rules {
+		rm.Logger.Infof("Set new Routing Rule with ID %d %s", rule.RequestRouteID(), rule)
 	}
 
 	return nil
@@ -265,24 +302,12 @@ func (rm *routeManager) confirmLoop(data []byte) error {
 		return err
 	}
 
-	var appRouteID routing.RouteID
-	var appRule routing.Rule
-	err := rm.rt.RangeRules(func(routeID routing.RouteID, rule routing.Rule) bool {
-		if rule.Type() != routing.RuleApp || rule.RemotePK() != ld.Loop.Remote.PubKey ||
-			rule.RemotePort() != ld.Loop.Remote.Port || rule.LocalPort() != ld.Loop.Local.Port {
-			return true
-		}
-
-		appRouteID = routeID
-		appRule = make(routing.Rule, len(rule))
-		copy(appRule, rule)
-		return false
-	})
+	appRules, err := rm.loopRules(ld.Loop)
 	if err != nil {
-		return fmt.Errorf("routing table: %s", err)
+		return err
 	}
 
-	if appRule == nil {
+	if len(appRules) == 0 {
 		return errors.New("unknown loop")
 	}
 
@@ -299,10 +324,12 @@ func (rm *routeManager) confirmLoop(data []byte) error {
 		return fmt.Errorf("confirm: %s", err)
 	}
 
-	rm.Logger.Infof("Setting reverse route ID %d for rule with ID %d", ld.RouteID, appRouteID)
-	appRule.SetRouteID(ld.RouteID)
-	if rErr := rm.rt.SetRule(appRouteID, appRule); rErr != nil {
-		return fmt.Errorf("routing table: %s", rErr)
+	for appRouteID, appRule := range appRules {
+		rm.Logger.Infof("Setting reverse route ID %d for rule with ID %d", ld.RouteID, appRouteID)
+		appRule.SetRouteID(ld.RouteID)
+		if rErr := rm.rt.SetRule(appRouteID, appRule); rErr != nil {
+			return fmt.Errorf("routing table: %s", rErr)
+		}
 	}
 
 	rm.Logger.Infof("Confirmed loop with %s:%d", ld.Loop.Remote.PubKey, ld.Loop.Remote.Port)
@@ -315,7 +342,21 @@ func (rm *routeManager) loopClosed(data []byte) error {
 		return err
 	}
 
-	return rm.conf.OnLoopClosed(ld.Loop)
+	rm.Logger.Debugf("Received loop closed packet for loop %s", ld.Loop)
+	return rm.conf.OnLoopClosed(ld.Loop, ld.CloseCode)
+}
+
+func (rm *routeManager) loopPaused(data []byte) error {
+	var ld routing.LoopData
+	if err := json.Unmarshal(data, &ld); err != nil {
+		return err
+	}
+	if rm.conf.OnLoopPaused == nil {
+		return errors.New("pausing loops is not supported")
+	}
+
+	rm.Logger.Debugf("Received loop paused packet for loop %s: paused=%t", ld.Loop, ld.Paused)
+	return rm.conf.OnLoopPaused(ld.Loop, ld.Paused)
 }
 
 func (rm *routeManager) occupyRouteID(data []byte) ([]routing.RouteID, error) {
@@ -325,13 +366,19 @@ func (rm *routeManager) occupyRouteID(data []byte) ([]routing.RouteID, error) {
 	}
 
 	var ids = make([]routing.RouteID, n)
-	for i := range ids {
-		rule := routing.ForwardRule(DefaultRouteKeepAlive, 0, uuid.UUID{}, 0)
-		routeID, err := rm.rt.AddRule(rule)
-		if err != nil {
-			return nil, err
+	err := rm.rt.Transaction(func(tx routing.RuleTx) error {
+		for i := range ids {
+			rule := routing.ForwardRule(DefaultRouteKeepAlive, 0, uuid.UUID{}, 0)
+			routeID, err := tx.AddRule(rule)
+			if err != nil {
+				return err
+			}
+			ids[i] = routeID
 		}
-		ids[i] = routeID
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 	return ids, nil
 }
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/SkycoinProject/skywire-mainnet/pkg/setup"
+	"github.com/SkycoinProject/skywire-mainnet/pkg/snet"
 	"github.com/SkycoinProject/skywire-mainnet/pkg/snet/snettest"
 
 	"github.com/SkycoinProject/dmsg/cipher"
@@ -29,6 +30,9 @@ func TestNewRouteManager(t *testing.T) {
 	require.NoError(t, err)
 	defer func() { require.NoError(t, rm.Close()) }()
 
+	clock := newFakeClock()
+	rm.rt.clock = clock
+
 	// CLOSURE: Delete all routing rules.
 	clearRules := func() {
 		var rules []routing.RouteID
@@ -51,9 +55,7 @@ func TestNewRouteManager(t *testing.T) {
 		id, err := rm.rt.AddRule(rule)
 		require.NoError(t, err)
 
-		// rule should already be expired at this point due to the execution time.
-		// However, we'll just a bit to be sure
-		time.Sleep(1 * time.Millisecond)
+		clock.Advance(time.Millisecond)
 
 		_, err = rm.GetRule(expiredID)
 		require.Error(t, err)
@@ -79,6 +81,11 @@ func TestNewRouteManager(t *testing.T) {
 		require.NoError(t, rm.RemoveLoopRule(loop))
 		assert.Equal(t, 1, rt.Count())
 
+		// All consume rules of the loop are removed.
+		_, err = rt.AddRule(rule)
+		require.NoError(t, err)
+		assert.Equal(t, 2, rt.Count())
+
 		loop = routing.Loop{Local: routing.Addr{Port: 2}, Remote: routing.Addr{PubKey: pk, Port: 3}}
 		require.NoError(t, rm.RemoveLoopRule(loop))
 		assert.Equal(t, 0, rt.Count())
@@ -222,10 +229,14 @@ func TestNewRouteManager(t *testing.T) {
 	t.Run("LoopClosed", func(t *testing.T) {
 		defer clearRules()
 
-		var inLoop routing.Loop
+		var (
+			inLoop routing.Loop
+			inCode routing.CloseCode
+		)
 
-		rm.conf.OnLoopClosed = func(loop routing.Loop) error {
+		rm.conf.OnLoopClosed = func(loop routing.Loop, code routing.CloseCode) error {
 			inLoop = loop
+			inCode = code
 			return nil
 		}
 		defer func() { rm.conf.OnLoopClosed = nil }()
@@ -260,11 +271,97 @@ func TestNewRouteManager(t *testing.T) {
 					Port: 2,
 				},
 			},
-			RouteID: 1,
+			RouteID:   1,
+			CloseCode: routing.CloseError,
 		}
 		require.NoError(t, setup.LoopClosed(context.TODO(), proto, ld))
 		assert.Equal(t, routing.Port(2), inLoop.Local.Port)
 		assert.Equal(t, routing.Port(3), inLoop.Remote.Port)
 		assert.Equal(t, pk, inLoop.Remote.PubKey)
+		assert.Equal(t, routing.CloseError, inCode)
 	})
 }
+
+// Ensure that setup nodes are dialed tier by tier.
+func TestRouteManager_dialSetupConn(t *testing.T) {
+	keys := snettest.GenKeyPairs(4)
+
+	env := snettest.NewEnv(t, keys)
+	defer env.Teardown()
+
+	// keys[1] is not a setup node, the others serve setup requests.
+	for _, n := range env.Nets[2:] {
+		lis, err := n.Listen(snet.DmsgType, snet.SetupPort)
+		require.NoError(t, err)
+		defer func() { assert.NoError(t, lis.Close()) }()
+		go func() {
+			for {
+				conn, err := lis.Accept()
+				if err != nil {
+					return
+				}
+				_ = conn.Close() //nolint:errcheck
+			}
+		}()
+	}
+	unknownPK, _ := cipher.GenerateKeyPair()
+
+	rm, err := newRouteManager(env.Nets[0], routing.InMemoryRoutingTable(), RMConfig{})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, rm.Close()) }()
+
+	cases := []struct {
+		name     string
+		primary  []cipher.PubKey
+		fallback [][]cipher.PubKey
+		wantPK   cipher.PubKey
+		wantErr  bool
+	}{
+		{
+			name:     "primary_succeeds",
+			primary:  []cipher.PubKey{keys[1].PK, keys[3].PK},
+			fallback: [][]cipher.PubKey{{keys[2].PK}},
+			wantPK:   keys[3].PK,
+		},
+		{
+			name:     "primary_fails",
+			primary:  []cipher.PubKey{unknownPK, keys[1].PK},
+			fallback: [][]cipher.PubKey{{keys[3].PK}, {keys[2].PK}},
+			wantPK:   keys[3].PK,
+		},
+		{
+			name:     "first_fallback_fails",
+			primary:  []cipher.PubKey{keys[1].PK},
+			fallback: [][]cipher.PubKey{{unknownPK}, {keys[2].PK}, {keys[3].PK}},
+			wantPK:   keys[2].PK,
+		},
+		{
+			name:     "all_fail",
+			primary:  []cipher.PubKey{keys[1].PK},
+			fallback: [][]cipher.PubKey{{unknownPK}},
+			wantErr:  true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rm.conf.SetupPKs = tc.primary
+			rm.conf.FallbackSetupPKs = tc.fallback
+
+			conn, err := rm.dialSetupConn(context.TODO())
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantPK, conn.RemotePK())
+			assert.NoError(t, conn.Close())
+
+			for _, tier := range tc.fallback {
+				for _, pk := range tier {
+					assert.True(t, rm.conf.SetupIsTrusted(pk))
+				}
+			}
+		})
+	}
+}
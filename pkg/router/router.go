@@ -8,10 +8,12 @@ import (
 	"io"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/SkycoinProject/dmsg/cipher"
 	"github.com/SkycoinProject/skycoin/src/util/logging"
+	"github.com/google/uuid"
 
 	"github.com/SkycoinProject/skywire-mainnet/pkg/app"
 	routeFinder "github.com/SkycoinProject/skywire-mainnet/pkg/route-finder/client"
@@ -32,6 +34,35 @@ const (
 	maxHops = 50
 )
 
+var (
+	// ErrNoPinnedFirstHop occurs when the route finder returns no forward route through the pinned first hop.
+	ErrNoPinnedFirstHop = errors.New("no route through the pinned first hop")
+
+	// ErrNoTransportToFirstHop occurs when there is no transport to the pinned first hop.
+	ErrNoTransportToFirstHop = errors.New("no transport to the pinned first hop")
+
+	// ErrPacketTTLExpired occurs when a packet to be forwarded has no hops left, it is dropped.
+	ErrPacketTTLExpired = errors.New("packet TTL expired")
+
+	// ErrSourceMismatch occurs when Config.SourceGuard is set and a packet of a consume rule is read
+	// from a transport to another remote than the previous hop of the rule, it is dropped.
+	ErrSourceMismatch = errors.New("packet source does not match consume rule")
+
+	// ErrDuplicatePacket occurs when Config.DedupWindow is set and a packet of a consume rule
+	// has a sequence number which was recently delivered, it is dropped.
+	ErrDuplicatePacket = errors.New("duplicate packet")
+
+	// ErrUnknownTransport occurs when a transport ID is not known to the transport manager.
+	ErrUnknownTransport = errors.New("unknown transport")
+
+	// ErrTransportRemoteMismatch occurs when a transport is replaced by a transport to another remote.
+	ErrTransportRemoteMismatch = errors.New("transports lead to different remotes")
+
+	// ErrPortBound occurs when an app binds a port which is already bound. As ports are shared by all
+	// network types, this is regardless of the networks the apps are reached through.
+	ErrPortBound = errors.New("port is already bound")
+)
+
 var log = logging.MustGetLogger("router")
 
 // Config configures Router.
@@ -43,7 +74,32 @@ type Config struct {
 	RoutingTable           routing.Table
 	RouteFinder            routeFinder.Client
 	SetupNodes             []cipher.PubKey
+	FallbackSetupNodes     [][]cipher.PubKey // tiers of setup nodes, dialed in order once all SetupNodes fail
 	GarbageCollectDuration time.Duration
+	FirstHops              map[cipher.PubKey]cipher.PubKey // key: destination, value: pinned first hop of forward routes
+	SetupTimeout           time.Duration                   // time budget of loop setups, 0 uses the setup node's default
+	CloseTimeout           time.Duration                   // time budget of notifying setup nodes of closed and paused loops, 0 waits for setup.ReadTimeout
+	LoopControlTimeout     time.Duration                   // time to wait for the remote router to acknowledge an in-band pause, 0 uses DefaultLoopControlTimeout
+	PacketSizeBuckets      []int                           // payload size buckets of Metrics, nil uses DefaultPacketSizeBuckets
+	SetupProgress          setup.ProgressFunc              // if set, receives the progress of loops initiated by this router
+	PacketTTL              uint8                           // hop limit of packets sent by local apps, 0 uses routing.DefaultPacketTTL
+	TransportPreference    []string                        // network types of transports to forward over, most preferred first, see selectTransport
+
+	// SourceGuard drops packets of consume rules which are not read from a transport to the previous hop
+	// recorded by the rule, that is the last node of the route to the local node, see routing.Rule.PreviousHop.
+	// Rules which record no previous hop, such as rules of older setup nodes, are not checked.
+	SourceGuard bool
+
+	// DedupWindow is the number of the latest sequence numbers remembered per consume rule. Packets whose
+	// sequence number was remembered are dropped instead of being delivered twice, 0 disables it.
+	// Sequence numbers are only carried by transports of which both ends enabled them, see snet.FeaturePacketSeq.
+	DedupWindow int
+
+	// TransportFailureThreshold is the number of consecutive failed writes after which a transport is skipped
+	// for TransportFailureCooldown in favour of other transports to the same remote, see selectTransport.
+	// As transports are only switched along TransportPreference, it has no effect without it. 0 disables it.
+	TransportFailureThreshold int
+	TransportFailureCooldown  time.Duration // 0 uses DefaultTransportFailureCooldown
 }
 
 // SetDefaults sets default values for certain empty values.
@@ -54,6 +110,15 @@ func (c *Config) SetDefaults() {
 	if c.GarbageCollectDuration <= 0 {
 		c.GarbageCollectDuration = DefaultGarbageCollectDuration
 	}
+	if c.PacketSizeBuckets == nil {
+		c.PacketSizeBuckets = DefaultPacketSizeBuckets
+	}
+	if c.PacketTTL == 0 {
+		c.PacketTTL = routing.DefaultPacketTTL
+	}
+	if c.LoopControlTimeout <= 0 {
+		c.LoopControlTimeout = DefaultLoopControlTimeout
+	}
 }
 
 // Router implements node.PacketRouter. It manages routing table by
@@ -66,10 +131,23 @@ type Router struct {
 	staticPorts map[routing.Port]struct{}
 
 	n  *snet.Network
-	tm *transport.Manager
+	tm TransportManager
 	pm *portManager
 	rm *routeManager
 
+	readSizes  *sizeCounter
+	writeSizes *sizeCounter
+	traffic    map[*app.Protocol]*appTraffic // per app, guarded by mx
+	consumed   *consumeCounters              // per consume rule
+	dedup      *dedupWindows                 // per consume rule, nil without Config.DedupWindow
+	cooldowns  *tpCooldowns                  // nil without Config.TransportFailureThreshold
+	acks       *loopAcks                     // in-band pauses awaiting acknowledgement, see pauseLoop
+	rejected   uint64                        // packets dropped by Config.SourceGuard, accessed atomically
+	duplicates uint64                        // packets dropped by Config.DedupWindow, accessed atomically
+
+	selections map[cipher.PubKey]*tpSelection // per remote, see selectTransport
+	selMx      sync.Mutex
+
 	wg sync.WaitGroup
 	mx sync.Mutex
 }
@@ -78,21 +156,18 @@ type Router struct {
 func New(n *snet.Network, config *Config) (*Router, error) {
 	config.SetDefaults()
 
-	r := &Router{
-		Logger:      config.Logger,
-		n:           n,
-		tm:          config.TransportManager,
-		pm:          newPortManager(10),
-		conf:        config,
-		staticPorts: make(map[routing.Port]struct{}),
-	}
+	r := newRouter(config, managerTransports{config.TransportManager})
+	r.n = n
 
 	// Prepare route manager.
 	rm, err := newRouteManager(n, config.RoutingTable, RMConfig{
+		Logger:                 config.Logger,
 		SetupPKs:               config.SetupNodes,
+		FallbackSetupPKs:       config.FallbackSetupNodes,
 		GarbageCollectDuration: config.GarbageCollectDuration,
 		OnConfirmLoop:          r.confirmLoop,
 		OnLoopClosed:           r.loopClosed,
+		OnLoopPaused:           r.loopPaused,
 	})
 	if err != nil {
 		return nil, err
@@ -102,25 +177,34 @@ func New(n *snet.Network, config *Config) (*Router, error) {
 	return r, nil
 }
 
+// newRouter constructs a Router over tm without a route manager. config must have its defaults set.
+func newRouter(config *Config, tm TransportManager) *Router {
+	var dedup *dedupWindows
+	if config.DedupWindow > 0 {
+		dedup = newDedupWindows(config.DedupWindow)
+	}
+	return &Router{
+		Logger:      config.Logger,
+		tm:          tm,
+		pm:          newPortManager(10),
+		conf:        config,
+		staticPorts: make(map[routing.Port]struct{}),
+		readSizes:   newSizeCounter(config.PacketSizeBuckets),
+		writeSizes:  newSizeCounter(config.PacketSizeBuckets),
+		traffic:     make(map[*app.Protocol]*appTraffic),
+		consumed:    newConsumeCounters(),
+		dedup:       dedup,
+		cooldowns:   newTPCooldowns(config.TransportFailureThreshold, config.TransportFailureCooldown),
+		acks:        newLoopAcks(),
+		selections:  make(map[cipher.PubKey]*tpSelection),
+	}
+}
+
 // Serve starts transport listening loop.
 func (r *Router) Serve(ctx context.Context) error {
 	r.Logger.Info("Starting router")
 
-	go func() {
-		for {
-			packet, err := r.tm.ReadPacket()
-			if err != nil {
-				return
-			}
-			if err := r.handlePacket(ctx, packet); err != nil {
-				if err == transport.ErrNotServing {
-					r.Logger.WithError(err).Warnf("Stopped serving Transport.")
-					return
-				}
-				r.Logger.Warnf("Failed to handle transport frame: %v", err)
-			}
-		}
-	}()
+	go r.servePackets(ctx)
 
 	r.wg.Add(1)
 	go func() {
@@ -132,16 +216,67 @@ func (r *Router) Serve(ctx context.Context) error {
 	return nil
 }
 
-func (r *Router) handlePacket(ctx context.Context, packet routing.Packet) error {
+// servePackets handles packets read from transports until the transport manager stops serving.
+// Read errors of single transports are handled by the transports, see transport.ManagedTransport.Serve.
+func (r *Router) servePackets(ctx context.Context) {
+	for {
+		packet, ttl, seq, typ, from, err := r.tm.ReadPacketFrom()
+		if err != nil {
+			r.Logger.WithError(err).Warnf("Stopped serving Transport.")
+			return
+		}
+
+		err = r.handlePacket(ctx, packet, ttl, seq, typ, from)
+		switch err {
+		case nil:
+		case transport.ErrNotServing:
+			r.Logger.WithError(err).Warnf("Stopped serving Transport.")
+			transport.ReleasePacket(packet)
+			return
+		case ErrSourceMismatch, ErrPacketTTLExpired, ErrDuplicatePacket, ErrUnknownPacketType:
+			// These are only returned for well-formed packets.
+			r.Logger.Warnf("Dropped packet with route ID %d from %s: %v", packet.RouteID(), from, err)
+		default:
+			r.Logger.Warnf("Failed to handle transport frame: %v", err)
+		}
+		// Packets are either written to a transport or sent to an app before handlePacket returns.
+		transport.ReleasePacket(packet)
+	}
+}
+
+// handlePacket forwards or consumes a packet with the given TTL, sequence number and type read from the transport to the remote from.
+// Packets of any type are forwarded, consumed packets other than routing.DataPackets control the loop of their rule.
+func (r *Router) handlePacket(ctx context.Context, packet routing.Packet, ttl uint8, seq uint32, typ routing.PacketType, from cipher.PubKey) error {
+	if err := routing.ValidatePacket(packet); err != nil {
+		return fmt.Errorf("dropped malformed packet: %v", err)
+	}
+	r.readSizes.add(len(packet.Payload()))
+
 	rule, err := r.rm.GetRule(packet.RouteID())
 	if err != nil {
 		return err
 	}
 	r.Logger.Infof("Got new remote packet with route ID %d. Using rule: %s", packet.RouteID(), rule)
 	if rule.Type() == routing.RuleForward {
-		return r.forwardPacket(ctx, packet.Payload(), rule)
+		return r.forwardPacket(ctx, packet, ttl, seq, typ, rule)
+	}
+	if r.conf.SourceGuard {
+		if prevHop, ok := rule.PreviousHop(); ok && from != prevHop {
+			atomic.AddUint64(&r.rejected, 1)
+			return ErrSourceMismatch
+		}
+	}
+	if typ != routing.DataPacket {
+		return r.handleLoopControl(ctx, typ, packet.Payload(), rule)
 	}
-	return r.consumePacket(packet.Payload(), rule)
+	if r.dedup != nil {
+		loop := routing.Loop{Local: routing.Addr{Port: rule.LocalPort()}, Remote: routing.Addr{PubKey: rule.RemotePK(), Port: rule.RemotePort()}}
+		if r.dedup.dup(packet.RouteID(), loop, seq) {
+			atomic.AddUint64(&r.duplicates, 1)
+			return ErrDuplicatePacket
+		}
+	}
+	return r.consumePacket(packet.RouteID(), packet.Payload(), rule)
 }
 
 // ServeApp handles App packets from the App connection on provided port.
@@ -158,11 +293,13 @@ func (r *Router) ServeApp(conn net.Conn, port routing.Port, appConf *app.Config)
 
 	r.mx.Lock()
 	r.staticPorts[port] = struct{}{}
+	r.traffic[appProto] = &appTraffic{port: port}
 	r.mx.Unlock()
 
 	callbacks := &appCallbacks{
 		CreateLoop: r.requestLoop,
 		CloseLoop:  r.closeLoop,
+		PauseLoop:  r.pauseLoop,
 		Forward:    r.forwardAppPacket,
 	}
 	am := &appManager{r.Logger, appProto, appConf, callbacks}
@@ -170,7 +307,9 @@ func (r *Router) ServeApp(conn net.Conn, port routing.Port, appConf *app.Config)
 
 	for _, port := range r.pm.AppPorts(appProto) {
 		for _, addr := range r.pm.Close(port) {
-			if err := r.closeLoop(context.TODO(), appProto, routing.Loop{Local: routing.Addr{Port: port}, Remote: addr}); err != nil {
+			// The app stopped being served without closing the loop.
+			loop := routing.Loop{Local: routing.Addr{Port: port}, Remote: addr}
+			if err := r.closeLoop(context.TODO(), appProto, loop, routing.CloseError); err != nil {
 				log.WithError(err).Warn("Failed to close loop")
 			}
 		}
@@ -178,6 +317,7 @@ func (r *Router) ServeApp(conn net.Conn, port routing.Port, appConf *app.Config)
 
 	r.mx.Lock()
 	delete(r.staticPorts, port)
+	delete(r.traffic, appProto)
 	r.mx.Unlock()
 
 	if err == io.EOF {
@@ -186,6 +326,17 @@ func (r *Router) ServeApp(conn net.Conn, port routing.Port, appConf *app.Config)
 	return err
 }
 
+// Metrics returns the payload size counts of the packets read from and written to transports
+// and the number of packets dropped by Config.SourceGuard and Config.DedupWindow.
+func (r *Router) Metrics() Metrics {
+	return Metrics{
+		Read:            r.readSizes.snapshot(),
+		Written:         r.writeSizes.snapshot(),
+		RejectedSources: atomic.LoadUint64(&r.rejected),
+		Duplicates:      atomic.LoadUint64(&r.duplicates),
+	}
+}
+
 // Close safely stops Router.
 func (r *Router) Close() error {
 	if r == nil {
@@ -207,19 +358,42 @@ func (r *Router) Close() error {
 	return r.tm.Close()
 }
 
-func (r *Router) forwardPacket(ctx context.Context, payload []byte, rule routing.Rule) error {
-	tp := r.tm.Transport(rule.TransportID())
+// forwardPacket forwards the packet to the next hop with its TTL decremented and its sequence number and type kept.
+// Packets which have no hops left are dropped, so that packets of looping routes do not circulate forever.
+// TTLs are only carried by transports of which both ends enabled them, see snet.FeaturePacketTTL.
+// Packets other than routing.DataPackets are dropped if the next transport does not carry packet types.
+func (r *Router) forwardPacket(ctx context.Context, packet routing.Packet, ttl uint8, seq uint32, typ routing.PacketType, rule routing.Rule) error {
+	if ttl <= 1 {
+		return ErrPacketTTLExpired
+	}
+
+	tp := r.selectTransport(rule.TransportID())
 	if tp == nil {
-		return errors.New("unknown transport")
+		return ErrUnknownTransport
+	}
+	payload := packet.Payload()
+	var err error
+	if typ != routing.DataPacket {
+		err = tp.WriteControlPacket(ctx, rule.RouteID(), ttl-1, typ, payload)
+	} else {
+		if max := tp.MaxPayloadSize(); max > 0 && len(payload) > max {
+			// The parts of a split packet would share its sequence number, so they are sent without one.
+			seq = 0
+		}
+		err = writePayload(ctx, tp, rule.RouteID(), ttl-1, func() uint32 { return seq }, payload)
 	}
-	if err := tp.WritePacket(ctx, rule.RouteID(), payload); err != nil {
+	r.cooldowns.record(tp.ID(), err)
+	if err != nil {
 		return err
 	}
-	r.Logger.Infof("Forwarded packet via Transport %s using rule %d", rule.TransportID(), rule.RouteID())
+	r.writeSizes.add(len(payload))
+	r.Logger.Infof("Forwarded packet via Transport %s using rule %d", tp.ID(), rule.RouteID())
 	return nil
 }
 
-func (r *Router) consumePacket(payload []byte, rule routing.Rule) error {
+// consumePacket delivers the payload of a packet read with routeID to the local app of the loop of rule.
+// Several consume rules may deliver to the same loop, their packets are delivered in the order they are read.
+func (r *Router) consumePacket(routeID routing.RouteID, payload []byte, rule routing.Rule) error {
 	laddr := routing.Addr{Port: rule.LocalPort()}
 	raddr := routing.Addr{PubKey: rule.RemotePK(), Port: rule.RemotePort()}
 
@@ -234,6 +408,8 @@ func (r *Router) consumePacket(payload []byte, rule routing.Rule) error {
 		return err
 	}
 	fmt.Println("done")
+	r.appTraffic(b.conn).addReceived(len(payload))
+	r.consumed.add(routeID, p.Loop, len(payload))
 
 	r.Logger.Infof("Forwarded packet to App on Port %d", rule.LocalPort())
 	return nil
@@ -241,7 +417,7 @@ func (r *Router) consumePacket(payload []byte, rule routing.Rule) error {
 
 func (r *Router) forwardAppPacket(ctx context.Context, appConn *app.Protocol, packet *app.Packet) error {
 	if packet.Loop.Remote.PubKey == r.conf.PubKey {
-		return r.forwardLocalAppPacket(packet)
+		return r.forwardLocalAppPacket(appConn, packet)
 	}
 
 	l, err := r.pm.GetLoop(packet.Loop.Local.Port, packet.Loop.Remote)
@@ -249,16 +425,32 @@ func (r *Router) forwardAppPacket(ctx context.Context, appConn *app.Protocol, pa
 		return err
 	}
 
-	tr := r.tm.Transport(l.trID)
+	tr := r.selectTransport(l.trID)
 	if tr == nil {
-		return errors.New("unknown transport")
+		return ErrUnknownTransport
+	}
+
+	if len(packet.Payload) == 0 {
+		return nil
+	}
+
+	if l.weight != 0 {
+		// The transport may have been replaced since the last write, so the weight is set on every write.
+		tr.SetRouteWeight(l.routeID, l.weight)
 	}
 
 	r.Logger.Infof("Forwarded App packet from LocalPort %d using route ID %d", packet.Loop.Local.Port, l.routeID)
-	return tr.WritePacket(ctx, l.routeID, packet.Payload)
+	err = writePayload(ctx, tr, l.routeID, r.conf.PacketTTL, l.nextSeq, packet.Payload)
+	r.cooldowns.record(tr.ID(), err)
+	if err != nil {
+		return err
+	}
+	r.writeSizes.add(len(packet.Payload))
+	r.appTraffic(appConn).addSent(len(packet.Payload))
+	return nil
 }
 
-func (r *Router) forwardLocalAppPacket(packet *app.Packet) error {
+func (r *Router) forwardLocalAppPacket(appConn *app.Protocol, packet *app.Packet) error {
 	b, err := r.pm.Get(packet.Loop.Remote.Port)
 	if err != nil {
 		return nil
@@ -271,7 +463,12 @@ func (r *Router) forwardLocalAppPacket(packet *app.Packet) error {
 		},
 		Payload: packet.Payload,
 	}
-	return b.conn.Send(app.FrameSend, p, nil)
+	if err := b.conn.Send(app.FrameSend, p, nil); err != nil {
+		return err
+	}
+	r.appTraffic(appConn).addSent(len(packet.Payload))
+	r.appTraffic(b.conn).addReceived(len(packet.Payload))
+	return nil
 }
 
 func (r *Router) requestLoop(ctx context.Context, appConn *app.Protocol, raddr routing.Addr) (routing.Addr, error) {
@@ -289,21 +486,32 @@ func (r *Router) requestLoop(ctx context.Context, appConn *app.Protocol, raddr r
 		return laddr, nil
 	}
 
+	l := routing.Loop{Local: laddr, Remote: raddr}
+
+	r.reportProgress(setup.StageFindingRoutes, l, cipher.PubKey{})
 	forwardRoute, reverseRoute, err := r.fetchBestRoutes(laddr.PubKey, raddr.PubKey)
 	if err != nil {
 		return routing.Addr{}, fmt.Errorf("route finder: %s", err)
 	}
 
 	ld := routing.LoopDescriptor{
-		Loop: routing.Loop{
-			Local:  laddr,
-			Remote: raddr,
-		},
-		KeepAlive: DefaultRouteKeepAlive,
-		Forward:   forwardRoute,
-		Reverse:   reverseRoute,
+		Loop:         l,
+		KeepAlive:    DefaultRouteKeepAlive,
+		Forward:      forwardRoute,
+		Reverse:      reverseRoute,
+		SetupTimeout: r.conf.SetupTimeout,
+	}
+	if err := ld.Validate(); err != nil {
+		return routing.Addr{}, fmt.Errorf("route finder: %s", err)
 	}
 
+	if r.conf.SetupTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.conf.SetupTimeout)
+		defer cancel()
+	}
+
+	r.reportProgress(setup.StageContactingSetupNode, l, cipher.PubKey{})
 	sConn, err := r.rm.dialSetupConn(ctx)
 	if err != nil {
 		return routing.Addr{}, err
@@ -313,14 +521,22 @@ func (r *Router) requestLoop(ctx context.Context, appConn *app.Protocol, raddr r
 			r.Logger.Warnf("Failed to close transport: %s", err)
 		}
 	}()
+	r.reportProgress(setup.StageRequestingLoop, l, sConn.RemotePK())
 	if err := setup.CreateLoop(ctx, setup.NewSetupProtocol(sConn), ld); err != nil {
 		return routing.Addr{}, fmt.Errorf("route setup: %s", err)
 	}
 
+	r.reportProgress(setup.StageLoopCreated, l, sConn.RemotePK())
 	r.Logger.Infof("Created new loop to %s on port %d", raddr, laddr.Port)
 	return laddr, nil
 }
 
+func (r *Router) reportProgress(stage setup.ProgressStage, l routing.Loop, pk cipher.PubKey) {
+	if r.conf.SetupProgress != nil {
+		r.conf.SetupProgress(setup.Progress{Stage: stage, Loop: l, PubKey: pk})
+	}
+}
+
 func (r *Router) confirmLocalLoop(laddr, raddr routing.Addr) error {
 	b, err := r.pm.Get(raddr.Port)
 	if err != nil {
@@ -341,7 +557,14 @@ func (r *Router) confirmLoop(l routing.Loop, rule routing.Rule) error {
 		return err
 	}
 
-	if err := r.pm.SetLoop(l.Local.Port, l.Remote, &loop{rule.TransportID(), rule.RouteID()}); err != nil {
+	// A weight set while the loop was being created is kept.
+	err = r.pm.UpdateLoop(l.Local.Port, l.Remote, true, func(lp *loop) {
+		lp.trID, lp.routeID = rule.TransportID(), rule.RouteID()
+		if lp.seq == nil {
+			lp.seq = new(uint32)
+		}
+	})
+	if err != nil {
 		return err
 	}
 
@@ -353,11 +576,63 @@ func (r *Router) confirmLoop(l routing.Loop, rule routing.Rule) error {
 	return nil
 }
 
-func (r *Router) closeLoop(ctx context.Context, appConn *app.Protocol, loop routing.Loop) error {
+// closeLoop closes a loop of a local app and notifies the remote app of it with code through a setup node.
+func (r *Router) closeLoop(ctx context.Context, appConn *app.Protocol, loop routing.Loop, code routing.CloseCode) error {
+	// The loop is closed locally regardless of whether the setup node can be notified.
 	if err := r.destroyLoop(loop); err != nil {
 		r.Logger.Warnf("Failed to remove loop: %s", err)
 	}
 
+	r.Logger.Debugf("Sending close loop packet for loop %s", loop)
+	err := r.requestSetup(ctx, func(ctx context.Context, proto *setup.Protocol) error {
+		return setup.CloseLoop(ctx, proto, routing.LoopData{Loop: loop, CloseCode: code})
+	})
+	if err != nil {
+		return err
+	}
+	r.Logger.Infof("Closed loop %s", loop)
+	return nil
+}
+
+// pauseLoop notifies the remote app that a local app paused or resumed a loop.
+// The remote router is told in-band over the route of the loop. If it does not acknowledge that within
+// Config.LoopControlTimeout, it is told through a setup node instead. Once a loop could not be paused in-band
+// as its route does not carry packet types, later pauses of the loop go through a setup node right away.
+func (r *Router) pauseLoop(ctx context.Context, appConn *app.Protocol, loop routing.Loop, paused bool) error {
+	err := r.pauseLoopInBand(ctx, loop, paused)
+	if err == nil {
+		r.Logger.Infof("Set loop %s paused=%t in-band", loop, paused)
+		return nil
+	}
+	if isInBandUnavailable(err) {
+		r.useSetupControl(loop)
+	}
+	if err != errSetupLoopControl {
+		r.Logger.Debugf("Failed to set loop %s paused=%t in-band, falling back to a setup node: %v", loop, paused, err)
+	}
+
+	// The remote app tracks the loop by our full address.
+	loop.Local.PubKey = r.conf.PubKey
+
+	r.Logger.Debugf("Sending pause loop packet for loop %s: paused=%t", loop, paused)
+	err = r.requestSetup(ctx, func(ctx context.Context, proto *setup.Protocol) error {
+		return setup.PauseLoop(ctx, proto, routing.LoopData{Loop: loop, Paused: paused})
+	})
+	if err != nil {
+		return err
+	}
+	r.Logger.Infof("Set loop %s paused=%t", loop, paused)
+	return nil
+}
+
+// requestSetup performs req with a setup node within Config.CloseTimeout.
+func (r *Router) requestSetup(ctx context.Context, req func(ctx context.Context, proto *setup.Protocol) error) error {
+	if r.conf.CloseTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.conf.CloseTimeout)
+		defer cancel()
+	}
+
 	sConn, err := r.rm.dialSetupConn(ctx)
 	if err != nil {
 		return err
@@ -367,14 +642,25 @@ func (r *Router) closeLoop(ctx context.Context, appConn *app.Protocol, loop rout
 			r.Logger.Warnf("Failed to close transport: %s", err)
 		}
 	}()
-	if err := setup.CloseLoop(ctx, setup.NewSetupProtocol(sConn), routing.LoopData{Loop: loop}); err != nil {
+
+	// Writing the request may block on an unresponsive setup node, closing sConn on return unblocks it.
+	done := make(chan error, 1)
+	go func() {
+		done <- req(ctx, setup.NewSetupProtocol(sConn))
+	}()
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if err != nil {
 		return fmt.Errorf("route setup: %s", err)
 	}
-	r.Logger.Infof("Closed loop %s", loop)
 	return nil
 }
 
-func (r *Router) loopClosed(loop routing.Loop) error {
+// loopClosed closes a loop closed by the remote app with code, passing code on to the local app.
+func (r *Router) loopClosed(loop routing.Loop, code routing.CloseCode) error {
 	b, err := r.pm.Get(loop.Local.Port)
 	if err != nil {
 		return nil
@@ -384,7 +670,7 @@ func (r *Router) loopClosed(loop routing.Loop) error {
 		r.Logger.Warnf("Failed to remove loop: %s", err)
 	}
 
-	if err := b.conn.Send(app.FrameClose, loop, nil); err != nil {
+	if err := b.conn.Send(app.FrameClose, &app.LoopClose{Loop: loop, Code: code}, nil); err != nil {
 		return err
 	}
 
@@ -392,7 +678,20 @@ func (r *Router) loopClosed(loop routing.Loop) error {
 	return nil
 }
 
+// loopPaused passes on to the local app that the remote app paused or resumed a loop.
+func (r *Router) loopPaused(loop routing.Loop, paused bool) error {
+	b, err := r.pm.Get(loop.Local.Port)
+	if err != nil {
+		return err
+	}
+	return b.conn.Send(app.FramePause, &app.LoopPause{Loop: loop, Paused: paused}, nil)
+}
+
 func (r *Router) destroyLoop(loop routing.Loop) error {
+	if l, err := r.pm.GetLoop(loop.Local.Port, loop.Remote); err == nil && l.weight != 0 {
+		r.resetLoopWeight(l)
+	}
+
 	r.mx.Lock()
 	_, ok := r.staticPorts[loop.Local.Port]
 	r.mx.Unlock()
@@ -405,9 +704,28 @@ func (r *Router) destroyLoop(loop routing.Loop) error {
 		r.pm.Close(loop.Local.Port)
 	}
 
+	r.consumed.drop(loop)
+	if r.dedup != nil {
+		r.dedup.drop(loop)
+	}
 	return r.rm.RemoveLoopRule(loop)
 }
 
+// resetLoopWeight restores the default weight of the route of l on the transports it may have been forwarded over.
+// Route IDs are only unique per next hop, so transports to other remotes are left alone.
+func (r *Router) resetLoopWeight(l *loop) {
+	tp := r.tm.Transport(l.trID)
+	if tp == nil {
+		return
+	}
+	r.tm.WalkTransports(func(mt Transport) bool {
+		if mt.Remote() == tp.Remote() {
+			mt.SetRouteWeight(l.routeID, 0)
+		}
+		return true
+	})
+}
+
 func (r *Router) fetchBestRoutes(source, destination cipher.PubKey) (fwd routing.Route, rev routing.Route, err error) {
 	r.Logger.Infof("Requesting new routes from %s to %s", source, destination)
 
@@ -426,7 +744,112 @@ fetchRoutesAgain:
 	}
 
 	r.Logger.Infof("Found routes Forward: %s. Reverse %s", fwdRoutes, revRoutes)
-	return fwdRoutes[0], revRoutes[0], nil
+	return r.selectRoutes(destination, fwdRoutes, revRoutes)
+}
+
+// selectRoutes selects the forward and reverse routes of a loop to 'destination'.
+// If a first hop is pinned for 'destination', the forward route has to start with an existing transport to it.
+func (r *Router) selectRoutes(destination cipher.PubKey, fwdRoutes, revRoutes []routing.Route) (fwd routing.Route, rev routing.Route, err error) {
+	firstHop, ok := r.conf.FirstHops[destination]
+	if !ok {
+		return fwdRoutes[0], revRoutes[0], nil
+	}
+
+	err = ErrNoPinnedFirstHop
+	for _, fwd := range fwdRoutes {
+		if len(fwd) == 0 || fwd[0].To != firstHop {
+			continue
+		}
+		if r.tm.Transport(fwd[0].Transport) == nil {
+			err = ErrNoTransportToFirstHop
+			continue
+		}
+		r.Logger.Infof("Pinned first hop %s for routes to %s", firstHop, destination)
+		return fwd, revRoutes[0], nil
+	}
+	return nil, nil, err
+}
+
+// LoopInfo describes a loop managed by the Router.
+// TransportID and RouteID are empty for loops which are not confirmed yet.
+type LoopInfo struct {
+	Loop        routing.Loop    `json:"loop"`
+	TransportID uuid.UUID       `json:"transport_id"`
+	RouteID     routing.RouteID `json:"route_id"`
+	Weight      int             `json:"weight,omitempty"` // see Router.SetLoopWeight, 0 if not set
+
+	// MaxPayloadSize is the largest payload sent over the loop in one packet, larger writes are split.
+	// It is the least limit of the transports the loop may be forwarded over, 0 if there is none.
+	MaxPayloadSize int `json:"max_payload_size,omitempty"`
+
+	Consumed map[routing.RouteID]ConsumeStats `json:"consumed,omitempty"` // per consume rule delivering to the loop
+}
+
+// Loops returns all loops currently managed by the Router.
+func (r *Router) Loops() []LoopInfo {
+	loops := r.pm.Loops(r.conf.PubKey)
+	for i := range loops {
+		loops[i].Consumed = r.consumed.loop(loops[i].Loop)
+		loops[i].MaxPayloadSize = r.maxPayloadSize(loops[i].TransportID)
+	}
+	return loops
+}
+
+// SetLoopWeight sets the share of the forwarding transport granted to the packets a local app sends over loop,
+// relative to other routes forwarded over the same transport, see transport.ManagedTransport.SetRouteWeight.
+// The weight may be set as soon as the loop is requested and lasts until the loop is closed.
+// A weight below 1 restores the default weight of 1.
+func (r *Router) SetLoopWeight(l routing.Loop, weight int) error {
+	if weight < 1 {
+		weight = 1
+	}
+	return r.pm.UpdateLoop(l.Local.Port, l.Remote, false, func(lp *loop) { lp.weight = weight })
+}
+
+// ReplaceTransport makes the loops and forward rules which forward over the transport oldID forward over newID,
+// for example once a transport to the same remote was established to take over from a failed one.
+// Route IDs are only unique per next hop, so newID has to be a transport to the same remote as oldID,
+// if oldID is still known. The forward rules are replaced within a single routing table transaction.
+func (r *Router) ReplaceTransport(oldID, newID uuid.UUID) error {
+	newTp := r.tm.Transport(newID)
+	if newTp == nil {
+		return fmt.Errorf("transport %s: %v", newID, ErrUnknownTransport)
+	}
+	if oldTp := r.tm.Transport(oldID); oldTp != nil && oldTp.Remote() != newTp.Remote() {
+		return ErrTransportRemoteMismatch
+	}
+
+	// Rules are read and rewritten in one transaction, so that rules deleted meanwhile are not recreated.
+	var replaced int
+	err := r.rm.rt.Transaction(func(tx routing.RuleTx) error {
+		rules := make(map[routing.RouteID]routing.Rule)
+		err := tx.RangeRules(func(routeID routing.RouteID, rule routing.Rule) bool {
+			if rule.Type() == routing.RuleForward && rule.TransportID() == oldID {
+				fwdRule := make(routing.Rule, len(rule))
+				copy(fwdRule, rule)
+				fwdRule.SetTransportID(newID)
+				rules[routeID] = fwdRule
+			}
+			return true
+		})
+		if err != nil {
+			return err
+		}
+		for routeID, rule := range rules {
+			if err := tx.SetRule(routeID, rule); err != nil {
+				return err
+			}
+		}
+		replaced = len(rules)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("routing table: %s", err)
+	}
+
+	loops := r.pm.ReplaceTransport(oldID, newID)
+	r.Logger.Infof("Replaced transport %s with %s in %d rules and %d loops", oldID, newID, replaced, loops)
+	return nil
 }
 
 // SetupIsTrusted checks if setup node is trusted.
@@ -1,20 +1,29 @@
 package router
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"math"
+	"net"
 	"os"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/SkycoinProject/dmsg"
+	"github.com/SkycoinProject/dmsg/cipher"
 	"github.com/SkycoinProject/skycoin/src/util/logging"
+	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/SkycoinProject/skywire-mainnet/pkg/app"
 	routeFinder "github.com/SkycoinProject/skywire-mainnet/pkg/route-finder/client"
 	"github.com/SkycoinProject/skywire-mainnet/pkg/routing"
+	"github.com/SkycoinProject/skywire-mainnet/pkg/setup"
 	"github.com/SkycoinProject/skywire-mainnet/pkg/snet"
 	"github.com/SkycoinProject/skywire-mainnet/pkg/snet/snettest"
 	"github.com/SkycoinProject/skywire-mainnet/pkg/transport"
@@ -56,6 +65,13 @@ func TestRouter_Serve(t *testing.T) {
 	// Create dmsg transport between two `snet.Network` entities.
 	tp1, err := rEnv.TpMngrs[1].SaveTransport(context.TODO(), keys[0].PK, dmsg.Type)
 	require.NoError(t, err)
+	// The remote end of the transport is settled in the background.
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if rEnv.TpMngrs[0].Transport(tp1.Entry.ID) != nil {
+			break
+		}
+		require.True(t, time.Now().Before(deadline), "transport was not established")
+	}
 
 	// CLOSURE: clear all rules in all router.
 	clearRules := func(routers ...*Router) {
@@ -82,7 +98,7 @@ func TestRouter_Serve(t *testing.T) {
 
 		// Call handlePacket for r0 (this should in turn, use the rule we added).
 		packet := routing.MakePacket(fwdRtID, []byte("This is a test!"))
-		require.NoError(t, r0.handlePacket(context.TODO(), packet))
+		require.NoError(t, r0.handlePacket(context.TODO(), packet, routing.DefaultPacketTTL, 0, routing.DataPacket, cipher.PubKey{}))
 
 		// r1 should receive the packet handled by r0.
 		recvPacket, err := r1.tm.ReadPacket()
@@ -92,6 +108,53 @@ func TestRouter_Serve(t *testing.T) {
 		assert.Equal(t, fwdRtID, packet.RouteID())
 	})
 
+	// TEST: Ensure handlePacket rejects malformed packets instead of panicking.
+	t.Run("handlePacket_malformed", func(t *testing.T) {
+		defer clearRules(r0, r1)
+
+		fwdRule := routing.ForwardRule(1*time.Hour, routing.RouteID(5), tp1.Entry.ID, routing.RouteID(0))
+		fwdRtID, err := r0.rm.rt.AddRule(fwdRule)
+		require.NoError(t, err)
+
+		packet := routing.MakePacket(fwdRtID, []byte("This is a test!"))
+		for _, p := range []routing.Packet{
+			packet[:routing.PacketHeaderSize-2],
+			packet[:len(packet)-3],
+			append(packet, []byte("extra")...),
+		} {
+			assert.NotPanics(t, func() {
+				assert.Error(t, r0.handlePacket(context.TODO(), p, routing.DefaultPacketTTL, 0, routing.DataPacket, cipher.PubKey{}))
+			})
+		}
+	})
+
+	// TEST: Ensure packets read and forwarded by handlePacket are counted in the right size buckets.
+	t.Run("handlePacket_metrics", func(t *testing.T) {
+		defer clearRules(r0, r1)
+
+		fwdRule := routing.ForwardRule(1*time.Hour, routing.RouteID(5), tp1.Entry.ID, routing.RouteID(0))
+		fwdRtID, err := r0.rm.rt.AddRule(fwdRule)
+		require.NoError(t, err)
+
+		before := r0.Metrics()
+		for _, size := range []int{0, 63, 64, 1000, 4096, 10000} {
+			packet := routing.MakePacket(fwdRtID, bytes.Repeat([]byte{1}, size))
+			require.NoError(t, r0.handlePacket(context.TODO(), packet, routing.DefaultPacketTTL, 0, routing.DataPacket, cipher.PubKey{}))
+
+			recvPacket, err := r1.tm.ReadPacket()
+			require.NoError(t, err)
+			assert.Equal(t, uint16(size), recvPacket.Size())
+		}
+		after := r0.Metrics()
+
+		assert.Equal(t, DefaultPacketSizeBuckets, after.Read.Buckets)
+		wantCounts := []uint64{2, 1, 1, 0, 2}
+		for i, want := range wantCounts {
+			assert.Equal(t, want, after.Read.Counts[i]-before.Read.Counts[i], "read bucket %d", i)
+			assert.Equal(t, want, after.Written.Counts[i]-before.Written.Counts[i], "written bucket %d", i)
+		}
+	})
+
 	// TODO(evanlinjin): I'm having so much trouble with this I officially give up.
 	//t.Run("handlePacket_appRule", func(t *testing.T) {
 	//	const duration = 10 * time.Second
@@ -155,10 +218,51 @@ func TestRouter_Serve(t *testing.T) {
 	//	rawRAddr, _ := json.Marshal(rAddr)
 	//	// payload := append([]byte{byte(app.FrameClose), 0}, rawRAddr...)
 	//	packet := routing.MakePacket(appRtID, rawRAddr)
-	//	require.NoError(t, r0.handlePacket(context.TODO(), packet))
+	//	require.NoError(t, r0.handlePacket(context.TODO(), packet, routing.DefaultPacketTTL, 0, routing.DataPacket, cipher.PubKey{}))
 	//})
 }
 
+// Ensure that packets are forwarded the same way regardless of the transport network type.
+func TestRouter_handlePacketNetworks(t *testing.T) {
+	for _, network := range []string{dmsg.Type, snet.STcpType, snet.MemType} {
+		t.Run(network, func(t *testing.T) {
+			keys := snettest.GenKeyPairs(2)
+
+			nEnv := snettest.NewEnv(t, keys, network)
+			defer nEnv.Teardown()
+			rEnv := NewTestEnv(t, nEnv.Nets)
+			defer rEnv.Teardown()
+
+			r0, err := New(nEnv.Nets[0], rEnv.GenRouterConfig(0))
+			require.NoError(t, err)
+			r1, err := New(nEnv.Nets[1], rEnv.GenRouterConfig(1))
+			require.NoError(t, err)
+
+			tp1, err := rEnv.TpMngrs[1].SaveTransport(context.TODO(), keys[0].PK, network)
+			require.NoError(t, err)
+			// The remote transport manager may not be listening yet, so retry dialing.
+			for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+				if tp1.Dial(context.TODO()) == nil && rEnv.TpMngrs[0].Transport(tp1.Entry.ID) != nil {
+					break
+				}
+				require.True(t, time.Now().Before(deadline), "transport was not established")
+			}
+
+			fwdRule := routing.ForwardRule(1*time.Hour, routing.RouteID(5), tp1.Entry.ID, routing.RouteID(0))
+			fwdRtID, err := r0.rm.rt.AddRule(fwdRule)
+			require.NoError(t, err)
+
+			packet := routing.MakePacket(fwdRtID, []byte("This is a test!"))
+			require.NoError(t, r0.handlePacket(context.TODO(), packet, routing.DefaultPacketTTL, 0, routing.DataPacket, cipher.PubKey{}))
+
+			recvPacket, err := r1.tm.ReadPacket()
+			require.NoError(t, err)
+			assert.Equal(t, packet.Size(), recvPacket.Size())
+			assert.Equal(t, packet.Payload(), recvPacket.Payload())
+		})
+	}
+}
+
 type TestEnv struct {
 	TpD transport.DiscoveryClient
 
@@ -220,3 +324,745 @@ func (e *TestEnv) GenRouterConfig(i int) *Config {
 func (e *TestEnv) Teardown() {
 	e.teardown()
 }
+
+// Ensure that the configured logger is used for loop close events.
+func TestRouter_Logger(t *testing.T) {
+	keys := snettest.GenKeyPairs(1)
+
+	nEnv := snettest.NewEnv(t, keys)
+	defer nEnv.Teardown()
+	rEnv := NewTestEnv(t, nEnv.Nets)
+	defer rEnv.Teardown()
+
+	// Capture logs.
+	logBuf := new(bytes.Buffer)
+	masterLog := logging.NewMasterLogger()
+	masterLog.Out = logBuf
+	masterLog.SetLevel(logrus.DebugLevel)
+
+	conf := rEnv.GenRouterConfig(0)
+	conf.Logger = masterLog.PackageLogger("test_router")
+
+	r, err := New(nEnv.Nets[0], conf)
+	require.NoError(t, err)
+
+	// Bind a mock app to the local port of the loop.
+	const localPort = routing.Port(9)
+	rConn, appConn := net.Pipe()
+	defer func() {
+		assert.NoError(t, rConn.Close())
+		assert.NoError(t, appConn.Close())
+	}()
+	rProto := app.NewProtocol(rConn)
+	go func() { _ = rProto.Serve(nil) }()                   //nolint:errcheck
+	go func() { _ = app.NewProtocol(appConn).Serve(nil) }() //nolint:errcheck
+	require.NoError(t, r.pm.Open(localPort, rProto))
+
+	loop := routing.Loop{
+		Local:  routing.Addr{PubKey: keys[0].PK, Port: localPort},
+		Remote: routing.Addr{PubKey: keys[0].PK, Port: 10},
+	}
+	ld, err := json.Marshal(routing.LoopData{Loop: loop})
+	require.NoError(t, err)
+	require.NoError(t, r.rm.loopClosed(ld))
+
+	logs := logBuf.String()
+	assert.Contains(t, logs, "test_router")
+	assert.Contains(t, logs, fmt.Sprintf("Received loop closed packet for loop %s", loop))
+	assert.Contains(t, logs, fmt.Sprintf("Closed loop %s", loop))
+}
+
+// Ensure that forward routes start with the pinned first hop.
+func TestRouter_selectRoutes(t *testing.T) {
+	keys := snettest.GenKeyPairs(2)
+	src, hop := keys[0].PK, keys[1].PK
+	dst, _ := cipher.GenerateKeyPair()
+
+	nEnv := snettest.NewEnv(t, keys)
+	defer nEnv.Teardown()
+	rEnv := NewTestEnv(t, nEnv.Nets)
+	defer rEnv.Teardown()
+
+	tp, err := rEnv.TpMngrs[0].SaveTransport(context.TODO(), hop, dmsg.Type)
+	require.NoError(t, err)
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if rEnv.TpMngrs[1].Transport(tp.Entry.ID) != nil {
+			break
+		}
+		require.True(t, time.Now().Before(deadline), "transport was not established")
+	}
+
+	direct := routing.Route{
+		{From: src, To: dst, Transport: transport.MakeTransportID(src, dst, dmsg.Type)},
+	}
+	viaHop := routing.Route{
+		{From: src, To: hop, Transport: tp.Entry.ID},
+		{From: hop, To: dst, Transport: transport.MakeTransportID(hop, dst, dmsg.Type)},
+	}
+	viaDstHop := routing.Route{
+		{From: src, To: dst, Transport: uuid.New()},
+	}
+	rev := routing.Route{
+		{From: dst, To: src, Transport: transport.MakeTransportID(src, dst, dmsg.Type)},
+	}
+
+	conf := rEnv.GenRouterConfig(0)
+	r, err := New(nEnv.Nets[0], conf)
+	require.NoError(t, err)
+
+	// Without a pinned first hop, the first route is selected.
+	fwd, gotRev, err := r.selectRoutes(dst, []routing.Route{direct, viaHop}, []routing.Route{rev})
+	require.NoError(t, err)
+	assert.Equal(t, direct, fwd)
+	assert.Equal(t, rev, gotRev)
+
+	conf.FirstHops = map[cipher.PubKey]cipher.PubKey{dst: hop}
+	fwd, _, err = r.selectRoutes(dst, []routing.Route{direct, viaHop}, []routing.Route{rev})
+	require.NoError(t, err)
+	assert.Equal(t, viaHop, fwd)
+	assert.Equal(t, hop, fwd[0].To)
+
+	_, _, err = r.selectRoutes(dst, []routing.Route{direct}, []routing.Route{rev})
+	assert.Equal(t, ErrNoPinnedFirstHop, err)
+
+	conf.FirstHops = map[cipher.PubKey]cipher.PubKey{dst: dst}
+	_, _, err = r.selectRoutes(dst, []routing.Route{viaDstHop}, []routing.Route{rev})
+	assert.Equal(t, ErrNoTransportToFirstHop, err)
+}
+
+// Ensure that closing a loop returns within CloseTimeout if the setup node does not respond,
+// and that the loop is still closed locally.
+func TestRouter_closeLoop_CloseTimeout(t *testing.T) {
+	const closeTimeout = 200 * time.Millisecond
+
+	keys := snettest.GenKeyPairs(2)
+
+	nEnv := snettest.NewEnv(t, keys)
+	defer nEnv.Teardown()
+	rEnv := NewTestEnv(t, nEnv.Nets)
+	defer rEnv.Teardown()
+
+	// The setup node accepts connections but never responds.
+	lis, err := nEnv.Nets[1].Listen(snet.DmsgType, snet.SetupPort)
+	require.NoError(t, err)
+	defer func() { assert.NoError(t, lis.Close()) }()
+	go func() {
+		for {
+			if _, err := lis.Accept(); err != nil {
+				return
+			}
+		}
+	}()
+
+	conf := rEnv.GenRouterConfig(0)
+	conf.SetupNodes = []cipher.PubKey{keys[1].PK}
+	conf.CloseTimeout = closeTimeout
+
+	r, err := New(nEnv.Nets[0], conf)
+	require.NoError(t, err)
+
+	const localPort = routing.Port(9)
+	rConn, appConn := net.Pipe()
+	defer func() {
+		assert.NoError(t, rConn.Close())
+		assert.NoError(t, appConn.Close())
+	}()
+	rProto := app.NewProtocol(rConn)
+	require.NoError(t, r.pm.Open(localPort, rProto))
+
+	l := routing.Loop{
+		Local:  routing.Addr{PubKey: keys[0].PK, Port: localPort},
+		Remote: routing.Addr{PubKey: keys[1].PK, Port: 10},
+	}
+	require.NoError(t, r.pm.SetLoop(localPort, l.Remote, &loop{routeID: 1}))
+
+	start := time.Now()
+	err = r.closeLoop(context.Background(), rProto, l, routing.CloseNormal)
+	assert.Error(t, err)
+	assert.True(t, time.Since(start) < closeTimeout+time.Second)
+
+	_, err = r.pm.Get(localPort)
+	assert.Error(t, err)
+}
+
+// Ensure that SetupProgress receives the steps of a successful loop setup in order.
+func TestRouter_requestLoop_SetupProgress(t *testing.T) {
+	keys := snettest.GenKeyPairs(2)
+
+	nEnv := snettest.NewEnv(t, keys)
+	defer nEnv.Teardown()
+	rEnv := NewTestEnv(t, nEnv.Nets)
+	defer rEnv.Teardown()
+
+	// The setup node accepts every loop.
+	lis, err := nEnv.Nets[1].Listen(snet.DmsgType, snet.SetupPort)
+	require.NoError(t, err)
+	defer func() { assert.NoError(t, lis.Close()) }()
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			proto := setup.NewSetupProtocol(conn)
+			if _, _, err := proto.ReadPacket(); err == nil {
+				_ = proto.WritePacket(setup.RespSuccess, nil) //nolint:errcheck
+			}
+			_ = proto.Close() //nolint:errcheck
+		}
+	}()
+
+	var events []setup.Progress
+	conf := rEnv.GenRouterConfig(0)
+	conf.SetupNodes = []cipher.PubKey{keys[1].PK}
+	conf.SetupProgress = func(p setup.Progress) { events = append(events, p) }
+
+	r, err := New(nEnv.Nets[0], conf)
+	require.NoError(t, err)
+
+	rConn, appConn := net.Pipe()
+	defer func() {
+		assert.NoError(t, rConn.Close())
+		assert.NoError(t, appConn.Close())
+	}()
+
+	raddr := routing.Addr{PubKey: keys[1].PK, Port: 10}
+	laddr, err := r.requestLoop(context.Background(), app.NewProtocol(rConn), raddr)
+	require.NoError(t, err)
+
+	l := routing.Loop{Local: laddr, Remote: raddr}
+	assert.Equal(t, []setup.Progress{
+		{Stage: setup.StageFindingRoutes, Loop: l},
+		{Stage: setup.StageContactingSetupNode, Loop: l},
+		{Stage: setup.StageRequestingLoop, Loop: l, PubKey: keys[1].PK},
+		{Stage: setup.StageLoopCreated, Loop: l, PubKey: keys[1].PK},
+	}, events)
+}
+
+// Ensure that the loop payload exchanged by each app is counted by the router, summed over all loops of the app.
+func TestRouter_AppTraffic(t *testing.T) {
+	keys := snettest.GenKeyPairs(1)
+
+	nEnv := snettest.NewEnv(t, keys)
+	defer nEnv.Teardown()
+	rEnv := NewTestEnv(t, nEnv.Nets)
+	defer rEnv.Teardown()
+
+	r, err := New(nEnv.Nets[0], rEnv.GenRouterConfig(0))
+	require.NoError(t, err)
+
+	// Serve two apps which exchange packets over local loops.
+	type testApp struct {
+		proto    *app.Protocol
+		packets  chan *app.Packet
+		rConn    net.Conn
+		serveErr chan error
+	}
+	serveApp := func(name string, port routing.Port) *testApp {
+		conf := &app.Config{AppName: name, AppVersion: "1.0", ProtocolVersion: supportedProtocolVersion}
+		rConn, appConn := net.Pipe()
+		a := &testApp{
+			proto:    app.NewProtocol(appConn),
+			packets:  make(chan *app.Packet, 1),
+			rConn:    rConn,
+			serveErr: make(chan error, 1),
+		}
+		go func() { a.serveErr <- r.ServeApp(rConn, port, conf) }()
+		go a.proto.Serve(func(frame app.Frame, payload []byte) (interface{}, error) { // nolint: errcheck
+			if frame == app.FrameSend {
+				p := &app.Packet{}
+				if err := json.Unmarshal(payload, p); err != nil {
+					return nil, err
+				}
+				a.packets <- p
+			}
+			return nil, nil
+		})
+		require.NoError(t, a.proto.Send(app.FrameInit, conf, nil))
+		return a
+	}
+	const dialerPort, listenerPort = routing.Port(1), routing.Port(2)
+	dialer := serveApp("dialer", dialerPort)
+	listener := serveApp("listener", listenerPort)
+
+	raddr := routing.Addr{PubKey: keys[0].PK, Port: listenerPort}
+	exchange := func(reqSize, respSize int) {
+		var laddr routing.Addr
+		require.NoError(t, dialer.proto.Send(app.FrameCreateLoop, raddr, &laddr))
+
+		req := &app.Packet{Loop: routing.Loop{Local: laddr, Remote: raddr}, Payload: bytes.Repeat([]byte{1}, reqSize)}
+		require.NoError(t, dialer.proto.Send(app.FrameSend, req, nil))
+		assert.Len(t, (<-listener.packets).Payload, reqSize)
+
+		resp := &app.Packet{
+			Loop:    routing.Loop{Local: routing.Addr{Port: listenerPort}, Remote: laddr},
+			Payload: bytes.Repeat([]byte{2}, respSize),
+		}
+		require.NoError(t, listener.proto.Send(app.FrameSend, resp, nil))
+		assert.Len(t, (<-dialer.packets).Payload, respSize)
+	}
+	exchange(100, 10)
+	exchange(200, 20)
+
+	assert.Equal(t, map[routing.Port]AppTraffic{
+		dialerPort:   {Sent: 300, Received: 30},
+		listenerPort: {Sent: 30, Received: 300},
+	}, r.AppTraffic())
+
+	// Apps which are no longer served are not reported.
+	require.NoError(t, dialer.rConn.Close())
+	require.NoError(t, <-dialer.serveErr)
+	assert.Equal(t, map[routing.Port]AppTraffic{
+		listenerPort: {Sent: 30, Received: 300},
+	}, r.AppTraffic())
+
+	require.NoError(t, listener.rConn.Close())
+	require.NoError(t, <-listener.serveErr)
+}
+
+// Ensure that packets of a looping route are dropped once their TTL expires.
+func TestRouter_forwardPacket_TTL(t *testing.T) {
+	keys := snettest.GenKeyPairs(2)
+
+	nEnv := snettest.NewEnvWithConfig(t, keys, func(conf *snet.Config) { conf.EnablePacketTTL = true })
+	defer nEnv.Teardown()
+	rEnv := NewTestEnv(t, nEnv.Nets)
+	defer rEnv.Teardown()
+
+	r0, err := New(nEnv.Nets[0], rEnv.GenRouterConfig(0))
+	require.NoError(t, err)
+	r1, err := New(nEnv.Nets[1], rEnv.GenRouterConfig(1))
+	require.NoError(t, err)
+
+	tp, err := rEnv.TpMngrs[0].SaveTransport(context.TODO(), keys[1].PK, dmsg.Type)
+	require.NoError(t, err)
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if rEnv.TpMngrs[1].Transport(tp.Entry.ID) != nil {
+			break
+		}
+		require.True(t, time.Now().Before(deadline), "transport was not established")
+	}
+
+	// The route loops: r0 forwards to r1, which forwards back to r0.
+	rtID0, err := r0.rm.rt.AddRule(routing.ForwardRule(time.Hour, 0, tp.Entry.ID, 0))
+	require.NoError(t, err)
+	rtID1, err := r1.rm.rt.AddRule(routing.ForwardRule(time.Hour, rtID0, tp.Entry.ID, 0))
+	require.NoError(t, err)
+	require.NoError(t, r0.rm.rt.SetRule(rtID0, routing.ForwardRule(time.Hour, rtID1, tp.Entry.ID, 0)))
+
+	const hops = 4
+	payload := []byte("looping")
+	routers := []*Router{r0, r1}
+	packet, ttl := routing.MakePacket(rtID0, payload), uint8(hops)
+
+	// Every hop decrements the TTL, the router receiving the packet with the last hop drops it.
+	for hop := 0; hop < hops-1; hop++ {
+		r, next := routers[hop%2], routers[(hop+1)%2]
+		require.NoError(t, r.handlePacket(context.TODO(), packet, ttl, 0, routing.DataPacket, cipher.PubKey{}))
+
+		packet, ttl, _, _, _, err = next.tm.ReadPacketFrom()
+		require.NoError(t, err)
+		assert.Equal(t, uint8(hops-hop-1), ttl)
+		assert.Equal(t, payload, packet.Payload())
+	}
+	assert.Equal(t, uint8(1), ttl)
+	assert.Equal(t, ErrPacketTTLExpired, routers[(hops-1)%2].handlePacket(context.TODO(), packet, ttl, 0, routing.DataPacket, cipher.PubKey{}))
+}
+
+func TestRouter_TransportPreference(t *testing.T) {
+	keys := snettest.GenKeyPairs(2)
+
+	nEnv := snettest.NewEnv(t, keys, dmsg.Type, snet.STcpType)
+	defer nEnv.Teardown()
+	rEnv := NewTestEnv(t, nEnv.Nets)
+	defer rEnv.Teardown()
+
+	conf := rEnv.GenRouterConfig(0)
+	conf.TransportPreference = []string{snet.STcpType, dmsg.Type}
+	r0, err := New(nEnv.Nets[0], conf)
+	require.NoError(t, err)
+	r1, err := New(nEnv.Nets[1], rEnv.GenRouterConfig(1))
+	require.NoError(t, err)
+
+	// Both transports connect the same routers.
+	tps := make(map[string]*transport.ManagedTransport)
+	for _, network := range []string{dmsg.Type, snet.STcpType} {
+		tp, err := rEnv.TpMngrs[0].SaveTransport(context.TODO(), keys[1].PK, network)
+		require.NoError(t, err)
+		for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+			if tp.IsUp() && rEnv.TpMngrs[1].Transport(tp.Entry.ID) != nil {
+				break
+			}
+			require.True(t, time.Now().Before(deadline), "transport was not established")
+		}
+		tps[network] = tp
+	}
+
+	// The rule names the dmsg transport.
+	rtID, err := r0.rm.rt.AddRule(routing.ForwardRule(time.Hour, 5, tps[dmsg.Type].Entry.ID, 0))
+	require.NoError(t, err)
+
+	forward := func(t *testing.T, want *transport.ManagedTransport) {
+		sent := atomic.LoadUint64(&want.LogEntry.SentBytes)
+		payload := []byte("preferred")
+		require.NoError(t, r0.handlePacket(context.TODO(), routing.MakePacket(rtID, payload), routing.DefaultPacketTTL, 0, routing.DataPacket, cipher.PubKey{}))
+
+		packet, err := r1.tm.ReadPacket()
+		require.NoError(t, err)
+		assert.Equal(t, routing.RouteID(5), packet.RouteID())
+		assert.Equal(t, payload, packet.Payload())
+		assert.Equal(t, sent+uint64(len(payload)), atomic.LoadUint64(&want.LogEntry.SentBytes))
+	}
+
+	t.Run("preferred_network_first", func(t *testing.T) {
+		assert.Equal(t, managedTransport{tps[snet.STcpType]}, r0.selectTransport(tps[dmsg.Type].Entry.ID))
+		forward(t, tps[snet.STcpType])
+	})
+
+	t.Run("fails_over_to_other_network", func(t *testing.T) {
+		tps[snet.STcpType].Close()
+		assert.Equal(t, managedTransport{tps[dmsg.Type]}, r0.selectTransport(tps[dmsg.Type].Entry.ID))
+		forward(t, tps[dmsg.Type])
+	})
+
+	t.Run("rule_transport_without_preference", func(t *testing.T) {
+		r1Tp := rEnv.TpMngrs[1].Transport(tps[snet.STcpType].Entry.ID)
+		require.NotNil(t, r1Tp)
+		assert.Equal(t, managedTransport{r1Tp}, r1.selectTransport(r1Tp.Entry.ID))
+	})
+}
+
+// Ensure that the close code of a loop closed by the remote app is passed on to the local app.
+func TestRouter_loopClosed_CloseCode(t *testing.T) {
+	keys := snettest.GenKeyPairs(1)
+
+	nEnv := snettest.NewEnv(t, keys)
+	defer nEnv.Teardown()
+	rEnv := NewTestEnv(t, nEnv.Nets)
+	defer rEnv.Teardown()
+
+	r, err := New(nEnv.Nets[0], rEnv.GenRouterConfig(0))
+	require.NoError(t, err)
+
+	const localPort = routing.Port(9)
+	rConn, appConn := net.Pipe()
+	defer func() {
+		assert.NoError(t, rConn.Close())
+		assert.NoError(t, appConn.Close())
+	}()
+	rProto := app.NewProtocol(rConn)
+	go func() { _ = rProto.Serve(nil) }() //nolint:errcheck
+
+	closeCh := make(chan []byte, 1)
+	go func() {
+		_ = app.NewProtocol(appConn).Serve(func(f app.Frame, p []byte) (interface{}, error) { //nolint:errcheck
+			if f == app.FrameClose {
+				closeCh <- p
+			}
+			return nil, nil
+		})
+	}()
+	require.NoError(t, r.pm.Open(localPort, rProto))
+
+	loop := routing.Loop{
+		Local:  routing.Addr{PubKey: keys[0].PK, Port: localPort},
+		Remote: routing.Addr{PubKey: keys[0].PK, Port: 10},
+	}
+	ld, err := json.Marshal(routing.LoopData{Loop: loop, CloseCode: 7})
+	require.NoError(t, err)
+	require.NoError(t, r.rm.loopClosed(ld))
+
+	var closing app.LoopClose
+	require.NoError(t, json.Unmarshal(<-closeCh, &closing))
+	assert.Equal(t, loop, closing.Loop)
+	assert.Equal(t, routing.CloseCode(7), closing.Code)
+}
+
+func TestRouter_loopPaused(t *testing.T) {
+	keys := snettest.GenKeyPairs(1)
+
+	nEnv := snettest.NewEnv(t, keys)
+	defer nEnv.Teardown()
+	rEnv := NewTestEnv(t, nEnv.Nets)
+	defer rEnv.Teardown()
+
+	r, err := New(nEnv.Nets[0], rEnv.GenRouterConfig(0))
+	require.NoError(t, err)
+
+	const localPort = routing.Port(9)
+	rConn, appConn := net.Pipe()
+	defer func() {
+		assert.NoError(t, rConn.Close())
+		assert.NoError(t, appConn.Close())
+	}()
+	rProto := app.NewProtocol(rConn)
+	go func() { _ = rProto.Serve(nil) }() //nolint:errcheck
+
+	pauseCh := make(chan []byte, 1)
+	go func() {
+		_ = app.NewProtocol(appConn).Serve(func(f app.Frame, p []byte) (interface{}, error) { //nolint:errcheck
+			if f == app.FramePause {
+				pauseCh <- p
+			}
+			return nil, nil
+		})
+	}()
+	require.NoError(t, r.pm.Open(localPort, rProto))
+
+	loop := routing.Loop{
+		Local:  routing.Addr{PubKey: keys[0].PK, Port: localPort},
+		Remote: routing.Addr{PubKey: keys[0].PK, Port: 10},
+	}
+	for _, paused := range []bool{true, false} {
+		ld, err := json.Marshal(routing.LoopData{Loop: loop, Paused: paused})
+		require.NoError(t, err)
+		require.NoError(t, r.rm.loopPaused(ld))
+
+		var pausing app.LoopPause
+		require.NoError(t, json.Unmarshal(<-pauseCh, &pausing))
+		assert.Equal(t, app.LoopPause{Loop: loop, Paused: paused}, pausing)
+	}
+}
+
+// Ensure that a loop fed by several consume rules receives the packets of all of them in the order they are read.
+func TestRouter_ConsumeFanIn(t *testing.T) {
+	pk, _ := cipher.GenerateKeyPair()
+	remotePK, _ := cipher.GenerateKeyPair()
+	tm := newMockTransportManager()
+	r, mrt, stop := serveMockRouter(t, &Config{PubKey: pk}, tm)
+	defer stop()
+
+	const localPort = routing.Port(3)
+	received, closeApp := serveMockApp(t, r, localPort)
+	defer closeApp()
+
+	// Two inbound routes of the same loop.
+	raddr := routing.Addr{PubKey: remotePK, Port: 7}
+	require.NoError(t, r.pm.SetLoop(localPort, raddr, &loop{trID: uuid.New(), routeID: 9}))
+	loop := routing.Loop{Local: routing.Addr{Port: localPort}, Remote: raddr}
+	rtID1, err := mrt.AddRule(routing.AppRule(time.Hour, 9, 0, remotePK, localPort, 7))
+	require.NoError(t, err)
+	rtID2, err := mrt.AddRule(routing.AppRule(time.Hour, 9, 0, remotePK, localPort, 7))
+	require.NoError(t, err)
+
+	sends := []struct {
+		rtID    routing.RouteID
+		payload string
+	}{{rtID1, "a"}, {rtID2, "bb"}, {rtID2, "ccc"}, {rtID1, "dddd"}}
+	for _, send := range sends {
+		tm.readCh <- mockRead{packet: routing.MakePacket(send.rtID, []byte(send.payload)), from: remotePK}
+	}
+	for _, send := range sends {
+		select {
+		case packet := <-received:
+			assert.Equal(t, app.Packet{Loop: loop, Payload: []byte(send.payload)}, packet)
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for the packet to be delivered to the app")
+		}
+	}
+
+	// Packets are counted once the app has received them.
+	want := map[routing.RouteID]ConsumeStats{
+		rtID1: {Packets: 2, Bytes: 5},
+		rtID2: {Packets: 2, Bytes: 5},
+	}
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(10 * time.Millisecond) {
+		loops := r.Loops()
+		require.Len(t, loops, 1)
+		if time.Now().After(deadline) || assert.ObjectsAreEqual(want, loops[0].Consumed) {
+			assert.Equal(t, want, loops[0].Consumed)
+			break
+		}
+	}
+
+	// Destroying the loop removes both consume rules.
+	require.NoError(t, r.destroyLoop(loop))
+	assert.Equal(t, 0, mrt.Count())
+	assert.Nil(t, r.consumed.loop(loop))
+}
+
+// Ensure that Config.SourceGuard drops packets of consume rules read from a transport to another remote
+// than the previous hop of the rule.
+func TestRouter_SourceGuard(t *testing.T) {
+	pk, _ := cipher.GenerateKeyPair()
+	remotePK, _ := cipher.GenerateKeyPair()
+	spoofPK, _ := cipher.GenerateKeyPair()
+	tm := newMockTransportManager()
+	r, mrt, stop := serveMockRouter(t, &Config{PubKey: pk, SourceGuard: true}, tm)
+	defer stop()
+
+	const localPort = routing.Port(3)
+	received, closeApp := serveMockApp(t, r, localPort)
+	defer closeApp()
+
+	// The loop is routed directly, so the previous hop is the remote.
+	rtID, err := mrt.AddRule(routing.AppRule(time.Hour, 0, 6, remotePK, localPort, 7).WithPreviousHop(remotePK))
+	require.NoError(t, err)
+
+	assert.Equal(t, ErrSourceMismatch, r.handlePacket(context.TODO(), routing.MakePacket(rtID, []byte("spoofed")), routing.DefaultPacketTTL, 0, routing.DataPacket, spoofPK))
+	assert.Equal(t, uint64(1), r.Metrics().RejectedSources)
+
+	// Packets read from the previous hop of the rule are still delivered, the spoofed one never is.
+	tm.readCh <- mockRead{packet: routing.MakePacket(rtID, []byte("spoofed")), from: spoofPK}
+	tm.readCh <- mockRead{packet: routing.MakePacket(rtID, []byte("genuine")), from: remotePK}
+	select {
+	case packet := <-received:
+		assert.Equal(t, []byte("genuine"), packet.Payload)
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the packet to be delivered to the app")
+	}
+	assert.Equal(t, uint64(2), r.Metrics().RejectedSources)
+
+	// Rules which record no previous hop are not checked.
+	require.NoError(t, mrt.SetRule(rtID, routing.AppRule(time.Hour, 0, 6, remotePK, localPort, 7)))
+	tm.readCh <- mockRead{packet: routing.MakePacket(rtID, []byte("unchecked")), from: spoofPK}
+	select {
+	case packet := <-received:
+		assert.Equal(t, []byte("unchecked"), packet.Payload)
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the packet to be delivered to the app")
+	}
+	assert.Equal(t, uint64(2), r.Metrics().RejectedSources)
+}
+
+// Ensure that Config.DedupWindow delivers a packet read twice with the same sequence number only once.
+func TestRouter_DedupWindow(t *testing.T) {
+	pk, _ := cipher.GenerateKeyPair()
+	remotePK, _ := cipher.GenerateKeyPair()
+	tm := newMockTransportManager()
+	r, mrt, stop := serveMockRouter(t, &Config{PubKey: pk, DedupWindow: 4}, tm)
+	defer stop()
+
+	const localPort = routing.Port(3)
+	received, closeApp := serveMockApp(t, r, localPort)
+	defer closeApp()
+
+	rtID, err := mrt.AddRule(routing.AppRule(time.Hour, 0, 6, remotePK, localPort, 7))
+	require.NoError(t, err)
+
+	// Packets without a sequence number are never duplicates.
+	sends := []struct {
+		seq     uint32
+		payload string
+	}{{1, "foo"}, {1, "foo"}, {2, "bar"}, {0, "baz"}, {0, "baz"}, {1, "foo"}, {3, "qux"}}
+	for _, send := range sends {
+		tm.readCh <- mockRead{packet: routing.MakePacket(rtID, []byte(send.payload)), from: remotePK, seq: send.seq}
+	}
+	for _, want := range []string{"foo", "bar", "baz", "baz", "qux"} {
+		select {
+		case packet := <-received:
+			assert.Equal(t, want, string(packet.Payload))
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for the packet to be delivered to the app")
+		}
+	}
+	select {
+	case packet := <-received:
+		t.Fatalf("unexpected packet %q", packet.Payload)
+	default:
+	}
+	assert.Equal(t, uint64(2), r.Metrics().Duplicates)
+}
+
+func TestSeqWindow(t *testing.T) {
+	w := &seqWindow{max: math.MaxUint32 - 1, seen: make([]bool, 4)}
+	steps := []struct {
+		seq uint32
+		dup bool
+	}{
+		{math.MaxUint32 - 1, false},
+		{math.MaxUint32 - 1, true},
+		{1, false}, // wraps around
+		{math.MaxUint32, false},
+		{math.MaxUint32, true},
+		{1, true},
+		{math.MaxUint32 - 1, true}, // still within the window
+		{10, false},
+		{1, false}, // older than the window
+		{1, false},
+		{7, false},
+		{7, true},
+		{10, true},
+	}
+	for i, step := range steps {
+		assert.Equal(t, step.dup, w.dup(step.seq), "step %d: seq %d", i, step.seq)
+	}
+}
+
+// Ensure that Config.SourceGuard passes packets of a loop routed over an intermediate node.
+func TestRouter_SourceGuard_MultiHop(t *testing.T) {
+	keys := snettest.GenKeyPairs(3)
+
+	nEnv := snettest.NewEnv(t, keys)
+	defer nEnv.Teardown()
+	rEnv := NewTestEnv(t, nEnv.Nets)
+	defer rEnv.Teardown()
+
+	routers := make([]*Router, len(keys))
+	for i := range routers {
+		conf := rEnv.GenRouterConfig(i)
+		conf.SourceGuard = true
+		r, err := New(nEnv.Nets[i], conf)
+		require.NoError(t, err)
+		routers[i] = r
+	}
+
+	saveTransport := func(from, to int) uuid.UUID {
+		tp, err := rEnv.TpMngrs[from].SaveTransport(context.TODO(), keys[to].PK, dmsg.Type)
+		require.NoError(t, err)
+		for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+			if rEnv.TpMngrs[to].Transport(tp.Entry.ID) != nil {
+				return tp.Entry.ID
+			}
+			require.True(t, time.Now().Before(deadline), "transport was not established")
+		}
+	}
+	tp01, tp12 := saveTransport(0, 1), saveTransport(1, 2)
+
+	const localPort = routing.Port(3)
+	received, closeApp := serveMockApp(t, routers[2], localPort)
+	defer closeApp()
+
+	// The route of the loop of keys[0] is keys[0] -> keys[1] -> keys[2].
+	appRule := routing.AppRule(time.Hour, 0, 6, keys[0].PK, localPort, 7)
+	rtID2, err := routers[2].rm.rt.AddRule(appRule.WithPreviousHop(keys[1].PK))
+	require.NoError(t, err)
+	rtID1, err := routers[1].rm.rt.AddRule(routing.ForwardRule(time.Hour, rtID2, tp12, 0))
+	require.NoError(t, err)
+	rtID0, err := routers[0].rm.rt.AddRule(routing.ForwardRule(time.Hour, rtID1, tp01, 0))
+	require.NoError(t, err)
+
+	// send routes a packet from keys[0] and returns the result of handling it at keys[2].
+	send := func(payload string) error {
+		packet := routing.MakePacket(rtID0, []byte(payload))
+		require.NoError(t, routers[0].handlePacket(context.TODO(), packet, routing.DefaultPacketTTL, 0, routing.DataPacket, keys[0].PK))
+		for _, r := range routers[1:] {
+			var (
+				ttl  uint8
+				seq  uint32
+				typ  routing.PacketType
+				from cipher.PubKey
+				err  error
+			)
+			packet, ttl, seq, typ, from, err = r.tm.ReadPacketFrom()
+			require.NoError(t, err)
+			if err = r.handlePacket(context.TODO(), packet, ttl, seq, typ, from); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	require.NoError(t, send("foo"))
+	select {
+	case packet := <-received:
+		assert.Equal(t, []byte("foo"), packet.Payload)
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the packet to be delivered to the app")
+	}
+
+	// A rule which expects the packets from the remote itself rejects them.
+	require.NoError(t, routers[2].rm.rt.SetRule(rtID2, appRule.WithPreviousHop(keys[0].PK)))
+	assert.Equal(t, ErrSourceMismatch, send("bar"))
+	assert.Equal(t, uint64(1), routers[2].Metrics().RejectedSources)
+}
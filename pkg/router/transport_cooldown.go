@@ -0,0 +1,102 @@
+package router
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DefaultTransportFailureCooldown is the time transports are skipped for once they failed too often,
+// if Config.TransportFailureCooldown is not set.
+const DefaultTransportFailureCooldown = 30 * time.Second
+
+type tpFailures struct {
+	count int       // consecutive failed writes
+	until time.Time // end of the cooldown, zero if not cooling down
+}
+
+// tpCooldowns counts consecutive failed writes of transports. Once a transport fails threshold times in a row,
+// it cools down: selectTransport skips it in favour of other transports to the same remote until cooldown passes.
+type tpCooldowns struct {
+	threshold int
+	cooldown  time.Duration
+	clock     clock
+
+	tps     map[uuid.UUID]*tpFailures
+	version uint64 // changes whenever a transport starts or stops cooling down
+	mx      sync.Mutex
+}
+
+// newTPCooldowns returns nil if threshold is not positive, which disables cooldowns.
+func newTPCooldowns(threshold int, cooldown time.Duration) *tpCooldowns {
+	if threshold <= 0 {
+		return nil
+	}
+	if cooldown <= 0 {
+		cooldown = DefaultTransportFailureCooldown
+	}
+	return &tpCooldowns{
+		threshold: threshold,
+		cooldown:  cooldown,
+		clock:     realClock{},
+		tps:       make(map[uuid.UUID]*tpFailures),
+	}
+}
+
+// record records the result of a write to the transport id.
+func (c *tpCooldowns) record(id uuid.UUID, err error) {
+	if c == nil {
+		return
+	}
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	f, ok := c.tps[id]
+	if err == nil {
+		if ok && c.clock.Now().Before(f.until) {
+			c.version++
+		}
+		delete(c.tps, id)
+		return
+	}
+	if !ok {
+		f = new(tpFailures)
+		c.tps[id] = f
+	}
+	if f.count++; f.count >= c.threshold {
+		// Once the cooldown passes, the transport is tried again and cools down after as many failures.
+		f.count, f.until = 0, c.clock.Now().Add(c.cooldown)
+		c.version++
+	}
+}
+
+// coolingDown reports whether the transport id is to be skipped.
+func (c *tpCooldowns) coolingDown(id uuid.UUID) bool {
+	return !c.until(id).IsZero()
+}
+
+// until returns the end of the cooldown of the transport id, zero if it is not cooling down.
+func (c *tpCooldowns) until(id uuid.UUID) time.Time {
+	if c == nil {
+		return time.Time{}
+	}
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	if f, ok := c.tps[id]; ok && c.clock.Now().Before(f.until) {
+		return f.until
+	}
+	return time.Time{}
+}
+
+// state returns the version of the cooldowns and the current time.
+// Cooldowns which passed by then do not change the version.
+func (c *tpCooldowns) state() (version uint64, now time.Time) {
+	if c == nil {
+		return 0, time.Time{}
+	}
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	return c.version, c.clock.Now()
+}
@@ -0,0 +1,64 @@
+package router
+
+import (
+	"context"
+
+	"github.com/SkycoinProject/dmsg/cipher"
+	"github.com/google/uuid"
+
+	"github.com/SkycoinProject/skywire-mainnet/pkg/routing"
+	"github.com/SkycoinProject/skywire-mainnet/pkg/transport"
+)
+
+// TransportManager is the part of a transport.Manager the Router depends on.
+// The Router reads packets from it and forwards packets over its transports,
+// tests may drive the Router through a mock instead of a transport.Manager.
+type TransportManager interface {
+	Serve(ctx context.Context)
+	ReadPacket() (routing.Packet, error)
+	ReadPacketFrom() (routing.Packet, uint8, uint32, routing.PacketType, cipher.PubKey, error) // also returns the TTL, sequence number and type of the packet and the remote of the transport it was read from
+	Transport(id uuid.UUID) Transport                                                          // returns nil if there is no transport of id
+	WalkTransports(walk func(tp Transport) bool)
+	StateVersion() uint64 // changes whenever a transport is added or removed, or goes up or down
+	Close() error
+}
+
+// Transport is the part of a transport.ManagedTransport the Router forwards packets over.
+type Transport interface {
+	ID() uuid.UUID
+	Remote() cipher.PubKey
+	Type() string
+	IsUp() bool
+	WritePacketWithSeq(ctx context.Context, rtID routing.RouteID, ttl uint8, seq uint32, payload []byte) error
+	WriteControlPacket(ctx context.Context, rtID routing.RouteID, ttl uint8, typ routing.PacketType, payload []byte) error // fails with transport.ErrPacketTypeUnsupported unless the transport carries packet types
+	SetRouteWeight(rtID routing.RouteID, weight int)
+	MaxPayloadSize() int // largest payload written in one packet
+}
+
+// managerTransports adapts a transport.Manager to TransportManager.
+type managerTransports struct {
+	*transport.Manager
+}
+
+func (tm managerTransports) Transport(id uuid.UUID) Transport {
+	tp := tm.Manager.Transport(id)
+	if tp == nil {
+		return nil
+	}
+	return managedTransport{tp}
+}
+
+func (tm managerTransports) WalkTransports(walk func(tp Transport) bool) {
+	tm.Manager.WalkTransports(func(tp *transport.ManagedTransport) bool {
+		return walk(managedTransport{tp})
+	})
+}
+
+// managedTransport adapts a transport.ManagedTransport to Transport.
+type managedTransport struct {
+	*transport.ManagedTransport
+}
+
+func (tp managedTransport) ID() uuid.UUID {
+	return tp.Entry.ID
+}
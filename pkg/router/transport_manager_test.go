@@ -0,0 +1,579 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/SkycoinProject/dmsg/cipher"
+	"github.com/SkycoinProject/skycoin/src/util/logging"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/SkycoinProject/skywire-mainnet/pkg/app"
+	"github.com/SkycoinProject/skywire-mainnet/pkg/routing"
+	"github.com/SkycoinProject/skywire-mainnet/pkg/transport"
+)
+
+// mockRead is a packet pushed to a mockTransportManager, as if it was read from the remote from.
+type mockRead struct {
+	packet routing.Packet
+	from   cipher.PubKey
+	ttl    uint8 // 0 uses routing.DefaultPacketTTL
+	seq    uint32
+	typ    routing.PacketType
+}
+
+// mockTransportManager is a TransportManager whose packets are pushed by tests.
+type mockTransportManager struct {
+	readCh chan mockRead
+	tps    map[uuid.UUID]*mockTransport
+	mx     sync.Mutex
+	once   sync.Once
+
+	walks   int32  // calls of WalkTransports, accessed atomically
+	version uint64 // returned by StateVersion, accessed atomically
+}
+
+func newMockTransportManager(tps ...*mockTransport) *mockTransportManager {
+	tm := &mockTransportManager{
+		readCh: make(chan mockRead),
+		tps:    make(map[uuid.UUID]*mockTransport),
+	}
+	for _, tp := range tps {
+		tm.tps[tp.id] = tp
+	}
+	return tm
+}
+
+func (tm *mockTransportManager) Serve(context.Context) {}
+
+func (tm *mockTransportManager) ReadPacket() (routing.Packet, error) {
+	p, _, _, _, _, err := tm.ReadPacketFrom()
+	return p, err
+}
+
+func (tm *mockTransportManager) ReadPacketFrom() (routing.Packet, uint8, uint32, routing.PacketType, cipher.PubKey, error) {
+	read, ok := <-tm.readCh
+	if !ok {
+		return nil, 0, 0, 0, cipher.PubKey{}, transport.ErrNotServing
+	}
+	if read.ttl == 0 {
+		read.ttl = routing.DefaultPacketTTL
+	}
+	return read.packet, read.ttl, read.seq, read.typ, read.from, nil
+}
+
+func (tm *mockTransportManager) Transport(id uuid.UUID) Transport {
+	tm.mx.Lock()
+	defer tm.mx.Unlock()
+	if tp, ok := tm.tps[id]; ok {
+		return tp
+	}
+	return nil
+}
+
+func (tm *mockTransportManager) WalkTransports(walk func(tp Transport) bool) {
+	atomic.AddInt32(&tm.walks, 1)
+	tm.mx.Lock()
+	defer tm.mx.Unlock()
+	for _, tp := range tm.tps {
+		if !walk(tp) {
+			return
+		}
+	}
+}
+
+func (tm *mockTransportManager) StateVersion() uint64 {
+	return atomic.LoadUint64(&tm.version)
+}
+
+// setUp sets whether tp is up, as a transport.Manager would.
+func (tm *mockTransportManager) setUp(tp *mockTransport, up bool) {
+	var down int32
+	if !up {
+		down = 1
+	}
+	atomic.StoreInt32(&tp.down, down)
+	atomic.AddUint64(&tm.version, 1)
+}
+
+func (tm *mockTransportManager) Close() error {
+	tm.once.Do(func() { close(tm.readCh) })
+	return nil
+}
+
+type mockWrite struct {
+	RouteID routing.RouteID
+	TTL     uint8
+	Seq     uint32
+	Type    routing.PacketType
+	Payload string
+}
+
+// mockTransport is a Transport which records the packets written to it.
+type mockTransport struct {
+	id      uuid.UUID
+	remote  cipher.PubKey
+	netType string
+	writes  chan mockWrite
+	maxSize int   // returned by MaxPayloadSize, math.MaxUint16 if 0
+	failing int32 // writes fail with errMockWrite while set, accessed atomically
+	down    int32 // IsUp returns false while set, accessed atomically, see mockTransportManager.setUp
+	untyped int32 // WriteControlPacket fails with transport.ErrPacketTypeUnsupported while set, accessed atomically
+
+	weightsMx sync.Mutex
+	weights   map[routing.RouteID]int
+}
+
+func newMockTransport(remote cipher.PubKey, netType string) *mockTransport {
+	return &mockTransport{
+		id:      uuid.New(),
+		remote:  remote,
+		netType: netType,
+		writes:  make(chan mockWrite, 10),
+		weights: make(map[routing.RouteID]int),
+	}
+}
+
+func (tp *mockTransport) ID() uuid.UUID         { return tp.id }
+func (tp *mockTransport) Remote() cipher.PubKey { return tp.remote }
+func (tp *mockTransport) Type() string          { return tp.netType }
+func (tp *mockTransport) IsUp() bool            { return atomic.LoadInt32(&tp.down) == 0 }
+
+var errMockWrite = errors.New("mock write failed")
+
+func (tp *mockTransport) WritePacketWithSeq(_ context.Context, rtID routing.RouteID, ttl uint8, seq uint32, payload []byte) error {
+	if atomic.LoadInt32(&tp.failing) != 0 {
+		return errMockWrite
+	}
+	tp.writes <- mockWrite{RouteID: rtID, TTL: ttl, Seq: seq, Payload: string(payload)}
+	return nil
+}
+
+func (tp *mockTransport) WriteControlPacket(_ context.Context, rtID routing.RouteID, ttl uint8, typ routing.PacketType, payload []byte) error {
+	if atomic.LoadInt32(&tp.untyped) != 0 {
+		return transport.ErrPacketTypeUnsupported
+	}
+	if atomic.LoadInt32(&tp.failing) != 0 {
+		return errMockWrite
+	}
+	tp.writes <- mockWrite{RouteID: rtID, TTL: ttl, Type: typ, Payload: string(payload)}
+	return nil
+}
+
+func (tp *mockTransport) SetRouteWeight(rtID routing.RouteID, weight int) {
+	tp.weightsMx.Lock()
+	defer tp.weightsMx.Unlock()
+	if weight < 1 {
+		delete(tp.weights, rtID)
+		return
+	}
+	tp.weights[rtID] = weight
+}
+
+func (tp *mockTransport) MaxPayloadSize() int {
+	if tp.maxSize == 0 {
+		return math.MaxUint16
+	}
+	return tp.maxSize
+}
+
+func (tp *mockTransport) routeWeight(rtID routing.RouteID) int {
+	tp.weightsMx.Lock()
+	defer tp.weightsMx.Unlock()
+	return tp.weights[rtID]
+}
+
+func (tp *mockTransport) nextWrite(t *testing.T) mockWrite {
+	select {
+	case w := <-tp.writes:
+		return w
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for a packet to be written to the transport")
+		return mockWrite{}
+	}
+}
+
+// serveMockRouter serves a Router over tm without setup nodes.
+// Rules are to be added through the returned table, which records them as active.
+func serveMockRouter(t *testing.T, conf *Config, tm *mockTransportManager) (*Router, *managedRoutingTable, func()) {
+	rt := routing.InMemoryRoutingTable()
+	conf.Logger = logging.MustGetLogger("router")
+	conf.RoutingTable = rt
+	conf.SetDefaults()
+	r := newRouter(conf, tm)
+	mrt := manageRoutingTable(rt)
+	r.rm = &routeManager{Logger: conf.Logger, rt: mrt}
+
+	served := make(chan struct{})
+	go func() {
+		r.servePackets(context.TODO())
+		close(served)
+	}()
+	return r, mrt, func() {
+		require.NoError(t, tm.Close())
+		<-served
+	}
+}
+
+// serveMockApp opens port of r for an app, the returned channel receives the packets sent to the app.
+func serveMockApp(t *testing.T, r *Router, port routing.Port) (<-chan app.Packet, func()) {
+	rConn, appConn := net.Pipe()
+	rProto := app.NewProtocol(rConn)
+	go func() { _ = rProto.Serve(nil) }() //nolint:errcheck
+	require.NoError(t, r.pm.Open(port, rProto))
+
+	received := make(chan app.Packet, 10)
+	go func() {
+		_ = app.NewProtocol(appConn).Serve(func(f app.Frame, p []byte) (interface{}, error) { //nolint:errcheck
+			var packet app.Packet
+			if err := json.Unmarshal(p, &packet); err != nil {
+				return nil, err
+			}
+			received <- packet
+			return nil, nil
+		})
+	}()
+	return received, func() {
+		// Closing the protocol also releases a delivery of the router which waits for the app.
+		assert.NoError(t, rProto.Close())
+		assert.NoError(t, appConn.Close())
+	}
+}
+
+func TestRouter_MockTransportManager(t *testing.T) {
+	pk, _ := cipher.GenerateKeyPair()
+	remotePK, _ := cipher.GenerateKeyPair()
+	tp := newMockTransport(remotePK, "mock")
+	tm := newMockTransportManager(tp)
+
+	rt := routing.InMemoryRoutingTable()
+	conf := &Config{Logger: logging.MustGetLogger("router"), PubKey: pk, RoutingTable: rt}
+	conf.SetDefaults()
+	r := newRouter(conf, tm)
+	// Rules are added through the managed routing table, which records them as active.
+	mrt := manageRoutingTable(rt)
+	r.rm = &routeManager{Logger: conf.Logger, rt: mrt}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	served := make(chan struct{})
+	go func() {
+		r.servePackets(ctx)
+		close(served)
+	}()
+	defer func() {
+		require.NoError(t, tm.Close())
+		<-served
+	}()
+
+	// An app served on port 3, with the router's end of its connection served like in ServeApp.
+	const localPort = routing.Port(3)
+	rConn, appConn := net.Pipe()
+	defer func() {
+		assert.NoError(t, rConn.Close())
+		assert.NoError(t, appConn.Close())
+	}()
+	appProto := app.NewProtocol(rConn)
+	go func() { _ = appProto.Serve(nil) }() //nolint:errcheck
+	require.NoError(t, r.pm.Open(localPort, appProto))
+
+	received := make(chan app.Packet, 1)
+	go func() {
+		_ = app.NewProtocol(appConn).Serve(func(f app.Frame, p []byte) (interface{}, error) { //nolint:errcheck
+			if f != app.FrameSend {
+				return nil, errors.New("unexpected frame")
+			}
+			var packet app.Packet
+			if err := json.Unmarshal(p, &packet); err != nil {
+				return nil, err
+			}
+			received <- packet
+			return nil, nil
+		})
+	}()
+
+	t.Run("forward", func(t *testing.T) {
+		rtID, err := mrt.AddRule(routing.ForwardRule(time.Hour, 5, tp.id, 0))
+		require.NoError(t, err)
+
+		tm.readCh <- mockRead{packet: routing.MakePacket(rtID, []byte("foo")), from: remotePK, ttl: 10}
+		assert.Equal(t, mockWrite{RouteID: 5, TTL: 9, Payload: "foo"}, tp.nextWrite(t))
+	})
+
+	t.Run("consume", func(t *testing.T) {
+		rtID, err := mrt.AddRule(routing.AppRule(time.Hour, 0, 6, remotePK, localPort, 7))
+		require.NoError(t, err)
+
+		tm.readCh <- mockRead{packet: routing.MakePacket(rtID, []byte("bar")), from: remotePK}
+		select {
+		case packet := <-received:
+			loop := routing.Loop{Local: routing.Addr{Port: localPort}, Remote: routing.Addr{PubKey: remotePK, Port: 7}}
+			assert.Equal(t, app.Packet{Loop: loop, Payload: []byte("bar")}, packet)
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for the packet to be delivered to the app")
+		}
+	})
+
+	t.Run("app_send", func(t *testing.T) {
+		raddr := routing.Addr{PubKey: remotePK, Port: 8}
+		require.NoError(t, r.pm.SetLoop(localPort, raddr, &loop{trID: tp.id, routeID: 9}))
+
+		packet := &app.Packet{Loop: routing.Loop{Local: routing.Addr{Port: localPort}, Remote: raddr}, Payload: []byte("baz")}
+		require.NoError(t, r.forwardAppPacket(context.TODO(), appProto, packet))
+		assert.Equal(t, mockWrite{RouteID: 9, TTL: routing.DefaultPacketTTL, Payload: "baz"}, tp.nextWrite(t))
+	})
+
+	t.Run("split", func(t *testing.T) {
+		tp.maxSize = 2
+		defer func() { tp.maxSize = 0 }()
+
+		rtID, err := mrt.AddRule(routing.ForwardRule(time.Hour, 5, tp.id, 0))
+		require.NoError(t, err)
+		tm.readCh <- mockRead{packet: routing.MakePacket(rtID, []byte("qux")), from: remotePK, ttl: 10}
+		assert.Equal(t, mockWrite{RouteID: 5, TTL: 9, Payload: "qu"}, tp.nextWrite(t))
+		assert.Equal(t, mockWrite{RouteID: 5, TTL: 9, Payload: "x"}, tp.nextWrite(t))
+
+		raddr := routing.Addr{PubKey: remotePK, Port: 8}
+		packet := &app.Packet{Loop: routing.Loop{Local: routing.Addr{Port: localPort}, Remote: raddr}, Payload: []byte("quux")}
+		require.NoError(t, r.forwardAppPacket(context.TODO(), appProto, packet))
+		assert.Equal(t, mockWrite{RouteID: 9, TTL: routing.DefaultPacketTTL, Payload: "qu"}, tp.nextWrite(t))
+		assert.Equal(t, mockWrite{RouteID: 9, TTL: routing.DefaultPacketTTL, Payload: "ux"}, tp.nextWrite(t))
+
+		var info LoopInfo
+		for _, li := range r.Loops() {
+			if li.Loop.Remote == raddr {
+				info = li
+			}
+		}
+		assert.Equal(t, 2, info.MaxPayloadSize)
+	})
+
+	t.Run("unknown_transport", func(t *testing.T) {
+		rtID, err := mrt.AddRule(routing.ForwardRule(time.Hour, 5, uuid.New(), 0))
+		require.NoError(t, err)
+		packet := routing.MakePacket(rtID, []byte("foo"))
+		assert.EqualError(t, r.handlePacket(context.TODO(), packet, routing.DefaultPacketTTL, 0, routing.DataPacket, remotePK), "unknown transport")
+	})
+
+	// Closes the port, so it goes last.
+	t.Run("loop_weight", func(t *testing.T) {
+		l := routing.Loop{Local: routing.Addr{Port: localPort}, Remote: routing.Addr{PubKey: remotePK, Port: 10}}
+		assert.EqualError(t, r.SetLoopWeight(l, 3), "unknown loop")
+
+		// The weight is set while the loop is being created and kept once it is confirmed.
+		require.NoError(t, r.pm.SetLoop(localPort, l.Remote, &loop{}))
+		require.NoError(t, r.SetLoopWeight(l, 3))
+		require.NoError(t, r.confirmLoop(l, routing.ForwardRule(time.Hour, 11, tp.id, 0)))
+
+		packet := &app.Packet{Loop: l, Payload: []byte("qux")}
+		require.NoError(t, r.forwardAppPacket(context.TODO(), appProto, packet))
+		assert.Equal(t, mockWrite{RouteID: 11, TTL: routing.DefaultPacketTTL, Seq: 1, Payload: "qux"}, tp.nextWrite(t))
+		assert.Equal(t, 3, tp.routeWeight(11))
+
+		var info LoopInfo
+		for _, li := range r.Loops() {
+			if li.Loop.Remote == l.Remote {
+				info = li
+			}
+		}
+		assert.Equal(t, 3, info.Weight)
+		assert.Equal(t, routing.RouteID(11), info.RouteID)
+
+		require.NoError(t, r.destroyLoop(l))
+		assert.Equal(t, 0, tp.routeWeight(11))
+	})
+}
+
+// Ensure that loops and forward rules keep forwarding once their transport is replaced.
+func TestRouter_ReplaceTransport(t *testing.T) {
+	pk, _ := cipher.GenerateKeyPair()
+	remotePK, _ := cipher.GenerateKeyPair()
+	otherPK, _ := cipher.GenerateKeyPair()
+	oldTp := newMockTransport(remotePK, "mock")
+	newTp := newMockTransport(remotePK, "mock")
+	otherTp := newMockTransport(otherPK, "mock")
+	tm := newMockTransportManager(oldTp, newTp, otherTp)
+	r, mrt, stop := serveMockRouter(t, &Config{PubKey: pk}, tm)
+	defer stop()
+
+	const localPort = routing.Port(3)
+	_, closeApp := serveMockApp(t, r, localPort)
+	defer closeApp()
+	appProto, err := r.pm.Get(localPort)
+	require.NoError(t, err)
+
+	fwdID, err := mrt.AddRule(routing.ForwardRule(time.Hour, 5, oldTp.id, 0))
+	require.NoError(t, err)
+	l := routing.Loop{Local: routing.Addr{Port: localPort}, Remote: routing.Addr{PubKey: remotePK, Port: 7}}
+	require.NoError(t, r.pm.SetLoop(localPort, l.Remote, &loop{trID: oldTp.id, routeID: 9}))
+
+	assert.Equal(t, ErrTransportRemoteMismatch, r.ReplaceTransport(oldTp.id, otherTp.id))
+	assert.EqualError(t, r.ReplaceTransport(oldTp.id, uuid.Nil), fmt.Sprintf("transport %s: unknown transport", uuid.Nil))
+	require.NoError(t, r.ReplaceTransport(oldTp.id, newTp.id))
+
+	rule, err := mrt.Rule(fwdID)
+	require.NoError(t, err)
+	assert.Equal(t, newTp.id, rule.TransportID())
+	assert.Equal(t, routing.RouteID(5), rule.RouteID())
+
+	tm.readCh <- mockRead{packet: routing.MakePacket(fwdID, []byte("foo")), from: otherPK, ttl: 10}
+	assert.Equal(t, mockWrite{RouteID: 5, TTL: 9, Payload: "foo"}, newTp.nextWrite(t))
+
+	require.NoError(t, r.forwardAppPacket(context.TODO(), appProto.conn, &app.Packet{Loop: l, Payload: []byte("bar")}))
+	assert.Equal(t, mockWrite{RouteID: 9, TTL: routing.DefaultPacketTTL, Payload: "bar"}, newTp.nextWrite(t))
+
+	select {
+	case w := <-oldTp.writes:
+		t.Fatalf("unexpected write to the replaced transport: %v", w)
+	default:
+	}
+	loops := r.Loops()
+	require.Len(t, loops, 1)
+	assert.Equal(t, newTp.id, loops[0].TransportID)
+}
+
+// deletingTable deletes a rule right before each transaction, as the rule GC or a closing loop may.
+type deletingTable struct {
+	routing.Table
+	routeID routing.RouteID
+}
+
+func (rt *deletingTable) Transaction(fn func(tx routing.RuleTx) error) error {
+	if err := rt.DeleteRules(rt.routeID); err != nil {
+		return err
+	}
+	return rt.Table.Transaction(fn)
+}
+
+// Ensure that forward rules deleted while their transport is replaced are not recreated.
+func TestRouter_ReplaceTransport_DeletedRule(t *testing.T) {
+	pk, _ := cipher.GenerateKeyPair()
+	remotePK, _ := cipher.GenerateKeyPair()
+	oldTp := newMockTransport(remotePK, "mock")
+	newTp := newMockTransport(remotePK, "mock")
+	r, mrt, stop := serveMockRouter(t, &Config{PubKey: pk}, newMockTransportManager(oldTp, newTp))
+	defer stop()
+
+	deletedID, err := mrt.AddRule(routing.ForwardRule(time.Hour, 5, oldTp.id, 0))
+	require.NoError(t, err)
+	keptID, err := mrt.AddRule(routing.ForwardRule(time.Hour, 6, oldTp.id, 0))
+	require.NoError(t, err)
+	mrt.Table = &deletingTable{Table: mrt.Table, routeID: deletedID}
+
+	require.NoError(t, r.ReplaceTransport(oldTp.id, newTp.id))
+
+	_, err = mrt.Rule(deletedID)
+	assert.Error(t, err)
+	rule, err := mrt.Rule(keptID)
+	require.NoError(t, err)
+	assert.Equal(t, newTp.id, rule.TransportID())
+	assert.Equal(t, 1, mrt.Count())
+}
+
+// Ensure that a transport which keeps failing is skipped in favour of the next preferred one while it cools down,
+// and tried again afterwards.
+func TestRouter_TransportFailureCooldown(t *testing.T) {
+	pk, _ := cipher.GenerateKeyPair()
+	remotePK, _ := cipher.GenerateKeyPair()
+	flapping := newMockTransport(remotePK, "preferred")
+	fallback := newMockTransport(remotePK, "fallback")
+	tm := newMockTransportManager(flapping, fallback)
+	conf := &Config{
+		PubKey:                    pk,
+		TransportPreference:       []string{"preferred", "fallback"},
+		TransportFailureThreshold: 2,
+		TransportFailureCooldown:  time.Minute,
+	}
+	r, _, stop := serveMockRouter(t, conf, tm)
+	defer stop()
+	clock := newFakeClock()
+	r.cooldowns.clock = clock
+
+	const localPort = routing.Port(3)
+	_, closeApp := serveMockApp(t, r, localPort)
+	defer closeApp()
+	appProto, err := r.pm.Get(localPort)
+	require.NoError(t, err)
+	l := routing.Loop{Local: routing.Addr{Port: localPort}, Remote: routing.Addr{PubKey: remotePK, Port: 7}}
+	require.NoError(t, r.pm.SetLoop(localPort, l.Remote, &loop{trID: flapping.id, routeID: 9}))
+	send := func(payload string) error {
+		return r.forwardAppPacket(context.TODO(), appProto.conn, &app.Packet{Loop: l, Payload: []byte(payload)})
+	}
+
+	// The preferred transport is retried until it failed as often as the threshold.
+	atomic.StoreInt32(&flapping.failing, 1)
+	assert.Equal(t, errMockWrite, send("foo"))
+	assert.Equal(t, errMockWrite, send("foo"))
+
+	// It is skipped while cooling down, even once it works again.
+	atomic.StoreInt32(&flapping.failing, 0)
+	require.NoError(t, send("bar"))
+	assert.Equal(t, mockWrite{RouteID: 9, TTL: routing.DefaultPacketTTL, Payload: "bar"}, fallback.nextWrite(t))
+
+	// Once the cooldown passed, it is tried again.
+	clock.Advance(time.Minute)
+	require.NoError(t, send("baz"))
+	assert.Equal(t, mockWrite{RouteID: 9, TTL: routing.DefaultPacketTTL, Payload: "baz"}, flapping.nextWrite(t))
+	select {
+	case w := <-fallback.writes:
+		t.Fatalf("unexpected write to the fallback transport: %v", w)
+	default:
+	}
+}
+
+func TestRouter_SelectTransportCache(t *testing.T) {
+	pk, _ := cipher.GenerateKeyPair()
+	remotePK, _ := cipher.GenerateKeyPair()
+	preferred := newMockTransport(remotePK, "preferred")
+	fallback := newMockTransport(remotePK, "fallback")
+	tm := newMockTransportManager(preferred, fallback)
+	conf := &Config{
+		PubKey:                    pk,
+		TransportPreference:       []string{"preferred", "fallback"},
+		TransportFailureThreshold: 1,
+		TransportFailureCooldown:  time.Minute,
+	}
+	conf.SetDefaults()
+	r := newRouter(conf, tm)
+	clock := newFakeClock()
+	r.cooldowns.clock = clock
+
+	// selects checks that the rule of the fallback transport is forwarded over want,
+	// and reports whether the transports were walked to select it.
+	selects := func(want *mockTransport) bool {
+		walks := atomic.LoadInt32(&tm.walks)
+		for i := 0; i < 3; i++ {
+			assert.Equal(t, want, r.selectTransport(fallback.id))
+		}
+		return atomic.LoadInt32(&tm.walks) != walks
+	}
+
+	// The selection is made once, later packets use the cached one.
+	assert.True(t, selects(preferred))
+	assert.False(t, selects(preferred))
+
+	// Transports going down or up invalidate the selection.
+	tm.setUp(preferred, false)
+	assert.True(t, selects(fallback))
+	assert.False(t, selects(fallback))
+	tm.setUp(preferred, true)
+	assert.True(t, selects(preferred))
+
+	// So do cooldowns starting and passing.
+	r.cooldowns.record(preferred.id, errMockWrite)
+	assert.True(t, selects(fallback))
+	assert.False(t, selects(fallback))
+	clock.Advance(time.Minute)
+	assert.True(t, selects(preferred))
+	assert.False(t, selects(preferred))
+}
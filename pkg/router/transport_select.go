@@ -0,0 +1,125 @@
+package router
+
+import (
+	"context"
+	"time"
+
+	"github.com/SkycoinProject/dmsg/cipher"
+	"github.com/google/uuid"
+
+	"github.com/SkycoinProject/skywire-mainnet/pkg/routing"
+)
+
+// selectTransport returns the transport to forward packets over in place of the transport tpID of a rule.
+//
+// Without Config.TransportPreference this is always the transport of the rule. Otherwise, packets may be forwarded
+// over any transport to the same remote, as the remote routes packets by route ID regardless of the transport
+// they arrive on. Of the transports which are up, the one whose network type comes first in the preference is
+// selected, network types missing from the preference come last and the transport of the rule wins ties.
+// Once the selected transport goes down or cools down after failed writes (see Config.TransportFailureThreshold),
+// packets fail over to the next one. If no transport is usable, the transport of the rule is returned.
+//
+// The preferred transport of each remote is cached until a transport is added, removed, goes up or down,
+// or starts or stops cooling down, so that forwarding a packet does not walk all transports.
+func (r *Router) selectTransport(tpID uuid.UUID) Transport {
+	tp := r.tm.Transport(tpID)
+	if tp == nil || len(r.conf.TransportPreference) == 0 {
+		return tp
+	}
+
+	best := r.bestTransport(tp.Remote())
+	if best == nil || (tp.IsUp() && !r.cooldowns.coolingDown(tp.ID()) && r.transportRank(tp) <= r.transportRank(best)) {
+		return tp
+	}
+	return best
+}
+
+// tpSelection is the preferred transport to a remote, see Router.bestTransport.
+type tpSelection struct {
+	tp        Transport // nil if no transport to the remote is usable
+	tmVersion uint64    // version of the transports the selection was made with, see TransportManager.StateVersion
+	cdVersion uint64    // version of the cooldowns the selection was made with
+	until     time.Time // the earliest end of a cooldown of a skipped transport, zero if none
+}
+
+// bestTransport returns the usable transport to remote whose network type comes first in Config.TransportPreference.
+// It returns nil if no transport to remote is usable.
+func (r *Router) bestTransport(remote cipher.PubKey) Transport {
+	// The versions are obtained first, so that changes during the walk invalidate the selection.
+	tmVersion := r.tm.StateVersion()
+	cdVersion, now := r.cooldowns.state()
+
+	r.selMx.Lock()
+	defer r.selMx.Unlock()
+
+	if sel, ok := r.selections[remote]; ok && sel.tmVersion == tmVersion && sel.cdVersion == cdVersion &&
+		(sel.until.IsZero() || now.Before(sel.until)) {
+		return sel.tp
+	}
+
+	sel := &tpSelection{tmVersion: tmVersion, cdVersion: cdVersion}
+	r.tm.WalkTransports(func(mt Transport) bool {
+		if mt.Remote() != remote || !mt.IsUp() {
+			return true
+		}
+		if until := r.cooldowns.until(mt.ID()); !until.IsZero() {
+			if sel.until.IsZero() || until.Before(sel.until) {
+				sel.until = until
+			}
+			return true
+		}
+		if sel.tp == nil || r.transportRank(mt) < r.transportRank(sel.tp) {
+			sel.tp = mt
+		}
+		return true
+	})
+	r.selections[remote] = sel
+	return sel.tp
+}
+
+// transportRank returns the index of the network type of tp in Config.TransportPreference,
+// network types missing from it rank last.
+func (r *Router) transportRank(tp Transport) int {
+	for i, t := range r.conf.TransportPreference {
+		if t == tp.Type() {
+			return i
+		}
+	}
+	return len(r.conf.TransportPreference)
+}
+
+// maxPayloadSize returns the largest payload forwarded in one packet over any transport selectTransport(tpID) may return,
+// 0 if there is no transport of tpID.
+func (r *Router) maxPayloadSize(tpID uuid.UUID) int {
+	tp := r.tm.Transport(tpID)
+	if tp == nil {
+		return 0
+	}
+	size := tp.MaxPayloadSize()
+	if len(r.conf.TransportPreference) == 0 {
+		return size
+	}
+	r.tm.WalkTransports(func(mt Transport) bool {
+		if mt.Remote() == tp.Remote() && mt.IsUp() {
+			if s := mt.MaxPayloadSize(); s < size {
+				size = s
+			}
+		}
+		return true
+	})
+	return size
+}
+
+// writePayload writes payload to tp in packets of rtID, each with the sequence number returned by nextSeq.
+// Routes carry a stream of bytes, so a payload larger than tp carries in one packet is split among several packets.
+func writePayload(ctx context.Context, tp Transport, rtID routing.RouteID, ttl uint8, nextSeq func() uint32, payload []byte) error {
+	if max := tp.MaxPayloadSize(); max > 0 {
+		for len(payload) > max {
+			if err := tp.WritePacketWithSeq(ctx, rtID, ttl, nextSeq(), payload[:max]); err != nil {
+				return err
+			}
+			payload = payload[max:]
+		}
+	}
+	return tp.WritePacketWithSeq(ctx, rtID, ttl, nextSeq(), payload)
+}
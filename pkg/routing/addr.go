@@ -6,7 +6,9 @@ import (
 	"github.com/SkycoinProject/dmsg/cipher"
 )
 
-// Port is a network port number
+// Port is a network port number.
+// Ports are network-agnostic: an app bound to a port is reachable on it through transports of every network type,
+// and a port cannot be bound separately for each network type.
 type Port uint16
 
 const networkType = "skywire"
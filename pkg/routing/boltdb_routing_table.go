@@ -1,11 +1,16 @@
 package routing
 
 import (
+	"crypto/aes"
+	stdcipher "crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"math"
 
+	"github.com/SkycoinProject/dmsg/cipher"
 	"github.com/SkycoinProject/skycoin/src/util/logging"
 	"go.etcd.io/bbolt"
 )
@@ -13,47 +18,177 @@ import (
 var boltDBBucket = []byte("routing")
 var log = logging.MustGetLogger("routing")
 
+// The meta bucket records the format rules are stored in.
+var (
+	boltDBMetaBucket = []byte("meta")
+	boltDBFormatKey  = []byte("format")
+)
+
+const (
+	boltDBFormatPlaintext = "plaintext"
+	boltDBFormatEncrypted = "encrypted"
+)
+
+// ErrRuleDecryption occurs when a rule of an encrypted routing table cannot be decrypted and authenticated,
+// for example because the stored rule was tampered with or encrypted with a different key.
+var ErrRuleDecryption = errors.New("failed to decrypt routing rule")
+
+// ErrTableEncrypted occurs when a routing table which stores encrypted rules is opened by BoltDBRoutingTable.
+var ErrTableEncrypted = errors.New("routing table is encrypted, it has to be opened with encryption enabled")
+
 // BoltDBRoutingTable implements RoutingTable on top of BoltDB.
 type boltDBRoutingTable struct {
-	db *bbolt.DB
+	db   *bbolt.DB
+	aead stdcipher.AEAD // encrypts stored rules, nil stores them in plaintext
 }
 
 // BoltDBRoutingTable constructs a new BoldDBRoutingTable.
 func BoltDBRoutingTable(path string) (Table, error) {
+	return newBoltDBRoutingTable(path, nil)
+}
+
+// EncryptedBoltDBRoutingTable is like BoltDBRoutingTable, but rules are encrypted with a key derived from sk
+// before they are written to disk. Reading a rule which fails to decrypt returns ErrRuleDecryption.
+// Rules of a table stored in plaintext are encrypted when it is opened, after which
+// BoltDBRoutingTable refuses to open it with ErrTableEncrypted.
+func EncryptedBoltDBRoutingTable(path string, sk cipher.SecKey) (Table, error) {
+	key := sha256.Sum256(append([]byte("skywire routing table encryption"), sk[:]...))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := stdcipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return newBoltDBRoutingTable(path, aead)
+}
+
+func newBoltDBRoutingTable(path string, aead stdcipher.AEAD) (Table, error) {
 	db, err := bbolt.Open(path, 0600, nil)
 	if err != nil {
 		return nil, err
 	}
 
+	rt := &boltDBRoutingTable{db: db, aead: aead}
 	err = db.Update(func(tx *bbolt.Tx) error {
-		if _, err := tx.CreateBucketIfNotExists(boltDBBucket); err != nil {
+		b, err := tx.CreateBucketIfNotExists(boltDBBucket)
+		if err != nil {
+			return fmt.Errorf("failed to create bucket: %s", err)
+		}
+		meta, err := tx.CreateBucketIfNotExists(boltDBMetaBucket)
+		if err != nil {
 			return fmt.Errorf("failed to create bucket: %s", err)
 		}
 
-		return nil
+		return rt.checkFormat(b, meta)
 	})
 	if err != nil {
+		if cErr := db.Close(); cErr != nil {
+			log.WithError(cErr).Warn("Failed to close routing table")
+		}
 		return nil, err
 	}
 
-	return &boltDBRoutingTable{db}, nil
+	return rt, nil
 }
 
-// AddRule adds routing rule to the table and returns assigned Route ID.
-func (rt *boltDBRoutingTable) AddRule(rule Rule) (routeID RouteID, err error) {
-	err = rt.db.Update(func(tx *bbolt.Tx) error {
-		b := tx.Bucket(boltDBBucket)
-		nextID, err := b.NextSequence()
+func (rt *boltDBRoutingTable) format() string {
+	if rt.aead == nil {
+		return boltDBFormatPlaintext
+	}
+	return boltDBFormatEncrypted
+}
+
+// checkFormat checks that the stored rules are in the format of rt, encrypting rules stored in plaintext
+// if rt encrypts them, and records the format. Tables without a recorded format store rules in plaintext.
+func (rt *boltDBRoutingTable) checkFormat(b, meta *bbolt.Bucket) error {
+	format := string(meta.Get(boltDBFormatKey))
+	if format == "" {
+		format = boltDBFormatPlaintext
+	}
+
+	switch {
+	case format == rt.format():
+		return nil
+	case format == boltDBFormatPlaintext && rt.aead != nil:
+		if err := rt.encryptRules(b); err != nil {
+			return fmt.Errorf("failed to encrypt routing table: %v", err)
+		}
+	case format == boltDBFormatEncrypted:
+		return ErrTableEncrypted
+	default:
+		return fmt.Errorf("unknown routing table format '%s'", format)
+	}
+	return meta.Put(boltDBFormatKey, []byte(rt.format()))
+}
+
+// encryptRules replaces the plaintext rules stored in b with encrypted ones.
+func (rt *boltDBRoutingTable) encryptRules(b *bbolt.Bucket) error {
+	var routeIDs []RouteID
+	var rules []Rule
+	err := b.ForEach(func(k, v []byte) error {
+		// The bucket cannot be modified while iterating it.
+		routeIDs = append(routeIDs, RouteID(binary.BigEndian.Uint32(k)))
+		rules = append(rules, append(Rule(nil), v...))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for i, routeID := range routeIDs {
+		v, err := rt.seal(routeID, rules[i])
 		if err != nil {
 			return err
 		}
-
-		if nextID > math.MaxUint32 {
-			return errors.New("no available routeIDs")
+		if err := b.Put(binaryID(routeID), v); err != nil {
+			return err
 		}
+	}
+	if len(routeIDs) > 0 {
+		log.Infof("Encrypted %d routing rules stored in plaintext", len(routeIDs))
+	}
+	return nil
+}
+
+// seal encrypts the rule of routeID if encryption is enabled. The route ID is authenticated along with the rule,
+// so that stored rules cannot be swapped between route IDs.
+func (rt *boltDBRoutingTable) seal(routeID RouteID, rule Rule) ([]byte, error) {
+	if rt.aead == nil {
+		return rule, nil
+	}
+	nonce := make([]byte, rt.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return rt.aead.Seal(nonce, nonce, rule, binaryID(routeID)), nil
+}
+
+// open decrypts the stored value of routeID if encryption is enabled.
+// The returned rule does not share memory with v, so it remains valid after the transaction v was read in.
+func (rt *boltDBRoutingTable) open(routeID RouteID, v []byte) (Rule, error) {
+	if rt.aead == nil {
+		rule := make(Rule, len(v))
+		copy(rule, v)
+		return rule, nil
+	}
+	nonceSize := rt.aead.NonceSize()
+	if len(v) < nonceSize {
+		return nil, ErrRuleDecryption
+	}
+	rule, err := rt.aead.Open(nil, v[:nonceSize], v[nonceSize:], binaryID(routeID))
+	if err != nil {
+		return nil, ErrRuleDecryption
+	}
+	return rule, nil
+}
 
-		routeID = RouteID(nextID)
-		return b.Put(binaryID(routeID), []byte(rule))
+// AddRule adds routing rule to the table and returns assigned Route ID.
+func (rt *boltDBRoutingTable) AddRule(rule Rule) (routeID RouteID, err error) {
+	err = rt.Transaction(func(tx RuleTx) error {
+		routeID, err = tx.AddRule(rule)
+		return err
 	})
 
 	return routeID, err
@@ -61,43 +196,120 @@ func (rt *boltDBRoutingTable) AddRule(rule Rule) (routeID RouteID, err error) {
 
 // SetRule sets RoutingRule for a given RouteID.
 func (rt *boltDBRoutingTable) SetRule(routeID RouteID, rule Rule) error {
-	return rt.db.Update(func(tx *bbolt.Tx) error {
-		b := tx.Bucket(boltDBBucket)
+	return rt.Transaction(func(tx RuleTx) error {
+		return tx.SetRule(routeID, rule)
+	})
+}
 
-		return b.Put(binaryID(routeID), []byte(rule))
+// Transaction runs fn within a single BoltDB update transaction, which other updates wait for.
+func (rt *boltDBRoutingTable) Transaction(fn func(tx RuleTx) error) error {
+	return rt.db.Update(func(tx *bbolt.Tx) error {
+		return fn(&boltDBRuleTx{b: tx.Bucket(boltDBBucket), rt: rt})
 	})
 }
 
+// boltDBRuleTx implements RuleTx on top of a BoltDB bucket within an update transaction.
+type boltDBRuleTx struct {
+	b  *bbolt.Bucket
+	rt *boltDBRoutingTable
+}
+
+func (tx *boltDBRuleTx) AddRule(rule Rule) (RouteID, error) {
+	nextID, err := tx.b.NextSequence()
+	if err != nil {
+		return 0, err
+	}
+
+	if nextID > math.MaxUint32 {
+		return 0, errors.New("no available routeIDs")
+	}
+
+	routeID := RouteID(nextID)
+	return routeID, tx.SetRule(routeID, rule)
+}
+
+func (tx *boltDBRuleTx) SetRule(routeID RouteID, rule Rule) error {
+	v, err := tx.rt.seal(routeID, rule)
+	if err != nil {
+		return err
+	}
+	return tx.b.Put(binaryID(routeID), v)
+}
+
+// RangeRules reads all rules before yielding them, as the bucket may not be modified while it is iterated over.
+func (tx *boltDBRuleTx) RangeRules(rangeFunc RangeFunc) error {
+	routeIDs, rules, err := tx.rt.readRules(tx.b)
+	if err != nil {
+		return err
+	}
+
+	for i, routeID := range routeIDs {
+		if !rangeFunc(routeID, rules[i]) {
+			break
+		}
+	}
+	return nil
+}
+
 // Rule returns RoutingRule with a given RouteID.
 func (rt *boltDBRoutingTable) Rule(routeID RouteID) (Rule, error) {
 	var rule Rule
 	err := rt.db.View(func(tx *bbolt.Tx) error {
 		b := tx.Bucket(boltDBBucket)
-		rule = b.Get(binaryID(routeID))
-		return nil
+		v := b.Get(binaryID(routeID))
+		if v == nil {
+			return nil
+		}
+		var err error
+		rule, err = rt.open(routeID, v)
+		return err
 	})
+	if err != nil {
+		return nil, err
+	}
 	if rule == nil {
 		return nil, fmt.Errorf("rule of routeID '%v' does not exist", routeID)
 	}
-	return rule, err
+	return rule, nil
 }
 
 // RangeRules iterates over all rules and yields values to the rangeFunc until `next` is false.
+// Rules are read within a single transaction and yielded after it ends, so rangeFunc may safely modify the table.
 func (rt *boltDBRoutingTable) RangeRules(rangeFunc RangeFunc) error {
-	return rt.db.View(func(tx *bbolt.Tx) error {
-		b := tx.Bucket(boltDBBucket)
-		f := func(k, v []byte) error {
-			if !rangeFunc(RouteID(binary.BigEndian.Uint32(k)), v) {
-				return errors.New("iterator stopped")
-			}
+	var routeIDs []RouteID
+	var rules []Rule
+	err := rt.db.View(func(tx *bbolt.Tx) error {
+		var err error
+		routeIDs, rules, err = rt.readRules(tx.Bucket(boltDBBucket))
+		return err
+	})
+	if err != nil {
+		return err
+	}
 
-			return nil
+	for i, routeID := range routeIDs {
+		if !rangeFunc(routeID, rules[i]) {
+			break
 		}
-		if err := b.ForEach(f); err != nil {
-			log.Warn(err)
+	}
+	return nil
+}
+
+// readRules reads all rules of b along with their route IDs.
+func (rt *boltDBRoutingTable) readRules(b *bbolt.Bucket) (routeIDs []RouteID, rules []Rule, err error) {
+	err = b.ForEach(func(k, v []byte) error {
+		// Values are only valid within the transaction, open copies them.
+		routeID := RouteID(binary.BigEndian.Uint32(k))
+		rule, err := rt.open(routeID, v)
+		if err != nil {
+			return err
 		}
+
+		routeIDs = append(routeIDs, routeID)
+		rules = append(rules, rule)
 		return nil
 	})
+	return routeIDs, rules, err
 }
 
 // Rules returns RoutingRules for a given RouteIDs.
@@ -107,8 +319,11 @@ func (rt *boltDBRoutingTable) Rules(routeIDs ...RouteID) (rules []Rule, err erro
 		b := tx.Bucket(boltDBBucket)
 
 		for _, routeID := range routeIDs {
-			rule := b.Get(binaryID(routeID))
-			if rule != nil {
+			if v := b.Get(binaryID(routeID)); v != nil {
+				rule, err := rt.open(routeID, v)
+				if err != nil {
+					return err
+				}
 				rules = append(rules, rule)
 			}
 		}
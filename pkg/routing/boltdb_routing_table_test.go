@@ -1,11 +1,17 @@
 package routing
 
 import (
+	"bytes"
 	"io/ioutil"
 	"os"
 	"testing"
+	"time"
 
+	"github.com/SkycoinProject/dmsg/cipher"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.etcd.io/bbolt"
 )
 
 func TestBoltDBRoutingTable(t *testing.T) {
@@ -23,4 +29,184 @@ func TestBoltDBRoutingTable(t *testing.T) {
 	require.NoError(t, err)
 
 	RoutingTableSuite(t, tbl)
+	RoutingTableRangeSuite(t, tbl)
+}
+
+func TestBoltDBRoutingTable_Transaction(t *testing.T) {
+	dbfile, err := ioutil.TempFile("", "routes.db")
+	require.NoError(t, err)
+
+	defer func() {
+		require.NoError(t, os.Remove(dbfile.Name()))
+	}()
+
+	tbl, err := BoltDBRoutingTable(dbfile.Name())
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, tbl.Close())
+	}()
+
+	RoutingTableTransactionSuite(t, tbl)
+}
+
+func TestEncryptedBoltDBRoutingTable(t *testing.T) {
+	dbfile, err := ioutil.TempFile("", "routes.db")
+	require.NoError(t, err)
+
+	defer func() {
+		require.NoError(t, os.Remove(dbfile.Name()))
+	}()
+
+	_, sk := cipher.GenerateKeyPair()
+
+	tbl, err := EncryptedBoltDBRoutingTable(dbfile.Name(), sk)
+	require.NoError(t, err)
+
+	RoutingTableSuite(t, tbl)
+	RoutingTableRangeSuite(t, tbl)
+	RoutingTableTransactionSuite(t, tbl)
+
+	rule := ForwardRule(time.Hour, 2, uuid.New(), 1)
+	routeID, err := tbl.AddRule(rule)
+	require.NoError(t, err)
+
+	// Rules are not stored in plaintext.
+	db := tbl.(*boltDBRoutingTable).db
+	require.NoError(t, db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(boltDBBucket).ForEach(func(_, v []byte) error {
+			assert.False(t, bytes.Contains(v, rule))
+			return nil
+		})
+	}))
+	require.NoError(t, tbl.Close())
+
+	// Rules are decrypted once reopened with the same key.
+	tbl, err = EncryptedBoltDBRoutingTable(dbfile.Name(), sk)
+	require.NoError(t, err)
+	r, err := tbl.Rule(routeID)
+	require.NoError(t, err)
+	assert.Equal(t, rule, r)
+	require.NoError(t, tbl.Close())
+
+	// Rules fail to decrypt with a different key.
+	_, otherSK := cipher.GenerateKeyPair()
+	tbl, err = EncryptedBoltDBRoutingTable(dbfile.Name(), otherSK)
+	require.NoError(t, err)
+	_, err = tbl.Rule(routeID)
+	assert.Equal(t, ErrRuleDecryption, err)
+	require.NoError(t, tbl.Close())
+}
+
+func TestEncryptedBoltDBRoutingTable_Upgrade(t *testing.T) {
+	dbfile, err := ioutil.TempFile("", "routes.db")
+	require.NoError(t, err)
+
+	defer func() {
+		require.NoError(t, os.Remove(dbfile.Name()))
+	}()
+
+	tbl, err := BoltDBRoutingTable(dbfile.Name())
+	require.NoError(t, err)
+	rules := []Rule{
+		ForwardRule(time.Hour, 2, uuid.New(), 1),
+		ForwardRule(time.Hour, 3, uuid.New(), 1),
+	}
+	var routeIDs []RouteID
+	for _, rule := range rules {
+		routeID, err := tbl.AddRule(rule)
+		require.NoError(t, err)
+		routeIDs = append(routeIDs, routeID)
+	}
+	require.NoError(t, tbl.Close())
+
+	// Rules stored in plaintext are encrypted once opened with encryption.
+	_, sk := cipher.GenerateKeyPair()
+	tbl, err = EncryptedBoltDBRoutingTable(dbfile.Name(), sk)
+	require.NoError(t, err)
+	for i, routeID := range routeIDs {
+		r, err := tbl.Rule(routeID)
+		require.NoError(t, err)
+		assert.Equal(t, rules[i], r)
+	}
+	assert.Equal(t, len(rules), tbl.Count())
+
+	db := tbl.(*boltDBRoutingTable).db
+	require.NoError(t, db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(boltDBBucket).ForEach(func(_, v []byte) error {
+			for _, rule := range rules {
+				assert.False(t, bytes.Contains(v, rule))
+			}
+			return nil
+		})
+	}))
+	require.NoError(t, tbl.Close())
+
+	// The encrypted table is not opened as plaintext.
+	_, err = BoltDBRoutingTable(dbfile.Name())
+	assert.Equal(t, ErrTableEncrypted, err)
+
+	// Nor is one of an unknown format.
+	db, err = bbolt.Open(dbfile.Name(), 0600, nil)
+	require.NoError(t, err)
+	require.NoError(t, db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(boltDBMetaBucket).Put(boltDBFormatKey, []byte("unknown"))
+	}))
+	require.NoError(t, db.Close())
+	_, err = EncryptedBoltDBRoutingTable(dbfile.Name(), sk)
+	assert.EqualError(t, err, "unknown routing table format 'unknown'")
+}
+
+func TestEncryptedBoltDBRoutingTable_Tampered(t *testing.T) {
+	dbfile, err := ioutil.TempFile("", "routes.db")
+	require.NoError(t, err)
+
+	defer func() {
+		require.NoError(t, os.Remove(dbfile.Name()))
+	}()
+
+	_, sk := cipher.GenerateKeyPair()
+	tbl, err := EncryptedBoltDBRoutingTable(dbfile.Name(), sk)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, tbl.Close())
+	}()
+
+	id1, err := tbl.AddRule(ForwardRule(time.Hour, 2, uuid.New(), 1))
+	require.NoError(t, err)
+	id2, err := tbl.AddRule(ForwardRule(time.Hour, 3, uuid.New(), 1))
+	require.NoError(t, err)
+
+	db := tbl.(*boltDBRoutingTable).db
+	update := func(fn func(b *bbolt.Bucket) error) {
+		require.NoError(t, db.Update(func(tx *bbolt.Tx) error {
+			return fn(tx.Bucket(boltDBBucket))
+		}))
+	}
+
+	// A flipped bit of a stored rule is detected.
+	update(func(b *bbolt.Bucket) error {
+		v := append([]byte(nil), b.Get(binaryID(id1))...)
+		v[len(v)-1] ^= 1
+		return b.Put(binaryID(id1), v)
+	})
+	_, err = tbl.Rule(id1)
+	assert.Equal(t, ErrRuleDecryption, err)
+	assert.Equal(t, ErrRuleDecryption, tbl.RangeRules(func(RouteID, Rule) bool { return true }))
+
+	// A stored rule moved to another route ID is detected.
+	update(func(b *bbolt.Bucket) error {
+		return b.Put(binaryID(id1), append([]byte(nil), b.Get(binaryID(id2))...))
+	})
+	_, err = tbl.Rule(id1)
+	assert.Equal(t, ErrRuleDecryption, err)
+
+	// A plaintext rule is rejected.
+	update(func(b *bbolt.Bucket) error {
+		return b.Put(binaryID(id1), ForwardRule(time.Hour, 2, uuid.New(), 1))
+	})
+	_, err = tbl.Rule(id1)
+	assert.Equal(t, ErrRuleDecryption, err)
+
+	_, err = tbl.Rule(id2)
+	assert.NoError(t, err)
 }
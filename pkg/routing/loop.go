@@ -1,6 +1,7 @@
 package routing
 
 import (
+	"errors"
 	"fmt"
 	"time"
 
@@ -13,17 +14,41 @@ type Loop struct {
 	Remote Addr
 }
 
+// Invert returns the same loop as seen from the remote end, with Local and Remote swapped.
+func (l Loop) Invert() Loop {
+	return Loop{Local: l.Remote, Remote: l.Local}
+}
+
 // TODO: discuss if we should add local PK to the output
 func (l Loop) String() string {
 	return fmt.Sprintf("%s:%d <-> %s:%d", l.Local.PubKey, l.Local.Port, l.Remote.PubKey, l.Remote.Port)
 }
 
+// MaxLoopMetadataSize is the maximum size of app-defined metadata attached to a LoopDescriptor.
+const MaxLoopMetadataSize = 1024
+
+var (
+	// ErrLoopMetadataTooLarge occurs when the metadata of a LoopDescriptor exceeds MaxLoopMetadataSize.
+	ErrLoopMetadataTooLarge = errors.New("loop metadata is too large")
+
+	// ErrEmptyForwardRoute occurs when a LoopDescriptor has no forward route.
+	ErrEmptyForwardRoute = errors.New("empty forward route")
+
+	// ErrEmptyReverseRoute occurs when a LoopDescriptor has no reverse route.
+	ErrEmptyReverseRoute = errors.New("empty reverse route")
+)
+
 // LoopDescriptor defines a loop over a pair of routes.
 type LoopDescriptor struct {
 	Loop      Loop
 	Forward   Route
 	Reverse   Route
 	KeepAlive time.Duration
+	Metadata  []byte // optional app-defined metadata, delivered to the responder
+
+	// SetupTimeout is the time budget of the whole loop setup, 0 uses the setup node's default.
+	// On expiry, rules that are already installed are removed.
+	SetupTimeout time.Duration
 }
 
 // Initiator returns initiator of the Loop.
@@ -44,13 +69,80 @@ func (l LoopDescriptor) Responder() cipher.PubKey {
 	return l.Reverse[0].From
 }
 
+// Validate checks that the routes of the LoopDescriptor are consistent with the Loop.
+// The forward route should lead from the local to the remote public key of the Loop and the reverse route back,
+// with each hop starting where the previous one ended.
+func (l LoopDescriptor) Validate() error {
+	if len(l.Metadata) > MaxLoopMetadataSize {
+		return ErrLoopMetadataTooLarge
+	}
+	if len(l.Forward) == 0 {
+		return ErrEmptyForwardRoute
+	}
+	if len(l.Reverse) == 0 {
+		return ErrEmptyReverseRoute
+	}
+	if err := validateRoute(l.Forward, l.Loop.Local.PubKey, l.Loop.Remote.PubKey); err != nil {
+		return fmt.Errorf("invalid forward route: %v", err)
+	}
+	if err := validateRoute(l.Reverse, l.Loop.Remote.PubKey, l.Loop.Local.PubKey); err != nil {
+		return fmt.Errorf("invalid reverse route: %v", err)
+	}
+	return nil
+}
+
+func validateRoute(r Route, from, to cipher.PubKey) error {
+	prev := from
+	for i, hop := range r {
+		if hop == nil {
+			return fmt.Errorf("hop %d is nil", i)
+		}
+		if hop.From != prev {
+			return fmt.Errorf("hop %d starts at %s instead of %s", i, hop.From, prev)
+		}
+		prev = hop.To
+	}
+	if prev != to {
+		return fmt.Errorf("route ends at %s instead of %s", prev, to)
+	}
+	return nil
+}
+
+// Invert returns the same LoopDescriptor as seen from the responder:
+// the Loop is inverted and the forward and reverse routes are swapped.
+// Inverting twice results in the original LoopDescriptor.
+func (l LoopDescriptor) Invert() LoopDescriptor {
+	return LoopDescriptor{
+		Loop:         l.Loop.Invert(),
+		Forward:      l.Reverse,
+		Reverse:      l.Forward,
+		KeepAlive:    l.KeepAlive,
+		Metadata:     l.Metadata,
+		SetupTimeout: l.SetupTimeout,
+	}
+}
+
 func (l LoopDescriptor) String() string {
 	return fmt.Sprintf("lport: %d. rport: %d. routes: %s/%s. keep-alive timeout %s",
 		l.Loop.Local.Port, l.Loop.Remote.Port, l.Forward, l.Reverse, l.KeepAlive)
 }
 
+// CloseCode tells why a loop was closed. Codes other than the ones defined here may be used by apps.
+type CloseCode uint8
+
+const (
+	// CloseNormal is the close code of a loop closed gracefully.
+	CloseNormal = CloseCode(iota)
+	// CloseError is the close code of a loop closed because of an error, such as an app exiting without closing it.
+	CloseError
+)
+
 // LoopData stores loop confirmation request data.
 type LoopData struct {
-	Loop    Loop    `json:"loop"`
-	RouteID RouteID `json:"resp-rid,omitempty"`
+	Loop      Loop      `json:"loop"`
+	RouteID   RouteID   `json:"resp-rid,omitempty"`
+	Metadata  []byte    `json:"metadata,omitempty"`   // app-defined metadata of the LoopDescriptor
+	CloseCode CloseCode `json:"close-code,omitempty"` // why the loop was closed, only set by loop close requests
+
+	Paused bool `json:"paused,omitempty"` // whether the loop is paused or resumed, only set by loop pause requests
 }
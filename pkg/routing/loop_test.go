@@ -0,0 +1,79 @@
+package routing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/SkycoinProject/dmsg/cipher"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoopDescriptor_Validate(t *testing.T) {
+	pk1, _ := cipher.GenerateKeyPair()
+	pk2, _ := cipher.GenerateKeyPair()
+	pk3, _ := cipher.GenerateKeyPair()
+
+	valid := LoopDescriptor{
+		Loop: Loop{
+			Local:  Addr{PubKey: pk1, Port: 1},
+			Remote: Addr{PubKey: pk3, Port: 2},
+		},
+		Forward: Route{
+			{From: pk1, To: pk2, Transport: uuid.New()},
+			{From: pk2, To: pk3, Transport: uuid.New()},
+		},
+		Reverse: Route{
+			{From: pk3, To: pk1, Transport: uuid.New()},
+		},
+		KeepAlive: time.Minute,
+		Metadata:  []byte("service"),
+	}
+	require.NoError(t, valid.Validate())
+	assert.Equal(t, pk1, valid.Initiator())
+	assert.Equal(t, pk3, valid.Responder())
+
+	t.Run("invert", func(t *testing.T) {
+		inv := valid.Invert()
+		require.NoError(t, inv.Validate())
+		assert.Equal(t, valid.Loop.Remote, inv.Loop.Local)
+		assert.Equal(t, valid.Loop.Local, inv.Loop.Remote)
+		assert.Equal(t, pk3, inv.Initiator())
+		assert.Equal(t, pk1, inv.Responder())
+		assert.Equal(t, valid, inv.Invert())
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		empty := valid
+		empty.Forward = nil
+		assert.Equal(t, ErrEmptyForwardRoute, empty.Validate())
+
+		empty = valid
+		empty.Reverse = Route{}
+		assert.Equal(t, ErrEmptyReverseRoute, empty.Validate())
+
+		wrongStart := valid
+		wrongStart.Forward = Route{{From: pk2, To: pk3, Transport: uuid.New()}}
+		assert.Error(t, wrongStart.Validate())
+
+		broken := valid
+		broken.Forward = Route{
+			{From: pk1, To: pk2, Transport: uuid.New()},
+			{From: pk1, To: pk3, Transport: uuid.New()},
+		}
+		assert.Error(t, broken.Validate())
+
+		wrongEnd := valid
+		wrongEnd.Reverse = Route{{From: pk3, To: pk2, Transport: uuid.New()}}
+		assert.Error(t, wrongEnd.Validate())
+
+		large := valid
+		large.Metadata = make([]byte, MaxLoopMetadataSize+1)
+		assert.Equal(t, ErrLoopMetadataTooLarge, large.Validate())
+
+		wrongLoop := valid
+		wrongLoop.Loop = valid.Loop.Invert()
+		assert.Error(t, wrongLoop.Validate())
+	})
+}
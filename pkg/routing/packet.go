@@ -2,14 +2,88 @@ package routing
 
 import (
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"math"
 )
 
 // PacketHeaderSize represents the base size of a packet.
 // All rules should have at-least this size.
-// TODO(evanlinjin): Document the format of packets in comments.
+//
+// The header consists of the payload size (2 bytes) and the route ID (4 bytes), both big-endian.
 const PacketHeaderSize = 6
 
+// TTLPacketHeaderSize is the size of the header of packets with a TTL, the TTL (1 byte) follows the header of a Packet.
+// Packets with a TTL are only exchanged over transports of which both ends support them.
+const TTLPacketHeaderSize = PacketHeaderSize + 1
+
+// SeqPacketHeaderSize is the size of the header of packets with a sequence number, the sequence number (4 bytes,
+// big-endian) follows the header of a packet with a TTL. Packets with a sequence number are only exchanged over
+// transports of which both ends support them.
+const SeqPacketHeaderSize = TTLPacketHeaderSize + 4
+
+// TypedPacketHeaderSize is the size of the header of packets with a type, the PacketType (1 byte) follows the header
+// of a packet with a sequence number. Packets with a type are only exchanged over transports of which both ends
+// support them.
+const TypedPacketHeaderSize = SeqPacketHeaderSize + 1
+
+// DefaultPacketTTL is the TTL of packets sent by apps, unless configured otherwise,
+// and of packets read from transports which do not carry TTLs.
+const DefaultPacketTTL = uint8(64)
+
+var (
+	// ErrPacketTooShort occurs when a packet is shorter than PacketHeaderSize.
+	ErrPacketTooShort = errors.New("packet is shorter than packet header")
+
+	// ErrPacketSizeMismatch occurs when the declared size of a packet does not match its payload length.
+	ErrPacketSizeMismatch = errors.New("packet size does not match payload length")
+
+	// ErrInvalidLoopState occurs when the payload of a loop state packet is malformed.
+	ErrInvalidLoopState = errors.New("invalid loop state payload")
+)
+
+// PacketType is the type of a packet. Packets read from transports which do not carry types are DataPackets.
+type PacketType byte
+
+const (
+	// DataPacket carries data of a route.
+	DataPacket PacketType = iota
+	// LoopStatePacket tells the router at the end of a loop's route that the app at its start paused or resumed
+	// the loop, see MakeLoopStatePayload.
+	LoopStatePacket
+	// LoopStateAckPacket acknowledges a LoopStatePacket to its sender over the reverse route, with the same payload.
+	LoopStateAckPacket
+)
+
+func (t PacketType) String() string {
+	switch t {
+	case DataPacket:
+		return "Data"
+	case LoopStatePacket:
+		return "LoopState"
+	case LoopStateAckPacket:
+		return "LoopStateAck"
+	default:
+		return fmt.Sprintf("Unknown(%d)", t)
+	}
+}
+
+// MakeLoopStatePayload returns the payload of LoopStatePackets and LoopStateAckPackets.
+func MakeLoopStatePayload(paused bool) []byte {
+	if paused {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+// ParseLoopStatePayload parses the payload of LoopStatePackets and LoopStateAckPackets.
+func ParseLoopStatePayload(payload []byte) (paused bool, err error) {
+	if len(payload) != 1 || payload[0] > 1 {
+		return false, ErrInvalidLoopState
+	}
+	return payload[0] == 1, nil
+}
+
 // RouteID represents ID of a Route in a Packet.
 type RouteID uint32
 
@@ -29,6 +103,53 @@ func MakePacket(id RouteID, payload []byte) Packet {
 	return Packet(append(packet, payload...))
 }
 
+// MakeTTLPacket constructs a packet with a TTL as it is written to transports which carry TTLs.
+// The TTL is the number of hops the packet may still take, every forwarding node decrements it.
+// If payload size is more than uint16, MakeTTLPacket will panic.
+func MakeTTLPacket(id RouteID, ttl uint8, payload []byte) []byte {
+	if len(payload) > math.MaxUint16 {
+		panic("packet size exceeded")
+	}
+
+	packet := make([]byte, TTLPacketHeaderSize)
+	binary.BigEndian.PutUint16(packet, uint16(len(payload)))
+	binary.BigEndian.PutUint32(packet[2:], uint32(id))
+	packet[PacketHeaderSize] = ttl
+	return append(packet, payload...)
+}
+
+// MakeSeqPacket constructs a packet with a TTL and a sequence number as it is written to transports which carry
+// sequence numbers. The sequence number is assigned by the router of the app which sent the payload, sequence number 0
+// means that the packet has none. If payload size is more than uint16, MakeSeqPacket will panic.
+func MakeSeqPacket(id RouteID, ttl uint8, seq uint32, payload []byte) []byte {
+	if len(payload) > math.MaxUint16 {
+		panic("packet size exceeded")
+	}
+
+	packet := make([]byte, SeqPacketHeaderSize)
+	binary.BigEndian.PutUint16(packet, uint16(len(payload)))
+	binary.BigEndian.PutUint32(packet[2:], uint32(id))
+	packet[PacketHeaderSize] = ttl
+	binary.BigEndian.PutUint32(packet[TTLPacketHeaderSize:], seq)
+	return append(packet, payload...)
+}
+
+// MakeTypedPacket constructs a packet with a TTL, a sequence number and a type as it is written to transports which
+// carry packet types. If payload size is more than uint16, MakeTypedPacket will panic.
+func MakeTypedPacket(id RouteID, ttl uint8, seq uint32, typ PacketType, payload []byte) []byte {
+	if len(payload) > math.MaxUint16 {
+		panic("packet size exceeded")
+	}
+
+	packet := make([]byte, TypedPacketHeaderSize)
+	binary.BigEndian.PutUint16(packet, uint16(len(payload)))
+	binary.BigEndian.PutUint32(packet[2:], uint32(id))
+	packet[PacketHeaderSize] = ttl
+	binary.BigEndian.PutUint32(packet[TTLPacketHeaderSize:], seq)
+	packet[SeqPacketHeaderSize] = byte(typ)
+	return append(packet, payload...)
+}
+
 // Size returns Packet's payload size.
 func (p Packet) Size() uint16 {
 	return binary.BigEndian.Uint16(p)
@@ -43,3 +164,15 @@ func (p Packet) RouteID() RouteID {
 func (p Packet) Payload() []byte {
 	return p[PacketHeaderSize:]
 }
+
+// ValidatePacket checks whether the packet is well-formed.
+// It should be called before accessing fields of packets received from remotes.
+func ValidatePacket(p Packet) error {
+	if len(p) < PacketHeaderSize {
+		return ErrPacketTooShort
+	}
+	if int(p.Size()) != len(p)-PacketHeaderSize {
+		return ErrPacketSizeMismatch
+	}
+	return nil
+}
@@ -18,3 +18,51 @@ func TestMakePacket(t *testing.T) {
 	assert.Equal(t, RouteID(2), packet.RouteID())
 	assert.Equal(t, []byte("foo"), packet.Payload())
 }
+
+func TestMakeTTLPacket(t *testing.T) {
+	assert.Equal(
+		t,
+		[]byte{0x0, 0x3, 0x0, 0x0, 0x0, 0x2, 0x5, 0x66, 0x6f, 0x6f},
+		MakeTTLPacket(2, 5, []byte("foo")),
+	)
+}
+
+func TestMakeSeqPacket(t *testing.T) {
+	assert.Equal(
+		t,
+		[]byte{0x0, 0x3, 0x0, 0x0, 0x0, 0x2, 0x5, 0x0, 0x0, 0x1, 0x2, 0x66, 0x6f, 0x6f},
+		MakeSeqPacket(2, 5, 258, []byte("foo")),
+	)
+}
+
+func TestMakeTypedPacket(t *testing.T) {
+	assert.Equal(
+		t,
+		[]byte{0x0, 0x1, 0x0, 0x0, 0x0, 0x2, 0x5, 0x0, 0x0, 0x1, 0x2, 0x1, 0x1},
+		MakeTypedPacket(2, 5, 258, LoopStatePacket, MakeLoopStatePayload(true)),
+	)
+}
+
+func TestParseLoopStatePayload(t *testing.T) {
+	for _, paused := range []bool{true, false} {
+		got, err := ParseLoopStatePayload(MakeLoopStatePayload(paused))
+		assert.NoError(t, err)
+		assert.Equal(t, paused, got)
+	}
+	for _, payload := range [][]byte{nil, {2}, {0, 1}} {
+		_, err := ParseLoopStatePayload(payload)
+		assert.Equal(t, ErrInvalidLoopState, err)
+	}
+}
+
+func TestValidatePacket(t *testing.T) {
+	packet := MakePacket(2, []byte("foo"))
+	assert.NoError(t, ValidatePacket(packet))
+	assert.NoError(t, ValidatePacket(MakePacket(2, nil)))
+
+	assert.Equal(t, ErrPacketTooShort, ValidatePacket(nil))
+	assert.Equal(t, ErrPacketTooShort, ValidatePacket(packet[:PacketHeaderSize-1]))
+
+	assert.Equal(t, ErrPacketSizeMismatch, ValidatePacket(packet[:len(packet)-1]))
+	assert.Equal(t, ErrPacketSizeMismatch, ValidatePacket(append(packet, 'o')))
+}
@@ -4,6 +4,7 @@ package routing
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/SkycoinProject/dmsg/cipher"
 	"github.com/google/uuid"
@@ -14,6 +15,7 @@ type Hop struct {
 	From      cipher.PubKey
 	To        cipher.PubKey
 	Transport uuid.UUID
+	KeepAlive time.Duration // keep-alive of the rule installed at From, 0 uses the keep-alive of the loop
 }
 
 func (h Hop) String() string {
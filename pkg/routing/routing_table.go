@@ -5,20 +5,40 @@ import (
 	"fmt"
 	"math"
 	"sync"
-	"sync/atomic"
 )
 
 // RangeFunc is used by RangeRules to iterate over rules.
 type RangeFunc func(routeID RouteID, rule Rule) (next bool)
 
+// RuleTx is used to read, add and set rules within a Table transaction.
+type RuleTx interface {
+	// AddRule adds a new RoutingRule to the transaction and returns the reserved RouteID.
+	AddRule(rule Rule) (routeID RouteID, err error)
+
+	// SetRule sets RoutingRule for a given RouteID within the transaction.
+	SetRule(routeID RouteID, rule Rule) error
+
+	// RangeRules iterates over the rules of the table, including the rules added and set within the transaction,
+	// and yields values to the rangeFunc until `next` is false.
+	// Rules are iterated over a snapshot, so rangeFunc may safely add and set rules through the transaction.
+	RangeRules(rangeFunc RangeFunc) error
+}
+
 // Table represents a routing table implementation.
 type Table interface {
 	// AddRule adds a new RoutingRules to the table and returns assigned RouteID.
+	// RouteIDs are allocated sequentially starting from 1, so they are reproducible for a fresh table.
 	AddRule(rule Rule) (routeID RouteID, err error)
 
 	// SetRule sets RoutingRule for a given RouteID.
 	SetRule(routeID RouteID, rule Rule) error
 
+	// Transaction runs fn within a single transaction.
+	// Rules added or set through tx are only committed if fn returns nil,
+	// otherwise they are discarded and the reserved RouteIDs are released.
+	// The table is locked while fn runs, so fn may only use tx: calling methods of the table from fn deadlocks.
+	Transaction(fn func(tx RuleTx) error) error
+
 	// Rule returns RoutingRule with a given RouteID.
 	Rule(routeID RouteID) (Rule, error)
 
@@ -26,6 +46,7 @@ type Table interface {
 	DeleteRules(routeIDs ...RouteID) error
 
 	// RangeRules iterates over all rules and yields values to the rangeFunc until `next` is false.
+	// Rules are iterated over a snapshot of the table, so rangeFunc may safely modify the table.
 	RangeRules(rangeFunc RangeFunc) error
 
 	// Count returns the number of RoutingRule entries stored.
@@ -50,15 +71,16 @@ func InMemoryRoutingTable() Table {
 }
 
 func (rt *inMemoryRoutingTable) AddRule(rule Rule) (routeID RouteID, err error) {
-	if routeID == math.MaxUint32 {
+	rt.Lock()
+	defer rt.Unlock()
+
+	if rt.nextID == math.MaxUint32 {
 		return 0, errors.New("no available routeIDs")
 	}
 
-	routeID = RouteID(atomic.AddUint32(&rt.nextID, 1))
-
-	rt.Lock()
+	rt.nextID++
+	routeID = RouteID(rt.nextID)
 	rt.rules[routeID] = rule
-	rt.Unlock()
 
 	return routeID, nil
 }
@@ -71,6 +93,66 @@ func (rt *inMemoryRoutingTable) SetRule(routeID RouteID, rule Rule) error {
 	return nil
 }
 
+// Transaction holds the lock of rt while fn runs, fn stages rules in tx which are copied to rt on success.
+func (rt *inMemoryRoutingTable) Transaction(fn func(tx RuleTx) error) error {
+	rt.Lock()
+	defer rt.Unlock()
+
+	tx := &inMemoryRuleTx{nextID: rt.nextID, committed: rt.rules, rules: make(map[RouteID]Rule)}
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	rt.nextID = tx.nextID
+	for routeID, rule := range tx.rules {
+		rt.rules[routeID] = rule
+	}
+
+	return nil
+}
+
+// inMemoryRuleTx stages rules and reserved RouteIDs until the transaction is committed.
+type inMemoryRuleTx struct {
+	nextID    uint32
+	committed map[RouteID]Rule // rules of the table, which is locked during the transaction
+	rules     map[RouteID]Rule
+}
+
+func (tx *inMemoryRuleTx) AddRule(rule Rule) (RouteID, error) {
+	if tx.nextID == math.MaxUint32 {
+		return 0, errors.New("no available routeIDs")
+	}
+
+	tx.nextID++
+	routeID := RouteID(tx.nextID)
+	tx.rules[routeID] = rule
+
+	return routeID, nil
+}
+
+func (tx *inMemoryRuleTx) SetRule(routeID RouteID, rule Rule) error {
+	tx.rules[routeID] = rule
+	return nil
+}
+
+func (tx *inMemoryRuleTx) RangeRules(rangeFunc RangeFunc) error {
+	rules := make(map[RouteID]Rule, len(tx.committed)+len(tx.rules))
+	for routeID, rule := range tx.committed {
+		rules[routeID] = rule
+	}
+	for routeID, rule := range tx.rules {
+		rules[routeID] = rule
+	}
+
+	for routeID, rule := range rules {
+		if !rangeFunc(routeID, rule) {
+			break
+		}
+	}
+
+	return nil
+}
+
 func (rt *inMemoryRoutingTable) Rule(routeID RouteID) (Rule, error) {
 	rt.RLock()
 	rule, ok := rt.rules[routeID]
@@ -83,12 +165,17 @@ func (rt *inMemoryRoutingTable) Rule(routeID RouteID) (Rule, error) {
 
 func (rt *inMemoryRoutingTable) RangeRules(rangeFunc RangeFunc) error {
 	rt.RLock()
+	rules := make(map[RouteID]Rule, len(rt.rules))
 	for routeID, rule := range rt.rules {
+		rules[routeID] = rule
+	}
+	rt.RUnlock()
+
+	for routeID, rule := range rules {
 		if !rangeFunc(routeID, rule) {
 			break
 		}
 	}
-	rt.RUnlock()
 
 	return nil
 }
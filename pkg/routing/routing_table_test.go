@@ -1,6 +1,7 @@
 package routing
 
 import (
+	"errors"
 	"os"
 	"testing"
 	"time"
@@ -32,6 +33,7 @@ func RoutingTableSuite(t *testing.T, tbl Table) {
 	rule := ForwardRule(15*time.Minute, 2, uuid.New(), 1)
 	id, err := tbl.AddRule(rule)
 	require.NoError(t, err)
+	assert.Equal(t, RouteID(1), id)
 
 	assert.Equal(t, 1, tbl.Count())
 
@@ -42,6 +44,7 @@ func RoutingTableSuite(t *testing.T, tbl Table) {
 	rule2 := ForwardRule(15*time.Minute, 3, uuid.New(), 2)
 	id2, err := tbl.AddRule(rule2)
 	require.NoError(t, err)
+	assert.Equal(t, RouteID(2), id2)
 
 	assert.Equal(t, 2, tbl.Count())
 
@@ -62,6 +65,121 @@ func RoutingTableSuite(t *testing.T, tbl Table) {
 	assert.Equal(t, 0, tbl.Count())
 }
 
+func RoutingTableTransactionSuite(t *testing.T, tbl Table) {
+	t.Helper()
+
+	rule := ForwardRule(15*time.Minute, 2, uuid.New(), 1)
+	errFailed := errors.New("failed")
+
+	// The second rule fails to be saved, so the first one is rolled back.
+	var reserved RouteID
+	err := tbl.Transaction(func(tx RuleTx) error {
+		id, err := tx.AddRule(rule)
+		if err != nil {
+			return err
+		}
+		reserved = id
+		if err := tx.SetRule(id, rule); err != nil {
+			return err
+		}
+		if _, err := tx.AddRule(rule); err != nil {
+			return err
+		}
+		return errFailed
+	})
+	assert.Equal(t, errFailed, err)
+	assert.Equal(t, 0, tbl.Count())
+	_, err = tbl.Rule(reserved)
+	assert.Error(t, err)
+
+	// Reserved route IDs are released on rollback.
+	var ids []RouteID
+	err = tbl.Transaction(func(tx RuleTx) error {
+		for i := 0; i < 2; i++ {
+			id, err := tx.AddRule(rule)
+			if err != nil {
+				return err
+			}
+			ids = append(ids, id)
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []RouteID{reserved, reserved + 1}, ids)
+	assert.Equal(t, 2, tbl.Count())
+
+	id, err := tbl.AddRule(rule)
+	require.NoError(t, err)
+	assert.Equal(t, reserved+2, id)
+
+	r, err := tbl.Rule(ids[1])
+	require.NoError(t, err)
+	assert.Equal(t, rule, r)
+
+	// Rules are read and rewritten within the transaction, including the rules added in it.
+	other := ForwardRule(15*time.Minute, 3, uuid.New(), 1)
+	err = tbl.Transaction(func(tx RuleTx) error {
+		added, err := tx.AddRule(rule)
+		if err != nil {
+			return err
+		}
+		seen := make(map[RouteID]bool)
+		err = tx.RangeRules(func(routeID RouteID, r Rule) bool {
+			seen[routeID] = true
+			assert.Equal(t, rule, r)
+			assert.NoError(t, tx.SetRule(routeID, other))
+			return true
+		})
+		assert.Equal(t, map[RouteID]bool{ids[0]: true, ids[1]: true, id: true, added: true}, seen)
+		return err
+	})
+	require.NoError(t, err)
+	require.NoError(t, tbl.RangeRules(func(_ RouteID, r Rule) bool {
+		assert.Equal(t, other, r)
+		return true
+	}))
+	assert.Equal(t, 4, tbl.Count())
+}
+
+func RoutingTableRangeSuite(t *testing.T, tbl Table) {
+	t.Helper()
+
+	const n = 5
+
+	rules := make(map[RouteID]Rule, n)
+	for i := 0; i < n; i++ {
+		rule := ForwardRule(15*time.Minute, RouteID(i), uuid.New(), 0)
+		id, err := tbl.AddRule(rule)
+		require.NoError(t, err)
+		rules[id] = rule
+	}
+
+	// All rules are visited.
+	visited := make(map[RouteID]Rule, n)
+	require.NoError(t, tbl.RangeRules(func(routeID RouteID, rule Rule) bool {
+		visited[routeID] = rule
+		return true
+	}))
+	assert.Equal(t, rules, visited)
+
+	// Iteration stops early.
+	var count int
+	require.NoError(t, tbl.RangeRules(func(RouteID, Rule) bool {
+		count++
+		return count < 2
+	}))
+	assert.Equal(t, 2, count)
+
+	// The table can be modified while iterating.
+	require.NoError(t, tbl.RangeRules(func(routeID RouteID, _ Rule) bool {
+		assert.NoError(t, tbl.DeleteRules(routeID))
+		return true
+	}))
+	assert.Equal(t, 0, tbl.Count())
+}
+
 func TestRoutingTable(t *testing.T) {
 	RoutingTableSuite(t, InMemoryRoutingTable())
+	RoutingTableRangeSuite(t, InMemoryRoutingTable())
+	RoutingTableTransactionSuite(t, InMemoryRoutingTable())
 }
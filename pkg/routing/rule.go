@@ -16,6 +16,9 @@ import (
 // TODO(evanlinjin): Document the format of rules in comments.
 const RuleHeaderSize = 13
 
+// appRuleSize is the size of app rules which do not record a previous hop.
+const appRuleSize = 54
+
 // RuleType defines type of a routing rule
 type RuleType byte
 
@@ -43,6 +46,7 @@ const (
 type Rule []byte
 
 // KeepAlive returns rule's keep-alive timeout.
+// A zero keep-alive timeout means that the rule never expires.
 func (r Rule) KeepAlive() time.Duration {
 	return time.Duration(binary.BigEndian.Uint64(r))
 }
@@ -72,6 +76,14 @@ func (r Rule) TransportID() uuid.UUID {
 	return uuid.Must(uuid.FromBytes(r[13:29]))
 }
 
+// SetTransportID sets next transport ID for a forward rule.
+func (r Rule) SetTransportID(id uuid.UUID) {
+	if r.Type() != RuleForward {
+		panic("invalid rule")
+	}
+	copy(r[13:29], id[:])
+}
+
 // RemotePK returns remove PK for an app rule.
 func (r Rule) RemotePK() cipher.PubKey {
 	if r.Type() != RuleApp {
@@ -101,6 +113,33 @@ func (r Rule) LocalPort() Port {
 	return Port(binary.BigEndian.Uint16(r[48:]))
 }
 
+// PreviousHop returns the public key of the node which packets of an app rule are read from,
+// that is the last hop of the route to the local node. It equals RemotePK for routes of a single hop.
+// ok is false if the rule does not record a previous hop, such as rules of older setup nodes.
+func (r Rule) PreviousHop() (pk cipher.PubKey, ok bool) {
+	if r.Type() != RuleApp {
+		panic("invalid rule")
+	}
+	if len(r) < appRuleSize+len(pk) {
+		return cipher.PubKey{}, false
+	}
+	if err := pk.UnmarshalBinary(r[appRuleSize : appRuleSize+len(pk)]); err != nil {
+		log.WithError(err).Warn("Failed to unmarshal public key")
+		return cipher.PubKey{}, false
+	}
+	return pk, true
+}
+
+// WithPreviousHop returns a copy of an app rule which records pk as its previous hop, see PreviousHop.
+func (r Rule) WithPreviousHop(pk cipher.PubKey) Rule {
+	if r.Type() != RuleApp {
+		panic("invalid rule")
+	}
+	rule := make(Rule, appRuleSize, appRuleSize+len(pk))
+	copy(rule, r)
+	return append(rule, pk[:]...)
+}
+
 // RequestRouteID returns route ID which will be used to register this rule within
 // the visor node.
 func (r Rule) RequestRouteID() RouteID {
@@ -132,10 +171,11 @@ func (r Rule) String() string {
 
 // RuleAppFields summarizes App fields of a RoutingRule.
 type RuleAppFields struct {
-	RespRID    RouteID       `json:"resp_rid"`
-	RemotePK   cipher.PubKey `json:"remote_pk"`
-	RemotePort Port          `json:"remote_port"`
-	LocalPort  Port          `json:"local_port"`
+	RespRID     RouteID        `json:"resp_rid"`
+	RemotePK    cipher.PubKey  `json:"remote_pk"`
+	RemotePort  Port           `json:"remote_port"`
+	LocalPort   Port           `json:"local_port"`
+	PreviousHop *cipher.PubKey `json:"previous_hop,omitempty"`
 }
 
 // RuleForwardFields summarizes Forward fields of a RoutingRule.
@@ -157,7 +197,11 @@ type RuleSummary struct {
 func (rs *RuleSummary) ToRule() (Rule, error) {
 	if rs.Type == RuleApp && rs.AppFields != nil && rs.ForwardFields == nil {
 		f := rs.AppFields
-		return AppRule(rs.KeepAlive, rs.RequestRouteID, f.RespRID, f.RemotePK, f.LocalPort, f.RemotePort), nil
+		rule := AppRule(rs.KeepAlive, rs.RequestRouteID, f.RespRID, f.RemotePK, f.LocalPort, f.RemotePort)
+		if f.PreviousHop != nil {
+			rule = rule.WithPreviousHop(*f.PreviousHop)
+		}
+		return rule, nil
 	}
 	if rs.Type == RuleForward && rs.AppFields == nil && rs.ForwardFields != nil {
 		f := rs.ForwardFields
@@ -180,6 +224,9 @@ func (r Rule) Summary() *RuleSummary {
 			RemotePort: r.RemotePort(),
 			LocalPort:  r.LocalPort(),
 		}
+		if prevHop, ok := r.PreviousHop(); ok {
+			summary.AppFields.PreviousHop = &prevHop
+		}
 	} else {
 		summary.ForwardFields = &RuleForwardFields{
 			NextRID: r.RouteID(),
@@ -190,13 +237,10 @@ func (r Rule) Summary() *RuleSummary {
 }
 
 // AppRule constructs a new consume RoutingRule.
+// If keepAlive is zero, the rule never expires. If it is negative, the rule is expired from the start.
 func AppRule(keepAlive time.Duration, reqRoute, respRoute RouteID, remotePK cipher.PubKey, localPort, remotePort Port) Rule {
 	rule := make([]byte, RuleHeaderSize)
 
-	if keepAlive < 0 {
-		keepAlive = 0
-	}
-
 	binary.BigEndian.PutUint64(rule, uint64(keepAlive))
 
 	rule[8] = byte(RuleApp)
@@ -210,13 +254,10 @@ func AppRule(keepAlive time.Duration, reqRoute, respRoute RouteID, remotePK ciph
 }
 
 // ForwardRule constructs a new forward RoutingRule.
+// If keepAlive is zero, the rule never expires. If it is negative, the rule is expired from the start.
 func ForwardRule(keepAlive time.Duration, nextRoute RouteID, nextTrID uuid.UUID, requestRouteID RouteID) Rule {
 	rule := make([]byte, RuleHeaderSize)
 
-	if keepAlive < 0 {
-		keepAlive = 0
-	}
-
 	binary.BigEndian.PutUint64(rule, uint64(keepAlive))
 
 	rule[8] = byte(RuleForward)
@@ -0,0 +1,172 @@
+package routing
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/SkycoinProject/dmsg/cipher"
+	"github.com/google/uuid"
+)
+
+// IncompleteRuleError is returned by rule builders when required fields were not set.
+type IncompleteRuleError struct {
+	Type    RuleType
+	Missing []string // names of the builder methods that were not called
+}
+
+func (e *IncompleteRuleError) Error() string {
+	return fmt.Sprintf("incomplete %s rule: missing %s", e.Type, strings.Join(e.Missing, ", "))
+}
+
+// ForwardRuleBuilder builds forward rules. NextRouteID, Transport and RequestRouteID are required,
+// KeepAlive defaults to zero (the rule never expires).
+type ForwardRuleBuilder struct {
+	keepAlive time.Duration
+	nextRID   RouteID
+	nextTpID  uuid.UUID
+	reqRID    RouteID
+
+	hasNextRID bool
+	hasTpID    bool
+	hasReqRID  bool
+}
+
+// NewForwardRuleBuilder returns an empty ForwardRuleBuilder.
+func NewForwardRuleBuilder() *ForwardRuleBuilder {
+	return &ForwardRuleBuilder{}
+}
+
+// KeepAlive sets the keep-alive timeout of the rule.
+func (b *ForwardRuleBuilder) KeepAlive(keepAlive time.Duration) *ForwardRuleBuilder {
+	b.keepAlive = keepAlive
+	return b
+}
+
+// NextRouteID sets the route ID packets are forwarded with.
+func (b *ForwardRuleBuilder) NextRouteID(id RouteID) *ForwardRuleBuilder {
+	b.nextRID, b.hasNextRID = id, true
+	return b
+}
+
+// Transport sets the ID of the transport packets are forwarded to.
+func (b *ForwardRuleBuilder) Transport(tpID uuid.UUID) *ForwardRuleBuilder {
+	b.nextTpID, b.hasTpID = tpID, true
+	return b
+}
+
+// RequestRouteID sets the route ID the rule is registered with.
+func (b *ForwardRuleBuilder) RequestRouteID(id RouteID) *ForwardRuleBuilder {
+	b.reqRID, b.hasReqRID = id, true
+	return b
+}
+
+// Build validates the set fields and returns the rule.
+func (b *ForwardRuleBuilder) Build() (Rule, error) {
+	var missing []string
+	if !b.hasNextRID {
+		missing = append(missing, "NextRouteID")
+	}
+	if !b.hasTpID || b.nextTpID == uuid.Nil {
+		missing = append(missing, "Transport")
+	}
+	if !b.hasReqRID {
+		missing = append(missing, "RequestRouteID")
+	}
+	if len(missing) > 0 {
+		return nil, &IncompleteRuleError{Type: RuleForward, Missing: missing}
+	}
+	return ForwardRule(b.keepAlive, b.nextRID, b.nextTpID, b.reqRID), nil
+}
+
+// AppRuleBuilder builds app rules. All fields except KeepAlive and PreviousHop are required,
+// KeepAlive defaults to zero (the rule never expires).
+type AppRuleBuilder struct {
+	keepAlive  time.Duration
+	reqRID     RouteID
+	respRID    RouteID
+	remotePK   cipher.PubKey
+	localPort  Port
+	remotePort Port
+	prevHop    cipher.PubKey
+
+	hasReqRID     bool
+	hasRespRID    bool
+	hasLocalPort  bool
+	hasRemotePort bool
+}
+
+// NewAppRuleBuilder returns an empty AppRuleBuilder.
+func NewAppRuleBuilder() *AppRuleBuilder {
+	return &AppRuleBuilder{}
+}
+
+// KeepAlive sets the keep-alive timeout of the rule.
+func (b *AppRuleBuilder) KeepAlive(keepAlive time.Duration) *AppRuleBuilder {
+	b.keepAlive = keepAlive
+	return b
+}
+
+// RequestRouteID sets the route ID the rule is registered with.
+func (b *AppRuleBuilder) RequestRouteID(id RouteID) *AppRuleBuilder {
+	b.reqRID, b.hasReqRID = id, true
+	return b
+}
+
+// ResponseRouteID sets the route ID used to respond to the remote end.
+func (b *AppRuleBuilder) ResponseRouteID(id RouteID) *AppRuleBuilder {
+	b.respRID, b.hasRespRID = id, true
+	return b
+}
+
+// RemotePK sets the public key of the remote end.
+func (b *AppRuleBuilder) RemotePK(pk cipher.PubKey) *AppRuleBuilder {
+	b.remotePK = pk
+	return b
+}
+
+// LocalPort sets the local port of the loop.
+func (b *AppRuleBuilder) LocalPort(port Port) *AppRuleBuilder {
+	b.localPort, b.hasLocalPort = port, true
+	return b
+}
+
+// RemotePort sets the remote port of the loop.
+func (b *AppRuleBuilder) RemotePort(port Port) *AppRuleBuilder {
+	b.remotePort, b.hasRemotePort = port, true
+	return b
+}
+
+// PreviousHop sets the public key of the node packets of the rule are read from, it is optional.
+func (b *AppRuleBuilder) PreviousHop(pk cipher.PubKey) *AppRuleBuilder {
+	b.prevHop = pk
+	return b
+}
+
+// Build validates the set fields and returns the rule.
+func (b *AppRuleBuilder) Build() (Rule, error) {
+	var missing []string
+	if !b.hasReqRID {
+		missing = append(missing, "RequestRouteID")
+	}
+	if !b.hasRespRID {
+		missing = append(missing, "ResponseRouteID")
+	}
+	if b.remotePK.Null() {
+		missing = append(missing, "RemotePK")
+	}
+	if !b.hasLocalPort {
+		missing = append(missing, "LocalPort")
+	}
+	if !b.hasRemotePort {
+		missing = append(missing, "RemotePort")
+	}
+	if len(missing) > 0 {
+		return nil, &IncompleteRuleError{Type: RuleApp, Missing: missing}
+	}
+	rule := AppRule(b.keepAlive, b.reqRID, b.respRID, b.remotePK, b.localPort, b.remotePort)
+	if !b.prevHop.Null() {
+		rule = rule.WithPreviousHop(b.prevHop)
+	}
+	return rule, nil
+}
@@ -0,0 +1,77 @@
+package routing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/SkycoinProject/dmsg/cipher"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestForwardRuleBuilder(t *testing.T) {
+	tpID := uuid.New()
+	keepAlive := 2 * time.Minute
+
+	rule, err := NewForwardRuleBuilder().
+		KeepAlive(keepAlive).
+		NextRouteID(2).
+		Transport(tpID).
+		RequestRouteID(1).
+		Build()
+	require.NoError(t, err)
+	assert.Equal(t, ForwardRule(keepAlive, 2, tpID, 1), rule)
+
+	// Zero route IDs are valid as long as they are set explicitly.
+	rule, err = NewForwardRuleBuilder().NextRouteID(0).Transport(tpID).RequestRouteID(0).Build()
+	require.NoError(t, err)
+	assert.Equal(t, ForwardRule(0, 0, tpID, 0), rule)
+
+	t.Run("incomplete", func(t *testing.T) {
+		_, err := NewForwardRuleBuilder().KeepAlive(keepAlive).NextRouteID(2).Build()
+		require.Error(t, err)
+		iErr, ok := err.(*IncompleteRuleError)
+		require.True(t, ok)
+		assert.Equal(t, RuleForward, iErr.Type)
+		assert.Equal(t, []string{"Transport", "RequestRouteID"}, iErr.Missing)
+		assert.Equal(t, "incomplete Forward rule: missing Transport, RequestRouteID", err.Error())
+
+		_, err = NewForwardRuleBuilder().NextRouteID(2).Transport(uuid.Nil).RequestRouteID(1).Build()
+		require.Error(t, err)
+		assert.Equal(t, []string{"Transport"}, err.(*IncompleteRuleError).Missing)
+	})
+}
+
+func TestAppRuleBuilder(t *testing.T) {
+	pk, _ := cipher.GenerateKeyPair()
+	keepAlive := 2 * time.Minute
+
+	rule, err := NewAppRuleBuilder().
+		KeepAlive(keepAlive).
+		RequestRouteID(1).
+		ResponseRouteID(2).
+		RemotePK(pk).
+		LocalPort(4).
+		RemotePort(3).
+		Build()
+	require.NoError(t, err)
+	assert.Equal(t, AppRule(keepAlive, 1, 2, pk, 4, 3), rule)
+
+	t.Run("previous_hop", func(t *testing.T) {
+		hopPK, _ := cipher.GenerateKeyPair()
+		rule, err := NewAppRuleBuilder().RequestRouteID(1).ResponseRouteID(2).RemotePK(pk).
+			LocalPort(4).RemotePort(3).PreviousHop(hopPK).Build()
+		require.NoError(t, err)
+		assert.Equal(t, AppRule(0, 1, 2, pk, 4, 3).WithPreviousHop(hopPK), rule)
+	})
+
+	t.Run("incomplete", func(t *testing.T) {
+		_, err := NewAppRuleBuilder().RequestRouteID(1).LocalPort(4).Build()
+		require.Error(t, err)
+		iErr, ok := err.(*IncompleteRuleError)
+		require.True(t, ok)
+		assert.Equal(t, RuleApp, iErr.Type)
+		assert.Equal(t, []string{"ResponseRouteID", "RemotePK", "RemotePort"}, iErr.Missing)
+	})
+}
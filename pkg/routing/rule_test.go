@@ -7,6 +7,7 @@ import (
 	"github.com/SkycoinProject/dmsg/cipher"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestAppRule(t *testing.T) {
@@ -25,6 +26,30 @@ func TestAppRule(t *testing.T) {
 	assert.Equal(t, RouteID(3), rule.RouteID())
 }
 
+func TestRule_PreviousHop(t *testing.T) {
+	pk, _ := cipher.GenerateKeyPair()
+	hopPK, _ := cipher.GenerateKeyPair()
+	rule := AppRule(time.Minute, 1, 2, pk, 4, 3)
+
+	_, ok := rule.PreviousHop()
+	assert.False(t, ok)
+
+	withHop := rule.WithPreviousHop(hopPK)
+	prevHop, ok := withHop.PreviousHop()
+	assert.True(t, ok)
+	assert.Equal(t, hopPK, prevHop)
+	assert.Equal(t, []byte(rule), []byte(withHop[:len(rule)]))
+	assert.Equal(t, pk, withHop.RemotePK())
+	assert.Equal(t, RouteID(1), withHop.RequestRouteID())
+
+	// The previous hop survives a round trip through the rule summary.
+	summary := withHop.Summary()
+	require.NotNil(t, summary.AppFields.PreviousHop)
+	fromSummary, err := summary.ToRule()
+	require.NoError(t, err)
+	assert.Equal(t, withHop, fromSummary)
+}
+
 func TestForwardRule(t *testing.T) {
 	trID := uuid.New()
 	keepAlive := 2 * time.Minute
@@ -37,4 +62,17 @@ func TestForwardRule(t *testing.T) {
 
 	rule.SetRouteID(3)
 	assert.Equal(t, RouteID(3), rule.RouteID())
+
+	newTrID := uuid.New()
+	rule.SetTransportID(newTrID)
+	assert.Equal(t, newTrID, rule.TransportID())
+	assert.Equal(t, RouteID(1), rule.RequestRouteID())
+}
+
+func TestRuleKeepAlive(t *testing.T) {
+	trID := uuid.New()
+
+	assert.Equal(t, time.Duration(0), ForwardRule(0, 2, trID, 1).KeepAlive())
+	assert.Equal(t, -time.Hour, ForwardRule(-time.Hour, 2, trID, 1).KeepAlive())
+	assert.Equal(t, time.Duration(0), AppRule(0, 1, 2, cipher.PubKey{}, 4, 3).KeepAlive())
 }
@@ -10,6 +10,7 @@ import (
 const (
 	RequestTimeout = time.Second * 60
 	ReadTimeout    = time.Second * 30
+	CleanupTimeout = time.Second * 10
 )
 
 // Config defines configuration parameters for setup Node.
@@ -78,6 +78,18 @@ func (idr *idReservoir) PopID(pk cipher.PubKey) (routing.RouteID, bool) {
 	return ids[0], true
 }
 
+// Reserved returns a copy of the route IDs that are reserved and not yet popped, per visor.
+func (idr *idReservoir) Reserved() map[cipher.PubKey][]routing.RouteID {
+	idr.mx.Lock()
+	defer idr.mx.Unlock()
+
+	reserved := make(map[cipher.PubKey][]routing.RouteID, len(idr.ids))
+	for pk, ids := range idr.ids {
+		reserved[pk] = append([]routing.RouteID(nil), ids...)
+	}
+	return reserved
+}
+
 func (idr *idReservoir) String() string {
 	idr.mx.Lock()
 	defer idr.mx.Unlock()
@@ -123,16 +135,20 @@ func GenerateRules(idc *idReservoir, ld routing.LoopDescriptor) (rules RulesMap,
 		return nil, 0, 0, err
 	}
 
+	// App rules record the last hop of their inbound route, which routers may check packets against.
 	rules[src.PubKey] = append(rules[src.PubKey],
-		routing.AppRule(ld.KeepAlive, lastRevRID, firstFwdRID, dst.PubKey, src.Port, dst.Port))
+		routing.AppRule(ld.KeepAlive, lastRevRID, firstFwdRID, dst.PubKey, src.Port, dst.Port).
+			WithPreviousHop(ld.Reverse[len(ld.Reverse)-1].From))
 	rules[dst.PubKey] = append(rules[dst.PubKey],
-		routing.AppRule(ld.KeepAlive, lastFwdRID, firstRevRID, src.PubKey, dst.Port, src.Port))
+		routing.AppRule(ld.KeepAlive, lastFwdRID, firstRevRID, src.PubKey, dst.Port, src.Port).
+			WithPreviousHop(ld.Forward[len(ld.Forward)-1].From))
 
 	return rules, firstFwdRID, firstRevRID, nil
 }
 
 // SaveForwardRules creates the rules of the given route, and saves them in the 'rules' input.
 // Note that the last rule for the route is always an APP rule, and so is not created here.
+// Each rule uses the keep-alive of its hop if set, and 'keepAlive' otherwise.
 // The outputs are as follows:
 // - firstRID: the first visor's route ID.
 // - lastRID: the last visor's route ID (note that there is no rule set for this ID yet).
@@ -151,7 +167,11 @@ func SaveForwardRules(rules RulesMap, idc *idReservoir, keepAlive time.Duration,
 		if !ok {
 			return 0, 0, errors.New("fucked up")
 		}
-		rule := routing.ForwardRule(keepAlive, nxtRID, hop.Transport, rID)
+		hopKeepAlive := keepAlive
+		if hop.KeepAlive != 0 {
+			hopKeepAlive = hop.KeepAlive
+		}
+		rule := routing.ForwardRule(hopKeepAlive, nxtRID, hop.Transport, rID)
 		rules[hop.From] = append(rules[hop.From], rule)
 
 		rID = nxtRID
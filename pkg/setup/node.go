@@ -5,6 +5,8 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"sync"
 	"time"
 
 	"github.com/SkycoinProject/dmsg"
@@ -24,6 +26,12 @@ type Node struct {
 	dmsgL    *dmsg.Listener
 	srvCount int
 	metrics  metrics.Recorder
+
+	// Progress, if set, receives the progress of loop setups handled by the node.
+	Progress ProgressFunc
+
+	dialProto   func(ctx context.Context, pk cipher.PubKey) (*Protocol, error) // overrides dmsg dials in tests
+	readTimeout time.Duration                                                  // overrides ReadTimeout of requests in tests
 }
 
 // NewNode constructs a new SetupNode.
@@ -92,8 +100,14 @@ func (sn *Node) handleRequest(ctx context.Context, tr *dmsg.Transport) error {
 	ctx, cancel := context.WithTimeout(ctx, RequestTimeout)
 	defer cancel()
 
-	proto := NewSetupProtocol(tr)
-	sp, data, err := proto.ReadPacket()
+	// Visors which enable snet features start their connections with a hello, it is answered without features.
+	conn, err := snet.NegotiateConn(tr, false, snet.ConnOptions{})
+	if err != nil {
+		return fmt.Errorf("handshake: %s", err)
+	}
+
+	proto := NewSetupProtocol(conn)
+	sp, data, err := sn.readRequest(ctx, proto, conn)
 	if err != nil {
 		return err
 	}
@@ -121,12 +135,14 @@ func (sn *Node) handleRequest(ctx context.Context, tr *dmsg.Transport) error {
 		if err = json.Unmarshal(data, &ld); err != nil {
 			break
 		}
-		err = sn.handleCloseLoop(ctx, ld.Loop.Remote.PubKey, routing.LoopData{
-			Loop: routing.Loop{
-				Remote: ld.Loop.Local,
-				Local:  ld.Loop.Remote,
-			},
-		})
+		err = sn.handleCloseLoop(ctx, ld.Loop.Remote.PubKey, routing.LoopData{Loop: ld.Loop.Invert(), CloseCode: ld.CloseCode})
+
+	case PacketPauseLoop:
+		var ld routing.LoopData
+		if err = json.Unmarshal(data, &ld); err != nil {
+			break
+		}
+		err = sn.handlePauseLoop(ctx, ld.Loop.Remote.PubKey, routing.LoopData{Loop: ld.Loop.Invert(), Paused: ld.Paused})
 
 	default:
 		err = errors.New("unknown foundation packet")
@@ -142,12 +158,70 @@ func (sn *Node) handleRequest(ctx context.Context, tr *dmsg.Transport) error {
 	return proto.WritePacket(RespSuccess, nil)
 }
 
-func (sn *Node) handleCreateLoop(ctx context.Context, ld routing.LoopDescriptor) error {
+// readRequest reads the request sent over conn. If the request is malformed or not sent within ReadTimeout,
+// conn is closed, so that a peer which connects and sends nothing does not hold it.
+func (sn *Node) readRequest(ctx context.Context, proto *Protocol, conn io.Closer) (PacketType, []byte, error) {
+	timeout := sn.readTimeout
+	if timeout == 0 {
+		timeout = ReadTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	type request struct {
+		sp   PacketType
+		data []byte
+		err  error
+	}
+	reqCh := make(chan request, 1)
+	go func() {
+		sp, data, err := proto.ReadPacket()
+		reqCh <- request{sp, data, err}
+	}()
+
+	var err error
+	select {
+	case <-ctx.Done():
+		err = fmt.Errorf("read request: %v", ctx.Err())
+	case req := <-reqCh:
+		if req.err == nil {
+			return req.sp, req.data, nil
+		}
+		err = fmt.Errorf("read request: %v", req.err)
+	}
+
+	if cErr := conn.Close(); cErr != nil {
+		sn.Logger.WithError(cErr).Warn("Failed to close transport")
+	}
+	return 0, nil, err
+}
+
+func (sn *Node) handleCreateLoop(ctx context.Context, ld routing.LoopDescriptor) (err error) {
+	if err := ld.Validate(); err != nil {
+		return fmt.Errorf("invalid loop descriptor: %s", err)
+	}
+
+	if ld.SetupTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, ld.SetupTimeout)
+		defer cancel()
+	}
+
 	src := ld.Loop.Local
 	dst := ld.Loop.Remote
 
 	// Reserve route IDs from visors.
+	sn.reportProgress(StageReservingRouteIDs, ld.Loop, cipher.PubKey{})
 	idr, err := sn.reserveRouteIDs(ctx, ld.Forward, ld.Reverse)
+
+	// Rules are installed under the reserved route IDs, so on failure these are deleted,
+	// including IDs of a partially failed reservation.
+	reserved := idr.Reserved()
+	defer func() {
+		if err != nil {
+			sn.deleteRules(reserved)
+		}
+	}()
 	if err != nil {
 		return err
 	}
@@ -167,6 +241,7 @@ func (sn *Node) handleCreateLoop(ctx context.Context, ld routing.LoopDescriptor)
 		go func() {
 			log := sn.Logger.WithField("remote", pk)
 
+			sn.reportProgress(StageInstallingRules, ld.Loop, pk)
 			proto, err := sn.dialAndCreateProto(ctx, pk)
 			if err != nil {
 				log.WithError(err).Warn("failed to create proto")
@@ -182,6 +257,7 @@ func (sn *Node) handleCreateLoop(ctx context.Context, ld routing.LoopDescriptor)
 				return
 			}
 			log.Debug("rules added")
+			sn.reportProgress(StageRulesInstalled, ld.Loop, pk)
 			errCh <- nil
 		}()
 	}
@@ -191,13 +267,14 @@ func (sn *Node) handleCreateLoop(ctx context.Context, ld routing.LoopDescriptor)
 
 	// Confirm loop with responding visor.
 	err = func() error {
+		sn.reportProgress(StageConfirmingLoop, ld.Loop, dst.PubKey)
 		proto, err := sn.dialAndCreateProto(ctx, dst.PubKey)
 		if err != nil {
 			return err
 		}
 		defer sn.closeProto(proto)
 
-		data := routing.LoopData{Loop: routing.Loop{Local: dst, Remote: src}, RouteID: dstFwdRID}
+		data := routing.LoopData{Loop: routing.Loop{Local: dst, Remote: src}, RouteID: dstFwdRID, Metadata: ld.Metadata}
 		return ConfirmLoop(ctx, proto, data)
 	}()
 	if err != nil {
@@ -206,6 +283,7 @@ func (sn *Node) handleCreateLoop(ctx context.Context, ld routing.LoopDescriptor)
 
 	// Confirm loop with initiating visor.
 	err = func() error {
+		sn.reportProgress(StageConfirmingLoop, ld.Loop, src.PubKey)
 		proto, err := sn.dialAndCreateProto(ctx, src.PubKey)
 		if err != nil {
 			return err
@@ -219,9 +297,18 @@ func (sn *Node) handleCreateLoop(ctx context.Context, ld routing.LoopDescriptor)
 		return fmt.Errorf("failed to confirm loop with destination visor: %v", err)
 	}
 
+	sn.reportProgress(StageLoopCreated, ld.Loop, cipher.PubKey{})
 	return nil
 }
 
+func (sn *Node) reportProgress(stage ProgressStage, l routing.Loop, pk cipher.PubKey) {
+	if sn.Progress != nil {
+		sn.Progress(Progress{Stage: stage, Loop: l, PubKey: pk})
+	}
+}
+
+// reserveRouteIDs reserves route IDs from the visors of the routes.
+// On failure, the returned reservoir still holds the route IDs that were reserved.
 func (sn *Node) reserveRouteIDs(ctx context.Context, fwd, rev routing.Route) (*idReservoir, error) {
 	idc, total := newIDReservoir(fwd, rev)
 	sn.Logger.Infof("There are %d route IDs to reserve.", total)
@@ -236,12 +323,44 @@ func (sn *Node) reserveRouteIDs(ctx context.Context, fwd, rev routing.Route) (*i
 	})
 	if err != nil {
 		sn.Logger.WithError(err).Warnf("Failed to reserve route IDs.")
-		return nil, err
+		return idc, err
 	}
 	sn.Logger.Infof("Successfully reserved route IDs: %s", idc.String())
 	return idc, err
 }
 
+// deleteRules deletes the given route IDs from the visors in parallel, errors are only logged.
+func (sn *Node) deleteRules(routeIDs map[cipher.PubKey][]routing.RouteID) {
+	ctx, cancel := context.WithTimeout(context.Background(), CleanupTimeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for pk, ids := range routeIDs {
+		if len(ids) == 0 {
+			continue
+		}
+		wg.Add(1)
+		go func(pk cipher.PubKey, ids []routing.RouteID) {
+			defer wg.Done()
+			log := sn.Logger.WithField("remote", pk)
+
+			proto, err := sn.dialAndCreateProto(ctx, pk)
+			if err != nil {
+				log.WithError(err).Warn("failed to create proto to delete rules")
+				return
+			}
+			defer sn.closeProto(proto)
+
+			if err := DeleteRules(ctx, proto, ids); err != nil {
+				log.WithError(err).Warnf("failed to delete rules %v", ids)
+				return
+			}
+			log.Debugf("deleted rules %v", ids)
+		}(pk, ids)
+	}
+	wg.Wait()
+}
+
 func (sn *Node) handleCloseLoop(ctx context.Context, on cipher.PubKey, ld routing.LoopData) error {
 	proto, err := sn.dialAndCreateProto(ctx, on)
 	if err != nil {
@@ -257,7 +376,26 @@ func (sn *Node) handleCloseLoop(ctx context.Context, on cipher.PubKey, ld routin
 	return nil
 }
 
+func (sn *Node) handlePauseLoop(ctx context.Context, on cipher.PubKey, ld routing.LoopData) error {
+	proto, err := sn.dialAndCreateProto(ctx, on)
+	if err != nil {
+		return err
+	}
+	defer sn.closeProto(proto)
+
+	if err := LoopPaused(ctx, proto, ld); err != nil {
+		return err
+	}
+
+	sn.Logger.Infof("Set loop paused=%t on %s. LocalPort: %d", ld.Paused, on, ld.Loop.Local.Port)
+	return nil
+}
+
 func (sn *Node) dialAndCreateProto(ctx context.Context, pk cipher.PubKey) (*Protocol, error) {
+	if sn.dialProto != nil {
+		return sn.dialProto(ctx, pk)
+	}
+
 	tr, err := sn.dmsgC.Dial(ctx, pk, snet.AwaitSetupPort)
 	if err != nil {
 		return nil, fmt.Errorf("transport: %s", err)
@@ -8,13 +8,17 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"net"
 	"os"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/SkycoinProject/dmsg"
 	"github.com/SkycoinProject/dmsg/cipher"
 	"github.com/SkycoinProject/dmsg/disc"
+	"github.com/google/uuid"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/net/nettest"
 
@@ -311,7 +315,8 @@ func TestNode(t *testing.T) {
 					Port:   2,
 				},
 			},
-			RouteID: 3,
+			RouteID:   3,
+			CloseCode: 5,
 		}
 
 		// client_1 initiates close loop with setup node.
@@ -346,6 +351,67 @@ func TestNode(t *testing.T) {
 		require.NoError(t, json.Unmarshal(pp, &d))
 		require.Equal(t, ld.Loop.Remote, d.Loop.Local)
 		require.Equal(t, ld.Loop.Local, d.Loop.Remote)
+		require.Equal(t, ld.CloseCode, d.CloseCode)
+
+		// TODO: This error is not checked due to a bug in dmsg.
+		err = proto.WritePacket(RespSuccess, nil)
+		_ = err
+	})
+
+	// TEST: Emulates the communication between 2 visor nodes and a setup node,
+	// where the first client pauses an established loop.
+	t.Run("PauseLoop", func(t *testing.T) {
+		// client index 0 is for setup node.
+		// clients index 1 and 2 are for visor nodes.
+		clients, closeClients := prepClients(3)
+		defer closeClients()
+
+		// prepare and serve setup node.
+		_, closeSetup := prepSetupNode(clients[0].Client, clients[0].Listener)
+		setupPK := clients[0].Addr.PK
+		setupPort := clients[0].Addr.Port
+		defer closeSetup()
+
+		ld := routing.LoopData{
+			Loop: routing.Loop{
+				Local:  routing.Addr{PubKey: clients[1].Addr.PK, Port: 1},
+				Remote: routing.Addr{PubKey: clients[2].Addr.PK, Port: 2},
+			},
+			Paused: true,
+		}
+
+		// client_1 pauses the loop through the setup node.
+		iTp, err := clients[1].Dial(context.TODO(), setupPK, setupPort)
+		require.NoError(t, err)
+		iTpErrs := make(chan error, 2)
+		go func() {
+			iTpErrs <- PauseLoop(context.TODO(), NewSetupProtocol(iTp), ld)
+			iTpErrs <- iTp.Close()
+			close(iTpErrs)
+		}()
+		defer func() {
+			i := 0
+			for err := range iTpErrs {
+				require.NoError(t, err, i)
+				i++
+			}
+		}()
+
+		// client_2 is told that the loop is paused.
+		tp, err := clients[2].Listener.AcceptTransport()
+		require.NoError(t, err)
+		defer func() { require.NoError(t, tp.Close()) }()
+
+		proto := NewSetupProtocol(tp)
+
+		pt, pp, err := proto.ReadPacket()
+		require.NoError(t, err)
+		require.Equal(t, PacketLoopPaused, pt)
+
+		var d routing.LoopData
+		require.NoError(t, json.Unmarshal(pp, &d))
+		require.Equal(t, ld.Loop.Invert(), d.Loop)
+		require.True(t, d.Paused)
 
 		// TODO: This error is not checked due to a bug in dmsg.
 		err = proto.WritePacket(RespSuccess, nil)
@@ -376,3 +442,365 @@ func errWithTimeout(ch <-chan error) error {
 		return errors.New("timeout")
 	}
 }
+
+func TestNode_handleCreateLoop_SetupTimeout(t *testing.T) {
+	const setupTimeout = 100 * time.Millisecond
+
+	pks := make([]cipher.PubKey, 3)
+	visors := make(map[cipher.PubKey]*fakeVisor, len(pks))
+	for i := range pks {
+		pks[i], _ = cipher.GenerateKeyPair()
+		visors[pks[i]] = &fakeVisor{}
+	}
+
+	// The intermediary is too slow to install rules within the setup timeout.
+	visors[pks[1]].addRulesDelay = 10 * setupTimeout
+
+	sn := &Node{
+		Logger:  logging.MustGetLogger("setup_node"),
+		metrics: metrics.NewDummy(),
+		dialProto: func(ctx context.Context, pk cipher.PubKey) (*Protocol, error) {
+			v, ok := visors[pk]
+			if !ok {
+				return nil, errors.New("unknown visor")
+			}
+			sConn, vConn := net.Pipe()
+			go v.serve(NewSetupProtocol(vConn))
+			return NewSetupProtocol(sConn), nil
+		},
+	}
+
+	hop := func(from, to cipher.PubKey) *routing.Hop {
+		return &routing.Hop{From: from, To: to, Transport: uuid.New()}
+	}
+	ld := routing.LoopDescriptor{
+		Loop: routing.Loop{
+			Local:  routing.Addr{PubKey: pks[0], Port: 1},
+			Remote: routing.Addr{PubKey: pks[2], Port: 2},
+		},
+		Forward:      routing.Route{hop(pks[0], pks[1]), hop(pks[1], pks[2])},
+		Reverse:      routing.Route{hop(pks[2], pks[1]), hop(pks[1], pks[0])},
+		KeepAlive:    time.Minute,
+		SetupTimeout: setupTimeout,
+	}
+
+	start := time.Now()
+	err := sn.handleCreateLoop(context.TODO(), ld)
+	require.Equal(t, context.DeadlineExceeded, err)
+	require.True(t, time.Since(start) < 5*setupTimeout)
+
+	// All reserved route IDs are deleted, including the ones of already installed rules.
+	for i, pk := range pks {
+		v := visors[pk]
+		v.mx.Lock()
+		require.NotEmpty(t, v.reserved, i)
+		require.ElementsMatch(t, v.reserved, v.deleted, i)
+		if i != 1 {
+			require.NotEmpty(t, v.added, i)
+		}
+		v.mx.Unlock()
+	}
+	require.Len(t, visors[pks[0]].confirmed, 0)
+}
+
+func TestNode_handleCreateLoop_Progress(t *testing.T) {
+	pks := make([]cipher.PubKey, 3)
+	visors := make(map[cipher.PubKey]*fakeVisor, len(pks))
+	for i := range pks {
+		pks[i], _ = cipher.GenerateKeyPair()
+		visors[pks[i]] = &fakeVisor{}
+	}
+
+	var (
+		mx     sync.Mutex
+		events []Progress
+	)
+	sn := &Node{
+		Logger:  logging.MustGetLogger("setup_node"),
+		metrics: metrics.NewDummy(),
+		Progress: func(p Progress) {
+			mx.Lock()
+			events = append(events, p)
+			mx.Unlock()
+		},
+		dialProto: func(ctx context.Context, pk cipher.PubKey) (*Protocol, error) {
+			v, ok := visors[pk]
+			if !ok {
+				return nil, errors.New("unknown visor")
+			}
+			sConn, vConn := net.Pipe()
+			go v.serve(NewSetupProtocol(vConn))
+			return NewSetupProtocol(sConn), nil
+		},
+	}
+
+	hop := func(from, to cipher.PubKey) *routing.Hop {
+		return &routing.Hop{From: from, To: to, Transport: uuid.New()}
+	}
+	ld := routing.LoopDescriptor{
+		Loop: routing.Loop{
+			Local:  routing.Addr{PubKey: pks[0], Port: 1},
+			Remote: routing.Addr{PubKey: pks[2], Port: 2},
+		},
+		Forward:   routing.Route{hop(pks[0], pks[1]), hop(pks[1], pks[2])},
+		Reverse:   routing.Route{hop(pks[2], pks[1]), hop(pks[1], pks[0])},
+		KeepAlive: time.Minute,
+	}
+	require.NoError(t, sn.handleCreateLoop(context.TODO(), ld))
+
+	mx.Lock()
+	defer mx.Unlock()
+	require.Len(t, events, 1+2*len(pks)+3)
+	require.Equal(t, Progress{Stage: StageReservingRouteIDs, Loop: ld.Loop}, events[0])
+
+	// Rules are installed concurrently, so only the order per visor is fixed.
+	installing := make(map[cipher.PubKey]bool)
+	for _, e := range events[1 : 1+2*len(pks)] {
+		switch e.Stage {
+		case StageInstallingRules:
+			installing[e.PubKey] = true
+		case StageRulesInstalled:
+			require.True(t, installing[e.PubKey], e.PubKey)
+		default:
+			t.Fatalf("unexpected stage %s", e.Stage)
+		}
+	}
+	require.Len(t, installing, len(pks))
+
+	require.Equal(t, []Progress{
+		{Stage: StageConfirmingLoop, Loop: ld.Loop, PubKey: pks[2]},
+		{Stage: StageConfirmingLoop, Loop: ld.Loop, PubKey: pks[0]},
+		{Stage: StageLoopCreated, Loop: ld.Loop},
+	}, events[1+2*len(pks):])
+}
+
+// fakeVisor serves setup requests of a setup node.
+type fakeVisor struct {
+	addRulesDelay time.Duration
+
+	mx        sync.Mutex
+	nextID    routing.RouteID
+	reserved  []routing.RouteID
+	added     []routing.Rule
+	deleted   []routing.RouteID
+	confirmed []routing.LoopData
+}
+
+func (v *fakeVisor) serve(proto *Protocol) {
+	defer func() { _ = proto.Close() }() //nolint:errcheck
+
+	t, body, err := proto.ReadPacket()
+	if err != nil {
+		return
+	}
+
+	var resp interface{}
+	switch t {
+	case PacketRequestRouteID:
+		var n uint8
+		if err := json.Unmarshal(body, &n); err != nil {
+			return
+		}
+		ids := make([]routing.RouteID, n)
+		v.mx.Lock()
+		for i := range ids {
+			v.nextID++
+			ids[i] = v.nextID
+		}
+		v.reserved = append(v.reserved, ids...)
+		v.mx.Unlock()
+		resp = ids
+
+	case PacketAddRules:
+		time.Sleep(v.addRulesDelay)
+		var rules []routing.Rule
+		if err := json.Unmarshal(body, &rules); err != nil {
+			return
+		}
+		v.mx.Lock()
+		v.added = append(v.added, rules...)
+		v.mx.Unlock()
+
+	case PacketDeleteRules:
+		var ids []routing.RouteID
+		if err := json.Unmarshal(body, &ids); err != nil {
+			return
+		}
+		v.mx.Lock()
+		v.deleted = append(v.deleted, ids...)
+		v.mx.Unlock()
+		resp = ids
+
+	case PacketConfirmLoop:
+		var ld routing.LoopData
+		if err := json.Unmarshal(body, &ld); err != nil {
+			return
+		}
+		v.mx.Lock()
+		v.confirmed = append(v.confirmed, ld)
+		v.mx.Unlock()
+
+	default:
+		_ = proto.WritePacket(RespFailure, "unexpected packet") //nolint:errcheck
+		return
+	}
+	_ = proto.WritePacket(RespSuccess, resp) //nolint:errcheck
+}
+
+func TestGenerateRules_HopKeepAlive(t *testing.T) {
+	const (
+		edgeKeepAlive = 30 * time.Second
+		keepAlive1    = 10 * time.Minute
+		keepAlive2    = 5 * time.Minute
+	)
+
+	pks := make([]cipher.PubKey, 4)
+	for i := range pks {
+		pks[i], _ = cipher.GenerateKeyPair()
+	}
+
+	hop := func(from, to cipher.PubKey, keepAlive time.Duration) *routing.Hop {
+		return &routing.Hop{From: from, To: to, Transport: uuid.New(), KeepAlive: keepAlive}
+	}
+
+	// Transit visors use longer keep-alives than the edges, which use the keep-alive of the loop.
+	ld := routing.LoopDescriptor{
+		Loop: routing.Loop{
+			Local:  routing.Addr{PubKey: pks[0], Port: 1},
+			Remote: routing.Addr{PubKey: pks[3], Port: 2},
+		},
+		Forward:   routing.Route{hop(pks[0], pks[1], 0), hop(pks[1], pks[2], keepAlive1), hop(pks[2], pks[3], keepAlive2)},
+		Reverse:   routing.Route{hop(pks[3], pks[2], 0), hop(pks[2], pks[1], keepAlive2), hop(pks[1], pks[0], keepAlive1)},
+		KeepAlive: edgeKeepAlive,
+	}
+
+	rules, _, _, err := GenerateRules(reservedIDs(t, ld), ld)
+	require.NoError(t, err)
+
+	wantKeepAlives := map[cipher.PubKey]time.Duration{
+		pks[0]: edgeKeepAlive,
+		pks[1]: keepAlive1,
+		pks[2]: keepAlive2,
+		pks[3]: edgeKeepAlive,
+	}
+	for pk, want := range wantKeepAlives {
+		require.Len(t, rules[pk], 2)
+		for _, rule := range rules[pk] {
+			require.Equal(t, want, rule.KeepAlive(), rule.String())
+		}
+	}
+}
+
+// Ensure that app rules record the last hop of their inbound route.
+func TestGenerateRules_PreviousHop(t *testing.T) {
+	pks := make([]cipher.PubKey, 3)
+	for i := range pks {
+		pks[i], _ = cipher.GenerateKeyPair()
+	}
+
+	hop := func(from, to cipher.PubKey) *routing.Hop {
+		return &routing.Hop{From: from, To: to, Transport: uuid.New()}
+	}
+
+	// Both routes pass through pks[1].
+	ld := routing.LoopDescriptor{
+		Loop: routing.Loop{
+			Local:  routing.Addr{PubKey: pks[0], Port: 1},
+			Remote: routing.Addr{PubKey: pks[2], Port: 2},
+		},
+		Forward:   routing.Route{hop(pks[0], pks[1]), hop(pks[1], pks[2])},
+		Reverse:   routing.Route{hop(pks[2], pks[1]), hop(pks[1], pks[0])},
+		KeepAlive: time.Minute,
+	}
+
+	rules, _, _, err := GenerateRules(reservedIDs(t, ld), ld)
+	require.NoError(t, err)
+
+	for _, pk := range []cipher.PubKey{pks[0], pks[2]} {
+		var appRules int
+		for _, rule := range rules[pk] {
+			if rule.Type() != routing.RuleApp {
+				continue
+			}
+			appRules++
+			prevHop, ok := rule.PreviousHop()
+			require.True(t, ok, rule.String())
+			require.Equal(t, pks[1], prevHop)
+		}
+		require.Equal(t, 1, appRules)
+	}
+}
+
+// reservedIDs returns an idReservoir of which the route IDs of the routes of ld are reserved.
+func reservedIDs(t *testing.T, ld routing.LoopDescriptor) *idReservoir {
+	idr, _ := newIDReservoir(ld.Forward, ld.Reverse)
+	var nextID uint32
+	require.NoError(t, idr.ReserveIDs(context.TODO(), func(_ context.Context, _ cipher.PubKey, n uint8) ([]routing.RouteID, error) {
+		ids := make([]routing.RouteID, n)
+		for i := range ids {
+			ids[i] = routing.RouteID(atomic.AddUint32(&nextID, 1))
+		}
+		return ids, nil
+	}))
+	return idr
+}
+
+func TestNode_readRequest(t *testing.T) {
+	const readTimeout = 100 * time.Millisecond
+
+	sn := &Node{
+		Logger:      logging.MustGetLogger("setup_node"),
+		metrics:     metrics.NewDummy(),
+		readTimeout: readTimeout,
+	}
+
+	type result struct {
+		sp  PacketType
+		err error
+	}
+	serve := func(conn net.Conn) <-chan result {
+		resCh := make(chan result, 1)
+		go func() {
+			sp, _, err := sn.readRequest(context.TODO(), NewSetupProtocol(conn), conn)
+			resCh <- result{sp, err}
+		}()
+		return resCh
+	}
+
+	// A peer which connects and sends nothing does not hold up the requests of others.
+	silentConn, silentPeer := net.Pipe()
+	silentCh := serve(silentConn)
+
+	malformedConn, malformedPeer := net.Pipe()
+	malformedCh := serve(malformedConn)
+	go func() {
+		_, _ = malformedPeer.Write([]byte{byte(PacketCreateLoop), 0, 0}) // nolint:errcheck
+	}()
+
+	conn, peer := net.Pipe()
+	validCh := serve(conn)
+	go func() {
+		_ = NewSetupProtocol(peer).WritePacket(PacketCloseLoop, routing.LoopData{}) // nolint:errcheck
+	}()
+
+	select {
+	case res := <-validCh:
+		require.NoError(t, res.err)
+		require.Equal(t, PacketCloseLoop, res.sp)
+	case <-time.After(readTimeout / 2):
+		t.Fatal("request was not read")
+	}
+
+	// The transports of the malformed and the silent request are closed.
+	res := <-malformedCh
+	require.Error(t, res.err)
+	_, err := malformedPeer.Write([]byte{0})
+	require.Error(t, err)
+
+	start := time.Now()
+	res = <-silentCh
+	require.Error(t, res.err)
+	require.True(t, time.Since(start) < 5*readTimeout)
+	_, err = silentPeer.Write([]byte{0})
+	require.Error(t, err)
+}
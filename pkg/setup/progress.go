@@ -0,0 +1,72 @@
+package setup
+
+import (
+	"fmt"
+
+	"github.com/SkycoinProject/dmsg/cipher"
+
+	"github.com/SkycoinProject/skywire-mainnet/pkg/routing"
+)
+
+// ProgressStage identifies a step of a loop setup.
+type ProgressStage int
+
+const (
+	// StageFindingRoutes is reported by the initiating visor when it requests routes from the route finder.
+	StageFindingRoutes ProgressStage = iota
+	// StageContactingSetupNode is reported by the initiating visor when it dials a setup node.
+	StageContactingSetupNode
+	// StageRequestingLoop is reported by the initiating visor when it sends the loop descriptor to the setup node.
+	StageRequestingLoop
+	// StageReservingRouteIDs is reported by the setup node when it reserves route IDs from the visors of the routes.
+	StageReservingRouteIDs
+	// StageInstallingRules is reported by the setup node for every visor it sends rules to.
+	StageInstallingRules
+	// StageRulesInstalled is reported by the setup node for every visor that installed its rules.
+	StageRulesInstalled
+	// StageConfirmingLoop is reported by the setup node for each of the two loop ends it confirms the loop with.
+	StageConfirmingLoop
+	// StageLoopCreated is reported by both the setup node and the initiating visor once the loop is set up.
+	StageLoopCreated
+)
+
+func (s ProgressStage) String() string {
+	switch s {
+	case StageFindingRoutes:
+		return "FindingRoutes"
+	case StageContactingSetupNode:
+		return "ContactingSetupNode"
+	case StageRequestingLoop:
+		return "RequestingLoop"
+	case StageReservingRouteIDs:
+		return "ReservingRouteIDs"
+	case StageInstallingRules:
+		return "InstallingRules"
+	case StageRulesInstalled:
+		return "RulesInstalled"
+	case StageConfirmingLoop:
+		return "ConfirmingLoop"
+	case StageLoopCreated:
+		return "LoopCreated"
+	}
+
+	return fmt.Sprintf("Unknown(%d)", s)
+}
+
+// Progress is an event reported during a loop setup.
+type Progress struct {
+	Stage  ProgressStage
+	Loop   routing.Loop
+	PubKey cipher.PubKey // the contacted setup node or visor, if any
+}
+
+func (p Progress) String() string {
+	if p.PubKey.Null() {
+		return fmt.Sprintf("%s(%s)", p.Stage, p.Loop)
+	}
+	return fmt.Sprintf("%s(%s, %s)", p.Stage, p.Loop, p.PubKey)
+}
+
+// ProgressFunc receives the progress of loop setups.
+// It may be called concurrently and should not block.
+type ProgressFunc func(Progress)
@@ -35,6 +35,10 @@ func (sp PacketType) String() string {
 		return "Failure"
 	case PacketRequestRouteID:
 		return "RequestRouteIDs"
+	case PacketPauseLoop:
+		return "PauseLoop"
+	case PacketLoopPaused:
+		return "OnLoopPaused"
 	}
 	return fmt.Sprintf("Unknown(%d)", sp)
 }
@@ -54,6 +58,10 @@ const (
 	PacketLoopClosed
 	// PacketRequestRouteID represents RequestRouteIDs foundation packet.
 	PacketRequestRouteID
+	// PacketPauseLoop represents PauseLoop foundation packet.
+	PacketPauseLoop
+	// PacketLoopPaused represents OnLoopPaused foundation packet.
+	PacketLoopPaused
 
 	// RespFailure represents failure response for a foundation packet.
 	RespFailure = 0xfe
@@ -138,7 +146,12 @@ func AddRules(ctx context.Context, p *Protocol, rules []routing.Rule) error {
 
 // DeleteRule sends DeleteRule setup request.
 func DeleteRule(ctx context.Context, p *Protocol, routeID routing.RouteID) error {
-	if err := p.WritePacket(PacketDeleteRules, []routing.RouteID{routeID}); err != nil {
+	return DeleteRules(ctx, p, []routing.RouteID{routeID})
+}
+
+// DeleteRules sends DeleteRules setup request.
+func DeleteRules(ctx context.Context, p *Protocol, routeIDs []routing.RouteID) error {
+	if err := p.WritePacket(PacketDeleteRules, routeIDs); err != nil {
 		return err
 	}
 	var res []routing.RouteID
@@ -183,6 +196,22 @@ func LoopClosed(ctx context.Context, p *Protocol, ld routing.LoopData) error {
 	return readAndDecodePacketWithTimeout(ctx, p, nil)
 }
 
+// PauseLoop sends PauseLoop setup request, ld.Paused tells whether the loop is paused or resumed.
+func PauseLoop(ctx context.Context, p *Protocol, ld routing.LoopData) error {
+	if err := p.WritePacket(PacketPauseLoop, ld); err != nil {
+		return err
+	}
+	return readAndDecodePacketWithTimeout(ctx, p, nil)
+}
+
+// LoopPaused sends LoopPaused setup request.
+func LoopPaused(ctx context.Context, p *Protocol, ld routing.LoopData) error {
+	if err := p.WritePacket(PacketLoopPaused, ld); err != nil {
+		return err
+	}
+	return readAndDecodePacketWithTimeout(ctx, p, nil)
+}
+
 func readAndDecodePacketWithTimeout(ctx context.Context, p *Protocol, v interface{}) error {
 	ctx, cancel := context.WithTimeout(ctx, ReadTimeout)
 	defer cancel()
@@ -1,14 +1,18 @@
 package setup
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net"
 	"testing"
 
+	"github.com/SkycoinProject/dmsg/cipher"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"github.com/SkycoinProject/skywire-mainnet/pkg/routing"
 )
 
 func ExampleNewSetupProtocol() {
@@ -56,3 +60,60 @@ func TestNewProtocol(t *testing.T) {
 		assert.NoError(t, <-errChan)
 	}
 }
+
+func TestLoopMetadata(t *testing.T) {
+	connA, connB := net.Pipe()
+	protoA := NewSetupProtocol(connA)
+	protoB := NewSetupProtocol(connB)
+	defer func() {
+		assert.NoError(t, protoA.Close())
+		assert.NoError(t, protoB.Close())
+	}()
+
+	pk1, _ := cipher.GenerateKeyPair()
+	pk2, _ := cipher.GenerateKeyPair()
+	loop := routing.Loop{
+		Local:  routing.Addr{PubKey: pk1, Port: 1},
+		Remote: routing.Addr{PubKey: pk2, Port: 2},
+	}
+	metadata := []byte("service=chat")
+
+	t.Run("create_loop", func(t *testing.T) {
+		errCh := make(chan error, 1)
+		go func() {
+			errCh <- CreateLoop(context.TODO(), protoA, routing.LoopDescriptor{Loop: loop, Metadata: metadata})
+		}()
+
+		pt, data, err := protoB.ReadPacket()
+		require.NoError(t, err)
+		assert.Equal(t, PacketCreateLoop, pt)
+
+		var ld routing.LoopDescriptor
+		require.NoError(t, json.Unmarshal(data, &ld))
+		assert.Equal(t, loop, ld.Loop)
+		assert.Equal(t, metadata, ld.Metadata)
+
+		require.NoError(t, protoB.WritePacket(RespSuccess, nil))
+		assert.NoError(t, <-errCh)
+	})
+
+	t.Run("confirm_loop", func(t *testing.T) {
+		errCh := make(chan error, 1)
+		go func() {
+			errCh <- ConfirmLoop(context.TODO(), protoA, routing.LoopData{Loop: loop.Invert(), RouteID: 3, Metadata: metadata})
+		}()
+
+		pt, data, err := protoB.ReadPacket()
+		require.NoError(t, err)
+		assert.Equal(t, PacketConfirmLoop, pt)
+
+		var ld routing.LoopData
+		require.NoError(t, json.Unmarshal(data, &ld))
+		assert.Equal(t, loop.Invert(), ld.Loop)
+		assert.Equal(t, routing.RouteID(3), ld.RouteID)
+		assert.Equal(t, metadata, ld.Metadata)
+
+		require.NoError(t, protoB.WritePacket(RespSuccess, nil))
+		assert.NoError(t, <-errCh)
+	})
+}
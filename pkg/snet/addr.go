@@ -0,0 +1,52 @@
+package snet
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/SkycoinProject/dmsg"
+	"github.com/SkycoinProject/dmsg/cipher"
+)
+
+// unspecifiedPort is the string representation of an unspecified (zero) port.
+const unspecifiedPort = "~"
+
+// ErrInvalidAddr occurs when an address string is not of the form "<pk>:<port>".
+var ErrInvalidAddr = errors.New("invalid address")
+
+// ParseAddr parses an address of the form "<pk>:<port>".
+// A port of "~" represents an unspecified port and results in port 0.
+func ParseAddr(s string) (pk cipher.PubKey, port uint16, err error) {
+	strs := strings.Split(s, ":")
+	if len(strs) != 2 {
+		return cipher.PubKey{}, 0, fmt.Errorf("%v '%s'", ErrInvalidAddr, s)
+	}
+	if err := pk.Set(strs[0]); err != nil {
+		return cipher.PubKey{}, 0, fmt.Errorf("%v '%s': %v", ErrInvalidAddr, s, err)
+	}
+	if strs[1] != unspecifiedPort {
+		p, err := strconv.ParseUint(strs[1], 10, 16)
+		if err != nil {
+			return cipher.PubKey{}, 0, fmt.Errorf("%v '%s': %v", ErrInvalidAddr, s, err)
+		}
+		port = uint16(p)
+	}
+	return pk, port, nil
+}
+
+// FormatAddr formats the given public key and port as "<pk>:<port>".
+// Port 0 is formatted as "~", so the result can be parsed back with ParseAddr.
+func FormatAddr(pk cipher.PubKey, port uint16) string {
+	return dmsg.Addr{PK: pk, Port: port}.String()
+}
+
+func disassembleAddr(addr net.Addr) (pk cipher.PubKey, port uint16) {
+	pk, port, err := ParseAddr(addr.String())
+	if err != nil {
+		panic(fmt.Errorf("network.disassembleAddr: %v", err))
+	}
+	return pk, port
+}
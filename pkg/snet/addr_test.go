@@ -0,0 +1,60 @@
+package snet
+
+import (
+	"testing"
+
+	"github.com/SkycoinProject/dmsg"
+	"github.com/SkycoinProject/dmsg/cipher"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAddr(t *testing.T) {
+	pk, _ := cipher.GenerateKeyPair()
+
+	t.Run("round_trip", func(t *testing.T) {
+		for _, port := range []uint16{0, 1, 80, 65535} {
+			s := FormatAddr(pk, port)
+			assert.Equal(t, dmsg.Addr{PK: pk, Port: port}.String(), s)
+
+			gotPK, gotPort, err := ParseAddr(s)
+			require.NoError(t, err)
+			assert.Equal(t, pk, gotPK)
+			assert.Equal(t, port, gotPort)
+		}
+	})
+
+	t.Run("unspecified_port", func(t *testing.T) {
+		assert.Equal(t, pk.String()+":~", FormatAddr(pk, 0))
+
+		_, port, err := ParseAddr(pk.String() + ":~")
+		require.NoError(t, err)
+		assert.Equal(t, uint16(0), port)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, s := range []string{
+			"",
+			pk.String(),
+			pk.String() + ":1:2",
+			"invalid:1",
+			pk.String() + ":port",
+			pk.String() + ":65536",
+			pk.String() + ":-1",
+		} {
+			_, _, err := ParseAddr(s)
+			assert.Error(t, err, s)
+		}
+	})
+}
+
+func TestDisassembleAddr(t *testing.T) {
+	pk, _ := cipher.GenerateKeyPair()
+	port := uint16(2)
+	addr := dmsg.Addr{
+		PK: pk, Port: port,
+	}
+	gotPK, gotPort := disassembleAddr(addr)
+	require.Equal(t, pk, gotPK)
+	require.Equal(t, port, gotPort)
+}
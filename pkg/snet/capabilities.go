@@ -0,0 +1,39 @@
+package snet
+
+// Features that are advertised in Capabilities.
+const (
+	FeatureCompression = "compression"
+	FeatureHeartbeat   = "heartbeat"
+	FeaturePacketTTL   = "packet_ttl"
+	FeaturePacketSeq   = "packet_seq"
+	FeaturePacketType  = "packet_type"
+)
+
+// Capabilities are advertised by the remote end of a connection during the hello exchange.
+// Remote ends that use an older hello version do not advertise networks.
+type Capabilities struct {
+	Version  byte     // hello version of the remote end
+	Networks []string // network types the remote end is able to use
+	Features []string // optional features requested by the remote end, enabled only if both ends requested them
+
+	MaxPayloadSize int // largest write the remote end accepts in one piece, 0 if it advertised no limit
+}
+
+// HasNetwork returns whether the remote end advertised the given network type.
+func (c Capabilities) HasNetwork(network string) bool {
+	return contains(c.Networks, network)
+}
+
+// HasFeature returns whether the remote end requested the given feature.
+func (c Capabilities) HasFeature(feature string) bool {
+	return contains(c.Features, feature)
+}
+
+func contains(s []string, v string) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
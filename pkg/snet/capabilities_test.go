@@ -0,0 +1,208 @@
+package snet
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/SkycoinProject/dmsg/cipher"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/SkycoinProject/skywire-mainnet/pkg/snet/mem"
+)
+
+func TestNetwork_RemoteCapabilities(t *testing.T) {
+	const port = uint16(3)
+
+	hub := mem.NewHub()
+	pk1, _ := cipher.GenerateKeyPair()
+	pk2, _ := cipher.GenerateKeyPair()
+
+	n1 := NewRaw(Config{PubKey: pk1, EnableCompression: true}, nil, nil).WithMem(mem.NewClient(hub, pk1))
+	defer func() { assert.NoError(t, n1.Close()) }()
+	n2 := NewRaw(Config{PubKey: pk2}, nil, nil).WithMem(mem.NewClient(hub, pk2))
+	defer func() { assert.NoError(t, n2.Close()) }()
+
+	lis, err := n2.Listen(MemType, port)
+	require.NoError(t, err)
+	defer func() { assert.NoError(t, lis.Close()) }()
+
+	type result struct {
+		conn *Conn
+		err  error
+	}
+	acceptCh := make(chan result, 1)
+	go func() {
+		conn, err := lis.AcceptConn()
+		acceptCh <- result{conn, err}
+	}()
+
+	conn1, err := n1.DialContext(context.TODO(), MemType, pk2, port)
+	require.NoError(t, err)
+	defer func() { assert.NoError(t, conn1.Close()) }()
+	res := <-acceptCh
+	require.NoError(t, res.err)
+	conn2 := res.conn
+	defer func() { assert.NoError(t, conn2.Close()) }()
+
+	caps2 := conn1.RemoteCapabilities()
+	assert.Equal(t, helloVersion, caps2.Version)
+	assert.Equal(t, []string{MemType}, caps2.Networks)
+	assert.True(t, caps2.HasNetwork(MemType))
+	assert.False(t, caps2.HasNetwork(DmsgType))
+	assert.False(t, caps2.HasFeature(FeatureCompression))
+
+	// n2 enables no feature, so it knows the capabilities of n1 once it read from the connection.
+	go func() { _, _ = conn1.Write([]byte{1}) }() //nolint:errcheck
+	_, err = conn2.Read(make([]byte, 1))
+	require.NoError(t, err)
+
+	caps1 := conn2.RemoteCapabilities()
+	assert.Equal(t, []string{MemType}, caps1.Networks)
+	assert.True(t, caps1.HasFeature(FeatureCompression))
+	assert.False(t, caps1.HasFeature(FeatureHeartbeat))
+}
+
+func TestConn_HasFeature(t *testing.T) {
+	const port = uint16(3)
+
+	cases := []struct {
+		name       string
+		ttl1, ttl2 bool
+		seq1, seq2 bool
+		typ        bool // enabled at both ends
+		want       bool
+		wantSeq    bool
+		wantType   bool
+	}{
+		{name: "both_ends", ttl1: true, ttl2: true, want: true},
+		{name: "dialer_only", ttl1: true},
+		{name: "listener_only", ttl2: true},
+		{name: "seq_both_ends", ttl1: true, ttl2: true, seq1: true, seq2: true, want: true, wantSeq: true},
+		{name: "seq_dialer_only", ttl1: true, ttl2: true, seq1: true, want: true},
+		{name: "seq_without_ttl", ttl1: true, seq1: true, seq2: true},
+		{name: "type_both_ends", ttl1: true, ttl2: true, seq1: true, seq2: true, typ: true, want: true, wantSeq: true, wantType: true},
+		{name: "type_without_seq", ttl1: true, ttl2: true, seq1: true, typ: true, want: true},
+	}
+	defer shortHelloSniff()()
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			hub := mem.NewHub()
+			pk1, _ := cipher.GenerateKeyPair()
+			pk2, _ := cipher.GenerateKeyPair()
+
+			n1 := NewRaw(Config{PubKey: pk1, EnablePacketTTL: tc.ttl1, EnablePacketSeq: tc.seq1, EnablePacketType: tc.typ}, nil, nil).WithMem(mem.NewClient(hub, pk1))
+			defer func() { assert.NoError(t, n1.Close()) }()
+			n2 := NewRaw(Config{PubKey: pk2, EnablePacketTTL: tc.ttl2, EnablePacketSeq: tc.seq2, EnablePacketType: tc.typ}, nil, nil).WithMem(mem.NewClient(hub, pk2))
+			defer func() { assert.NoError(t, n2.Close()) }()
+
+			lis, err := n2.Listen(MemType, port)
+			require.NoError(t, err)
+			defer func() { assert.NoError(t, lis.Close()) }()
+
+			acceptCh := make(chan *Conn, 1)
+			go func() {
+				conn, err := lis.AcceptConn()
+				assert.NoError(t, err)
+				acceptCh <- conn
+			}()
+
+			conn1, err := n1.DialContext(context.TODO(), MemType, pk2, port)
+			require.NoError(t, err)
+			defer func() { assert.NoError(t, conn1.Close()) }()
+			conn2 := <-acceptCh
+			require.NotNil(t, conn2)
+			defer func() { assert.NoError(t, conn2.Close()) }()
+
+			assert.Equal(t, tc.want, conn1.HasFeature(FeaturePacketTTL))
+			assert.Equal(t, tc.want, conn2.HasFeature(FeaturePacketTTL))
+			assert.Equal(t, tc.wantSeq, conn1.HasFeature(FeaturePacketSeq))
+			assert.Equal(t, tc.wantSeq, conn2.HasFeature(FeaturePacketSeq))
+			assert.Equal(t, tc.wantType, conn1.HasFeature(FeaturePacketType))
+			assert.Equal(t, tc.wantType, conn2.HasFeature(FeaturePacketType))
+			assert.False(t, conn1.HasFeature(FeatureCompression))
+		})
+	}
+}
+
+func TestNegotiateConn_NoCapabilities(t *testing.T) {
+	iRaw, rRaw := net.Pipe()
+	defer func() { assert.NoError(t, rRaw.Close()) }()
+
+	// The responder uses the first hello version which does not carry capabilities.
+	go func() {
+		if _, err := readHello(rRaw); err != nil {
+			return
+		}
+		_ = writeHello(rRaw, hello{version: 1, flags: helloFlagCompress}) //nolint:errcheck
+	}()
+
+	conn, caps, err := negotiateConn(iRaw, true, ConnOptions{Compress: true, Networks: []string{DmsgType}})
+	require.NoError(t, err)
+	defer func() { assert.NoError(t, conn.Close()) }()
+
+	assert.Equal(t, byte(1), caps.Version)
+	assert.Empty(t, caps.Networks)
+	assert.False(t, caps.HasNetwork(DmsgType))
+	assert.True(t, caps.HasFeature(FeatureCompression))
+}
+
+func TestConn_MaxPayloadSize(t *testing.T) {
+	const port = uint16(3)
+
+	cases := []struct {
+		name       string
+		max1, max2 int
+		want1      int
+		want2      int
+	}{
+		{name: "no_limits"},
+		{name: "dialer_limit", max1: 1000, want1: 1000, want2: 1000},
+		{name: "both_limits", max1: 1000, max2: 500, want1: 500, want2: 500},
+		// The dialer sends no hello, so it does not learn the limit of the listener.
+		{name: "listener_limit", max2: 500, want2: 500},
+	}
+	defer shortHelloSniff()()
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			hub := mem.NewHub()
+			pk1, _ := cipher.GenerateKeyPair()
+			pk2, _ := cipher.GenerateKeyPair()
+
+			n1 := NewRaw(Config{PubKey: pk1, MaxPayloadSize: tc.max1}, nil, nil).WithMem(mem.NewClient(hub, pk1))
+			defer func() { assert.NoError(t, n1.Close()) }()
+			n2 := NewRaw(Config{PubKey: pk2, MaxPayloadSize: tc.max2}, nil, nil).WithMem(mem.NewClient(hub, pk2))
+			defer func() { assert.NoError(t, n2.Close()) }()
+
+			lis, err := n2.Listen(MemType, port)
+			require.NoError(t, err)
+			defer func() { assert.NoError(t, lis.Close()) }()
+
+			acceptCh := make(chan *Conn, 1)
+			go func() {
+				conn, err := lis.AcceptConn()
+				assert.NoError(t, err)
+				acceptCh <- conn
+			}()
+
+			conn1, err := n1.DialContext(context.TODO(), MemType, pk2, port)
+			require.NoError(t, err)
+			defer func() { assert.NoError(t, conn1.Close()) }()
+			conn2 := <-acceptCh
+			require.NotNil(t, conn2)
+			defer func() { assert.NoError(t, conn2.Close()) }()
+
+			// Listeners which enable nothing learn the capabilities of the dialer once data is read.
+			go func() {
+				_, err := conn1.Write([]byte{1})
+				assert.NoError(t, err)
+			}()
+			_, err = conn2.Read(make([]byte, 1))
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.want1, conn1.MaxPayloadSize())
+			assert.Equal(t, tc.want2, conn2.MaxPayloadSize())
+		})
+	}
+}
@@ -0,0 +1,432 @@
+package snet
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// HandshakeTimeout is the maximum duration of the hello exchange performed on new connections.
+const HandshakeTimeout = 5 * time.Second
+
+// A hello consists of a magic byte, a version byte and a flags byte.
+// Since version 2, these are followed by a big-endian uint16 length and a JSON payload of that length.
+//
+// A hello is only sent by the initiator of a connection if it requests an optional feature, so connections
+// without features keep the wire format of peers which do not know hellos. The responder detects a hello
+// by its first byte: the protocols served over snet (transport handshakes, setup packets, RPC) never start
+// with helloMagic, and in all of them the initiator writes first.
+const (
+	helloMagic         = byte(0x5e)
+	helloVersion       = byte(0x02)
+	helloLen           = 3
+	helloMaxPayloadLen = 4096
+
+	sniffBufSize = 4096 // bytes read at once to detect a hello
+
+	helloFlagCompress   = byte(1 << 0)
+	helloFlagHeartbeat  = byte(1 << 1)
+	helloFlagPacketTTL  = byte(1 << 2)
+	helloFlagPacketSeq  = byte(1 << 3)
+	helloFlagPacketType = byte(1 << 4)
+)
+
+// helloSniffTimeout is how long a responder waits for the first data of the initiator
+// before it assumes that the initiator sends no hello, replaced in tests.
+var helloSniffTimeout = HandshakeTimeout
+
+var (
+	// ErrInvalidHello occurs when the remote hello is malformed.
+	ErrInvalidHello = errors.New("invalid snet hello")
+
+	// ErrHandshakeTimeout occurs when the hello exchange is not completed in time.
+	ErrHandshakeTimeout = errors.New("snet handshake timeout")
+)
+
+// ConnOptions are the optional features of a connection.
+// A feature is only enabled when both ends request it.
+type ConnOptions struct {
+	Compress          bool          // compress data with flate
+	HeartbeatInterval time.Duration // interval of heartbeat pings, 0 disables heartbeats
+	HeartbeatMisses   int           // consecutive unanswered pings before the conn is closed, 0 uses DefaultHeartbeatMisses
+	Networks          []string      // network types advertised to the remote end
+	PacketTTL         bool          // exchange routing packets with a TTL, see Conn.HasFeature
+	PacketSeq         bool          // exchange routing packets with a sequence number, only requested along with PacketTTL
+	PacketType        bool          // exchange routing packets with a type, only requested along with PacketSeq
+	MaxPayloadSize    int           // largest write accepted in one piece, advertised to the remote end, 0 advertises no limit
+
+	// HeartbeatMaxInterval makes the interval of heartbeat pings adaptive: it lengthens up to HeartbeatMaxInterval
+	// while data flows and shortens down to HeartbeatInterval while the conn is idle.
+	// If not above HeartbeatInterval, pings are sent every HeartbeatInterval.
+	HeartbeatMaxInterval time.Duration
+}
+
+// needsHello returns whether a connection with o requires a hello exchange.
+func (o ConnOptions) needsHello() bool {
+	return o.flags() != 0 || o.MaxPayloadSize > 0
+}
+
+func (o ConnOptions) flags() byte {
+	var flags byte
+	if o.Compress {
+		flags |= helloFlagCompress
+	}
+	if o.HeartbeatInterval > 0 {
+		flags |= helloFlagHeartbeat
+	}
+	if o.PacketTTL {
+		flags |= helloFlagPacketTTL
+		if o.PacketSeq {
+			flags |= helloFlagPacketSeq
+			if o.PacketType {
+				flags |= helloFlagPacketType
+			}
+		}
+	}
+	return flags
+}
+
+// features returns the features requested by o.
+func (o ConnOptions) features() []string {
+	return hello{flags: o.flags()}.capabilities().Features
+}
+
+// NegotiateConn exchanges a hello with the remote end of conn to agree on connection options.
+// The initiator only writes a hello if opts request a feature, it then reads the hello of the responder.
+// The responder replies with its hello if the data of the initiator starts with one, otherwise the data
+// is passed on as is. A responder which requests no feature returns right away and detects the hello
+// on the first read. Otherwise, if the initiator writes nothing within HandshakeTimeout, the responder
+// assumes that it sends no hello and returns conn without waiting further.
+// If no option is enabled by both ends, conn is returned without compression or heartbeats.
+// On failure, conn is closed.
+func NegotiateConn(conn net.Conn, initiator bool, opts ConnOptions) (net.Conn, error) {
+	conn, _, err := negotiateConn(conn, initiator, opts)
+	return conn, err
+}
+
+// negotiateConn is NegotiateConn which also returns the capabilities advertised by the remote end.
+// The capabilities are empty if no hello was exchanged.
+func negotiateConn(conn net.Conn, initiator bool, opts ConnOptions) (net.Conn, Capabilities, error) {
+	lHello := hello{version: helloVersion, flags: opts.flags(), networks: opts.Networks, maxPayloadSize: opts.MaxPayloadSize}
+	if initiator && !opts.needsHello() {
+		return conn, Capabilities{}, nil
+	}
+
+	if !initiator {
+		// A responder which enables nothing replies to a hello in the background.
+		var reply *hello
+		if !opts.needsHello() {
+			reply = &lHello
+		}
+		sc := sniffConn(conn, reply)
+		if reply != nil {
+			return sc, Capabilities{}, nil
+		}
+		isHello, err := sc.awaitHello(helloSniffTimeout)
+		if err != nil {
+			_ = conn.Close() //nolint:errcheck
+			return nil, Capabilities{}, err
+		}
+		if !isHello {
+			return sc, Capabilities{}, nil
+		}
+		// The hello and the data after it are read through sc, which buffered their start.
+		conn = sc
+	}
+
+	timer := time.NewTimer(HandshakeTimeout)
+	defer timer.Stop()
+
+	type result struct {
+		hello hello
+		err   error
+	}
+
+	// Deadlines are not used as dmsg transports share the underlying connection.
+	done := make(chan result, 1)
+	go func() {
+		rHello, err := exchangeHello(conn, initiator, lHello)
+		done <- result{hello: rHello, err: err}
+	}()
+
+	select {
+	case res := <-done:
+		if res.err != nil {
+			_ = conn.Close() //nolint:errcheck
+			return nil, Capabilities{}, res.err
+		}
+		flags := lHello.flags & res.hello.flags
+		if flags&helloFlagHeartbeat != 0 {
+			conn = newHeartbeatConn(conn, opts.HeartbeatInterval, opts.HeartbeatMaxInterval, opts.HeartbeatMisses)
+		}
+		if flags&helloFlagCompress != 0 {
+			conn = newCompressedConn(conn)
+		}
+		return conn, res.hello.capabilities(), nil
+	case <-timer.C:
+		_ = conn.Close() //nolint:errcheck
+		return nil, Capabilities{}, ErrHandshakeTimeout
+	}
+}
+
+// sniffedConn is a connection of a responder of which the first data is read in the background
+// to detect whether the initiator sends a hello. Data which is not part of a hello is passed on.
+type sniffedConn struct {
+	net.Conn
+
+	done    chan struct{} // closed once the first data, and a hello replied to, are read
+	isHello bool          // whether the first data starts with helloMagic, which is then consumed
+	caps    Capabilities  // of a hello replied to
+	err     error         // error of reading the first data, returned once the buffered data is read
+
+	mx   sync.Mutex
+	rest *bytes.Reader // data read in the background which is not read through Read yet
+}
+
+// sniffConn starts reading the first data of conn.
+// If reply is set, a hello of the initiator is read in full and replied to with reply.
+// Otherwise, only the magic byte of a hello is consumed.
+func sniffConn(conn net.Conn, reply *hello) *sniffedConn {
+	sc := &sniffedConn{Conn: conn, done: make(chan struct{}), rest: bytes.NewReader(nil)}
+	go func() {
+		defer close(sc.done)
+
+		buf := make([]byte, sniffBufSize)
+		n, err := conn.Read(buf)
+		for n == 0 && err == nil {
+			n, err = conn.Read(buf)
+		}
+		sc.rest = bytes.NewReader(buf[:n])
+		if n == 0 || buf[0] != helloMagic {
+			sc.err = err
+			return
+		}
+		sc.isHello = true
+		_, _ = sc.rest.ReadByte() //nolint:errcheck
+		if reply == nil {
+			sc.err = err
+			return
+		}
+		if err != nil && sc.rest.Len() == 0 {
+			sc.err = err
+			return
+		}
+		rHello, err := readHelloBody(io.MultiReader(sc.rest, conn))
+		if err == nil {
+			err = writeHello(conn, *reply)
+		}
+		sc.caps, sc.err = rHello.capabilities(), err
+	}()
+	return sc
+}
+
+// awaitHello returns whether the initiator sent a hello, or the error reading its first data.
+// If the first data does not arrive within timeout, the initiator is assumed to send no hello.
+// A hello which arrives later is passed on as data.
+func (c *sniffedConn) awaitHello(timeout time.Duration) (bool, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-c.done:
+		if c.isHello {
+			return true, nil
+		}
+		if c.rest.Len() == 0 {
+			return false, c.err
+		}
+		return false, nil
+	case <-timer.C:
+		return false, nil
+	}
+}
+
+// Read implements io.Reader
+func (c *sniffedConn) Read(p []byte) (int, error) {
+	<-c.done
+
+	c.mx.Lock()
+	if c.rest.Len() > 0 {
+		n, err := c.rest.Read(p)
+		c.mx.Unlock()
+		return n, err
+	}
+	c.mx.Unlock()
+
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.Conn.Read(p)
+}
+
+// capabilities returns the capabilities of a hello which was replied to, if it was read already.
+func (c *sniffedConn) capabilities() Capabilities {
+	select {
+	case <-c.done:
+		return c.caps
+	default:
+		return Capabilities{}
+	}
+}
+
+type hello struct {
+	version        byte
+	flags          byte
+	networks       []string
+	maxPayloadSize int
+}
+
+// helloPayload is the JSON payload of a hello.
+// Unknown fields are ignored, so that fields can be added without bumping the version.
+type helloPayload struct {
+	Networks       []string `json:"networks,omitempty"`
+	MaxPayloadSize int      `json:"max_payload_size,omitempty"`
+}
+
+func (h hello) capabilities() Capabilities {
+	caps := Capabilities{Version: h.version, Networks: h.networks, MaxPayloadSize: h.maxPayloadSize}
+	if h.flags&helloFlagCompress != 0 {
+		caps.Features = append(caps.Features, FeatureCompression)
+	}
+	if h.flags&helloFlagHeartbeat != 0 {
+		caps.Features = append(caps.Features, FeatureHeartbeat)
+	}
+	if h.flags&helloFlagPacketTTL != 0 {
+		caps.Features = append(caps.Features, FeaturePacketTTL)
+	}
+	if h.flags&helloFlagPacketSeq != 0 {
+		caps.Features = append(caps.Features, FeaturePacketSeq)
+	}
+	if h.flags&helloFlagPacketType != 0 {
+		caps.Features = append(caps.Features, FeaturePacketType)
+	}
+	return caps
+}
+
+func exchangeHello(rw io.ReadWriter, initiator bool, lHello hello) (hello, error) {
+	if initiator {
+		if err := writeHello(rw, lHello); err != nil {
+			return hello{}, err
+		}
+		return readHello(rw)
+	}
+	// The magic byte of the initiator's hello was read by sniffHello.
+	rHello, err := readHelloBody(rw)
+	if err != nil {
+		return hello{}, err
+	}
+	return rHello, writeHello(rw, lHello)
+}
+
+func writeHello(w io.Writer, h hello) error {
+	b := []byte{helloMagic, h.version, h.flags}
+	if h.version >= 2 {
+		payload, err := json.Marshal(helloPayload{Networks: h.networks, MaxPayloadSize: h.maxPayloadSize})
+		if err != nil {
+			return err
+		}
+		if len(payload) > helloMaxPayloadLen {
+			return ErrInvalidHello
+		}
+		b = append(b, 0, 0)
+		binary.BigEndian.PutUint16(b[helloLen:], uint16(len(payload)))
+		b = append(b, payload...)
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+func readHello(r io.Reader) (hello, error) {
+	b := make([]byte, 1)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return hello{}, err
+	}
+	if b[0] != helloMagic {
+		return hello{}, ErrInvalidHello
+	}
+	return readHelloBody(r)
+}
+
+// readHelloBody reads a hello of which the magic byte was already read.
+func readHelloBody(r io.Reader) (hello, error) {
+	b := make([]byte, helloLen-1)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return hello{}, err
+	}
+	if b[0] == 0 {
+		return hello{}, ErrInvalidHello
+	}
+	h := hello{version: b[0], flags: b[1]}
+	if h.version < 2 {
+		return h, nil
+	}
+
+	lb := make([]byte, 2)
+	if _, err := io.ReadFull(r, lb); err != nil {
+		return hello{}, err
+	}
+	n := binary.BigEndian.Uint16(lb)
+	if n > helloMaxPayloadLen {
+		return hello{}, ErrInvalidHello
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return hello{}, err
+	}
+	var p helloPayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return hello{}, ErrInvalidHello
+	}
+	h.networks, h.maxPayloadSize = p.Networks, p.MaxPayloadSize
+	return h, nil
+}
+
+// compressedConn is a net.Conn that compresses written data and decompresses read data with flate.
+// Writes are flushed immediately so that the remote end can read them without waiting for more data.
+type compressedConn struct {
+	net.Conn
+	r  io.ReadCloser
+	w  *flate.Writer
+	wx sync.Mutex
+}
+
+func newCompressedConn(conn net.Conn) *compressedConn {
+	w, err := flate.NewWriter(conn, flate.DefaultCompression)
+	if err != nil {
+		panic(err) // only happens with an invalid compression level
+	}
+	return &compressedConn{Conn: conn, r: flate.NewReader(conn), w: w}
+}
+
+// Read implements io.Reader
+func (c *compressedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// Write implements io.Writer
+func (c *compressedConn) Write(p []byte) (int, error) {
+	c.wx.Lock()
+	defer c.wx.Unlock()
+
+	n, err := c.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	if err := c.w.Flush(); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// Close implements io.Closer
+func (c *compressedConn) Close() error {
+	err := c.Conn.Close()
+	_ = c.r.Close() //nolint:errcheck
+	return err
+}
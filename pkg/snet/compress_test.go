@@ -0,0 +1,194 @@
+package snet
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/SkycoinProject/dmsg"
+	"github.com/SkycoinProject/dmsg/cipher"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiateConn(t *testing.T) {
+	cases := []struct {
+		name           string
+		iComp, rComp   bool
+		wantCompressed bool
+	}{
+		{"both_compress", true, true, true},
+		{"initiator_compresses", true, false, false},
+		{"responder_compresses", false, true, false},
+		{"none_compress", false, false, false},
+	}
+
+	msg := bytes.Repeat([]byte("skywire compressible payload "), 256)
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			iConn, rConn := negotiatePipe(t, tc.iComp, tc.rComp)
+			defer func() {
+				assert.NoError(t, iConn.Close())
+				assert.NoError(t, rConn.Close())
+			}()
+
+			_, iCompressed := iConn.(*compressedConn)
+			_, rCompressed := rConn.(*compressedConn)
+			assert.Equal(t, tc.wantCompressed, iCompressed)
+			assert.Equal(t, tc.wantCompressed, rCompressed)
+
+			roundTrip(t, iConn, rConn, msg)
+			roundTrip(t, rConn, iConn, msg)
+		})
+	}
+}
+
+func TestNegotiateConn_InvalidHello(t *testing.T) {
+	iConn, rConn := net.Pipe()
+	defer func() { assert.NoError(t, iConn.Close()) }()
+
+	go func() {
+		_, _ = iConn.Write([]byte{helloMagic, 0, 0}) //nolint:errcheck
+	}()
+
+	_, err := NegotiateConn(rConn, false, ConnOptions{Compress: true})
+	assert.Equal(t, ErrInvalidHello, err)
+}
+
+// Ensure that connections of initiators which send no hello keep their data, as with peers which do not know hellos.
+func TestNegotiateConn_WithoutHello(t *testing.T) {
+	t.Run("initiator_without_features", func(t *testing.T) {
+		iConn, rConn := negotiatePipeOpts(t, ConnOptions{}, ConnOptions{Compress: true, HeartbeatInterval: time.Second})
+		defer func() {
+			assert.NoError(t, iConn.Close())
+			assert.NoError(t, rConn.Close())
+		}()
+
+		_, iCompressed := iConn.(*compressedConn)
+		assert.False(t, iCompressed)
+		roundTrip(t, iConn, rConn, []byte("not a hello"))
+		roundTrip(t, rConn, iConn, []byte("reply"))
+	})
+
+	t.Run("legacy_initiator", func(t *testing.T) {
+		iConn, rRaw := net.Pipe()
+		defer func() { assert.NoError(t, iConn.Close()) }()
+
+		// A setup packet of a peer which does not know hellos.
+		packet := []byte{0, 0, 2, '{', '}'}
+		go func() {
+			_, _ = iConn.Write(packet) //nolint:errcheck
+		}()
+
+		rConn, caps, err := negotiateConn(rRaw, false, ConnOptions{Compress: true})
+		require.NoError(t, err)
+		defer func() { assert.NoError(t, rConn.Close()) }()
+		assert.Equal(t, Capabilities{}, caps)
+
+		got := make([]byte, len(packet))
+		_, err = io.ReadFull(rConn, got)
+		require.NoError(t, err)
+		assert.Equal(t, packet, got)
+	})
+}
+
+func negotiatePipe(t *testing.T, iComp, rComp bool) (net.Conn, net.Conn) {
+	return negotiatePipeOpts(t, ConnOptions{Compress: iComp}, ConnOptions{Compress: rComp})
+}
+
+// shortHelloSniff shortens the wait of responders for a hello, for initiators which send none.
+// The returned func restores it.
+func shortHelloSniff() func() {
+	prev := helloSniffTimeout
+	helloSniffTimeout = 50 * time.Millisecond
+	return func() { helloSniffTimeout = prev }
+}
+
+func negotiatePipeOpts(t *testing.T, iOpts, rOpts ConnOptions) (net.Conn, net.Conn) {
+	defer shortHelloSniff()()
+	iConn, rConn := net.Pipe()
+
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+
+	rCh := make(chan result, 1)
+	go func() {
+		conn, err := NegotiateConn(rConn, false, rOpts)
+		rCh <- result{conn, err}
+	}()
+
+	iNeg, err := NegotiateConn(iConn, true, iOpts)
+	require.NoError(t, err)
+	res := <-rCh
+	require.NoError(t, res.err)
+
+	return iNeg, res.conn
+}
+
+func roundTrip(t *testing.T, w, r net.Conn, msg []byte) {
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := w.Write(msg)
+		errCh <- err
+	}()
+
+	got := make([]byte, len(msg))
+	_, err := io.ReadFull(r, got)
+	require.NoError(t, err)
+	require.NoError(t, <-errCh)
+	assert.Equal(t, msg, got)
+}
+
+func TestConn_UnwrapNegotiated(t *testing.T) {
+	opts := ConnOptions{Compress: true, HeartbeatInterval: time.Second}
+
+	cases := []struct {
+		name  string
+		iOpts ConnOptions
+	}{
+		{"plain", ConnOptions{}},
+		{"compressed_with_heartbeats", opts},
+	}
+
+	defer shortHelloSniff()()
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			iPipe, rPipe := net.Pipe()
+			pk, _ := cipher.GenerateKeyPair()
+			iRaw, rRaw := addrConn{iPipe, pk}, addrConn{rPipe, pk}
+
+			rCh := make(chan net.Conn, 1)
+			go func() {
+				conn, err := NegotiateConn(rRaw, false, opts)
+				assert.NoError(t, err)
+				rCh <- conn
+			}()
+			iConn, err := NegotiateConn(iRaw, true, tc.iOpts)
+			require.NoError(t, err)
+			rConn := <-rCh
+			defer func() {
+				assert.NoError(t, iConn.Close())
+				assert.NoError(t, rConn.Close())
+			}()
+
+			// Unwrap returns the connection snet negotiated its options over.
+			assert.True(t, makeConn(iConn, MemType, ConnOptions{}, Capabilities{}, nil).Unwrap() == iRaw)
+			assert.True(t, makeConn(rConn, MemType, ConnOptions{}, Capabilities{}, nil).Unwrap() == rRaw)
+		})
+	}
+}
+
+// addrConn is a net.Conn with the addresses of an snet connection.
+type addrConn struct {
+	net.Conn
+	pk cipher.PubKey
+}
+
+func (c addrConn) LocalAddr() net.Addr  { return dmsg.Addr{PK: c.pk, Port: 1} }
+func (c addrConn) RemoteAddr() net.Addr { return dmsg.Addr{PK: c.pk, Port: 2} }
@@ -0,0 +1,287 @@
+package snet_test
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/SkycoinProject/skywire-mainnet/pkg/snet"
+	"github.com/SkycoinProject/skywire-mainnet/pkg/snet/snettest"
+)
+
+func TestConn_SetBuffers(t *testing.T) {
+	const port = uint16(81)
+
+	keys := snettest.GenKeyPairs(2)
+	env := snettest.NewEnv(t, keys)
+	defer env.Teardown()
+
+	cases := []struct {
+		network string
+		wantErr error
+	}{
+		{snet.STcpType, nil},
+		{snet.DmsgType, snet.ErrBuffersNotSupported},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.network, func(t *testing.T) {
+			lis, err := env.Nets[0].Listen(tc.network, port)
+			require.NoError(t, err)
+			defer func() { assert.NoError(t, lis.Close()) }()
+
+			acceptCh := make(chan *snet.Conn, 1)
+			go func() {
+				conn, err := lis.AcceptConn()
+				assert.NoError(t, err)
+				acceptCh <- conn
+			}()
+
+			conn, err := env.Nets[1].Dial(tc.network, keys[0].PK, port)
+			require.NoError(t, err)
+			defer func() { assert.NoError(t, conn.Close()) }()
+
+			rConn := <-acceptCh
+			require.NotNil(t, rConn)
+			defer func() { assert.NoError(t, rConn.Close()) }()
+
+			for _, c := range []*snet.Conn{conn, rConn} {
+				assert.Equal(t, tc.wantErr, c.SetReadBuffer(1<<16))
+				assert.Equal(t, tc.wantErr, c.SetWriteBuffer(1<<16))
+			}
+		})
+	}
+}
+
+// A write of Conn.MaxPayloadSize bytes is delivered over dmsg in one piece.
+func TestConn_MaxPayloadSize_Dmsg(t *testing.T) {
+	const port = uint16(82)
+
+	keys := snettest.GenKeyPairs(2)
+	env := snettest.NewEnv(t, keys)
+	defer env.Teardown()
+
+	lis, err := env.Nets[0].Listen(snet.DmsgType, port)
+	require.NoError(t, err)
+	defer func() { assert.NoError(t, lis.Close()) }()
+
+	acceptCh := make(chan *snet.Conn, 1)
+	go func() {
+		conn, err := lis.AcceptConn()
+		assert.NoError(t, err)
+		acceptCh <- conn
+	}()
+
+	conn, err := env.Nets[1].Dial(snet.DmsgType, keys[0].PK, port)
+	require.NoError(t, err)
+	defer func() { assert.NoError(t, conn.Close()) }()
+
+	rConn := <-acceptCh
+	require.NotNil(t, rConn)
+	defer func() { assert.NoError(t, rConn.Close()) }()
+
+	require.Equal(t, snet.DmsgMaxWriteSize, conn.MaxPayloadSize())
+	assert.Equal(t, snet.DmsgMaxWriteSize, rConn.MaxPayloadSize())
+
+	msg := bytes.Repeat([]byte{0x42}, conn.MaxPayloadSize())
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := conn.Write(msg)
+		errCh <- err
+	}()
+
+	got := make([]byte, len(msg))
+	_, err = io.ReadFull(rConn, got)
+	require.NoError(t, err)
+	require.NoError(t, <-errCh)
+	assert.Equal(t, msg, got)
+}
+
+func TestConn_Stats(t *testing.T) {
+	const port = uint16(82)
+
+	keys := snettest.GenKeyPairs(2)
+	env := snettest.NewEnv(t, keys)
+	defer env.Teardown()
+
+	for _, network := range []string{snet.DmsgType, snet.STcpType} {
+		t.Run(network, func(t *testing.T) {
+			lis, err := env.Nets[0].Listen(network, port)
+			require.NoError(t, err)
+			defer func() { assert.NoError(t, lis.Close()) }()
+
+			acceptCh := make(chan *snet.Conn, 1)
+			go func() {
+				conn, err := lis.AcceptConn()
+				assert.NoError(t, err)
+				acceptCh <- conn
+			}()
+
+			start := time.Now()
+			conn, err := env.Nets[1].Dial(network, keys[0].PK, port)
+			require.NoError(t, err)
+			defer func() { assert.NoError(t, conn.Close()) }()
+
+			rConn := <-acceptCh
+			require.NotNil(t, rConn)
+			defer func() { assert.NoError(t, rConn.Close()) }()
+
+			stats := conn.Stats()
+			assert.Zero(t, stats.BytesRead)
+			assert.Zero(t, stats.BytesWritten)
+			assert.False(t, stats.OpenedAt.Before(start))
+
+			req := bytes.Repeat([]byte("a"), 1000)
+			resp := bytes.Repeat([]byte("b"), 300)
+
+			_, err = conn.Write(req)
+			require.NoError(t, err)
+			_, err = io.ReadFull(rConn, make([]byte, len(req)))
+			require.NoError(t, err)
+
+			_, err = rConn.Write(resp)
+			require.NoError(t, err)
+			_, err = io.ReadFull(conn, make([]byte, len(resp)))
+			require.NoError(t, err)
+
+			time.Sleep(10 * time.Millisecond)
+
+			stats = conn.Stats()
+			assert.Equal(t, uint64(len(req)), stats.BytesWritten)
+			assert.Equal(t, uint64(len(resp)), stats.BytesRead)
+			assert.True(t, stats.OpenDuration >= 10*time.Millisecond)
+
+			rStats := rConn.Stats()
+			assert.Equal(t, uint64(len(req)), rStats.BytesRead)
+			assert.Equal(t, uint64(len(resp)), rStats.BytesWritten)
+		})
+	}
+}
+
+func TestConn_Unwrap(t *testing.T) {
+	const port = uint16(83)
+
+	keys := snettest.GenKeyPairs(2)
+	env := snettest.NewEnvWithConfig(t, keys, func(conf *snet.Config) {
+		conf.EnableCompression = true
+		conf.HeartbeatInterval = time.Second
+	})
+	defer env.Teardown()
+
+	for _, network := range []string{snet.DmsgType, snet.STcpType} {
+		t.Run(network, func(t *testing.T) {
+			lis, err := env.Nets[0].Listen(network, port)
+			require.NoError(t, err)
+			defer func() { assert.NoError(t, lis.Close()) }()
+
+			acceptCh := make(chan *snet.Conn, 1)
+			go func() {
+				conn, err := lis.AcceptConn()
+				assert.NoError(t, err)
+				acceptCh <- conn
+			}()
+
+			conn, err := env.Nets[1].Dial(network, keys[0].PK, port)
+			require.NoError(t, err)
+			defer func() { assert.NoError(t, conn.Close()) }()
+
+			rConn := <-acceptCh
+			require.NotNil(t, rConn)
+			defer func() { assert.NoError(t, rConn.Close()) }()
+
+			for _, c := range []*snet.Conn{conn, rConn} {
+				tp, isDmsg := c.DmsgTransport()
+				stcpConn, isSTCP := c.STCPConn()
+				assert.Equal(t, network == snet.DmsgType, isDmsg)
+				assert.Equal(t, network == snet.STcpType, isSTCP)
+
+				if isDmsg {
+					assert.True(t, c.Unwrap() == net.Conn(tp))
+				} else {
+					assert.True(t, c.Unwrap() == net.Conn(stcpConn))
+				}
+			}
+		})
+	}
+}
+
+func TestConn_MaxLifetime(t *testing.T) {
+	const (
+		port     = uint16(86)
+		lifetime = 200 * time.Millisecond
+	)
+
+	// Only connections of the second network have a maximum lifetime.
+	keys := snettest.GenKeyPairs(2)
+	env := snettest.NewEnvWithConfig(t, keys, func(conf *snet.Config) {
+		if conf.PubKey == keys[1].PK {
+			conf.MaxConnLifetime = lifetime
+		}
+	})
+	defer env.Teardown()
+
+	for _, network := range []string{snet.DmsgType, snet.STcpType} {
+		t.Run(network, func(t *testing.T) {
+			for _, side := range []string{"dialed", "accepted"} {
+				t.Run(side, func(t *testing.T) {
+					lNet, dNet, lPK := env.Nets[1], env.Nets[0], keys[1].PK
+					if side == "dialed" {
+						lNet, dNet, lPK = env.Nets[0], env.Nets[1], keys[0].PK
+					}
+
+					lis, err := lNet.Listen(network, port)
+					require.NoError(t, err)
+					defer func() { assert.NoError(t, lis.Close()) }()
+
+					acceptCh := make(chan *snet.Conn, 1)
+					go func() {
+						conn, err := lis.AcceptConn()
+						assert.NoError(t, err)
+						acceptCh <- conn
+					}()
+
+					start := time.Now()
+					dConn, err := dNet.Dial(network, lPK, port)
+					require.NoError(t, err)
+					aConn := <-acceptCh
+					require.NotNil(t, aConn)
+
+					conn, other := dConn, aConn
+					if side == "accepted" {
+						conn, other = aConn, dConn
+					}
+					defer func() {
+						assert.NoError(t, conn.Close())
+						_ = other.Close() //nolint:errcheck
+					}()
+
+					// The lifetime wrapper is transparent to Unwrap.
+					_, isDmsg := conn.DmsgTransport()
+					_, isSTCP := conn.STCPConn()
+					assert.True(t, isDmsg || isSTCP)
+
+					// The conn works until its lifetime elapses.
+					msg := []byte("before rotation")
+					_, err = other.Write(msg)
+					require.NoError(t, err)
+					got := make([]byte, len(msg))
+					_, err = io.ReadFull(conn, got)
+					require.NoError(t, err)
+					assert.Equal(t, msg, got)
+
+					_, err = conn.Read(make([]byte, 1))
+					assert.Equal(t, snet.ErrConnLifetimeExceeded, err)
+					assert.True(t, time.Since(start) >= lifetime)
+
+					_, err = conn.Write([]byte("after rotation"))
+					assert.Equal(t, snet.ErrConnLifetimeExceeded, err)
+				})
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package snet
+
+import (
+	"context"
+	"sync"
+
+	"github.com/SkycoinProject/dmsg/cipher"
+)
+
+// DefaultDialConcurrency is the number of concurrent dials of DialBatch if no limit is given.
+const DefaultDialConcurrency = 16
+
+// DialTarget is the remote end of a dial of DialBatch.
+type DialTarget struct {
+	PK   cipher.PubKey
+	Port uint16
+}
+
+// DialResult is the outcome of dialing a DialTarget. Exactly one of Conn and Err is set.
+type DialResult struct {
+	Target DialTarget
+	Conn   *Conn
+	Err    error
+}
+
+// DialBatch dials all targets over the given network type, at most maxConcurrency at a time.
+// If maxConcurrency is not positive, DefaultDialConcurrency is used.
+// Results are in the order of targets. An error is only returned if the network type can't be dialed at all,
+// failures of single dials are reported in their results.
+func (n *Network) DialBatch(ctx context.Context, network string, targets []DialTarget, maxConcurrency int) ([]DialResult, error) {
+	if err := n.checkConfigured(network); err != nil {
+		return nil, err
+	}
+	if maxConcurrency <= 0 {
+		maxConcurrency = DefaultDialConcurrency
+	}
+	if maxConcurrency > len(targets) {
+		maxConcurrency = len(targets)
+	}
+
+	results := make([]DialResult, len(targets))
+	indexes := make(chan int)
+
+	var wg sync.WaitGroup
+	wg.Add(maxConcurrency)
+	for i := 0; i < maxConcurrency; i++ {
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				target := targets[i]
+				conn, err := n.DialContext(ctx, network, target.PK, target.Port)
+				results[i] = DialResult{Target: target, Conn: conn, Err: err}
+			}
+		}()
+	}
+
+	for i := range targets {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	return results, nil
+}
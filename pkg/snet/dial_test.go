@@ -0,0 +1,133 @@
+package snet_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/SkycoinProject/dmsg/cipher"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/nettest"
+
+	"github.com/SkycoinProject/skywire-mainnet/pkg/snet"
+	"github.com/SkycoinProject/skywire-mainnet/pkg/snet/mem"
+	"github.com/SkycoinProject/skywire-mainnet/pkg/snet/snettest"
+	"github.com/SkycoinProject/skywire-mainnet/pkg/snet/stcp"
+)
+
+func TestNetwork_DialTimeout(t *testing.T) {
+	const (
+		port    = uint16(80)
+		timeout = 200 * time.Millisecond
+	)
+
+	keys := snettest.GenKeyPairs(2)
+
+	// CLOSURE: dials and ensures that the dial is aborted after the configured timeout.
+	dialBlackHole := func(t *testing.T, n *snet.Network, network string, rPK cipher.PubKey) {
+		start := time.Now()
+		_, err := n.Dial(network, rPK, port)
+		assert.Error(t, err)
+		assert.True(t, time.Since(start) >= timeout)
+		assert.True(t, time.Since(start) < 5*timeout)
+	}
+
+	t.Run("default_timeout", func(t *testing.T) {
+		hub := mem.NewHub()
+		n1 := snet.NewRaw(snet.Config{PubKey: keys[1].PK, SecKey: keys[1].SK, DefaultDialTimeout: timeout}, nil, nil).
+			WithMem(mem.NewClient(hub, keys[1].PK))
+		defer func() { assert.NoError(t, n1.Close()) }()
+
+		// The listener never accepts, so dials are never introduced.
+		// It is not an snet listener, as those accept in the background.
+		lis, err := mem.NewClient(hub, keys[0].PK).Listen(port)
+		require.NoError(t, err)
+		defer func() { assert.NoError(t, lis.Close()) }()
+
+		dialBlackHole(t, n1, snet.MemType, keys[0].PK)
+	})
+
+	t.Run("network_timeout", func(t *testing.T) {
+		// The TCP listener accepts but never completes the stcp handshake.
+		tcpL, err := nettest.NewLocalListener("tcp")
+		require.NoError(t, err)
+		defer func() { assert.NoError(t, tcpL.Close()) }()
+		go func() {
+			var conns []net.Conn
+			defer func() {
+				for _, conn := range conns {
+					_ = conn.Close() //nolint:errcheck
+				}
+			}()
+			for {
+				conn, err := tcpL.Accept()
+				if err != nil {
+					return
+				}
+				conns = append(conns, conn)
+			}
+		}()
+
+		table := stcp.NewTable(map[cipher.PubKey]string{keys[0].PK: tcpL.Addr().String()})
+		n1 := snet.NewRaw(
+			snet.Config{
+				PubKey:             keys[1].PK,
+				SecKey:             keys[1].SK,
+				DefaultDialTimeout: time.Minute,
+				DialTimeouts:       map[string]time.Duration{snet.STcpType: timeout},
+			},
+			nil,
+			stcp.NewClient(nil, keys[1].PK, keys[1].SK, table))
+		defer func() { assert.NoError(t, n1.Close()) }()
+
+		dialBlackHole(t, n1, snet.STcpType, keys[0].PK)
+	})
+}
+
+func TestNetwork_DialBatch(t *testing.T) {
+	const port = uint16(83)
+
+	keys := snettest.GenKeyPairs(4)
+	env := snettest.NewEnv(t, keys)
+	defer env.Teardown()
+
+	// Visors 1 and 2 listen, visor 3 does not.
+	for _, n := range env.Nets[1:3] {
+		lis, err := n.Listen(snet.DmsgType, port)
+		require.NoError(t, err)
+		defer func() { assert.NoError(t, lis.Close()) }()
+		go func() {
+			for {
+				if _, err := lis.Accept(); err != nil {
+					return
+				}
+			}
+		}()
+	}
+
+	targets := []snet.DialTarget{
+		{PK: keys[1].PK, Port: port},
+		{PK: keys[3].PK, Port: port},
+		{PK: keys[2].PK, Port: port},
+	}
+	results, err := env.Nets[0].DialBatch(context.TODO(), snet.DmsgType, targets, 2)
+	require.NoError(t, err)
+	require.Len(t, results, len(targets))
+
+	for i, res := range results {
+		assert.Equal(t, targets[i], res.Target)
+		if targets[i].PK == keys[3].PK {
+			assert.Error(t, res.Err)
+			assert.Nil(t, res.Conn)
+			continue
+		}
+		require.NoError(t, res.Err)
+		assert.Equal(t, targets[i].PK, res.Conn.RemotePK())
+		assert.NoError(t, res.Conn.Close())
+	}
+
+	_, err = env.Nets[0].DialBatch(context.TODO(), "unknown", targets, 2)
+	assert.Equal(t, snet.ErrUnknownNetwork, err)
+}
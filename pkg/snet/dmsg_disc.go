@@ -0,0 +1,234 @@
+package snet
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/SkycoinProject/dmsg/cipher"
+	"github.com/SkycoinProject/dmsg/disc"
+	"github.com/SkycoinProject/skycoin/src/util/logging"
+)
+
+// ErrNoDmsgDiscovery occurs when no dmsg discovery addresses are provided.
+var ErrNoDmsgDiscovery = errors.New("no dmsg discovery addresses")
+
+// NewDmsgDiscovery creates a dmsg discovery client from the given addresses.
+// If more than one address is given, the addresses are tried in order until one of them responds.
+func NewDmsgDiscovery(addrs ...string) disc.APIClient {
+	if len(addrs) == 1 {
+		return disc.NewHTTP(addrs[0])
+	}
+	clients := make([]disc.APIClient, len(addrs))
+	for i, addr := range addrs {
+		clients[i] = disc.NewHTTP(addr)
+	}
+	return newFallbackDisc(logging.MustGetLogger("snet.dmsgD"), clients...)
+}
+
+// fallbackDisc implements disc.APIClient on top of multiple underlying clients.
+// Requests are passed to the underlying clients in order until one of them responds.
+type fallbackDisc struct {
+	log     *logging.Logger
+	clients []disc.APIClient
+}
+
+func newFallbackDisc(log *logging.Logger, clients ...disc.APIClient) *fallbackDisc {
+	return &fallbackDisc{log: log, clients: clients}
+}
+
+// Entry implements disc.APIClient
+func (d *fallbackDisc) Entry(ctx context.Context, pk cipher.PubKey) (entry *disc.Entry, err error) {
+	err = d.do(func(c disc.APIClient) error {
+		entry, err = c.Entry(ctx, pk)
+		return err
+	})
+	return entry, err
+}
+
+// SetEntry implements disc.APIClient
+func (d *fallbackDisc) SetEntry(ctx context.Context, entry *disc.Entry) error {
+	return d.do(func(c disc.APIClient) error {
+		return c.SetEntry(ctx, entry)
+	})
+}
+
+// UpdateEntry implements disc.APIClient
+func (d *fallbackDisc) UpdateEntry(ctx context.Context, sk cipher.SecKey, entry *disc.Entry) error {
+	return d.do(func(c disc.APIClient) error {
+		return c.UpdateEntry(ctx, sk, entry)
+	})
+}
+
+// AvailableServers implements disc.APIClient
+func (d *fallbackDisc) AvailableServers(ctx context.Context) (entries []*disc.Entry, err error) {
+	err = d.do(func(c disc.APIClient) error {
+		entries, err = c.AvailableServers(ctx)
+		return err
+	})
+	return entries, err
+}
+
+func (d *fallbackDisc) do(fn func(c disc.APIClient) error) error {
+	err := ErrNoDmsgDiscovery
+	for i, c := range d.clients {
+		if err = fn(c); err == nil || isDiscResponse(err) {
+			return err
+		}
+		d.log.WithError(err).Warnf("dmsg discovery %d/%d did not respond", i+1, len(d.clients))
+	}
+	return err
+}
+
+// isDiscResponse determines whether the error is a valid response of a dmsg discovery.
+// Such errors should not be retried with other discoveries.
+func isDiscResponse(err error) bool {
+	switch err {
+	case disc.ErrKeyNotFound, disc.ErrUnauthorized, disc.ErrBadInput:
+		return true
+	}
+	_, ok := err.(disc.EntryValidationError)
+	return ok
+}
+
+// DefaultDiscCacheSize is the number of entries kept by a dmsg discovery cache if Config.DmsgDiscCacheSize is not set.
+const DefaultDiscCacheSize = 1024
+
+// DiscCacheStats counts the lookups of entries served from and passed through a dmsg discovery cache.
+type DiscCacheStats struct {
+	Hits   uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+}
+
+type cachedEntry struct {
+	entry   *disc.Entry
+	expires time.Time
+	elem    *list.Element // in cachingDisc.lru, its value is the public key of the entry
+}
+
+// cachingDisc implements disc.APIClient on top of an underlying client, caching the entries it looks up for ttl.
+// Once size entries are cached, the least recently used one is evicted.
+// Entries of which the underlying client is asked to set or update are evicted, so that they are looked up again.
+type cachingDisc struct {
+	disc.APIClient
+	ttl  time.Duration
+	size int
+
+	entries map[cipher.PubKey]*cachedEntry
+	lru     *list.List // most recently used first
+	stats   DiscCacheStats
+	mx      sync.Mutex
+}
+
+func newCachingDisc(c disc.APIClient, ttl time.Duration, size int) *cachingDisc {
+	if size <= 0 {
+		size = DefaultDiscCacheSize
+	}
+	return &cachingDisc{
+		APIClient: c,
+		ttl:       ttl,
+		size:      size,
+		entries:   make(map[cipher.PubKey]*cachedEntry),
+		lru:       list.New(),
+	}
+}
+
+// Entry implements disc.APIClient
+func (d *cachingDisc) Entry(ctx context.Context, pk cipher.PubKey) (*disc.Entry, error) {
+	if entry, ok := d.get(pk); ok {
+		return entry, nil
+	}
+	entry, err := d.APIClient.Entry(ctx, pk)
+	if err != nil {
+		return nil, err
+	}
+	d.put(pk, entry)
+	return copyEntry(entry), nil
+}
+
+// SetEntry implements disc.APIClient
+func (d *cachingDisc) SetEntry(ctx context.Context, entry *disc.Entry) error {
+	d.invalidate(entry.Static)
+	return d.APIClient.SetEntry(ctx, entry)
+}
+
+// UpdateEntry implements disc.APIClient
+func (d *cachingDisc) UpdateEntry(ctx context.Context, sk cipher.SecKey, entry *disc.Entry) error {
+	d.invalidate(entry.Static)
+	return d.APIClient.UpdateEntry(ctx, sk, entry)
+}
+
+func (d *cachingDisc) get(pk cipher.PubKey) (*disc.Entry, bool) {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+
+	ce, ok := d.entries[pk]
+	if ok && time.Now().After(ce.expires) {
+		d.remove(pk, ce)
+		ok = false
+	}
+	if !ok {
+		d.stats.Misses++
+		return nil, false
+	}
+	d.stats.Hits++
+	d.lru.MoveToFront(ce.elem)
+	return copyEntry(ce.entry), true
+}
+
+func (d *cachingDisc) put(pk cipher.PubKey, entry *disc.Entry) {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+
+	if ce, ok := d.entries[pk]; ok {
+		d.remove(pk, ce)
+	}
+	for d.lru.Len() >= d.size {
+		oldest := d.lru.Back().Value.(cipher.PubKey)
+		d.remove(oldest, d.entries[oldest])
+	}
+	d.entries[pk] = &cachedEntry{
+		entry:   copyEntry(entry),
+		expires: time.Now().Add(d.ttl),
+		elem:    d.lru.PushFront(pk),
+	}
+}
+
+// invalidate evicts the entry of pk, for example once a dial to pk failed, as the entry may be stale.
+func (d *cachingDisc) invalidate(pk cipher.PubKey) {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+
+	if ce, ok := d.entries[pk]; ok {
+		d.remove(pk, ce)
+	}
+}
+
+func (d *cachingDisc) remove(pk cipher.PubKey, ce *cachedEntry) {
+	d.lru.Remove(ce.elem)
+	delete(d.entries, pk)
+}
+
+// Stats returns the hits and misses of the cache so far.
+func (d *cachingDisc) Stats() DiscCacheStats {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	return d.stats
+}
+
+// copyEntry returns a copy of entry, so that callers modifying entries do not modify the cached ones.
+func copyEntry(entry *disc.Entry) *disc.Entry {
+	cp := *entry
+	if entry.Client != nil {
+		client := *entry.Client
+		client.DelegatedServers = append([]cipher.PubKey(nil), entry.Client.DelegatedServers...)
+		cp.Client = &client
+	}
+	if entry.Server != nil {
+		server := *entry.Server
+		cp.Server = &server
+	}
+	return &cp
+}
@@ -0,0 +1,211 @@
+package snet
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/SkycoinProject/dmsg"
+	"github.com/SkycoinProject/dmsg/cipher"
+	"github.com/SkycoinProject/dmsg/disc"
+	"github.com/SkycoinProject/skycoin/src/util/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/nettest"
+)
+
+// failingDisc is a disc.APIClient that always fails with 'err'.
+type failingDisc struct {
+	err   error
+	calls int
+}
+
+var errDiscDown = errors.New("discovery is down")
+
+func newFailingDisc(err error) *failingDisc { return &failingDisc{err: err} }
+
+func (d *failingDisc) Entry(context.Context, cipher.PubKey) (*disc.Entry, error) {
+	d.calls++
+	return nil, d.err
+}
+
+func (d *failingDisc) SetEntry(context.Context, *disc.Entry) error {
+	d.calls++
+	return d.err
+}
+
+func (d *failingDisc) UpdateEntry(context.Context, cipher.SecKey, *disc.Entry) error {
+	d.calls++
+	return d.err
+}
+
+func (d *failingDisc) AvailableServers(context.Context) ([]*disc.Entry, error) {
+	d.calls++
+	return nil, d.err
+}
+
+func TestFallbackDisc(t *testing.T) {
+	log := logging.MustGetLogger("fallback_disc")
+
+	t.Run("first_fails_second_succeeds", func(t *testing.T) {
+		down := newFailingDisc(errDiscDown)
+		up := disc.NewMock()
+
+		srvPK, srvSK := cipher.GenerateKeyPair()
+		l, err := nettest.NewLocalListener("tcp")
+		require.NoError(t, err)
+		srv, err := dmsg.NewServer(srvPK, srvSK, "", l, up)
+		require.NoError(t, err)
+		srvErr := make(chan error, 1)
+		go func() { srvErr <- srv.Serve() }()
+		defer func() {
+			require.NoError(t, srv.Close())
+			<-srvErr
+		}()
+
+		pk, sk := cipher.GenerateKeyPair()
+		dmsgC := dmsg.NewClient(pk, sk, newFallbackDisc(log, down, up))
+		defer func() { require.NoError(t, dmsgC.Close()) }()
+
+		require.NoError(t, dmsgC.InitiateServerConnections(context.TODO(), 1))
+		assert.NotZero(t, down.calls)
+
+		entry, err := up.Entry(context.TODO(), pk)
+		require.NoError(t, err)
+		assert.Equal(t, pk, entry.Static)
+	})
+
+	t.Run("all_fail", func(t *testing.T) {
+		d := newFallbackDisc(log, newFailingDisc(errDiscDown), newFailingDisc(errDiscDown))
+		_, err := d.AvailableServers(context.TODO())
+		assert.Equal(t, errDiscDown, err)
+	})
+
+	t.Run("response_is_not_retried", func(t *testing.T) {
+		second := newFailingDisc(errDiscDown)
+		d := newFallbackDisc(log, newFailingDisc(disc.ErrKeyNotFound), second)
+		pk, _ := cipher.GenerateKeyPair()
+		_, err := d.Entry(context.TODO(), pk)
+		assert.Equal(t, disc.ErrKeyNotFound, err)
+		assert.Zero(t, second.calls)
+	})
+
+	t.Run("no_discoveries", func(t *testing.T) {
+		_, err := NewDmsgDiscovery().AvailableServers(context.TODO())
+		assert.Equal(t, ErrNoDmsgDiscovery, err)
+	})
+}
+
+// countingDisc is a disc.APIClient which counts the entries looked up through it.
+type countingDisc struct {
+	disc.APIClient
+	entryCalls int
+}
+
+func (d *countingDisc) Entry(ctx context.Context, pk cipher.PubKey) (*disc.Entry, error) {
+	d.entryCalls++
+	return d.APIClient.Entry(ctx, pk)
+}
+
+func TestCachingDisc(t *testing.T) {
+	// setEntry registers a client entry of a new key pair with d.
+	setEntry := func(t *testing.T, d disc.APIClient) cipher.PubKey {
+		pk, sk := cipher.GenerateKeyPair()
+		entry := disc.NewClientEntry(pk, 0, nil)
+		require.NoError(t, entry.Sign(sk))
+		require.NoError(t, d.SetEntry(context.TODO(), entry))
+		return pk
+	}
+
+	t.Run("hits_avoid_backend", func(t *testing.T) {
+		backend := &countingDisc{APIClient: disc.NewMock()}
+		d := newCachingDisc(backend, time.Hour, 0)
+		pk := setEntry(t, d)
+
+		for i := 0; i < 3; i++ {
+			entry, err := d.Entry(context.TODO(), pk)
+			require.NoError(t, err)
+			assert.Equal(t, pk, entry.Static)
+		}
+		assert.Equal(t, 1, backend.entryCalls)
+		assert.Equal(t, DiscCacheStats{Hits: 2, Misses: 1}, d.Stats())
+
+		// Modifying a returned entry does not modify the cached one.
+		entry, err := d.Entry(context.TODO(), pk)
+		require.NoError(t, err)
+		entry.Client.DelegatedServers = append(entry.Client.DelegatedServers, pk)
+		entry, err = d.Entry(context.TODO(), pk)
+		require.NoError(t, err)
+		assert.Empty(t, entry.Client.DelegatedServers)
+	})
+
+	t.Run("errors_are_not_cached", func(t *testing.T) {
+		backend := &countingDisc{APIClient: disc.NewMock()}
+		d := newCachingDisc(backend, time.Hour, 0)
+		pk, _ := cipher.GenerateKeyPair()
+
+		for i := 0; i < 2; i++ {
+			_, err := d.Entry(context.TODO(), pk)
+			assert.Error(t, err)
+		}
+		assert.Equal(t, 2, backend.entryCalls)
+	})
+
+	t.Run("expiry", func(t *testing.T) {
+		backend := &countingDisc{APIClient: disc.NewMock()}
+		d := newCachingDisc(backend, time.Millisecond, 0)
+		pk := setEntry(t, d)
+
+		_, err := d.Entry(context.TODO(), pk)
+		require.NoError(t, err)
+		time.Sleep(10 * time.Millisecond)
+		_, err = d.Entry(context.TODO(), pk)
+		require.NoError(t, err)
+		assert.Equal(t, 2, backend.entryCalls)
+	})
+
+	t.Run("least_recently_used_is_evicted", func(t *testing.T) {
+		backend := &countingDisc{APIClient: disc.NewMock()}
+		d := newCachingDisc(backend, time.Hour, 2)
+		pk1, pk2, pk3 := setEntry(t, d), setEntry(t, d), setEntry(t, d)
+
+		for _, pk := range []cipher.PubKey{pk1, pk2, pk1, pk3} {
+			_, err := d.Entry(context.TODO(), pk)
+			require.NoError(t, err)
+		}
+		assert.Equal(t, 3, backend.entryCalls)
+
+		// pk2 was used least recently.
+		_, err := d.Entry(context.TODO(), pk1)
+		require.NoError(t, err)
+		assert.Equal(t, 3, backend.entryCalls)
+		_, err = d.Entry(context.TODO(), pk2)
+		require.NoError(t, err)
+		assert.Equal(t, 4, backend.entryCalls)
+	})
+
+	t.Run("failed_dial_invalidates", func(t *testing.T) {
+		backend := &countingDisc{APIClient: disc.NewMock()}
+		d := newCachingDisc(backend, time.Hour, 0)
+		pk := setEntry(t, d)
+
+		localPK, localSK := cipher.GenerateKeyPair()
+		n := NewRaw(Config{PubKey: localPK, SecKey: localSK}, dmsg.NewClient(localPK, localSK, d), nil)
+		n.discCache = d
+
+		_, err := d.Entry(context.TODO(), pk)
+		require.NoError(t, err)
+
+		// The remote has no delegated servers, so it can not be dialed.
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_, err = n.DialContext(ctx, DmsgType, pk, 1)
+		require.Error(t, err)
+
+		calls := backend.entryCalls
+		_, err = d.Entry(context.TODO(), pk)
+		require.NoError(t, err)
+		assert.Equal(t, calls+1, backend.entryCalls)
+	})
+}
@@ -0,0 +1,264 @@
+package snet
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultHeartbeatMisses is the number of consecutive unanswered pings after which a conn is closed,
+// used when ConnOptions.HeartbeatMisses is not set.
+const DefaultHeartbeatMisses = 3
+
+const (
+	frameHeaderLen  = 3
+	maxFramePayload = 0xffff
+
+	frameData = byte(0)
+	framePing = byte(1)
+	framePong = byte(2)
+)
+
+var (
+	// ErrHeartbeatTimeout occurs when a conn is closed because the remote end stopped answering pings.
+	ErrHeartbeatTimeout = errors.New("snet heartbeat timeout")
+
+	// ErrInvalidFrame occurs when a heartbeat conn receives a frame of unknown type.
+	ErrInvalidFrame = errors.New("invalid snet frame")
+)
+
+// heartbeatConn is a net.Conn that frames written data so that pings can be interleaved with it.
+// Every frame consists of a type byte, a big-endian uint16 payload length and the payload.
+//
+// A ping is sent every interval and answered by the reader of the remote end.
+// The interval adapts to the traffic of the conn, see heartbeatInterval.
+// Any frame received from the remote end counts as an answer.
+// Misses are only counted while a Read waits on the underlying conn, so that a conn which is not being read
+// is not mistaken for a dead one. Once misses pings in a row are unanswered, the conn is closed
+// and pending and further Reads return ErrHeartbeatTimeout.
+type heartbeatConn struct {
+	net.Conn
+	interval *heartbeatInterval // only used by heartbeat
+	current  int64              // current interval, read atomically
+	misses   int32
+	traffic  int32 // 1 once a data frame is read or written within the current interval
+
+	rx      sync.Mutex // serializes reads
+	rLeft   int        // unread payload bytes of the current data frame
+	waiting int32      // 1 while a Read waits on the underlying conn
+	missed  int32      // consecutive unanswered pings
+
+	wx      sync.Mutex // serializes frame writes
+	pinging int32      // 1 while a ping is being written
+	ponging int32      // 1 while a pong is being written
+
+	timedOut int32
+	done     chan struct{}
+	once     sync.Once
+}
+
+func newHeartbeatConn(conn net.Conn, minInterval, maxInterval time.Duration, misses int) *heartbeatConn {
+	if misses <= 0 {
+		misses = DefaultHeartbeatMisses
+	}
+	c := &heartbeatConn{
+		Conn:     conn,
+		interval: newHeartbeatInterval(minInterval, maxInterval),
+		current:  int64(minInterval),
+		misses:   int32(misses),
+		done:     make(chan struct{}),
+	}
+	go c.heartbeat()
+	return c
+}
+
+func (c *heartbeatConn) heartbeat() {
+	timer := time.NewTimer(c.currentInterval())
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-timer.C:
+			if atomic.LoadInt32(&c.waiting) == 1 {
+				if atomic.AddInt32(&c.missed, 1) > c.misses {
+					atomic.StoreInt32(&c.timedOut, 1)
+					_ = c.Close() //nolint:errcheck
+					return
+				}
+			}
+			c.sendControl(&c.pinging, framePing)
+
+			next := c.interval.next(atomic.SwapInt32(&c.traffic, 0) == 1)
+			atomic.StoreInt64(&c.current, int64(next))
+			timer.Reset(next)
+		}
+	}
+}
+
+// currentInterval returns the interval until the next ping.
+func (c *heartbeatConn) currentInterval() time.Duration {
+	return time.Duration(atomic.LoadInt64(&c.current))
+}
+
+// heartbeatInterval adapts the interval of heartbeat pings to the traffic of a conn within [min, max].
+// While data flows the remote end is evidently alive, so the interval doubles to save pings.
+// While the conn is idle the interval halves, so that a dead remote end is detected quickly.
+// If max is not above min, the interval is fixed.
+type heartbeatInterval struct {
+	min, max, cur time.Duration
+}
+
+func newHeartbeatInterval(min, max time.Duration) *heartbeatInterval {
+	if max < min {
+		max = min
+	}
+	return &heartbeatInterval{min: min, max: max, cur: min}
+}
+
+// next returns the interval until the next ping, given whether data was exchanged during the last interval.
+func (i *heartbeatInterval) next(active bool) time.Duration {
+	if active {
+		if i.cur *= 2; i.cur > i.max {
+			i.cur = i.max
+		}
+	} else {
+		if i.cur /= 2; i.cur < i.min {
+			i.cur = i.min
+		}
+	}
+	return i.cur
+}
+
+// sendControl writes a ping or pong frame in the background, as writes to an unresponsive peer may block.
+// The frame is skipped if a previous one of the same type is still being written.
+func (c *heartbeatConn) sendControl(inFlight *int32, typ byte) {
+	if !atomic.CompareAndSwapInt32(inFlight, 0, 1) {
+		return
+	}
+	go func() {
+		defer atomic.StoreInt32(inFlight, 0)
+		if err := c.writeFrame(typ, nil); err != nil {
+			_ = c.Close() //nolint:errcheck
+		}
+	}()
+}
+
+// Read implements io.Reader
+func (c *heartbeatConn) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	c.rx.Lock()
+	defer c.rx.Unlock()
+
+	for c.rLeft == 0 {
+		var hdr [frameHeaderLen]byte
+		if err := c.readFull(hdr[:]); err != nil {
+			return 0, err
+		}
+		n := int(binary.BigEndian.Uint16(hdr[1:]))
+
+		switch hdr[0] {
+		case frameData:
+			c.rLeft = n
+			atomic.StoreInt32(&c.traffic, 1)
+		case framePing, framePong:
+			if err := c.readFull(make([]byte, n)); err != nil {
+				return 0, err
+			}
+			if hdr[0] == framePing {
+				c.sendControl(&c.ponging, framePong)
+			}
+		default:
+			_ = c.Close() //nolint:errcheck
+			return 0, ErrInvalidFrame
+		}
+	}
+
+	if len(p) > c.rLeft {
+		p = p[:c.rLeft]
+	}
+	n, err := c.read(p)
+	c.rLeft -= n
+	return n, err
+}
+
+func (c *heartbeatConn) readFull(p []byte) error {
+	for len(p) > 0 {
+		n, err := c.read(p)
+		if err != nil {
+			return err
+		}
+		p = p[n:]
+	}
+	return nil
+}
+
+func (c *heartbeatConn) read(p []byte) (int, error) {
+	atomic.StoreInt32(&c.missed, 0)
+	atomic.StoreInt32(&c.waiting, 1)
+	n, err := c.Conn.Read(p)
+	atomic.StoreInt32(&c.waiting, 0)
+	if err != nil {
+		if err == io.EOF && n > 0 {
+			err = nil
+		}
+		return n, c.err(err)
+	}
+	return n, nil
+}
+
+// Write implements io.Writer
+func (c *heartbeatConn) Write(p []byte) (int, error) {
+	var written int
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > maxFramePayload {
+			chunk = chunk[:maxFramePayload]
+		}
+		if err := c.writeFrame(frameData, chunk); err != nil {
+			return written, c.err(err)
+		}
+		atomic.StoreInt32(&c.traffic, 1)
+		written += len(chunk)
+		p = p[len(chunk):]
+	}
+	return written, nil
+}
+
+func (c *heartbeatConn) writeFrame(typ byte, payload []byte) error {
+	frame := make([]byte, frameHeaderLen+len(payload))
+	frame[0] = typ
+	binary.BigEndian.PutUint16(frame[1:], uint16(len(payload)))
+	copy(frame[frameHeaderLen:], payload)
+
+	c.wx.Lock()
+	defer c.wx.Unlock()
+
+	_, err := c.Conn.Write(frame)
+	return err
+}
+
+func (c *heartbeatConn) err(err error) error {
+	if err != nil && atomic.LoadInt32(&c.timedOut) == 1 {
+		return ErrHeartbeatTimeout
+	}
+	return err
+}
+
+// Close implements io.Closer
+func (c *heartbeatConn) Close() error {
+	var err error
+	c.once.Do(func() {
+		close(c.done)
+		err = c.Conn.Close()
+	})
+	return err
+}
@@ -0,0 +1,184 @@
+package snet
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiateConn_Heartbeat(t *testing.T) {
+	const interval = 10 * time.Millisecond
+
+	cases := []struct {
+		name          string
+		iOpts, rOpts  ConnOptions
+		wantHeartbeat bool
+	}{
+		{"both_heartbeat", ConnOptions{HeartbeatInterval: interval}, ConnOptions{HeartbeatInterval: interval}, true},
+		{"initiator_heartbeats", ConnOptions{HeartbeatInterval: interval}, ConnOptions{}, false},
+		{"both_heartbeat_and_compress",
+			ConnOptions{Compress: true, HeartbeatInterval: interval},
+			ConnOptions{Compress: true, HeartbeatInterval: interval}, true},
+	}
+
+	// Larger than a single frame.
+	msg := bytes.Repeat([]byte("skywire heartbeat payload "), 4096)
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			iConn, rConn := negotiatePipeOpts(t, tc.iOpts, tc.rOpts)
+			defer func() {
+				assert.NoError(t, iConn.Close())
+				assert.NoError(t, rConn.Close())
+			}()
+
+			_, iHeartbeat := unwrapCompressed(iConn).(*heartbeatConn)
+			_, rHeartbeat := unwrapCompressed(rConn).(*heartbeatConn)
+			assert.Equal(t, tc.wantHeartbeat, iHeartbeat)
+			assert.Equal(t, tc.wantHeartbeat, rHeartbeat)
+
+			roundTrip(t, iConn, rConn, msg)
+			roundTrip(t, rConn, iConn, msg)
+		})
+	}
+}
+
+func TestHeartbeatConn_Alive(t *testing.T) {
+	const interval = 10 * time.Millisecond
+
+	opts := ConnOptions{HeartbeatInterval: interval, HeartbeatMisses: 2}
+	iConn, rConn := negotiatePipeOpts(t, opts, opts)
+	defer func() {
+		assert.NoError(t, iConn.Close())
+		assert.NoError(t, rConn.Close())
+	}()
+
+	// Keep the responder answering pings.
+	go func() {
+		_, _ = io.Copy(ioutil.Discard, rConn) //nolint:errcheck
+	}()
+
+	// The initiator waits far longer than misses * interval for data that arrives late.
+	msg := []byte("late but alive")
+	go func() {
+		time.Sleep(20 * interval)
+		_, _ = rConn.Write(msg) //nolint:errcheck
+	}()
+
+	got := make([]byte, len(msg))
+	_, err := io.ReadFull(iConn, got)
+	require.NoError(t, err)
+	assert.Equal(t, msg, got)
+}
+
+func TestHeartbeatConn_UnresponsivePeer(t *testing.T) {
+	const interval = 10 * time.Millisecond
+
+	iRaw, rRaw := net.Pipe()
+	defer func() { assert.NoError(t, rRaw.Close()) }()
+
+	// The responder agrees to heartbeats, then stops responding.
+	go func() {
+		if _, err := readHello(rRaw); err != nil {
+			return
+		}
+		_ = writeHello(rRaw, hello{version: helloVersion, flags: helloFlagHeartbeat}) //nolint:errcheck
+	}()
+
+	iConn, err := NegotiateConn(iRaw, true, ConnOptions{HeartbeatInterval: interval, HeartbeatMisses: 3})
+	require.NoError(t, err)
+	_, ok := iConn.(*heartbeatConn)
+	require.True(t, ok)
+
+	start := time.Now()
+	_, err = iConn.Read(make([]byte, 1))
+	assert.Equal(t, ErrHeartbeatTimeout, err)
+	assert.True(t, time.Since(start) >= 3*interval)
+
+	_, err = iConn.Read(make([]byte, 1))
+	assert.Error(t, err)
+}
+
+func unwrapCompressed(conn net.Conn) net.Conn {
+	if cc, ok := conn.(*compressedConn); ok {
+		return cc.Conn
+	}
+	return conn
+}
+
+func TestHeartbeatInterval(t *testing.T) {
+	const (
+		min = 10 * time.Millisecond
+		max = 80 * time.Millisecond
+	)
+
+	t.Run("adaptive", func(t *testing.T) {
+		i := newHeartbeatInterval(min, max)
+
+		// The interval lengthens under continuous traffic, up to max.
+		var got []time.Duration
+		for j := 0; j < 5; j++ {
+			got = append(got, i.next(true))
+		}
+		assert.Equal(t, []time.Duration{2 * min, 4 * min, max, max, max}, got)
+
+		// The interval shortens while idle, down to min.
+		got = nil
+		for j := 0; j < 5; j++ {
+			got = append(got, i.next(false))
+		}
+		assert.Equal(t, []time.Duration{4 * min, 2 * min, min, min, min}, got)
+	})
+
+	t.Run("fixed", func(t *testing.T) {
+		for _, maxInterval := range []time.Duration{0, min} {
+			i := newHeartbeatInterval(min, maxInterval)
+			assert.Equal(t, min, i.next(true))
+			assert.Equal(t, min, i.next(false))
+		}
+	})
+}
+
+// The adaptation itself is tested by TestHeartbeatInterval, here only that a conn applies it.
+// Intervals are coarse, so that scheduling delays (e.g. with -race) do not matter.
+func TestHeartbeatConn_AdaptiveInterval(t *testing.T) {
+	const (
+		min = 50 * time.Millisecond
+		max = 400 * time.Millisecond
+	)
+
+	opts := ConnOptions{HeartbeatInterval: min, HeartbeatMaxInterval: max}
+	iConn, rConn := negotiatePipeOpts(t, opts, opts)
+	defer func() {
+		assert.NoError(t, iConn.Close())
+		assert.NoError(t, rConn.Close())
+	}()
+	hbConn := iConn.(*heartbeatConn)
+
+	go func() {
+		_, _ = io.Copy(ioutil.Discard, rConn) //nolint:errcheck
+	}()
+	assert.Equal(t, min, hbConn.currentInterval())
+
+	// waitInterval waits until the interval satisfies ok, writing to the conn meanwhile if traffic is set.
+	waitInterval := func(what string, traffic bool, ok func(time.Duration) bool) {
+		for deadline := time.Now().Add(10 * time.Second); !ok(hbConn.currentInterval()); {
+			require.True(t, time.Now().Before(deadline), "interval did not %s", what)
+			if traffic {
+				_, err := iConn.Write([]byte("traffic"))
+				require.NoError(t, err)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// Continuous traffic lengthens the interval, idling shortens it back to min.
+	waitInterval("lengthen", true, func(d time.Duration) bool { return d > min })
+	waitInterval("shorten", false, func(d time.Duration) bool { return d == min })
+}
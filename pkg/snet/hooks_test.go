@@ -0,0 +1,90 @@
+package snet_test
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/SkycoinProject/dmsg/cipher"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/SkycoinProject/skywire-mainnet/pkg/snet"
+	"github.com/SkycoinProject/skywire-mainnet/pkg/snet/snettest"
+)
+
+func TestNetwork_Hooks(t *testing.T) {
+	const port = uint16(80)
+
+	keys := snettest.GenKeyPairs(2)
+	events := make(chan string, 16)
+
+	env := snettest.NewEnvWithConfig(t, keys, func(conf *snet.Config) {
+		conf.OnDial = func(network string, rPK cipher.PubKey, rPort uint16, err error) {
+			events <- fmt.Sprintf("dial %s %s:%d %v", network, rPK, rPort, err == nil)
+		}
+		conf.OnAccept = func(network string, remote net.Addr) {
+			events <- fmt.Sprintf("accept %s %s", network, remote)
+		}
+		conf.OnClose = func(network string, remote net.Addr) {
+			events <- fmt.Sprintf("close %s %s", network, remote)
+		}
+	})
+	defer env.Teardown()
+
+	// CLOSURE: obtains the next hook event.
+	nextEvent := func(t *testing.T) string {
+		select {
+		case e := <-events:
+			return e
+		case <-time.After(5 * time.Second):
+			t.Fatal("hook was not called")
+			return ""
+		}
+	}
+
+	for _, network := range []string{snet.DmsgType, snet.STcpType} {
+		t.Run(network, func(t *testing.T) {
+			lis, err := env.Nets[0].Listen(network, port)
+			require.NoError(t, err)
+			defer func() { assert.NoError(t, lis.Close()) }()
+
+			dialCh := make(chan *snet.Conn, 1)
+			go func() {
+				conn, err := env.Nets[1].Dial(network, keys[0].PK, port)
+				assert.NoError(t, err)
+				dialCh <- conn
+			}()
+
+			rConn, err := lis.AcceptConn()
+			require.NoError(t, err)
+			lConn := <-dialCh
+			require.NotNil(t, lConn)
+
+			assert.ElementsMatch(t, []string{
+				fmt.Sprintf("dial %s %s:%d true", network, keys[0].PK, port),
+				fmt.Sprintf("accept %s %s", network, rConn.RemoteAddr()),
+			}, []string{nextEvent(t), nextEvent(t)})
+
+			require.NoError(t, lConn.Close())
+			assert.Equal(t, fmt.Sprintf("close %s %s", network, lConn.RemoteAddr()), nextEvent(t))
+			require.NoError(t, rConn.Close())
+			assert.Equal(t, fmt.Sprintf("close %s %s", network, rConn.RemoteAddr()), nextEvent(t))
+
+			// Closing again should not call the hook.
+			_ = lConn.Close() //nolint:errcheck
+			select {
+			case e := <-events:
+				t.Fatalf("unexpected event: %s", e)
+			default:
+			}
+		})
+	}
+
+	t.Run("dial_failure", func(t *testing.T) {
+		_, err := env.Nets[1].Dial(snet.STcpType, keys[0].PK, port+1)
+		require.Error(t, err)
+		assert.Equal(t, fmt.Sprintf("dial %s %s:%d false", snet.STcpType, keys[0].PK, port+1), nextEvent(t))
+	})
+}
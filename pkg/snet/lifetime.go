@@ -0,0 +1,61 @@
+package snet
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// ErrConnLifetimeExceeded occurs on use of a connection which was closed as it reached Config.MaxConnLifetime.
+// The connection may be re-established by dialing again.
+var ErrConnLifetimeExceeded = errors.New("snet connection lifetime exceeded")
+
+// lifetimeConn closes the underlying conn once it has been open for its maximum lifetime.
+// Pending and further Reads and Writes then return ErrConnLifetimeExceeded.
+type lifetimeConn struct {
+	net.Conn
+	timer   *time.Timer
+	expired int32
+}
+
+// withLifetime wraps conn to be closed after lifetime. A lifetime of 0 or less leaves conn as is.
+func withLifetime(conn net.Conn, lifetime time.Duration) net.Conn {
+	if lifetime <= 0 {
+		return conn
+	}
+	c := &lifetimeConn{Conn: conn}
+	c.timer = time.AfterFunc(lifetime, func() {
+		atomic.StoreInt32(&c.expired, 1)
+		_ = c.Conn.Close() //nolint:errcheck
+	})
+	return c
+}
+
+// Read implements io.Reader
+func (c *lifetimeConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	return n, c.err(err)
+}
+
+// Write implements io.Writer
+func (c *lifetimeConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	return n, c.err(err)
+}
+
+// Close implements io.Closer. Closing a conn which already exceeded its lifetime is a no-op.
+func (c *lifetimeConn) Close() error {
+	c.timer.Stop()
+	if atomic.LoadInt32(&c.expired) == 1 {
+		return nil
+	}
+	return c.Conn.Close()
+}
+
+func (c *lifetimeConn) err(err error) error {
+	if err != nil && atomic.LoadInt32(&c.expired) == 1 {
+		return ErrConnLifetimeExceeded
+	}
+	return err
+}
@@ -0,0 +1,87 @@
+package snet_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/SkycoinProject/dmsg/cipher"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/SkycoinProject/skywire-mainnet/pkg/snet"
+	"github.com/SkycoinProject/skywire-mainnet/pkg/snet/mem"
+	"github.com/SkycoinProject/skywire-mainnet/pkg/snet/snettest"
+)
+
+func TestNetwork_ListenAllowed(t *testing.T) {
+	const port = uint16(82)
+
+	keys := snettest.GenKeyPairs(3)
+	env := snettest.NewEnv(t, keys)
+	defer env.Teardown()
+
+	for _, network := range []string{snet.DmsgType, snet.STcpType} {
+		t.Run(network, func(t *testing.T) {
+			lis, err := env.Nets[0].ListenAllowed(network, port, []cipher.PubKey{keys[1].PK})
+			require.NoError(t, err)
+			defer func() { assert.NoError(t, lis.Close()) }()
+
+			acceptCh := make(chan *snet.Conn, 1)
+			go func() {
+				conn, err := lis.AcceptConn()
+				assert.NoError(t, err)
+				acceptCh <- conn
+			}()
+
+			// The connection of the peer which is not allowed is closed by the listener, it is never accepted.
+			// Its dial may still succeed, as dialers without features send no hello which would fail.
+			if rejected, err := env.Nets[2].Dial(network, keys[0].PK, port); err == nil {
+				assert.NoError(t, rejected.Close())
+			}
+
+			conn, err := env.Nets[1].Dial(network, keys[0].PK, port)
+			require.NoError(t, err)
+			defer func() { assert.NoError(t, conn.Close()) }()
+
+			rConn := <-acceptCh
+			require.NotNil(t, rConn)
+			defer func() { assert.NoError(t, rConn.Close()) }()
+			assert.Equal(t, keys[1].PK, rConn.RemotePK())
+		})
+	}
+}
+
+// Ensure that a dialer which stays silent does not hold up the connections of other dialers.
+func TestListener_SilentDialer(t *testing.T) {
+	const port = uint16(83)
+
+	keys := snettest.GenKeyPairs(3)
+	hub := mem.NewHub()
+	n0 := snet.NewRaw(snet.Config{PubKey: keys[0].PK, EnableCompression: true}, nil, nil).
+		WithMem(mem.NewClient(hub, keys[0].PK))
+	defer func() { assert.NoError(t, n0.Close()) }()
+	n1 := snet.NewRaw(snet.Config{PubKey: keys[1].PK, EnableCompression: true}, nil, nil).
+		WithMem(mem.NewClient(hub, keys[1].PK))
+	defer func() { assert.NoError(t, n1.Close()) }()
+
+	lis, err := n0.Listen(snet.MemType, port)
+	require.NoError(t, err)
+	defer func() { assert.NoError(t, lis.Close()) }()
+
+	// The listener requests compression, so it waits for the hello of each dialer.
+	silent, err := mem.NewClient(hub, keys[2].PK).Dial(context.TODO(), keys[0].PK, port)
+	require.NoError(t, err)
+	defer func() { assert.NoError(t, silent.Close()) }()
+
+	start := time.Now()
+	conn, err := n1.Dial(snet.MemType, keys[0].PK, port)
+	require.NoError(t, err)
+	defer func() { assert.NoError(t, conn.Close()) }()
+
+	rConn, err := lis.AcceptConn()
+	require.NoError(t, err)
+	defer func() { assert.NoError(t, rConn.Close()) }()
+	assert.Equal(t, keys[1].PK, rConn.RemotePK())
+	assert.True(t, time.Since(start) < snet.HandshakeTimeout)
+}
@@ -0,0 +1,36 @@
+package snet
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/SkycoinProject/dmsg/cipher"
+	"github.com/SkycoinProject/skycoin/src/util/logging"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfig_Logger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := logging.NewMasterLogger()
+	logger.Out = &buf
+	logger.Formatter = &logrus.JSONFormatter{}
+
+	n := NewRaw(Config{Logger: logger}, nil, nil)
+	defer func() { assert.NoError(t, n.Close()) }()
+
+	pk, _ := cipher.GenerateKeyPair()
+	_, err := n.Dial(MemType, pk, 5)
+	require.Equal(t, ErrNetworkNotConfigured, err)
+
+	var entry map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+	assert.Equal(t, "snet", entry["_module"])
+	assert.Equal(t, "dial", entry["event"])
+	assert.Equal(t, MemType, entry["network"])
+	assert.Equal(t, pk.Hex(), entry["remote_pk"])
+	assert.Equal(t, float64(5), entry["remote_port"])
+	assert.Equal(t, ErrNetworkNotConfigured.Error(), entry["error"])
+}
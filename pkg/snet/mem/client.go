@@ -0,0 +1,257 @@
+// Package mem implements an in-memory network type backed by net.Pipe.
+// It is intended for tests which do not need real sockets.
+package mem
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/SkycoinProject/dmsg"
+	"github.com/SkycoinProject/dmsg/cipher"
+
+	"github.com/SkycoinProject/skywire-mainnet/pkg/snet/stcp"
+)
+
+var (
+	// ErrUnknownPK occurs when dialing a public key that is not registered in the Hub.
+	ErrUnknownPK = errors.New("public key is not registered in hub")
+
+	// ErrNotListening occurs when dialing a port that the remote client does not listen on.
+	ErrNotListening = errors.New("not listening on given port")
+)
+
+// Hub connects in-memory clients with each other.
+type Hub struct {
+	clients map[cipher.PubKey]*Client
+	mx      sync.RWMutex
+}
+
+// NewHub creates a new Hub.
+func NewHub() *Hub {
+	return &Hub{clients: make(map[cipher.PubKey]*Client)}
+}
+
+func (h *Hub) client(pk cipher.PubKey) (*Client, bool) {
+	h.mx.RLock()
+	defer h.mx.RUnlock()
+	c, ok := h.clients[pk]
+	return c, ok
+}
+
+func (h *Hub) register(c *Client) {
+	h.mx.Lock()
+	h.clients[c.lPK] = c
+	h.mx.Unlock()
+}
+
+func (h *Hub) deregister(c *Client) {
+	h.mx.Lock()
+	if h.clients[c.lPK] == c {
+		delete(h.clients, c.lPK)
+	}
+	h.mx.Unlock()
+}
+
+// Conn wraps one end of a net.Pipe and modifies various methods to integrate better with the 'network' package.
+type Conn struct {
+	net.Conn
+	lAddr    dmsg.Addr
+	rAddr    dmsg.Addr
+	freePort func()
+}
+
+// LocalAddr implements net.Conn
+func (c *Conn) LocalAddr() net.Addr {
+	return c.lAddr
+}
+
+// RemoteAddr implements net.Conn
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.rAddr
+}
+
+// Close implements net.Conn
+func (c *Conn) Close() error {
+	if c.freePort != nil {
+		c.freePort()
+	}
+	return c.Conn.Close()
+}
+
+// Listener implements net.Listener
+type Listener struct {
+	lAddr    dmsg.Addr
+	freePort func()
+	accept   chan *Conn
+	done     chan struct{}
+	once     sync.Once
+}
+
+func newListener(lAddr dmsg.Addr, freePort func()) *Listener {
+	return &Listener{
+		lAddr:    lAddr,
+		freePort: freePort,
+		accept:   make(chan *Conn),
+		done:     make(chan struct{}),
+	}
+}
+
+func (l *Listener) introduce(ctx context.Context, conn *Conn) error {
+	select {
+	case l.accept <- conn:
+		return nil
+	case <-l.done:
+		return io.ErrClosedPipe
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Accept implements net.Listener
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.accept:
+		return conn, nil
+	case <-l.done:
+		return nil, io.ErrClosedPipe
+	}
+}
+
+// Close implements net.Listener
+func (l *Listener) Close() error {
+	l.once.Do(func() {
+		close(l.done)
+		l.freePort()
+	})
+	return nil
+}
+
+// Addr implements net.Listener
+func (l *Listener) Addr() net.Addr {
+	return l.lAddr
+}
+
+// Client is the central control for incoming and outgoing 'mem.Conn's.
+type Client struct {
+	lPK cipher.PubKey
+	hub *Hub
+	p   *stcp.Porter
+
+	lMap map[uint16]*Listener // key: lPort
+	mx   sync.Mutex
+
+	done chan struct{}
+	once sync.Once
+}
+
+// NewClient creates a Client and registers it in the Hub.
+func NewClient(hub *Hub, pk cipher.PubKey) *Client {
+	c := &Client{
+		lPK:  pk,
+		hub:  hub,
+		p:    stcp.NewPorter(stcp.PorterMinEphemeral),
+		lMap: make(map[uint16]*Listener),
+		done: make(chan struct{}),
+	}
+	hub.register(c)
+	return c
+}
+
+// Dial dials a new mem.Conn to specified remote public key and port.
+func (c *Client) Dial(ctx context.Context, rPK cipher.PubKey, rPort uint16) (*Conn, error) {
+	if c.isClosed() {
+		return nil, io.ErrClosedPipe
+	}
+
+	remote, ok := c.hub.client(rPK)
+	if !ok {
+		return nil, ErrUnknownPK
+	}
+	lis, ok := remote.listener(rPort)
+	if !ok {
+		return nil, ErrNotListening
+	}
+
+	lPort, freePort, err := c.p.ReserveEphemeral(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	lAddr := dmsg.Addr{PK: c.lPK, Port: lPort}
+	rAddr := dmsg.Addr{PK: rPK, Port: rPort}
+	lConn, rConn := net.Pipe()
+
+	if err := lis.introduce(ctx, &Conn{Conn: rConn, lAddr: rAddr, rAddr: lAddr}); err != nil {
+		freePort()
+		_ = lConn.Close() //nolint:errcheck
+		_ = rConn.Close() //nolint:errcheck
+		return nil, err
+	}
+	return &Conn{Conn: lConn, lAddr: lAddr, rAddr: rAddr, freePort: freePort}, nil
+}
+
+// Listen creates a new listener for mem.
+func (c *Client) Listen(lPort uint16) (*Listener, error) {
+	if c.isClosed() {
+		return nil, io.ErrClosedPipe
+	}
+
+	ok, freePort := c.p.Reserve(lPort)
+	if !ok {
+		return nil, errors.New("port is already occupied")
+	}
+
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	lis := newListener(dmsg.Addr{PK: c.lPK, Port: lPort}, func() {
+		c.mx.Lock()
+		delete(c.lMap, lPort)
+		c.mx.Unlock()
+		freePort()
+	})
+	c.lMap[lPort] = lis
+	return lis, nil
+}
+
+func (c *Client) listener(port uint16) (*Listener, bool) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	lis, ok := c.lMap[port]
+	return lis, ok
+}
+
+// Close closes the Client.
+func (c *Client) Close() error {
+	if c == nil {
+		return nil
+	}
+	c.once.Do(func() {
+		close(c.done)
+		c.hub.deregister(c)
+
+		c.mx.Lock()
+		lis := make([]*Listener, 0, len(c.lMap))
+		for _, l := range c.lMap {
+			lis = append(lis, l)
+		}
+		c.mx.Unlock()
+
+		for _, l := range lis {
+			_ = l.Close() // nolint:errcheck
+		}
+	})
+	return nil
+}
+
+func (c *Client) isClosed() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
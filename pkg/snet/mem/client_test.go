@@ -0,0 +1,70 @@
+package mem
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/SkycoinProject/dmsg"
+	"github.com/SkycoinProject/dmsg/cipher"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient(t *testing.T) {
+	const port = uint16(80)
+
+	hub := NewHub()
+	pk1, _ := cipher.GenerateKeyPair()
+	pk2, _ := cipher.GenerateKeyPair()
+
+	c1 := NewClient(hub, pk1)
+	defer func() { assert.NoError(t, c1.Close()) }()
+	c2 := NewClient(hub, pk2)
+	defer func() { assert.NoError(t, c2.Close()) }()
+
+	_, err := c1.Dial(context.TODO(), pk2, port)
+	assert.Equal(t, ErrNotListening, err)
+
+	lis, err := c2.Listen(port)
+	require.NoError(t, err)
+
+	type result struct {
+		conn *Conn
+		err  error
+	}
+	dialCh := make(chan result, 1)
+	go func() {
+		conn, err := c1.Dial(context.TODO(), pk2, port)
+		dialCh <- result{conn, err}
+	}()
+
+	rConn, err := lis.Accept()
+	require.NoError(t, err)
+	res := <-dialCh
+	require.NoError(t, res.err)
+	lConn := res.conn
+
+	assert.Equal(t, dmsg.Addr{PK: pk2, Port: port}, rConn.LocalAddr())
+	assert.Equal(t, lConn.LocalAddr(), rConn.RemoteAddr())
+	assert.Equal(t, rConn.LocalAddr(), lConn.RemoteAddr())
+
+	msg := []byte("hello over memory")
+	go func() {
+		_, _ = lConn.Write(msg) //nolint:errcheck
+	}()
+	got := make([]byte, len(msg))
+	_, err = io.ReadFull(rConn, got)
+	require.NoError(t, err)
+	assert.Equal(t, msg, got)
+
+	require.NoError(t, lConn.Close())
+	require.NoError(t, rConn.Close())
+
+	require.NoError(t, lis.Close())
+	_, err = c1.Dial(context.TODO(), pk2, port)
+	assert.Equal(t, ErrNotListening, err)
+
+	_, err = c1.Dial(context.TODO(), cipher.PubKey{}, port)
+	assert.Equal(t, ErrUnknownPK, err)
+}
@@ -0,0 +1,143 @@
+package snet
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/SkycoinProject/dmsg"
+	"github.com/SkycoinProject/dmsg/cipher"
+	"github.com/SkycoinProject/skycoin/src/util/logging"
+)
+
+// MultiListener listens on the same port across multiple network types.
+// Connections accepted by any of the underlying listeners are returned by a single Accept call.
+// If an underlying listener fails, the failure is logged and the others keep accepting.
+// Once all of them failed, Accept returns the first failure.
+type MultiListener struct {
+	log   *logging.Logger
+	lPK   cipher.PubKey
+	lPort uint16
+	ls    []*Listener
+
+	accept chan *Conn
+	done   chan struct{}
+	once   sync.Once
+
+	errMx sync.Mutex
+	err   error // first failure of an underlying listener
+}
+
+func makeMultiListener(log *logging.Logger, lPK cipher.PubKey, lPort uint16, ls []*Listener) *MultiListener {
+	ml := &MultiListener{
+		log:    log,
+		lPK:    lPK,
+		lPort:  lPort,
+		ls:     ls,
+		accept: make(chan *Conn),
+		done:   make(chan struct{}),
+	}
+
+	wg := new(sync.WaitGroup)
+	wg.Add(len(ls))
+	for _, l := range ls {
+		go func(l *Listener) {
+			defer wg.Done()
+			ml.acceptLoop(l)
+		}(l)
+	}
+	go func() {
+		wg.Wait()
+		close(ml.accept)
+	}()
+
+	return ml
+}
+
+func (ml *MultiListener) acceptLoop(l *Listener) {
+	for {
+		conn, err := l.AcceptConn()
+		if err != nil {
+			ml.fail(l.Network(), err)
+			return
+		}
+		select {
+		case ml.accept <- conn:
+		case <-ml.done:
+			_ = conn.Close() //nolint:errcheck
+			return
+		}
+	}
+}
+
+// fail records the failure of the underlying listener of the given network type, unless ml is closed.
+func (ml *MultiListener) fail(network string, err error) {
+	select {
+	case <-ml.done:
+		return
+	default:
+	}
+	ml.log.WithError(err).Warnf("stopped accepting connections on network '%s'", network)
+
+	ml.errMx.Lock()
+	defer ml.errMx.Unlock()
+	if ml.err == nil {
+		ml.err = fmt.Errorf("failed to accept on network '%s': %v", network, err)
+	}
+}
+
+// LocalPK returns a local public key of listener.
+func (ml *MultiListener) LocalPK() cipher.PubKey { return ml.lPK }
+
+// LocalPort returns a local port of listener.
+func (ml *MultiListener) LocalPort() uint16 { return ml.lPort }
+
+// Networks returns the network types the listener is listening on.
+func (ml *MultiListener) Networks() []string {
+	networks := make([]string, len(ml.ls))
+	for i, l := range ml.ls {
+		networks[i] = l.Network()
+	}
+	return networks
+}
+
+// AcceptConn accepts a connection arriving on any of the underlying networks.
+// The network type of the connection can be obtained via (*Conn).Network.
+// Once all underlying listeners failed, it returns the first failure, once ml is closed io.ErrClosedPipe.
+func (ml *MultiListener) AcceptConn() (*Conn, error) {
+	conn, ok := <-ml.accept
+	if !ok {
+		ml.errMx.Lock()
+		defer ml.errMx.Unlock()
+		if ml.err != nil {
+			return nil, ml.err
+		}
+		return nil, io.ErrClosedPipe
+	}
+	return conn, nil
+}
+
+// Accept implements net.Listener
+func (ml *MultiListener) Accept() (net.Conn, error) {
+	return ml.AcceptConn()
+}
+
+// Addr implements net.Listener
+func (ml *MultiListener) Addr() net.Addr {
+	return dmsg.Addr{PK: ml.lPK, Port: ml.lPort}
+}
+
+// Close closes all underlying listeners.
+func (ml *MultiListener) Close() error {
+	var err error
+	ml.once.Do(func() {
+		close(ml.done)
+		for _, l := range ml.ls {
+			if lErr := l.Close(); lErr != nil && err == nil {
+				err = lErr
+			}
+		}
+	})
+	return err
+}
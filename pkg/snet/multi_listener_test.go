@@ -0,0 +1,90 @@
+package snet_test
+
+import (
+	"testing"
+
+	"github.com/SkycoinProject/dmsg/cipher"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/SkycoinProject/skywire-mainnet/pkg/snet"
+	"github.com/SkycoinProject/skywire-mainnet/pkg/snet/mem"
+	"github.com/SkycoinProject/skywire-mainnet/pkg/snet/snettest"
+)
+
+func TestNetwork_ListenAll(t *testing.T) {
+	const port = uint16(80)
+
+	keys := snettest.GenKeyPairs(2)
+	env := snettest.NewEnv(t, keys)
+	defer env.Teardown()
+
+	ml, err := env.Nets[0].ListenAll(port)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{snet.DmsgType, snet.STcpType}, ml.Networks())
+
+	for _, network := range []string{snet.DmsgType, snet.STcpType} {
+		dialCh := make(chan error, 1)
+		go func(network string) {
+			conn, err := env.Nets[1].Dial(network, keys[0].PK, port)
+			if err == nil {
+				_, err = conn.Write([]byte(network))
+			}
+			dialCh <- err
+		}(network)
+
+		conn, err := ml.AcceptConn()
+		require.NoError(t, err)
+		require.NoError(t, <-dialCh)
+
+		assert.Equal(t, network, conn.Network())
+		assert.Equal(t, keys[1].PK, conn.RemotePK())
+		assert.Equal(t, port, conn.LocalPort())
+
+		b := make([]byte, len(network))
+		_, err = conn.Read(b)
+		require.NoError(t, err)
+		assert.Equal(t, network, string(b))
+	}
+
+	require.NoError(t, ml.Close())
+	_, err = ml.AcceptConn()
+	assert.Error(t, err)
+
+}
+
+func TestNetwork_ListenAll_NotReady(t *testing.T) {
+	pk, sk := cipher.GenerateKeyPair()
+
+	// dmsg is configured, but not connected to any server.
+	n := snet.New(snet.Config{
+		PubKey:        pk,
+		SecKey:        sk,
+		TpNetworks:    []string{snet.DmsgType},
+		DmsgDiscAddrs: []string{"http://localhost:9090"},
+		DmsgMinSrvs:   1,
+	})
+	defer func() { assert.NoError(t, n.Close()) }()
+
+	_, err := n.ListenAll(80)
+	assert.Equal(t, snet.ErrNoReadyNetworks, err)
+}
+
+func TestMultiListener_AcceptError(t *testing.T) {
+	pk, _ := cipher.GenerateKeyPair()
+	memC := mem.NewClient(mem.NewHub(), pk)
+	n := snet.NewRaw(snet.Config{PubKey: pk}, nil, nil).WithMem(memC)
+	defer func() { assert.NoError(t, n.Close()) }()
+
+	ml, err := n.ListenAll(80)
+	require.NoError(t, err)
+	assert.Equal(t, []string{snet.MemType}, ml.Networks())
+
+	// Closing the client fails its listener, which is the only one.
+	require.NoError(t, memC.Close())
+	_, err = ml.AcceptConn()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to accept on network 'mem'")
+
+	require.NoError(t, ml.Close())
+}
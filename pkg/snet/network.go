@@ -2,19 +2,25 @@ package snet
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
+	"math"
 	"net"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/SkycoinProject/skywire-mainnet/pkg/snet/mem"
 	"github.com/SkycoinProject/skywire-mainnet/pkg/snet/stcp"
 
 	"github.com/SkycoinProject/skycoin/src/util/logging"
+	"github.com/sirupsen/logrus"
 
 	"github.com/SkycoinProject/dmsg"
 	"github.com/SkycoinProject/dmsg/cipher"
-	"github.com/SkycoinProject/dmsg/disc"
 )
 
 // Default ports.
@@ -23,17 +29,52 @@ const (
 	SetupPort      = uint16(36)  // Listening port of a setup node.
 	AwaitSetupPort = uint16(136) // Listening port of a visor node for setup operations.
 	TransportPort  = uint16(45)  // Listening port of a visor node for incoming transports.
+	ProbePort      = uint16(46)  // Listening port of a visor node for end-to-end probes.
 )
 
 // Network types.
 const (
 	DmsgType = "dmsg"
 	STcpType = "stcp"
+	MemType  = "mem" // in-memory network, only used in tests
+)
+
+// DmsgMaxWriteSize is the largest write that a dmsg connection carries in one piece.
+// The write is sent as a dmsg frame with a 5-byte header and a 2-byte sequence number,
+// encrypted into a noise packet of at most math.MaxUint16 bytes with a 4-byte nonce and a 16-byte tag.
+const DmsgMaxWriteSize = math.MaxUint16 - 4 - 16 - 5 - 2
+
+// MaxWriteSize returns the largest write that connections of the given network type carry in one piece,
+// 0 if there is no limit.
+func MaxWriteSize(network string) int {
+	if network == DmsgType {
+		return DmsgMaxWriteSize
+	}
+	return 0
+}
+
+// Retry delays of dmsg server connection initiation.
+const (
+	dmsgInitMinRetry = time.Second
+	dmsgInitMaxRetry = time.Minute
 )
 
 var (
+	// ErrNetworkClosed occurs when the network is closed while an operation is in progress.
+	ErrNetworkClosed = errors.New("network is closed")
+
 	// ErrUnknownNetwork occurs on attempt to dial an unknown network type.
 	ErrUnknownNetwork = errors.New("unknown network type")
+
+	// ErrNetworkNotConfigured occurs on attempt to use a known network type which has no client configured.
+	ErrNetworkNotConfigured = errors.New("network type is not configured")
+
+	// ErrNoReadyNetworks occurs on attempt to listen when no network types are ready.
+	ErrNoReadyNetworks = errors.New("no ready network types")
+
+	// ErrBuffersNotSupported occurs on attempt to set socket buffer sizes of a connection
+	// of a network type which is not directly backed by a socket.
+	ErrBuffersNotSupported = errors.New("socket buffers are not supported by network type")
 )
 
 // Config represents a network configuration.
@@ -42,63 +83,303 @@ type Config struct {
 	SecKey     cipher.SecKey
 	TpNetworks []string // networks to be used with transports
 
-	DmsgDiscAddr string
-	DmsgMinSrvs  int
+	DmsgDiscAddrs []string // tried in order until one responds
+	DmsgMinSrvs   int
+
+	// Init retries failed attempts to connect to dmsg servers with exponential backoff until one succeeds
+	// or either limit is reached.
+	DmsgInitAttempts int           // attempts to connect to dmsg servers, 0 means no limit
+	DmsgInitTimeout  time.Duration // total time of attempts to connect to dmsg servers, 0 means no limit
+
+	// DmsgDiscCacheTTL caches the dmsg discovery entries of remotes for it, so that repeated dials of
+	// the same remote do not look it up each time. Entries are evicted once a dial to their remote fails.
+	// 0 disables the cache.
+	DmsgDiscCacheTTL  time.Duration
+	DmsgDiscCacheSize int // entries kept by the cache, 0 uses DefaultDiscCacheSize
+
+	STCPLocalAddrs []string // if empty, don't listen.
+	STCPTable      map[cipher.PubKey]string
+	STCPKeepAlive  time.Duration // 0 uses the system default, negative disables keep-alives
+	STCPTLSConfig  *tls.Config   // if set, stcp connections are wrapped in TLS
+	STCPReuseAddr  bool          // set SO_REUSEADDR and SO_REUSEPORT on stcp listeners
+
+	STCPResolveAttempts int           // lookups of a remote stcp address per dial, 1 or less disables retries
+	STCPResolveTimeout  time.Duration // total time of lookups of a remote stcp address per dial, 0 means no limit
+
+	EnableCompression bool // compress connections if the remote end supports it
+	EnablePacketTTL   bool // exchange routing packets with a TTL over connections if the remote end supports it
+	EnablePacketSeq   bool // also exchange sequence numbers of routing packets, requires EnablePacketTTL
+	EnablePacketType  bool // also exchange types of routing packets, such as in-band loop control, requires EnablePacketSeq
+
+	// MaxPayloadSize is the largest write accepted over connections in one piece. It is advertised to remote ends
+	// which send a hello, see Conn.MaxPayloadSize. 0 advertises no limit.
+	MaxPayloadSize int
+
+	// Application-level heartbeats detect half-open connections, they are used if the remote end supports them.
+	HeartbeatInterval time.Duration // 0 disables heartbeats
+	HeartbeatMisses   int           // consecutive unanswered pings before a connection is closed, 0 uses DefaultHeartbeatMisses
+
+	// HeartbeatMaxInterval lets the interval of pings lengthen up to it while data flows,
+	// it shortens back to HeartbeatInterval while a connection is idle. If not above HeartbeatInterval, the interval is fixed.
+	HeartbeatMaxInterval time.Duration
+
+	// MaxConnLifetime closes dialed and accepted connections once they have been open for it,
+	// so that they are re-established. Operations on such connections return ErrConnLifetimeExceeded.
+	// 0 means no limit.
+	MaxConnLifetime time.Duration
+
+	DefaultDialTimeout time.Duration            // applied to dials without a deadline, 0 means no timeout
+	DialTimeouts       map[string]time.Duration // per network type, overrides DefaultDialTimeout
+
+	// Logger creates the loggers of the network and its clients, nil uses the default master logger.
+	// Setting its formatter, for example to a logrus.JSONFormatter, changes the format of all snet logs.
+	Logger *logging.MasterLogger
+
+	// Connection lifecycle hooks, nil hooks are skipped.
+	OnDial   func(network string, rPK cipher.PubKey, rPort uint16, err error) // called after every dial attempt
+	OnAccept func(network string, remote net.Addr)                            // called for every accepted connection
+	OnClose  func(network string, remote net.Addr)                            // called once a connection is closed
+}
+
+// DialTimeout returns the dial timeout for the given network type.
+func (c Config) DialTimeout(network string) time.Duration {
+	if timeout, ok := c.DialTimeouts[network]; ok {
+		return timeout
+	}
+	return c.DefaultDialTimeout
+}
 
-	STCPLocalAddr string // if empty, don't listen.
-	STCPTable     map[cipher.PubKey]string
+func (c Config) logger(module string) *logging.Logger {
+	if c.Logger != nil {
+		return c.Logger.PackageLogger(module)
+	}
+	return logging.MustGetLogger(module)
 }
 
 // Network represents a network between nodes in Skywire.
 type Network struct {
+	log   *logging.Logger
 	conf  Config
 	dmsgC *dmsg.Client
 	stcpC *stcp.Client
+	memC  *mem.Client
+
+	discCache *cachingDisc // nil without Config.DmsgDiscCacheTTL
+
+	initDmsg      func(ctx context.Context, minSrvs int) error
+	dmsgInitRetry time.Duration // initial retry delay of initDmsg
+
+	ready   map[string]chan struct{} // key: network type, closed once the network type is ready
+	readyMx sync.Mutex
+
+	dials      map[uint64]*pendingDial // key: dial ID
+	nextDialID uint64
+	dialsMx    sync.Mutex
+
+	done chan struct{}
+	once sync.Once
 }
 
 // New creates a network from a config.
 func New(conf Config) *Network {
+	dmsgD := NewDmsgDiscovery(conf.DmsgDiscAddrs...)
+	var discCache *cachingDisc
+	if conf.DmsgDiscCacheTTL > 0 {
+		discCache = newCachingDisc(dmsgD, conf.DmsgDiscCacheTTL, conf.DmsgDiscCacheSize)
+		dmsgD = discCache
+	}
+
 	dmsgC := dmsg.NewClient(
 		conf.PubKey,
 		conf.SecKey,
-		disc.NewHTTP(conf.DmsgDiscAddr),
-		dmsg.SetLogger(logging.MustGetLogger("snet.dmsgC")))
+		dmsgD,
+		dmsg.SetLogger(conf.logger("snet.dmsgC")))
 
 	stcpC := stcp.NewClient(
-		logging.MustGetLogger("snet.stcpC"),
+		conf.logger("snet.stcpC"),
 		conf.PubKey,
 		conf.SecKey,
 		stcp.NewTable(conf.STCPTable))
-
-	return NewRaw(conf, dmsgC, stcpC)
+	stcpC.SetKeepAlive(conf.STCPKeepAlive)
+	stcpC.SetTLSConfig(conf.STCPTLSConfig)
+	stcpC.SetReuseAddr(conf.STCPReuseAddr)
+	stcpC.SetResolveRetry(conf.STCPResolveAttempts, conf.STCPResolveTimeout)
+
+	n := NewRaw(conf, dmsgC, stcpC)
+	n.discCache = discCache
+	return n
 }
 
 // NewRaw creates a network from a config and a dmsg client.
 func NewRaw(conf Config, dmsgC *dmsg.Client, stcpC *stcp.Client) *Network {
 	return &Network{
-		conf:  conf,
-		dmsgC: dmsgC,
-		stcpC: stcpC,
+		log:           conf.logger("snet"),
+		conf:          conf,
+		dmsgC:         dmsgC,
+		stcpC:         stcpC,
+		initDmsg:      dmsgC.InitiateServerConnections,
+		dmsgInitRetry: dmsgInitMinRetry,
+		ready: map[string]chan struct{}{
+			DmsgType: make(chan struct{}),
+			STcpType: make(chan struct{}),
+			MemType:  make(chan struct{}),
+		},
+		dials: make(map[uint64]*pendingDial),
+		done:  make(chan struct{}),
 	}
 }
 
+// WithMem attaches an in-memory client to the network, enabling the 'mem' network type.
+// It is intended for tests.
+func (n *Network) WithMem(memC *mem.Client) *Network {
+	n.memC = memC
+	n.setReady(MemType)
+	return n
+}
+
+// InitError occurs when Network.Init fails to initiate some of the configured network types.
+// The network types without an error were initiated and are ready to use.
+type InitError struct {
+	Errs map[string]error // key: network type
+}
+
+func (e *InitError) Error() string {
+	networks := make([]string, 0, len(e.Errs))
+	for network := range e.Errs {
+		networks = append(networks, network)
+	}
+	sort.Strings(networks)
+
+	msgs := make([]string, 0, len(networks))
+	for _, network := range networks {
+		msgs = append(msgs, fmt.Sprintf("failed to initiate '%s': %v", network, e.Errs[network]))
+	}
+	return strings.Join(msgs, "; ")
+}
+
 // Init initiates server connections.
+// Every configured network type is initiated, a failure of one does not prevent the others from being initiated.
+// If some network types fail, an *InitError reporting them is returned.
+// Failed attempts to connect to dmsg servers are retried with exponential backoff
+// until they succeed, Config.DmsgInitAttempts or Config.DmsgInitTimeout is reached, ctx is done or the network is closed.
 func (n *Network) Init(ctx context.Context) error {
-	if err := n.dmsgC.InitiateServerConnections(ctx, n.conf.DmsgMinSrvs); err != nil {
-		return fmt.Errorf("failed to initiate 'dmsg': %v", err)
-	}
-	if n.conf.STCPLocalAddr != "" {
-		if err := n.stcpC.Serve(n.conf.STCPLocalAddr); err != nil {
-			return fmt.Errorf("failed to initiate 'stcp': %v", err)
+	errs := make(map[string]error)
+
+	if len(n.conf.STCPLocalAddrs) > 0 {
+		if err := n.initSTCP(); err != nil {
+			errs[STcpType] = err
 		}
 	} else {
 		fmt.Println("No config found for stcp")
 	}
+
+	if err := n.initDmsgWithRetry(ctx); err != nil {
+		errs[DmsgType] = err
+	} else {
+		n.setReady(DmsgType)
+	}
+
+	if len(errs) > 0 {
+		return &InitError{Errs: errs}
+	}
 	return nil
 }
 
+// initSTCP serves stcp on all configured addresses. stcp is ready once it is served on any of them.
+func (n *Network) initSTCP() error {
+	var failed []string
+	for _, addr := range n.conf.STCPLocalAddrs {
+		if err := n.stcpC.Serve(addr); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", addr, err))
+		}
+	}
+	if len(failed) < len(n.conf.STCPLocalAddrs) {
+		n.setReady(STcpType)
+	}
+	if len(failed) > 0 {
+		return errors.New(strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+func (n *Network) initDmsgWithRetry(ctx context.Context) error {
+	if n.conf.DmsgInitTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, n.conf.DmsgInitTimeout)
+		defer cancel()
+	}
+
+	retry := n.dmsgInitRetry
+	for attempt := 1; ; attempt++ {
+		err := n.initDmsg(ctx, n.conf.DmsgMinSrvs)
+		if err == nil {
+			return nil
+		}
+		if attempt == n.conf.DmsgInitAttempts {
+			return fmt.Errorf("gave up after %d attempts: %v", attempt, err)
+		}
+		n.log.WithError(err).Warnf("failed to initiate 'dmsg' (attempt %d): trying again in %v...", attempt, retry)
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-n.done:
+			return ErrNetworkClosed
+		case <-time.After(retry):
+		}
+
+		if retry *= 2; retry > dmsgInitMaxRetry {
+			retry = dmsgInitMaxRetry
+		}
+	}
+}
+
+// IsNetworkReady returns whether the given network type is initiated.
+func (n *Network) IsNetworkReady(network string) bool {
+	ready, ok := n.ready[network]
+	if !ok {
+		return false
+	}
+	select {
+	case <-ready:
+		return true
+	default:
+		return false
+	}
+}
+
+// WaitForNetwork blocks until the given network type is initiated, ctx is done or the network is closed.
+func (n *Network) WaitForNetwork(ctx context.Context, network string) error {
+	ready, ok := n.ready[network]
+	if !ok {
+		return ErrUnknownNetwork
+	}
+	select {
+	case <-ready:
+		return nil
+	case <-n.done:
+		return ErrNetworkClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (n *Network) setReady(network string) {
+	n.readyMx.Lock()
+	defer n.readyMx.Unlock()
+
+	select {
+	case <-n.ready[network]:
+	default:
+		close(n.ready[network])
+	}
+}
+
 // Close closes underlying connections.
 func (n *Network) Close() error {
+	n.once.Do(func() { close(n.done) })
+	n.CancelPendingDials()
+
 	wg := new(sync.WaitGroup)
 	wg.Add(2)
 
@@ -116,6 +397,9 @@ func (n *Network) Close() error {
 
 	wg.Wait()
 
+	if err := n.memC.Close(); err != nil {
+		return err
+	}
 	if dmsgErr != nil {
 		return dmsgErr
 	}
@@ -142,56 +426,309 @@ func (n *Network) STcp() *stcp.Client { return n.stcpC }
 
 // Dial dials a node by its public key and returns a connection.
 func (n *Network) Dial(network string, pk cipher.PubKey, port uint16) (*Conn, error) {
-	ctx := context.Background()
+	return n.DialContext(context.Background(), network, pk, port)
+}
+
+// DialContext dials a node by its public key and returns a connection.
+// If ctx has no deadline, the configured dial timeout of the network type is applied.
+// The dial is cancelled if the network is closed in the meantime.
+func (n *Network) DialContext(ctx context.Context, network string, pk cipher.PubKey, port uint16) (*Conn, error) {
+	ctx, done, err := n.trackDial(ctx, network, pk, port)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	if _, ok := ctx.Deadline(); !ok {
+		if timeout := n.conf.DialTimeout(network); timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+	}
+
+	conn, err := n.dial(ctx, network, pk, port)
+	log := n.log.WithFields(logrus.Fields{
+		"event":       "dial",
+		"network":     network,
+		"remote_pk":   pk,
+		"remote_port": port,
+	})
+	if err != nil {
+		log.WithError(err).Debug("failed to dial")
+	} else {
+		log.Debug("dialed")
+	}
+	if n.conf.OnDial != nil {
+		n.conf.OnDial(network, pk, port, err)
+	}
+	return conn, err
+}
+
+// PendingDial describes a dial that is in progress.
+type PendingDial struct {
+	Network    string
+	RemotePK   cipher.PubKey
+	RemotePort uint16
+	StartedAt  time.Time
+}
+
+type pendingDial struct {
+	PendingDial
+	cancel context.CancelFunc
+}
+
+// PendingDials returns the dials that are in progress.
+func (n *Network) PendingDials() []PendingDial {
+	n.dialsMx.Lock()
+	defer n.dialsMx.Unlock()
+
+	dials := make([]PendingDial, 0, len(n.dials))
+	for _, d := range n.dials {
+		dials = append(dials, d.PendingDial)
+	}
+	return dials
+}
+
+// CancelPendingDials cancels the dials that are in progress. Cancelled dials return an error.
+func (n *Network) CancelPendingDials() {
+	n.dialsMx.Lock()
+	defer n.dialsMx.Unlock()
+
+	for _, d := range n.dials {
+		d.cancel()
+	}
+}
+
+// trackDial registers a dial so that it can be cancelled, done must be called once the dial returns.
+func (n *Network) trackDial(ctx context.Context, network string, pk cipher.PubKey, port uint16) (context.Context, func(), error) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	n.dialsMx.Lock()
+	defer n.dialsMx.Unlock()
+
+	select {
+	case <-n.done:
+		cancel()
+		return nil, nil, ErrNetworkClosed
+	default:
+	}
+
+	id := n.nextDialID
+	n.nextDialID++
+	n.dials[id] = &pendingDial{
+		PendingDial: PendingDial{Network: network, RemotePK: pk, RemotePort: port, StartedAt: time.Now()},
+		cancel:      cancel,
+	}
+
+	return ctx, func() {
+		n.dialsMx.Lock()
+		delete(n.dials, id)
+		n.dialsMx.Unlock()
+		cancel()
+	}, nil
+}
+
+func (n *Network) dial(ctx context.Context, network string, pk cipher.PubKey, port uint16) (*Conn, error) {
+	if err := n.checkConfigured(network); err != nil {
+		return nil, err
+	}
 	switch network {
 	case DmsgType:
 		conn, err := n.dmsgC.Dial(ctx, pk, port)
 		if err != nil {
+			if n.discCache != nil {
+				n.discCache.invalidate(pk)
+			}
 			return nil, err
 		}
-		return makeConn(conn, network), nil
+		return n.negotiateConn(conn, network)
 	case STcpType:
 		conn, err := n.stcpC.Dial(ctx, pk, port)
 		if err != nil {
 			return nil, err
 		}
-		return makeConn(conn, network), nil
+		return n.negotiateConn(conn, network)
+	case MemType:
+		conn, err := n.memC.Dial(ctx, pk, port)
+		if err != nil {
+			return nil, err
+		}
+		return n.negotiateConn(conn, network)
 	default:
 		return nil, ErrUnknownNetwork
 	}
 }
 
+// checkConfigured returns ErrUnknownNetwork if the network type is unknown
+// and ErrNetworkNotConfigured if it has no client.
+func (n *Network) checkConfigured(network string) error {
+	var configured bool
+	switch network {
+	case DmsgType:
+		configured = n.dmsgC != nil
+	case STcpType:
+		configured = n.stcpC != nil
+	case MemType:
+		configured = n.memC != nil
+	default:
+		return ErrUnknownNetwork
+	}
+	if !configured {
+		return ErrNetworkNotConfigured
+	}
+	return nil
+}
+
+// connOptions returns the options of new connections, advertising the configured network types.
+func (n *Network) connOptions() ConnOptions {
+	var networks []string
+	for _, network := range []string{DmsgType, STcpType, MemType} {
+		if n.checkConfigured(network) == nil {
+			networks = append(networks, network)
+		}
+	}
+	return ConnOptions{
+		Compress:          n.conf.EnableCompression,
+		HeartbeatInterval: n.conf.HeartbeatInterval,
+		HeartbeatMisses:   n.conf.HeartbeatMisses,
+		Networks:          networks,
+		PacketTTL:         n.conf.EnablePacketTTL,
+		PacketSeq:         n.conf.EnablePacketSeq,
+		PacketType:        n.conf.EnablePacketType,
+		MaxPayloadSize:    n.conf.MaxPayloadSize,
+
+		HeartbeatMaxInterval: n.conf.HeartbeatMaxInterval,
+	}
+}
+
+func (n *Network) negotiateConn(conn net.Conn, network string) (*Conn, error) {
+	opts := n.connOptions()
+	conn, rCaps, err := negotiateConn(conn, true, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to negotiate connection: %v", err)
+	}
+	return makeConn(withLifetime(conn, n.conf.MaxConnLifetime), network, opts, rCaps, n.conf.OnClose), nil
+}
+
 // Listen listens on the specified port.
 func (n *Network) Listen(network string, port uint16) (*Listener, error) {
+	return n.listen(network, port, nil)
+}
+
+// ListenAllowed is like Listen, but only accepts connections from the given remote public keys.
+// Connections from other public keys are closed before the hello exchange.
+func (n *Network) ListenAllowed(network string, port uint16, allowed []cipher.PubKey) (*Listener, error) {
+	allowedPKs := make(map[cipher.PubKey]struct{}, len(allowed))
+	for _, pk := range allowed {
+		allowedPKs[pk] = struct{}{}
+	}
+	return n.listen(network, port, allowedPKs)
+}
+
+func (n *Network) listen(network string, port uint16, allowed map[cipher.PubKey]struct{}) (*Listener, error) {
+	if err := n.checkConfigured(network); err != nil {
+		return nil, err
+	}
+	var lis net.Listener
+	var err error
 	switch network {
 	case DmsgType:
-		lis, err := n.dmsgC.Listen(port)
-		if err != nil {
-			return nil, err
-		}
-		return makeListener(lis, network), nil
+		lis, err = n.dmsgC.Listen(port)
 	case STcpType:
-		lis, err := n.stcpC.Listen(port)
-		if err != nil {
-			return nil, err
-		}
-		return makeListener(lis, network), nil
+		lis, err = n.stcpC.Listen(port)
+	case MemType:
+		lis, err = n.memC.Listen(port)
 	default:
 		return nil, ErrUnknownNetwork
 	}
+	if err != nil {
+		return nil, err
+	}
+	return n.makeListener(lis, network, allowed), nil
+}
+
+// SetSTCPAddr sets the stcp address of a remote visor, for example after its address changed.
+// Existing connections are not affected, later dials to pk use addr. This lets transports to
+// the visor reconnect to its new address once their connection breaks, without changing any routes.
+func (n *Network) SetSTCPAddr(pk cipher.PubKey, addr string) error {
+	if err := n.checkConfigured(STcpType); err != nil {
+		return err
+	}
+	n.stcpC.Table().SetAddr(pk, addr)
+	return nil
+}
+
+// ListenAll listens on the specified port across all ready network types.
+func (n *Network) ListenAll(port uint16) (*MultiListener, error) {
+	networks := n.readyNetworks()
+	if len(networks) == 0 {
+		return nil, ErrNoReadyNetworks
+	}
+
+	ls := make([]*Listener, 0, len(networks))
+	for _, network := range networks {
+		lis, err := n.Listen(network, port)
+		if err != nil {
+			for _, l := range ls {
+				_ = l.Close() //nolint:errcheck
+			}
+			return nil, fmt.Errorf("failed to listen on network '%s': %v", network, err)
+		}
+		ls = append(ls, lis)
+	}
+	return makeMultiListener(n.log, n.conf.PubKey, port, ls), nil
+}
+
+// readyNetworks returns the network types that are initiated and thus able to accept remote connections.
+func (n *Network) readyNetworks() []string {
+	var networks []string
+	for _, network := range []string{DmsgType, STcpType, MemType} {
+		if n.IsNetworkReady(network) {
+			networks = append(networks, network)
+		}
+	}
+	return networks
 }
 
 // Listener represents a listener.
 type Listener struct {
 	net.Listener
-	lPK     cipher.PubKey
-	lPort   uint16
-	network string
+	lPK      cipher.PubKey
+	lPort    uint16
+	network  string
+	opts     ConnOptions
+	allowed  map[cipher.PubKey]struct{} // if not nil, only these remote public keys are accepted
+	lifetime time.Duration              // maximum lifetime of accepted connections, 0 means no limit
+	onAccept func(network string, remote net.Addr)
+	onClose  func(network string, remote net.Addr)
+
+	accepted *acceptQueue
+}
+
+// acceptQueue holds the connections of a Listener which completed the hello exchange.
+type acceptQueue struct {
+	conns chan *Conn
+	done  chan struct{} // closed once accepting from the underlying listener failed
+	err   error         // the error accepting failed with, set before done is closed
 }
 
-func makeListener(l net.Listener, network string) *Listener {
+func (n *Network) makeListener(l net.Listener, network string, allowed map[cipher.PubKey]struct{}) *Listener {
 	lPK, lPort := disassembleAddr(l.Addr())
-	return &Listener{Listener: l, lPK: lPK, lPort: lPort, network: network}
+	lis := &Listener{
+		Listener: l,
+		lPK:      lPK,
+		lPort:    lPort,
+		network:  network,
+		opts:     n.connOptions(),
+		allowed:  allowed,
+		lifetime: n.conf.MaxConnLifetime,
+		onAccept: n.conf.OnAccept,
+		onClose:  n.conf.OnClose,
+		accepted: &acceptQueue{conns: make(chan *Conn), done: make(chan struct{})},
+	}
+	go lis.acceptLoop()
+	return lis
 }
 
 // LocalPK returns a local public key of listener.
@@ -204,12 +741,64 @@ func (l Listener) LocalPort() uint16 { return l.lPort }
 func (l Listener) Network() string { return l.network }
 
 // AcceptConn accepts a connection from listener.
+// Connections from remote public keys which are not allowed and connections that fail the hello exchange
+// are closed and skipped. The hello exchange of each connection runs on its own, so a slow remote does not
+// hold up connections from others.
 func (l Listener) AcceptConn() (*Conn, error) {
-	conn, err := l.Listener.Accept()
+	select {
+	case conn := <-l.accepted.conns:
+		return conn, nil
+	case <-l.accepted.done:
+		return nil, l.accepted.err
+	}
+}
+
+// acceptLoop accepts connections from the underlying listener until it fails.
+func (l Listener) acceptLoop() {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			l.accepted.err = err
+			close(l.accepted.done)
+			return
+		}
+		if !l.isAllowed(conn.RemoteAddr()) {
+			_ = conn.Close() //nolint:errcheck
+			continue
+		}
+		go l.negotiate(conn)
+	}
+}
+
+// negotiate exchanges the hello of an accepted connection and queues it for AcceptConn.
+func (l Listener) negotiate(conn net.Conn) {
+	conn, rCaps, err := negotiateConn(conn, false, l.opts)
 	if err != nil {
-		return nil, err
+		return
+	}
+	if l.onAccept != nil {
+		l.onAccept(l.network, conn.RemoteAddr())
+	}
+	sConn := makeConn(withLifetime(conn, l.lifetime), l.network, l.opts, rCaps, l.onClose)
+	select {
+	case l.accepted.conns <- sConn:
+	case <-l.accepted.done:
+		_ = sConn.Close() //nolint:errcheck
 	}
-	return makeConn(conn, l.network), nil
+}
+
+func (l Listener) isAllowed(remote net.Addr) bool {
+	if l.allowed == nil {
+		return true
+	}
+	rPK, _ := disassembleAddr(remote)
+	_, ok := l.allowed[rPK]
+	return ok
+}
+
+// Accept implements net.Listener
+func (l Listener) Accept() (net.Conn, error) {
+	return l.AcceptConn()
 }
 
 // Conn represent a connection between nodes in Skywire.
@@ -220,12 +809,141 @@ type Conn struct {
 	lPort   uint16
 	rPort   uint16
 	network string
+	lFeats  []string // features requested by the local end
+	lMax    int      // largest write the local end accepts in one piece, 0 if not limited
+	rCaps   Capabilities
+	stats   *connStats
+	onClose func(network string, remote net.Addr)
+	once    *sync.Once
+}
+
+// ConnStats are the statistics of a Conn.
+// Byte counts are of the data read and written by the user of the Conn, before compression and framing.
+type ConnStats struct {
+	BytesRead    uint64
+	BytesWritten uint64
+	OpenedAt     time.Time
+	OpenDuration time.Duration // time since the connection was opened
+}
+
+type connStats struct {
+	read     uint64
+	written  uint64
+	openedAt time.Time
 }
 
-func makeConn(conn net.Conn, network string) *Conn {
+func makeConn(conn net.Conn, network string, opts ConnOptions, rCaps Capabilities, onClose func(network string, remote net.Addr)) *Conn {
 	lPK, lPort := disassembleAddr(conn.LocalAddr())
 	rPK, rPort := disassembleAddr(conn.RemoteAddr())
-	return &Conn{Conn: conn, lPK: lPK, rPK: rPK, lPort: lPort, rPort: rPort, network: network}
+	return &Conn{
+		Conn:    conn,
+		lPK:     lPK,
+		rPK:     rPK,
+		lPort:   lPort,
+		rPort:   rPort,
+		network: network,
+		lFeats:  opts.features(),
+		lMax:    opts.MaxPayloadSize,
+		rCaps:   rCaps,
+		stats:   &connStats{openedAt: time.Now()},
+		onClose: onClose,
+		once:    new(sync.Once),
+	}
+}
+
+// Read implements net.Conn
+func (c *Conn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	atomic.AddUint64(&c.stats.read, uint64(n))
+	return n, err
+}
+
+// Write implements net.Conn
+func (c *Conn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	atomic.AddUint64(&c.stats.written, uint64(n))
+	return n, err
+}
+
+// Stats returns the statistics of connection.
+func (c *Conn) Stats() ConnStats {
+	return ConnStats{
+		BytesRead:    atomic.LoadUint64(&c.stats.read),
+		BytesWritten: atomic.LoadUint64(&c.stats.written),
+		OpenedAt:     c.stats.openedAt,
+		OpenDuration: time.Since(c.stats.openedAt),
+	}
+}
+
+// Close implements net.Conn
+func (c *Conn) Close() error {
+	err := c.Conn.Close()
+	if c.onClose != nil {
+		c.once.Do(func() { c.onClose(c.network, c.RemoteAddr()) })
+	}
+	return err
+}
+
+// SetReadBuffer sets the size of the receive buffer of the underlying socket.
+// It returns ErrBuffersNotSupported for network types which are not directly backed by a socket (such as dmsg).
+func (c *Conn) SetReadBuffer(bytes int) error {
+	bs, ok := c.bufferSetter()
+	if !ok {
+		return ErrBuffersNotSupported
+	}
+	return bs.SetReadBuffer(bytes)
+}
+
+// SetWriteBuffer sets the size of the transmit buffer of the underlying socket.
+// It returns ErrBuffersNotSupported for network types which are not directly backed by a socket (such as dmsg).
+func (c *Conn) SetWriteBuffer(bytes int) error {
+	bs, ok := c.bufferSetter()
+	if !ok {
+		return ErrBuffersNotSupported
+	}
+	return bs.SetWriteBuffer(bytes)
+}
+
+type bufferSetter interface {
+	SetReadBuffer(bytes int) error
+	SetWriteBuffer(bytes int) error
+}
+
+func (c *Conn) bufferSetter() (bufferSetter, bool) {
+	bs, ok := c.Unwrap().(bufferSetter)
+	return bs, ok
+}
+
+// Unwrap returns the network-specific connection underlying c, such as a *dmsg.Transport or a *stcp.Conn.
+// Data read from or written to it directly bypasses the compression, heartbeats and lifetime of c,
+// so it should only be used for network-specific APIs.
+func (c *Conn) Unwrap() net.Conn {
+	conn := c.Conn
+	if lc, ok := conn.(*lifetimeConn); ok {
+		conn = lc.Conn
+	}
+	if cc, ok := conn.(*compressedConn); ok {
+		conn = cc.Conn
+	}
+	if hc, ok := conn.(*heartbeatConn); ok {
+		conn = hc.Conn
+	}
+	if sc, ok := conn.(*sniffedConn); ok {
+		conn = sc.Conn
+	}
+	return conn
+}
+
+// DmsgTransport returns the dmsg transport underlying c. It returns false if c is not of the dmsg network type.
+func (c *Conn) DmsgTransport() (*dmsg.Transport, bool) {
+	tp, ok := c.Unwrap().(*dmsg.Transport)
+	return tp, ok
+}
+
+// STCPConn returns the stcp connection underlying c. It returns false if c is not of the stcp network type.
+func (c *Conn) STCPConn() (*stcp.Conn, bool) {
+	conn, ok := c.Unwrap().(*stcp.Conn)
+	return conn, ok
 }
 
 // LocalPK returns local public key of connection.
@@ -240,21 +958,43 @@ func (c Conn) LocalPort() uint16 { return c.lPort }
 // RemotePort returns remote port of connection.
 func (c Conn) RemotePort() uint16 { return c.rPort }
 
-// Network returns network of connection.
-func (c Conn) Network() string { return c.network }
-
-func disassembleAddr(addr net.Addr) (pk cipher.PubKey, port uint16) {
-	strs := strings.Split(addr.String(), ":")
-	if len(strs) != 2 {
-		panic(fmt.Errorf("network.disassembleAddr: %v %s", "invalid addr", addr.String()))
+// RemoteCapabilities returns the capabilities advertised by the remote end of connection.
+// They are empty if the remote end sent no hello. Connections accepted without enabled features
+// only know the capabilities once data was read from them.
+func (c Conn) RemoteCapabilities() Capabilities {
+	if c.rCaps.Version != 0 {
+		return c.rCaps
+	}
+	conn := c.Conn
+	if lc, ok := conn.(*lifetimeConn); ok {
+		conn = lc.Conn
+	}
+	if sc, ok := conn.(*sniffedConn); ok {
+		return sc.capabilities()
 	}
-	if err := pk.Set(strs[0]); err != nil {
-		panic(fmt.Errorf("network.disassembleAddr: %v %s", err, addr.String()))
+	return c.rCaps
+}
+
+// HasFeature returns whether the given feature is enabled on the connection, that is requested by both ends.
+func (c Conn) HasFeature(feature string) bool {
+	return contains(c.lFeats, feature) && c.RemoteCapabilities().HasFeature(feature)
+}
+
+// MaxPayloadSize returns the largest write that is delivered to the remote end in one piece: the least of
+// the limit of the network type (see MaxWriteSize) and the limits advertised by both ends. It returns 0 if none applies.
+func (c Conn) MaxPayloadSize() int {
+	size := MaxWriteSize(c.network)
+	if size > 0 && c.HasFeature(FeatureHeartbeat) {
+		// Heartbeats frame every write.
+		size -= frameHeaderLen
 	}
-	if strs[1] != "~" {
-		if _, err := fmt.Sscanf(strs[1], "%d", &port); err != nil {
-			panic(fmt.Errorf("network.disassembleAddr: %v", err))
+	for _, max := range []int{c.lMax, c.RemoteCapabilities().MaxPayloadSize} {
+		if max > 0 && (size == 0 || max < size) {
+			size = max
 		}
 	}
-	return
+	return size
 }
+
+// Network returns network of connection.
+func (c Conn) Network() string { return c.network }
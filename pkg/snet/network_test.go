@@ -1,21 +1,242 @@
 package snet
 
 import (
+	"context"
+	"errors"
 	"testing"
+	"time"
 
+	"github.com/SkycoinProject/dmsg/cipher"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/net/nettest"
 
-	"github.com/SkycoinProject/dmsg"
-	"github.com/SkycoinProject/dmsg/cipher"
+	"github.com/SkycoinProject/skywire-mainnet/pkg/snet/stcp"
 )
 
-func TestDisassembleAddr(t *testing.T) {
-	pk, _ := cipher.GenerateKeyPair()
-	port := uint16(2)
-	addr := dmsg.Addr{
-		PK: pk, Port: port,
+func TestNetwork_Init(t *testing.T) {
+	errInit := errors.New("failed to connect to dmsg servers")
+
+	t.Run("retries_failed_dmsg", func(t *testing.T) {
+		n := NewRaw(Config{DmsgMinSrvs: 1}, nil, nil)
+		n.dmsgInitRetry = 10 * time.Millisecond
+
+		var calls int
+		n.initDmsg = func(ctx context.Context, minSrvs int) error {
+			assert.Equal(t, 1, minSrvs)
+			if calls++; calls == 1 {
+				return errInit
+			}
+			return nil
+		}
+
+		require.NoError(t, n.Init(context.TODO()))
+		assert.Equal(t, 2, calls)
+		require.NoError(t, n.Close())
+	})
+
+	t.Run("stops_on_close", func(t *testing.T) {
+		n := NewRaw(Config{DmsgMinSrvs: 1}, nil, nil)
+		n.dmsgInitRetry = 10 * time.Millisecond
+		n.initDmsg = func(context.Context, int) error { return errInit }
+
+		errCh := make(chan error, 1)
+		go func() { errCh <- n.Init(context.TODO()) }()
+
+		time.Sleep(50 * time.Millisecond)
+		require.NoError(t, n.Close())
+
+		select {
+		case err := <-errCh:
+			assert.Error(t, err)
+		case <-time.After(time.Second):
+			t.Fatal("Init did not stop after Close")
+		}
+	})
+
+	t.Run("gives_up_after_attempts", func(t *testing.T) {
+		n := NewRaw(Config{DmsgMinSrvs: 1, DmsgInitAttempts: 3}, nil, nil)
+		n.dmsgInitRetry = time.Millisecond
+		defer func() { assert.NoError(t, n.Close()) }()
+
+		var calls int
+		n.initDmsg = func(context.Context, int) error {
+			calls++
+			return errInit
+		}
+
+		err := n.Init(context.TODO())
+		require.IsType(t, &InitError{}, err)
+		assert.EqualError(t, err.(*InitError).Errs[DmsgType], "gave up after 3 attempts: "+errInit.Error())
+		assert.Equal(t, 3, calls)
+		assert.False(t, n.IsNetworkReady(DmsgType))
+	})
+
+	t.Run("gives_up_after_timeout", func(t *testing.T) {
+		n := NewRaw(Config{DmsgMinSrvs: 1, DmsgInitTimeout: 50 * time.Millisecond}, nil, nil)
+		n.dmsgInitRetry = 10 * time.Millisecond
+		n.initDmsg = func(context.Context, int) error { return errInit }
+		defer func() { assert.NoError(t, n.Close()) }()
+
+		errCh := make(chan error, 1)
+		go func() { errCh <- n.Init(context.TODO()) }()
+
+		select {
+		case err := <-errCh:
+			require.IsType(t, &InitError{}, err)
+			assert.Equal(t, errInit, err.(*InitError).Errs[DmsgType])
+		case <-time.After(time.Second):
+			t.Fatal("Init did not stop after DmsgInitTimeout")
+		}
+		assert.False(t, n.IsNetworkReady(DmsgType))
+	})
+
+	t.Run("reports_failed_networks", func(t *testing.T) {
+		busy, err := nettest.NewLocalListener("tcp")
+		require.NoError(t, err)
+		defer func() { assert.NoError(t, busy.Close()) }()
+
+		pk, sk := cipher.GenerateKeyPair()
+		conf := Config{PubKey: pk, SecKey: sk, STCPLocalAddrs: []string{busy.Addr().String()}}
+		n := NewRaw(conf, nil, stcp.NewClient(nil, pk, sk, stcp.NewTable(nil)))
+		n.initDmsg = func(context.Context, int) error { return nil }
+		defer func() { assert.NoError(t, n.Close()) }()
+
+		err = n.Init(context.TODO())
+		require.IsType(t, &InitError{}, err)
+		errs := err.(*InitError).Errs
+		assert.Len(t, errs, 1)
+		assert.Error(t, errs[STcpType])
+		assert.Contains(t, err.Error(), "failed to initiate 'stcp': "+busy.Addr().String())
+
+		assert.True(t, n.IsNetworkReady(DmsgType))
+		assert.False(t, n.IsNetworkReady(STcpType))
+	})
+
+	t.Run("stcp_ready_on_any_addr", func(t *testing.T) {
+		busy, err := nettest.NewLocalListener("tcp")
+		require.NoError(t, err)
+		defer func() { assert.NoError(t, busy.Close()) }()
+		free, err := nettest.NewLocalListener("tcp")
+		require.NoError(t, err)
+		freeAddr := free.Addr().String()
+		require.NoError(t, free.Close())
+
+		pk, sk := cipher.GenerateKeyPair()
+		conf := Config{PubKey: pk, SecKey: sk, STCPLocalAddrs: []string{busy.Addr().String(), freeAddr}}
+		n := NewRaw(conf, nil, stcp.NewClient(nil, pk, sk, stcp.NewTable(nil)))
+		n.initDmsg = func(context.Context, int) error { return nil }
+		defer func() { assert.NoError(t, n.Close()) }()
+
+		err = n.Init(context.TODO())
+		require.IsType(t, &InitError{}, err)
+		assert.NotContains(t, err.Error(), freeAddr)
+		assert.True(t, n.IsNetworkReady(STcpType))
+	})
+}
+
+func TestNetwork_WaitForNetwork(t *testing.T) {
+	n := NewRaw(Config{}, nil, nil)
+	n.initDmsg = func(context.Context, int) error { return nil }
+	defer func() { assert.NoError(t, n.Close()) }()
+
+	// Already ready.
+	n.WithMem(nil)
+	assert.True(t, n.IsNetworkReady(MemType))
+	assert.NoError(t, n.WaitForNetwork(context.TODO(), MemType))
+
+	// Becomes ready later.
+	assert.False(t, n.IsNetworkReady(DmsgType))
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- n.WaitForNetwork(context.TODO(), DmsgType)
+	}()
+	select {
+	case err := <-errCh:
+		t.Fatalf("returned before network is ready: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	require.NoError(t, n.Init(context.TODO()))
+	assert.NoError(t, <-errCh)
+	assert.True(t, n.IsNetworkReady(DmsgType))
+
+	// Never ready as stcp is not served.
+	assert.False(t, n.IsNetworkReady(STcpType))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, n.WaitForNetwork(ctx, STcpType))
+
+	assert.Equal(t, ErrUnknownNetwork, n.WaitForNetwork(context.TODO(), "unknown"))
+}
+
+func TestNetwork_NotConfigured(t *testing.T) {
+	n := NewRaw(Config{}, nil, nil)
+	defer func() { assert.NoError(t, n.Close()) }()
+
+	for _, network := range []string{DmsgType, STcpType, MemType} {
+		_, err := n.Dial(network, cipher.PubKey{}, 1)
+		assert.Equal(t, ErrNetworkNotConfigured, err, network)
+
+		_, err = n.Listen(network, 1)
+		assert.Equal(t, ErrNetworkNotConfigured, err, network)
+	}
+
+	_, err := n.Dial("unknown", cipher.PubKey{}, 1)
+	assert.Equal(t, ErrUnknownNetwork, err)
+
+	_, err = n.Listen("unknown", 1)
+	assert.Equal(t, ErrUnknownNetwork, err)
+}
+
+func TestNetwork_CancelPendingDials(t *testing.T) {
+	// The black hole accepts TCP connections but never completes the stcp handshake.
+	blackHole, err := nettest.NewLocalListener("tcp")
+	require.NoError(t, err)
+	defer func() { assert.NoError(t, blackHole.Close()) }()
+	go func() {
+		for {
+			if _, err := blackHole.Accept(); err != nil {
+				return
+			}
+		}
+	}()
+
+	lPK, lSK := cipher.GenerateKeyPair()
+	rPK, _ := cipher.GenerateKeyPair()
+	stcpC := stcp.NewClient(nil, lPK, lSK, stcp.NewTable(map[cipher.PubKey]string{rPK: blackHole.Addr().String()}))
+	n := NewRaw(Config{PubKey: lPK, SecKey: lSK}, nil, stcpC)
+
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := n.Dial(STcpType, rPK, 3)
+		errCh <- err
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for len(n.PendingDials()) == 0 {
+		require.True(t, time.Now().Before(deadline), "dial is not pending")
+		time.Sleep(time.Millisecond)
 	}
-	gotPK, gotPort := disassembleAddr(addr)
-	require.Equal(t, pk, gotPK)
-	require.Equal(t, port, gotPort)
+	dials := n.PendingDials()
+	require.Len(t, dials, 1)
+	assert.Equal(t, STcpType, dials[0].Network)
+	assert.Equal(t, rPK, dials[0].RemotePK)
+	assert.Equal(t, uint16(3), dials[0].RemotePort)
+
+	// Let the dial reach the handshake.
+	time.Sleep(50 * time.Millisecond)
+
+	start := time.Now()
+	require.NoError(t, n.Close())
+	select {
+	case err := <-errCh:
+		assert.Equal(t, context.Canceled, err)
+	case <-time.After(time.Second):
+		t.Fatal("dial did not return after Close")
+	}
+	assert.True(t, time.Since(start) < time.Second)
+	assert.Empty(t, n.PendingDials())
+
+	_, err = n.Dial(STcpType, rPK, 3)
+	assert.Equal(t, ErrNetworkClosed, err)
 }
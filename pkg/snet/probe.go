@@ -0,0 +1,150 @@
+package snet
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/SkycoinProject/dmsg/cipher"
+)
+
+const (
+	probeNonceLen = 16
+
+	// DefaultProbeTimeout is the time budget of a probe when its context has no deadline.
+	DefaultProbeTimeout = 10 * time.Second
+
+	// DefaultMaxProbes is the default number of probes answered at once.
+	DefaultMaxProbes = 16
+)
+
+// ErrProbeMismatch occurs when the remote end of a probe does not echo the sent nonce.
+var ErrProbeMismatch = errors.New("probe response does not match request")
+
+// ProbeConfig configures the probes answered by ServeProbes.
+type ProbeConfig struct {
+	// MaxConcurrent is the maximum number of probes answered at once, further probes are refused.
+	// Zero uses DefaultMaxProbes.
+	MaxConcurrent int
+
+	// Allowed are the visors whose probes are answered. If empty, probes of all visors are answered.
+	Allowed []cipher.PubKey
+}
+
+// ServeProbes listens on ProbePort of the given network type and answers the probes of remote visors.
+// Probes are answered until the returned listener or the network is closed.
+func (n *Network) ServeProbes(network string, conf ProbeConfig) (*Listener, error) {
+	if conf.MaxConcurrent <= 0 {
+		conf.MaxConcurrent = DefaultMaxProbes
+	}
+	var allowed map[cipher.PubKey]struct{}
+	if len(conf.Allowed) > 0 {
+		allowed = make(map[cipher.PubKey]struct{}, len(conf.Allowed))
+		for _, pk := range conf.Allowed {
+			allowed[pk] = struct{}{}
+		}
+	}
+
+	lis, err := n.Listen(network, ProbePort)
+	if err != nil {
+		return nil, err
+	}
+
+	answering := make(chan struct{}, conf.MaxConcurrent) // holds a token per probe being answered
+	go func() {
+		for {
+			conn, err := lis.AcceptConn()
+			if err != nil {
+				return
+			}
+			if _, ok := allowed[conn.RemotePK()]; allowed != nil && !ok {
+				n.log.Debugf("refused probe of %s: not allowed", conn.RemotePK())
+				_ = conn.Close() //nolint:errcheck
+				continue
+			}
+			select {
+			case answering <- struct{}{}:
+			default:
+				n.log.Debugf("refused probe of %s: too many probes", conn.RemotePK())
+				_ = conn.Close() //nolint:errcheck
+				continue
+			}
+			go func() {
+				defer func() { <-answering }()
+				n.answerProbe(conn)
+			}()
+		}
+	}()
+
+	return lis, nil
+}
+
+func (n *Network) answerProbe(conn *Conn) {
+	// Deadlines of dmsg transports apply to the connection to the dmsg server, so the conn is closed on timeout instead.
+	timer := time.AfterFunc(DefaultProbeTimeout, func() { _ = conn.Close() }) //nolint:errcheck
+	defer func() {
+		timer.Stop()
+		_ = conn.Close() //nolint:errcheck
+	}()
+
+	nonce := make([]byte, probeNonceLen)
+	if _, err := io.ReadFull(conn, nonce); err != nil {
+		n.log.WithError(err).Debugf("failed to read probe of %s", conn.RemotePK())
+		return
+	}
+	if _, err := conn.Write(nonce); err != nil {
+		n.log.WithError(err).Debugf("failed to answer probe of %s", conn.RemotePK())
+	}
+}
+
+// ProbeDmsg checks that pk is reachable via dmsg by establishing a dmsg stream to its ProbePort
+// and exchanging a random nonce with it. Unlike IsNetworkReady, it confirms that the dmsg servers
+// route to pk. The remote visor has to serve probes, see ServeProbes.
+// If ctx has no deadline, DefaultProbeTimeout applies.
+func (n *Network) ProbeDmsg(ctx context.Context, pk cipher.PubKey) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultProbeTimeout)
+		defer cancel()
+	}
+
+	conn, err := n.DialContext(ctx, DmsgType, pk, ProbePort)
+	if err != nil {
+		return fmt.Errorf("failed to dial probe port: %v", err)
+	}
+	done := make(chan struct{})
+	defer func() {
+		close(done)
+		_ = conn.Close() //nolint:errcheck
+	}()
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = conn.Close() //nolint:errcheck
+		case <-done:
+		}
+	}()
+
+	nonce := make([]byte, probeNonceLen)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	if _, err := conn.Write(nonce); err != nil {
+		return fmt.Errorf("failed to send probe: %v", err)
+	}
+	resp := make([]byte, probeNonceLen)
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("failed to read probe response: %v", err)
+	}
+	if !bytes.Equal(nonce, resp) {
+		return ErrProbeMismatch
+	}
+	return nil
+}
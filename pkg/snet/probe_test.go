@@ -0,0 +1,86 @@
+package snet_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/SkycoinProject/dmsg/cipher"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/SkycoinProject/skywire-mainnet/pkg/snet"
+	"github.com/SkycoinProject/skywire-mainnet/pkg/snet/snettest"
+)
+
+func TestNetwork_ProbeDmsg(t *testing.T) {
+	keys := snettest.GenKeyPairs(3)
+
+	env := snettest.NewEnv(t, keys)
+	defer env.Teardown()
+
+	lis, err := env.Nets[1].ServeProbes(snet.DmsgType, snet.ProbeConfig{})
+	require.NoError(t, err)
+	defer func() { assert.NoError(t, lis.Close()) }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	t.Run("reachable", func(t *testing.T) {
+		assert.NoError(t, env.Nets[0].ProbeDmsg(ctx, keys[1].PK))
+		assert.NoError(t, env.Nets[2].ProbeDmsg(ctx, keys[1].PK))
+	})
+
+	t.Run("not_serving_probes", func(t *testing.T) {
+		assert.Error(t, env.Nets[0].ProbeDmsg(ctx, keys[2].PK))
+	})
+
+	t.Run("unknown_visor", func(t *testing.T) {
+		pk, _ := cipher.GenerateKeyPair()
+		assert.Error(t, env.Nets[0].ProbeDmsg(ctx, pk))
+	})
+}
+
+func TestNetwork_ServeProbes_Limits(t *testing.T) {
+	keys := snettest.GenKeyPairs(4)
+
+	env := snettest.NewEnv(t, keys)
+	defer env.Teardown()
+
+	conf := snet.ProbeConfig{MaxConcurrent: 1, Allowed: []cipher.PubKey{keys[0].PK, keys[2].PK}}
+	lis, err := env.Nets[1].ServeProbes(snet.DmsgType, conf)
+	require.NoError(t, err)
+	defer func() { assert.NoError(t, lis.Close()) }()
+
+	// Shorter than DefaultProbeTimeout, after which a probe that is not sent is closed.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// probeUntil probes keys[1] from keys[2] until the probe succeeds or fails as wanted.
+	probeUntil := func(ok bool) {
+		for {
+			probeCtx, cancel := context.WithTimeout(ctx, time.Second)
+			err := env.Nets[2].ProbeDmsg(probeCtx, keys[1].PK)
+			cancel()
+			require.NoError(t, ctx.Err(), "probe error: %v", err)
+			if (err == nil) == ok {
+				return
+			}
+		}
+	}
+
+	t.Run("not_allowed", func(t *testing.T) {
+		assert.NoError(t, env.Nets[0].ProbeDmsg(ctx, keys[1].PK))
+		assert.Error(t, env.Nets[3].ProbeDmsg(ctx, keys[1].PK))
+	})
+
+	t.Run("max_concurrent", func(t *testing.T) {
+		// A probe which is never sent holds the only slot until it is closed.
+		conn, err := env.Nets[0].DialContext(ctx, snet.DmsgType, keys[1].PK, snet.ProbePort)
+		require.NoError(t, err)
+		probeUntil(false)
+
+		require.NoError(t, conn.Close())
+		probeUntil(true)
+	})
+}
@@ -0,0 +1,116 @@
+package snet
+
+import (
+	"net"
+	"sync"
+)
+
+// Server accepts connections on a listener and handles each of them with a handler, see Network.Serve.
+type Server struct {
+	n       *Network
+	lis     *Listener
+	handler func(*Conn)
+
+	conns map[*Conn]struct{} // connections being handled
+	mx    sync.Mutex
+	wg    sync.WaitGroup
+
+	done chan struct{}
+	once sync.Once
+	err  error
+}
+
+// Serve listens on the given network type and port and calls handler in a new goroutine for every accepted connection.
+// A connection is closed once its handler returns. A panicking handler is recovered and its connection is closed,
+// other connections are not affected.
+// Connections are served until the returned Server or the network is closed.
+func (n *Network) Serve(network string, port uint16, handler func(*Conn)) (*Server, error) {
+	lis, err := n.Listen(network, port)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		n:       n,
+		lis:     lis,
+		handler: handler,
+		conns:   make(map[*Conn]struct{}),
+		done:    make(chan struct{}),
+	}
+
+	s.wg.Add(1)
+	go s.serve()
+
+	return s, nil
+}
+
+// Addr returns the address the Server listens on.
+func (s *Server) Addr() net.Addr {
+	return s.lis.Addr()
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.lis.AcceptConn()
+		if err != nil {
+			return
+		}
+
+		s.mx.Lock()
+		if s.isClosed() {
+			s.mx.Unlock()
+			_ = conn.Close() //nolint:errcheck
+			return
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mx.Unlock()
+
+		go s.handle(conn)
+	}
+}
+
+func (s *Server) handle(conn *Conn) {
+	defer func() {
+		if r := recover(); r != nil {
+			s.n.log.Errorf("handler of connection from %s on port %d panicked: %v", conn.RemoteAddr(), conn.LocalPort(), r)
+		}
+
+		s.mx.Lock()
+		delete(s.conns, conn)
+		s.mx.Unlock()
+
+		_ = conn.Close() //nolint:errcheck
+		s.wg.Done()
+	}()
+
+	s.handler(conn)
+}
+
+func (s *Server) isClosed() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close stops accepting connections and closes the connections being handled.
+// It returns once all handlers have returned.
+func (s *Server) Close() error {
+	s.once.Do(func() {
+		s.mx.Lock()
+		close(s.done)
+		s.err = s.lis.Close()
+		for conn := range s.conns {
+			_ = conn.Close() //nolint:errcheck
+		}
+		s.mx.Unlock()
+
+		s.wg.Wait()
+	})
+	return s.err
+}
@@ -0,0 +1,99 @@
+package snet_test
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/SkycoinProject/skywire-mainnet/pkg/snet"
+	"github.com/SkycoinProject/skywire-mainnet/pkg/snet/snettest"
+)
+
+func TestNetwork_Serve(t *testing.T) {
+	const port = uint16(84)
+
+	keys := snettest.GenKeyPairs(2)
+	env := snettest.NewEnv(t, keys)
+	defer env.Teardown()
+
+	echo := func(conn *snet.Conn) {
+		_, _ = io.Copy(conn, conn) //nolint:errcheck
+	}
+
+	for _, network := range []string{snet.DmsgType, snet.STcpType} {
+		t.Run(network, func(t *testing.T) {
+			srv, err := env.Nets[0].Serve(network, port, echo)
+			require.NoError(t, err)
+
+			// Several connections are handled at once.
+			var conns []*snet.Conn
+			for i := 0; i < 3; i++ {
+				conn, err := env.Nets[1].Dial(network, keys[0].PK, port)
+				require.NoError(t, err)
+				conns = append(conns, conn)
+			}
+			for _, conn := range conns {
+				msg := []byte("echo via " + network)
+				_, err := conn.Write(msg)
+				require.NoError(t, err)
+				got := make([]byte, len(msg))
+				_, err = io.ReadFull(conn, got)
+				require.NoError(t, err)
+				assert.Equal(t, msg, got)
+			}
+
+			// Closing the server closes the connections being handled.
+			require.NoError(t, srv.Close())
+			for _, conn := range conns {
+				_, err := conn.Read(make([]byte, 1))
+				assert.Error(t, err)
+				assert.NoError(t, conn.Close())
+			}
+		})
+	}
+}
+
+func TestNetwork_Serve_Panic(t *testing.T) {
+	const port = uint16(85)
+
+	keys := snettest.GenKeyPairs(2)
+	env := snettest.NewEnv(t, keys)
+	defer env.Teardown()
+
+	srv, err := env.Nets[0].Serve(snet.STcpType, port, func(conn *snet.Conn) {
+		b := make([]byte, 1)
+		if _, err := conn.Read(b); err != nil {
+			return
+		}
+		if b[0] == 'p' {
+			panic("handler panic")
+		}
+		_, _ = conn.Write(b) //nolint:errcheck
+	})
+	require.NoError(t, err)
+	defer func() { assert.NoError(t, srv.Close()) }()
+
+	// The connection of the panicking handler is closed.
+	pConn, err := env.Nets[1].Dial(snet.STcpType, keys[0].PK, port)
+	require.NoError(t, err)
+	defer func() { assert.NoError(t, pConn.Close()) }()
+	_, err = pConn.Write([]byte("p"))
+	require.NoError(t, err)
+	require.NoError(t, pConn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, err = pConn.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err)
+
+	// Other connections are still served.
+	conn, err := env.Nets[1].Dial(snet.STcpType, keys[0].PK, port)
+	require.NoError(t, err)
+	defer func() { assert.NoError(t, conn.Close()) }()
+	_, err = conn.Write([]byte("x"))
+	require.NoError(t, err)
+	got := make([]byte, 1)
+	_, err = io.ReadFull(conn, got)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("x"), got)
+}
@@ -0,0 +1,57 @@
+package snet
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/hashicorp/yamux"
+)
+
+// Session multiplexes independent streams over a single Conn.
+// Each stream has its own flow control, so a slow stream does not block the others.
+type Session struct {
+	conn *Conn
+	sess *yamux.Session
+}
+
+// NewSession creates a Session over 'conn'.
+// Exactly one end of 'conn' should be the initiator, usually the dialing end.
+// If 'conf' is nil, the default yamux config is used.
+func NewSession(conn *Conn, initiator bool, conf *yamux.Config) (*Session, error) {
+	var sess *yamux.Session
+	var err error
+	if initiator {
+		sess, err = yamux.Client(conn, conf)
+	} else {
+		sess, err = yamux.Server(conn, conf)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("yamux: %v", err)
+	}
+	return &Session{conn: conn, sess: sess}, nil
+}
+
+// OpenStream opens a new stream to the remote end of the session.
+func (s *Session) OpenStream() (net.Conn, error) {
+	return s.sess.OpenStream()
+}
+
+// AcceptStream blocks until the remote end of the session opens a new stream.
+func (s *Session) AcceptStream() (net.Conn, error) {
+	return s.sess.AcceptStream()
+}
+
+// NumStreams returns the number of currently open streams.
+func (s *Session) NumStreams() int {
+	return s.sess.NumStreams()
+}
+
+// Conn returns the underlying connection of the session.
+func (s *Session) Conn() *Conn {
+	return s.conn
+}
+
+// Close closes all streams and the underlying connection.
+func (s *Session) Close() error {
+	return s.sess.Close()
+}
@@ -0,0 +1,106 @@
+package snet_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/SkycoinProject/skywire-mainnet/pkg/snet"
+	"github.com/SkycoinProject/skywire-mainnet/pkg/snet/snettest"
+)
+
+func TestSession(t *testing.T) {
+	const (
+		port     = uint16(82)
+		nStreams = 5
+	)
+
+	keys := snettest.GenKeyPairs(2)
+	env := snettest.NewEnv(t, keys, snet.MemType)
+	defer env.Teardown()
+
+	lis, err := env.Nets[0].Listen(snet.MemType, port)
+	require.NoError(t, err)
+	defer func() { assert.NoError(t, lis.Close()) }()
+
+	acceptCh := make(chan *snet.Conn, 1)
+	go func() {
+		conn, err := lis.AcceptConn()
+		assert.NoError(t, err)
+		acceptCh <- conn
+	}()
+
+	conn, err := env.Nets[1].Dial(snet.MemType, keys[0].PK, port)
+	require.NoError(t, err)
+	rConn := <-acceptCh
+	require.NotNil(t, rConn)
+
+	lSess, err := snet.NewSession(conn, true, nil)
+	require.NoError(t, err)
+	defer func() { assert.NoError(t, lSess.Close()) }()
+
+	rSess, err := snet.NewSession(rConn, false, nil)
+	require.NoError(t, err)
+	defer func() { assert.NoError(t, rSess.Close()) }()
+
+	// Remote end echoes every stream, except for the first stream which is never read.
+	stalled := make(chan net.Conn, 1)
+	go func() {
+		for i := 0; ; i++ {
+			stream, err := rSess.AcceptStream()
+			if err != nil {
+				return
+			}
+			if i == 0 {
+				stalled <- stream
+				continue
+			}
+			go func() {
+				_, _ = io.Copy(stream, stream) //nolint:errcheck
+			}()
+		}
+	}()
+
+	// The first stream is filled beyond its receive window, which blocks only its own writer.
+	sStream, err := lSess.OpenStream()
+	require.NoError(t, err)
+	go func() {
+		_, _ = sStream.Write(make([]byte, 1<<20)) //nolint:errcheck
+	}()
+	rStalled := <-stalled
+
+	var wg sync.WaitGroup
+	for i := 0; i < nStreams; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			stream, err := lSess.OpenStream()
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer func() { assert.NoError(t, stream.Close()) }()
+
+			msg := bytes.Repeat([]byte(fmt.Sprintf("stream %d;", i)), 1000)
+			go func() {
+				_, err := stream.Write(msg)
+				assert.NoError(t, err)
+			}()
+
+			got := make([]byte, len(msg))
+			_, err = io.ReadFull(stream, got)
+			assert.NoError(t, err)
+			assert.Equal(t, msg, got)
+		}(i)
+	}
+	wg.Wait()
+
+	assert.NoError(t, sStream.Close())
+	assert.NoError(t, rStalled.Close())
+}
@@ -0,0 +1,85 @@
+package snet
+
+import (
+	"time"
+
+	"github.com/SkycoinProject/dmsg/cipher"
+)
+
+// Snapshot is a JSON-serializable view of the configuration and state of a Network.
+// It never contains secrets: the secret key is omitted and TLS configs are only reported as enabled.
+type Snapshot struct {
+	PubKey            cipher.PubKey     `json:"pub_key"`
+	TpNetworks        []string          `json:"tp_networks"`
+	Compression       bool              `json:"compression"`
+	HeartbeatInterval time.Duration     `json:"heartbeat_interval"`
+	HeartbeatMisses   int               `json:"heartbeat_misses"`
+	Networks          []NetworkSnapshot `json:"networks"`
+	PendingDials      int               `json:"pending_dials"`
+
+	HeartbeatMaxInterval time.Duration `json:"heartbeat_max_interval,omitempty"`
+}
+
+// NetworkSnapshot describes a single configured network type.
+type NetworkSnapshot struct {
+	Type        string        `json:"type"`
+	Ready       bool          `json:"ready"`
+	DialTimeout time.Duration `json:"dial_timeout"`
+	LocalAddrs  []string      `json:"local_addrs,omitempty"` // addresses the network type is served on
+
+	// dmsg
+	DiscAddrs  []string        `json:"disc_addrs,omitempty"`
+	MinServers int             `json:"min_servers,omitempty"`
+	DiscCache  *DiscCacheStats `json:"disc_cache,omitempty"` // nil if dmsg discovery entries are not cached
+
+	// stcp
+	KnownPeers int           `json:"known_peers,omitempty"` // entries of the stcp table
+	KeepAlive  time.Duration `json:"keep_alive,omitempty"`
+	TLS        bool          `json:"tls,omitempty"`
+}
+
+// Snapshot returns the current configuration and state of the network.
+// Only configured network types are included.
+func (n *Network) Snapshot() Snapshot {
+	s := Snapshot{
+		PubKey:            n.conf.PubKey,
+		TpNetworks:        n.conf.TpNetworks,
+		Compression:       n.conf.EnableCompression,
+		HeartbeatInterval: n.conf.HeartbeatInterval,
+		HeartbeatMisses:   n.conf.HeartbeatMisses,
+		Networks:          make([]NetworkSnapshot, 0, 3),
+		PendingDials:      len(n.PendingDials()),
+
+		HeartbeatMaxInterval: n.conf.HeartbeatMaxInterval,
+	}
+
+	for _, network := range []string{DmsgType, STcpType, MemType} {
+		if n.checkConfigured(network) != nil {
+			continue
+		}
+		ns := NetworkSnapshot{
+			Type:        network,
+			Ready:       n.IsNetworkReady(network),
+			DialTimeout: n.conf.DialTimeout(network),
+		}
+		switch network {
+		case DmsgType:
+			ns.DiscAddrs = n.conf.DmsgDiscAddrs
+			ns.MinServers = n.conf.DmsgMinSrvs
+			if n.discCache != nil {
+				stats := n.discCache.Stats()
+				ns.DiscCache = &stats
+			}
+		case STcpType:
+			for _, addr := range n.stcpC.ServingAddrs() {
+				ns.LocalAddrs = append(ns.LocalAddrs, addr.String())
+			}
+			ns.KnownPeers = n.stcpC.Table().Count()
+			ns.KeepAlive = n.conf.STCPKeepAlive
+			ns.TLS = n.conf.STCPTLSConfig != nil
+		}
+		s.Networks = append(s.Networks, ns)
+	}
+
+	return s
+}
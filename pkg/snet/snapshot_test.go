@@ -0,0 +1,56 @@
+package snet
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/SkycoinProject/dmsg/cipher"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNetwork_Snapshot(t *testing.T) {
+	pk, sk := cipher.GenerateKeyPair()
+	peerPK, _ := cipher.GenerateKeyPair()
+
+	n := New(Config{
+		PubKey:             pk,
+		SecKey:             sk,
+		TpNetworks:         []string{DmsgType, STcpType},
+		DmsgDiscAddrs:      []string{"http://localhost:9090"},
+		DmsgMinSrvs:        1,
+		STCPTable:          map[cipher.PubKey]string{peerPK: "127.0.0.1:7777"},
+		STCPTLSConfig:      &tls.Config{},
+		DefaultDialTimeout: time.Second,
+	})
+	defer func() { assert.NoError(t, n.Close()) }()
+
+	require.NoError(t, n.stcpC.Serve("127.0.0.1:0"))
+	n.setReady(STcpType)
+
+	s := n.Snapshot()
+	assert.Equal(t, pk, s.PubKey)
+	require.Len(t, s.Networks, 2)
+
+	dmsgS, stcpS := s.Networks[0], s.Networks[1]
+	assert.Equal(t, DmsgType, dmsgS.Type)
+	assert.False(t, dmsgS.Ready)
+	assert.Equal(t, time.Second, dmsgS.DialTimeout)
+	assert.Equal(t, []string{"http://localhost:9090"}, dmsgS.DiscAddrs)
+	assert.Equal(t, 1, dmsgS.MinServers)
+
+	assert.Equal(t, STcpType, stcpS.Type)
+	assert.True(t, stcpS.Ready)
+	require.Len(t, stcpS.LocalAddrs, 1)
+	assert.Equal(t, n.stcpC.ServingAddrs()[0].String(), stcpS.LocalAddrs[0])
+	assert.Equal(t, 1, stcpS.KnownPeers)
+	assert.True(t, stcpS.TLS)
+
+	raw, err := json.Marshal(s)
+	require.NoError(t, err)
+	assert.Contains(t, string(raw), `"type":"dmsg"`)
+	assert.Contains(t, string(raw), `"type":"stcp"`)
+	assert.NotContains(t, string(raw), sk.Hex())
+}
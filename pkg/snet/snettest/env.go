@@ -13,6 +13,8 @@ import (
 	"golang.org/x/net/nettest"
 
 	"github.com/SkycoinProject/skywire-mainnet/pkg/snet"
+	"github.com/SkycoinProject/skywire-mainnet/pkg/snet/mem"
+	"github.com/SkycoinProject/skywire-mainnet/pkg/snet/stcp"
 )
 
 // KeyPair holds a public/private key pair.
@@ -45,25 +47,58 @@ type Env struct {
 
 // NewEnv creates a `network.Network` test environment.
 // `nPairs` is the public/private key pairs of all the `network.Network`s to be created.
-func NewEnv(t *testing.T, keys []KeyPair) *Env {
+// `tpNetworks` are the network types used for transports (defaults to `dmsg`).
+// Including `mem` attaches in-memory clients to the networks.
+func NewEnv(t *testing.T, keys []KeyPair, tpNetworks ...string) *Env {
+	return NewEnvWithConfig(t, keys, nil, tpNetworks...)
+}
+
+// NewEnvWithConfig is similar to NewEnv, but allows `confFn` to modify the config of each `network.Network`.
+func NewEnvWithConfig(t *testing.T, keys []KeyPair, confFn func(conf *snet.Config), tpNetworks ...string) *Env {
+	if len(tpNetworks) == 0 {
+		tpNetworks = []string{dmsg.Type}
+	}
 
 	// Prepare `dmsg`.
 	dmsgD := disc.NewMock()
 	dmsgS, dmsgSErr := createDmsgSrv(t, dmsgD)
 
+	// Prepare `stcp` table.
+	stcpT := make(map[cipher.PubKey]string, len(keys))
+	for _, pairs := range keys {
+		stcpT[pairs.PK] = reserveLocalAddr(t)
+	}
+
+	// Prepare `mem` hub.
+	var memH *mem.Hub
+	for _, network := range tpNetworks {
+		if network == snet.MemType {
+			memH = mem.NewHub()
+		}
+	}
+
 	// Prepare `snets`.
 	ns := make([]*snet.Network, len(keys))
 	for i, pairs := range keys {
+		conf := snet.Config{
+			PubKey:         pairs.PK,
+			SecKey:         pairs.SK,
+			TpNetworks:     tpNetworks,
+			DmsgMinSrvs:    1,
+			STCPLocalAddrs: []string{stcpT[pairs.PK]},
+			STCPTable:      stcpT,
+		}
+		if confFn != nil {
+			confFn(&conf)
+		}
 		n := snet.NewRaw(
-			snet.Config{
-				PubKey:      pairs.PK,
-				SecKey:      pairs.SK,
-				TpNetworks:  []string{dmsg.Type},
-				DmsgMinSrvs: 1,
-			},
+			conf,
 			dmsg.NewClient(pairs.PK, pairs.SK, dmsgD),
-			nil,
+			stcp.NewClient(nil, pairs.PK, pairs.SK, stcp.NewTable(stcpT)),
 		)
+		if memH != nil {
+			n.WithMem(mem.NewClient(memH, pairs.PK))
+		}
 		require.NoError(t, n.Init(context.TODO()))
 		ns[i] = n
 	}
@@ -91,6 +126,15 @@ func NewEnv(t *testing.T, keys []KeyPair) *Env {
 // Teardown shutdowns the Env.
 func (e *Env) Teardown() { e.teardown() }
 
+// reserveLocalAddr obtains a free local tcp address.
+func reserveLocalAddr(t *testing.T) string {
+	l, err := nettest.NewLocalListener("tcp")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	require.NoError(t, l.Close())
+	return addr
+}
+
 func createDmsgSrv(t *testing.T, dc disc.APIClient) (srv *dmsg.Server, srvErr <-chan error) {
 	pk, sk, err := cipher.GenerateDeterministicKeyPair([]byte("s"))
 	require.NoError(t, err)
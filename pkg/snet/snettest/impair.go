@@ -0,0 +1,52 @@
+package snettest
+
+import (
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// Impairment describes the degradation applied to writes of an ImpairedConn.
+type Impairment struct {
+	DropRate float64       // probability in [0, 1] of silently dropping a write
+	Latency  time.Duration // delay added before each write
+	Seed     int64         // seed of the drop decisions, for reproducible tests
+}
+
+// ImpairedConn wraps a net.Conn and applies an Impairment to each write.
+// As a write is dropped as a whole, each write should contain exactly one packet.
+type ImpairedConn struct {
+	net.Conn
+	imp Impairment
+
+	rand *rand.Rand
+	mx   sync.Mutex
+}
+
+// Impair wraps 'conn' so that its writes are delayed and dropped as described by 'imp'.
+func Impair(conn net.Conn, imp Impairment) *ImpairedConn {
+	return &ImpairedConn{
+		Conn: conn,
+		imp:  imp,
+		rand: rand.New(rand.NewSource(imp.Seed)), // nolint:gosec
+	}
+}
+
+// Write implements net.Conn.
+// A dropped write reports success without writing anything to the underlying connection.
+func (c *ImpairedConn) Write(b []byte) (int, error) {
+	if c.imp.Latency > 0 {
+		time.Sleep(c.imp.Latency)
+	}
+	if c.drop() {
+		return len(b), nil
+	}
+	return c.Conn.Write(b)
+}
+
+func (c *ImpairedConn) drop() bool {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	return c.rand.Float64() < c.imp.DropRate
+}
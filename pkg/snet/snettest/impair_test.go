@@ -0,0 +1,102 @@
+package snettest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/SkycoinProject/dmsg/cipher"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImpair(t *testing.T) {
+	t.Run("latency", func(t *testing.T) {
+		conn, remote := net.Pipe()
+		defer func() { assert.NoError(t, remote.Close()) }()
+		lossy := Impair(conn, Impairment{Latency: 50 * time.Millisecond})
+		defer func() { assert.NoError(t, lossy.Close()) }()
+
+		go func() {
+			_, _ = io.Copy(ioutil.Discard, remote) //nolint:errcheck
+		}()
+
+		start := time.Now()
+		_, err := lossy.Write([]byte("ping"))
+		require.NoError(t, err)
+		assert.True(t, time.Since(start) >= 50*time.Millisecond)
+	})
+
+	t.Run("reliable_stream_over_loss", func(t *testing.T) {
+		const (
+			chunkSize = 64
+			timeout   = 20 * time.Millisecond
+		)
+
+		data := cipher.RandByte(100 * chunkSize)
+
+		// Data flows over a link with 10% loss, acknowledgements flow back over a clean link.
+		dataConn, dataRemote := net.Pipe()
+		lossy := Impair(dataConn, Impairment{DropRate: 0.1, Seed: 1})
+		ackConn, ackRemote := net.Pipe()
+		defer func() {
+			for _, c := range []net.Conn{lossy, dataRemote, ackConn, ackRemote} {
+				assert.NoError(t, c.Close())
+			}
+		}()
+
+		// Receiver acknowledges every received chunk with its sequence number.
+		recvCh := make(chan []byte, 1)
+		go func() {
+			var buf bytes.Buffer
+			var next uint32
+			for buf.Len() < len(data) {
+				frame := make([]byte, 4+chunkSize)
+				n, err := dataRemote.Read(frame)
+				if !assert.NoError(t, err) {
+					break
+				}
+				seq := binary.BigEndian.Uint32(frame)
+				if seq == next {
+					buf.Write(frame[4:n])
+					next++
+				}
+				if _, err := ackRemote.Write(frame[:4]); !assert.NoError(t, err) {
+					break
+				}
+			}
+			recvCh <- buf.Bytes()
+		}()
+
+		// Sender retransmits each chunk until it is acknowledged (stop-and-wait).
+		var dropped int
+		for seq := uint32(0); int(seq)*chunkSize < len(data); seq++ {
+			frame := make([]byte, 4, 4+chunkSize)
+			binary.BigEndian.PutUint32(frame, seq)
+			frame = append(frame, data[int(seq)*chunkSize:int(seq+1)*chunkSize]...)
+
+			for acked := false; !acked; {
+				// A dropped write leaves the receiver waiting, so retransmit after a timeout.
+				_, err := lossy.Write(frame)
+				require.NoError(t, err)
+
+				ack := make([]byte, 4)
+				require.NoError(t, ackConn.SetReadDeadline(time.Now().Add(timeout)))
+				_, err = io.ReadFull(ackConn, ack)
+				if err, ok := err.(net.Error); ok && err.Timeout() {
+					dropped++
+					continue
+				}
+				require.NoError(t, err)
+				acked = binary.BigEndian.Uint32(ack) == seq
+			}
+		}
+
+		assert.Equal(t, data, <-recvCh)
+		assert.True(t, dropped > 0, "expected some writes to be dropped")
+	})
+}
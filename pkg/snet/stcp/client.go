@@ -2,6 +2,7 @@ package stcp
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
@@ -14,15 +15,22 @@ import (
 	"github.com/SkycoinProject/skycoin/src/util/logging"
 )
 
+// Retry delays of remote address lookups, see Client.SetResolveRetry.
+const (
+	ResolveMinRetry = 100 * time.Millisecond
+	ResolveMaxRetry = 2 * time.Second
+)
+
 // Conn wraps an underlying net.Conn and modifies various methods to integrate better with the 'network' package.
 type Conn struct {
 	net.Conn
+	tcp      net.Conn // underlying TCP connection, differs from Conn when wrapped in TLS
 	lAddr    dmsg.Addr
 	rAddr    dmsg.Addr
 	freePort func()
 }
 
-func newConn(conn net.Conn, deadline time.Time, hs Handshake, freePort func()) (*Conn, error) {
+func newConn(tcpConn, conn net.Conn, deadline time.Time, hs Handshake, freePort func()) (*Conn, error) {
 	lAddr, rAddr, err := hs(conn, deadline)
 	if err != nil {
 		_ = conn.Close() //nolint:errcheck
@@ -31,7 +39,7 @@ func newConn(conn net.Conn, deadline time.Time, hs Handshake, freePort func()) (
 		}
 		return nil, err
 	}
-	return &Conn{Conn: conn, lAddr: lAddr, rAddr: rAddr, freePort: freePort}, nil
+	return &Conn{Conn: conn, tcp: tcpConn, lAddr: lAddr, rAddr: rAddr, freePort: freePort}, nil
 }
 
 // LocalAddr implements net.Conn
@@ -44,6 +52,32 @@ func (c *Conn) RemoteAddr() net.Addr {
 	return c.rAddr
 }
 
+// SetReadBuffer sets the size of the operating system's receive buffer of the underlying TCP connection.
+func (c *Conn) SetReadBuffer(bytes int) error {
+	tcpConn, err := c.tcpConn()
+	if err != nil {
+		return err
+	}
+	return tcpConn.SetReadBuffer(bytes)
+}
+
+// SetWriteBuffer sets the size of the operating system's transmit buffer of the underlying TCP connection.
+func (c *Conn) SetWriteBuffer(bytes int) error {
+	tcpConn, err := c.tcpConn()
+	if err != nil {
+		return err
+	}
+	return tcpConn.SetWriteBuffer(bytes)
+}
+
+func (c *Conn) tcpConn() (*net.TCPConn, error) {
+	tcpConn, ok := c.tcp.(*net.TCPConn)
+	if !ok {
+		return nil, fmt.Errorf("underlying connection is of type %T instead of *net.TCPConn", c.tcp)
+	}
+	return tcpConn, nil
+}
+
 // Close implements net.Conn
 func (c *Conn) Close() error {
 	if c.freePort != nil {
@@ -119,14 +153,20 @@ func (l *Listener) Addr() net.Addr {
 
 // Client is the central control for incoming and outgoing 'stcp.Conn's.
 type Client struct {
-	log *logging.Logger
+	log       *logging.Logger
+	keepAlive time.Duration
+	tlsConf   *tls.Config
+	reuseAddr bool
+
+	resolveAttempts int           // lookups of a remote address per dial
+	resolveTimeout  time.Duration // total time of lookups of a remote address per dial, 0 means no limit
 
 	lPK cipher.PubKey
 	lSK cipher.SecKey
 	t   PKTable
 	p   *Porter
 
-	lTCP net.Listener
+	lTCP []net.Listener
 	lMap map[uint16]*Listener // key: lPort
 	mx   sync.Mutex
 
@@ -144,28 +184,151 @@ func NewClient(log *logging.Logger, pk cipher.PubKey, sk cipher.SecKey, t PKTabl
 		lPK:  pk,
 		lSK:  sk,
 		t:    t,
-		p:    newPorter(PorterMinEphemeral),
+		p:    NewPorter(PorterMinEphemeral),
 		lMap: make(map[uint16]*Listener),
 		done: make(chan struct{}),
 	}
 }
 
-// Serve serves the listening portion of the client.
+// SetKeepAlive sets the TCP keep-alive period of dialed and accepted connections.
+// Zero enables keep-alives with the system default period, negative disables them.
+func (c *Client) SetKeepAlive(period time.Duration) {
+	c.mx.Lock()
+	c.keepAlive = period
+	c.mx.Unlock()
+}
+
+func (c *Client) keepAlivePeriod() time.Duration {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	return c.keepAlive
+}
+
+// SetReuseAddr enables SO_REUSEADDR and SO_REUSEPORT on TCP listeners started by subsequent calls to Serve.
+// This allows a restarted client to listen on an address that still has connections in TIME_WAIT.
+// It has no effect on platforms that do not support SO_REUSEPORT.
+func (c *Client) SetReuseAddr(reuse bool) {
+	c.mx.Lock()
+	c.reuseAddr = reuse
+	c.mx.Unlock()
+}
+
+func (c *Client) listenConfig() *net.ListenConfig {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	if !c.reuseAddr {
+		return &net.ListenConfig{}
+	}
+	return &net.ListenConfig{Control: reuseControl}
+}
+
+// SetResolveRetry sets how dials look up remote addresses in the PKTable, which may be backed by a remote resolver.
+// A failed lookup is retried with exponential backoff until it was attempted the given number of times
+// or the timeout elapses. Attempts of 1 or less disable retries, a timeout of 0 means no limit other than the dial context.
+func (c *Client) SetResolveRetry(attempts int, timeout time.Duration) {
+	c.mx.Lock()
+	c.resolveAttempts = attempts
+	c.resolveTimeout = timeout
+	c.mx.Unlock()
+}
+
+func (c *Client) resolveRetry() (int, time.Duration) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	return c.resolveAttempts, c.resolveTimeout
+}
+
+// resolve obtains the TCP address of rPK from the PKTable, retrying failed lookups as set by SetResolveRetry.
+func (c *Client) resolve(ctx context.Context, rPK cipher.PubKey) (string, error) {
+	attempts, timeout := c.resolveRetry()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	retry := ResolveMinRetry
+	for i := 1; ; i++ {
+		if addr, ok := c.t.Addr(rPK); ok {
+			return addr, nil
+		}
+		if i >= attempts {
+			return "", fmt.Errorf("pk table: entry of %s does not exist", rPK)
+		}
+
+		c.log.Warnf("pk table: entry of %s does not exist: trying again in %v...", rPK, retry)
+		select {
+		case <-ctx.Done():
+			return "", fmt.Errorf("pk table: entry of %s does not exist: %v", rPK, ctx.Err())
+		case <-c.done:
+			return "", io.ErrClosedPipe
+		case <-time.After(retry):
+		}
+		if retry *= 2; retry > ResolveMaxRetry {
+			retry = ResolveMaxRetry
+		}
+	}
+}
+
+// SetTLSConfig enables TLS for dialed and accepted connections. The TLS handshake is performed before the stcp handshake.
+// Both ends of a connection must have TLS either enabled or disabled, otherwise the handshake fails.
+// A nil config disables TLS.
+func (c *Client) SetTLSConfig(conf *tls.Config) {
+	c.mx.Lock()
+	c.tlsConf = conf
+	c.mx.Unlock()
+}
+
+func (c *Client) tlsConfig() *tls.Config {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	return c.tlsConf
+}
+
+// wrapTLS wraps the TCP connection in TLS if enabled.
+// If the TLS config has no ServerName, the host of the dialed address is used to verify the remote certificate.
+func (c *Client) wrapTLS(tcpConn net.Conn, initiator bool, tcpAddr string) net.Conn {
+	conf := c.tlsConfig()
+	if conf == nil {
+		return tcpConn
+	}
+	if !initiator {
+		return tls.Server(tcpConn, conf)
+	}
+	if conf.ServerName == "" {
+		if host, _, err := net.SplitHostPort(tcpAddr); err == nil {
+			conf = conf.Clone()
+			conf.ServerName = host
+		}
+	}
+	return tls.Client(tcpConn, conf)
+}
+
+// Serve serves the listening portion of the client on the given TCP address.
+// It may be called once per local address to accept connections on several addresses.
 func (c *Client) Serve(tcpAddr string) error {
-	if c.lTCP != nil {
-		return errors.New("already listening")
+	if c.isClosed() {
+		return io.ErrClosedPipe
 	}
 
-	lTCP, err := net.Listen("tcp", tcpAddr)
+	lTCP, err := c.listenConfig().Listen(context.Background(), "tcp", tcpAddr)
 	if err != nil {
 		return err
 	}
-	c.lTCP = lTCP
+
+	c.mx.Lock()
+	if c.isClosed() {
+		c.mx.Unlock()
+		_ = lTCP.Close() //nolint:errcheck
+		return io.ErrClosedPipe
+	}
+	c.lTCP = append(c.lTCP, lTCP)
+	c.mx.Unlock()
 	c.log.Infof("listening on tcp addr: %v", lTCP.Addr())
 
 	go func() {
 		for {
-			if err := c.acceptTCPConn(); err != nil {
+			if err := c.acceptTCPConn(lTCP); err != nil {
 				c.log.Warnf("failed to accept incoming connection: %v", err)
 				if !IsHandshakeError(err) {
 					c.log.Warnf("stopped serving stcp")
@@ -178,15 +341,35 @@ func (c *Client) Serve(tcpAddr string) error {
 	return nil
 }
 
-func (c *Client) acceptTCPConn() error {
+// Table returns the table of remote addresses used for dials.
+func (c *Client) Table() PKTable {
+	return c.t
+}
+
+// ServingAddrs returns the TCP addresses the client accepts connections on.
+func (c *Client) ServingAddrs() []net.Addr {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	addrs := make([]net.Addr, 0, len(c.lTCP))
+	for _, lTCP := range c.lTCP {
+		addrs = append(addrs, lTCP.Addr())
+	}
+	return addrs
+}
+
+func (c *Client) acceptTCPConn(lTCP net.Listener) error {
 	if c.isClosed() {
 		return io.ErrClosedPipe
 	}
 
-	tcpConn, err := c.lTCP.Accept()
+	tcpConn, err := lTCP.Accept()
 	if err != nil {
 		return err
 	}
+	if err := setKeepAlive(tcpConn, c.keepAlivePeriod()); err != nil {
+		c.log.WithError(err).Warn("failed to set keep-alive of incoming connection")
+	}
 	var lis *Listener
 	hs := ResponderHandshake(func(f2 Frame2) error {
 		c.mx.Lock()
@@ -197,7 +380,7 @@ func (c *Client) acceptTCPConn() error {
 		}
 		return nil
 	})
-	conn, err := newConn(tcpConn, time.Now().Add(HandshakeTimeout), hs, nil)
+	conn, err := newConn(tcpConn, c.wrapTLS(tcpConn, false, ""), time.Now().Add(HandshakeTimeout), hs, nil)
 	if err != nil {
 		return err
 	}
@@ -210,21 +393,53 @@ func (c *Client) Dial(ctx context.Context, rPK cipher.PubKey, rPort uint16) (*Co
 		return nil, io.ErrClosedPipe
 	}
 
-	tcpAddr, ok := c.t.Addr(rPK)
-	if !ok {
-		return nil, fmt.Errorf("pk table: entry of %s does not exist", rPK)
+	tcpAddr, err := c.resolve(ctx, rPK)
+	if err != nil {
+		return nil, err
 	}
-	conn, err := net.Dial("tcp", tcpAddr)
+	dialer := net.Dialer{KeepAlive: c.keepAlivePeriod()}
+	tcpConn, err := dialer.DialContext(ctx, "tcp", tcpAddr)
 	if err != nil {
 		return nil, err
 	}
 
 	lPort, freePort, err := c.p.ReserveEphemeral(ctx)
 	if err != nil {
+		_ = tcpConn.Close() //nolint:errcheck
 		return nil, err
 	}
+	deadline := time.Now().Add(HandshakeTimeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
 	hs := InitiatorHandshake(c.lSK, dmsg.Addr{PK: c.lPK, Port: lPort}, dmsg.Addr{PK: rPK, Port: rPort})
-	return newConn(conn, time.Now().Add(HandshakeTimeout), hs, freePort)
+
+	// Abort the handshake if ctx is cancelled, deadlines of ctx are already applied to the handshake.
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	var aborted bool
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			if ctx.Err() == context.Canceled {
+				aborted = true
+				_ = tcpConn.Close() //nolint:errcheck
+			}
+		case <-stop:
+		}
+	}()
+	conn, err := newConn(tcpConn, c.wrapTLS(tcpConn, true, tcpAddr), deadline, hs, freePort)
+	close(stop)
+	<-stopped
+
+	if aborted {
+		if err == nil {
+			_ = conn.Close() //nolint:errcheck
+		}
+		return nil, context.Canceled
+	}
+	return conn, err
 }
 
 // Listen creates a new listener for stcp.
@@ -259,8 +474,8 @@ func (c *Client) Close() error {
 		c.mx.Lock()
 		defer c.mx.Unlock()
 
-		if c.lTCP != nil {
-			_ = c.lTCP.Close() //nolint:errcheck
+		for _, lTCP := range c.lTCP {
+			_ = lTCP.Close() //nolint:errcheck
 		}
 
 		for _, lis := range c.lMap {
@@ -278,3 +493,20 @@ func (c *Client) isClosed() bool {
 		return false
 	}
 }
+
+func setKeepAlive(conn net.Conn, period time.Duration) error {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return nil
+	}
+	if period < 0 {
+		return tcpConn.SetKeepAlive(false)
+	}
+	if err := tcpConn.SetKeepAlive(true); err != nil {
+		return err
+	}
+	if period == 0 {
+		return nil
+	}
+	return tcpConn.SetKeepAlivePeriod(period)
+}
@@ -0,0 +1,191 @@
+package stcp
+
+import (
+	"context"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/SkycoinProject/dmsg/cipher"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/nettest"
+)
+
+func TestClient_ServeMultipleAddrs(t *testing.T) {
+	const port = uint16(10)
+
+	rPK, rSK := cipher.GenerateKeyPair()
+	rC := NewClient(nil, rPK, rSK, NewTable(nil))
+	defer func() { assert.NoError(t, rC.Close()) }()
+
+	var rAddrs []string
+	for i := 0; i < 2; i++ {
+		l, err := nettest.NewLocalListener("tcp")
+		require.NoError(t, err)
+		rAddrs = append(rAddrs, l.Addr().String())
+		require.NoError(t, l.Close())
+
+		require.NoError(t, rC.Serve(rAddrs[i]))
+	}
+	require.Len(t, rC.ServingAddrs(), 2)
+
+	lis, err := rC.Listen(port)
+	require.NoError(t, err)
+
+	// Each initiator only knows one of the responder's addresses.
+	for _, rAddr := range rAddrs {
+		iPK, iSK := cipher.GenerateKeyPair()
+		iC := NewClient(nil, iPK, iSK, NewTable(map[cipher.PubKey]string{rPK: rAddr}))
+
+		iConn, err := iC.Dial(context.TODO(), rPK, port)
+		require.NoError(t, err)
+
+		rConn, err := lis.Accept()
+		require.NoError(t, err)
+		assert.Equal(t, iPK, rConn.(*Conn).rAddr.PK)
+
+		msg := []byte("hello via " + rAddr)
+		_, err = iConn.Write(msg)
+		require.NoError(t, err)
+		got := make([]byte, len(msg))
+		_, err = io.ReadFull(rConn, got)
+		require.NoError(t, err)
+		assert.Equal(t, msg, got)
+
+		assert.NoError(t, iConn.Close())
+		assert.NoError(t, rConn.Close())
+		assert.NoError(t, iC.Close())
+	}
+}
+
+func TestClient_ReuseAddr(t *testing.T) {
+	const port = uint16(10)
+
+	l, err := nettest.NewLocalListener("tcp")
+	require.NoError(t, err)
+	rAddr := l.Addr().String()
+	require.NoError(t, l.Close())
+
+	rPK, rSK := cipher.GenerateKeyPair()
+	iPK, iSK := cipher.GenerateKeyPair()
+
+	for i := 0; i < 2; i++ {
+		rC := NewClient(nil, rPK, rSK, NewTable(nil))
+		rC.SetReuseAddr(true)
+		require.NoError(t, rC.Serve(rAddr))
+
+		lis, err := rC.Listen(port)
+		require.NoError(t, err)
+
+		iC := NewClient(nil, iPK, iSK, NewTable(map[cipher.PubKey]string{rPK: rAddr}))
+		iConn, err := iC.Dial(context.TODO(), rPK, port)
+		require.NoError(t, err)
+		rConn, err := lis.Accept()
+		require.NoError(t, err)
+
+		// Closing the accepted side first leaves the listening address in TIME_WAIT.
+		assert.NoError(t, rConn.Close())
+		assert.NoError(t, iConn.Close())
+		assert.NoError(t, iC.Close())
+		assert.NoError(t, rC.Close())
+	}
+}
+
+// flakyTable is a PKTable whose first lookups of an address fail.
+type flakyTable struct {
+	PKTable
+	fails   int
+	lookups int
+	mx      sync.Mutex
+}
+
+func (ft *flakyTable) Addr(pk cipher.PubKey) (string, bool) {
+	ft.mx.Lock()
+	defer ft.mx.Unlock()
+
+	ft.lookups++
+	if ft.lookups <= ft.fails {
+		return "", false
+	}
+	return ft.PKTable.Addr(pk)
+}
+
+func (ft *flakyTable) Lookups() int {
+	ft.mx.Lock()
+	defer ft.mx.Unlock()
+	return ft.lookups
+}
+
+func TestClient_ResolveRetry(t *testing.T) {
+	const port = uint16(10)
+
+	l, err := nettest.NewLocalListener("tcp")
+	require.NoError(t, err)
+	rAddr := l.Addr().String()
+	require.NoError(t, l.Close())
+
+	rPK, rSK := cipher.GenerateKeyPair()
+	rC := NewClient(nil, rPK, rSK, NewTable(nil))
+	defer func() { assert.NoError(t, rC.Close()) }()
+	require.NoError(t, rC.Serve(rAddr))
+
+	lis, err := rC.Listen(port)
+	require.NoError(t, err)
+
+	iPK, iSK := cipher.GenerateKeyPair()
+	newTable := func(fails int) *flakyTable {
+		return &flakyTable{PKTable: NewTable(map[cipher.PubKey]string{rPK: rAddr}), fails: fails}
+	}
+
+	t.Run("no_retry_by_default", func(t *testing.T) {
+		table := newTable(1)
+		iC := NewClient(nil, iPK, iSK, table)
+		defer func() { assert.NoError(t, iC.Close()) }()
+
+		_, err := iC.Dial(context.TODO(), rPK, port)
+		assert.Error(t, err)
+		assert.Equal(t, 1, table.Lookups())
+	})
+
+	t.Run("retries_failed_lookup", func(t *testing.T) {
+		table := newTable(1)
+		iC := NewClient(nil, iPK, iSK, table)
+		defer func() { assert.NoError(t, iC.Close()) }()
+		iC.SetResolveRetry(3, 0)
+
+		iConn, err := iC.Dial(context.TODO(), rPK, port)
+		require.NoError(t, err)
+		assert.Equal(t, 2, table.Lookups())
+
+		rConn, err := lis.Accept()
+		require.NoError(t, err)
+		assert.NoError(t, rConn.Close())
+		assert.NoError(t, iConn.Close())
+	})
+
+	t.Run("gives_up_after_attempts", func(t *testing.T) {
+		table := newTable(5)
+		iC := NewClient(nil, iPK, iSK, table)
+		defer func() { assert.NoError(t, iC.Close()) }()
+		iC.SetResolveRetry(3, 0)
+
+		_, err := iC.Dial(context.TODO(), rPK, port)
+		assert.Error(t, err)
+		assert.Equal(t, 3, table.Lookups())
+	})
+
+	t.Run("gives_up_after_timeout", func(t *testing.T) {
+		table := newTable(5)
+		iC := NewClient(nil, iPK, iSK, table)
+		defer func() { assert.NoError(t, iC.Close()) }()
+		iC.SetResolveRetry(10, ResolveMinRetry/2)
+
+		start := time.Now()
+		_, err := iC.Dial(context.TODO(), rPK, port)
+		assert.Error(t, err)
+		assert.True(t, time.Since(start) < ResolveMinRetry)
+		assert.Equal(t, 1, table.Lookups())
+	})
+}
@@ -21,8 +21,14 @@ const (
 
 	// HandshakeNonceSize is the size of the nonce for the handshake.
 	HandshakeNonceSize = 16
+
+	// MaxHandshakeFrameSize is the maximum size of an encoded handshake frame.
+	MaxHandshakeFrameSize = 4096
 )
 
+// ErrHandshakeFrameTooLarge occurs when a received handshake frame exceeds MaxHandshakeFrameSize.
+var ErrHandshakeFrameTooLarge = fmt.Errorf("handshake frame exceeds %d bytes", MaxHandshakeFrameSize)
+
 // HandshakeError occurs when the handshake fails.
 type HandshakeError string
 
@@ -179,7 +185,7 @@ func writeFrame1(w io.Writer, nonce [HandshakeNonceSize]byte) error {
 
 func readFrame1(r io.Reader) (Frame1, error) {
 	var f1 Frame1
-	err := json.NewDecoder(r).Decode(&f1)
+	err := readFrame(r, &f1)
 	return f1, err
 }
 
@@ -189,7 +195,7 @@ func writeFrame2(w io.Writer, f2 Frame2) error {
 
 func readFrame2(r io.Reader) (Frame2, error) {
 	var f2 Frame2
-	err := json.NewDecoder(r).Decode(&f2)
+	err := readFrame(r, &f2)
 	return f2, err
 }
 
@@ -204,6 +210,18 @@ func writeFrame3(w io.Writer, err error) error {
 
 func readFrame3(r io.Reader) (Frame3, error) {
 	var f3 Frame3
-	err := json.NewDecoder(r).Decode(&f3)
+	err := readFrame(r, &f3)
 	return f3, err
 }
+
+// readFrame decodes a handshake frame, reading at most MaxHandshakeFrameSize bytes from r.
+func readFrame(r io.Reader, v interface{}) error {
+	lr := &io.LimitedReader{R: r, N: MaxHandshakeFrameSize}
+	if err := json.NewDecoder(lr).Decode(v); err != nil {
+		if lr.N == 0 {
+			return ErrHandshakeFrameTooLarge
+		}
+		return err
+	}
+	return nil
+}
@@ -1,8 +1,11 @@
 package stcp
 
 import (
+	"bytes"
 	"errors"
+	"io"
 	"net"
+	"strings"
 	"testing"
 	"time"
 
@@ -64,3 +67,39 @@ func TestHandshake(t *testing.T) {
 		assert.NoError(t, respC.Close())
 	}
 }
+
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += n
+	return n, err
+}
+
+type endlessReader byte
+
+func (b endlessReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = byte(b)
+	}
+	return len(p), nil
+}
+
+func TestReadFrame_TooLarge(t *testing.T) {
+	// The frame never ends, so it only fails because of the size limit.
+	r := &countingReader{r: io.MultiReader(strings.NewReader(`{"ErrMsg":"`), endlessReader('a'))}
+
+	_, err := readFrame3(r)
+	assert.Equal(t, ErrHandshakeFrameTooLarge, err)
+	assert.True(t, r.n <= MaxHandshakeFrameSize, "read %d bytes", r.n)
+
+	// Frames within the limit are decoded.
+	var buf bytes.Buffer
+	require.NoError(t, writeFrame3(&buf, errors.New("failed")))
+	f3, err := readFrame3(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, Frame3{OK: false, ErrMsg: "failed"}, f3)
+}
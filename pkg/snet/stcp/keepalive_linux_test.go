@@ -0,0 +1,71 @@
+package stcp
+
+import (
+	"context"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/SkycoinProject/dmsg/cipher"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/nettest"
+)
+
+func TestClient_KeepAlive(t *testing.T) {
+	const (
+		port      = uint16(10)
+		keepAlive = 7 * time.Second
+	)
+
+	// Reserve a local address for the responder.
+	l, err := nettest.NewLocalListener("tcp")
+	require.NoError(t, err)
+	rAddr := l.Addr().String()
+	require.NoError(t, l.Close())
+
+	iPK, iSK := cipher.GenerateKeyPair()
+	rPK, rSK := cipher.GenerateKeyPair()
+	table := NewTable(map[cipher.PubKey]string{rPK: rAddr})
+
+	iC := NewClient(nil, iPK, iSK, table)
+	iC.SetKeepAlive(keepAlive)
+	defer func() { assert.NoError(t, iC.Close()) }()
+
+	rC := NewClient(nil, rPK, rSK, table)
+	rC.SetKeepAlive(keepAlive)
+	defer func() { assert.NoError(t, rC.Close()) }()
+	require.NoError(t, rC.Serve(rAddr))
+
+	lis, err := rC.Listen(port)
+	require.NoError(t, err)
+
+	iConn, err := iC.Dial(context.TODO(), rPK, port)
+	require.NoError(t, err)
+	defer func() { assert.NoError(t, iConn.Close()) }()
+
+	rConn, err := lis.Accept()
+	require.NoError(t, err)
+	defer func() { assert.NoError(t, rConn.Close()) }()
+
+	for _, conn := range []*Conn{iConn, rConn.(*Conn)} {
+		tcpConn, err := conn.tcpConn()
+		require.NoError(t, err)
+		assert.Equal(t, 1, sockOpt(t, tcpConn, syscall.SOL_SOCKET, syscall.SO_KEEPALIVE))
+		assert.Equal(t, int(keepAlive/time.Second), sockOpt(t, tcpConn, syscall.IPPROTO_TCP, syscall.TCP_KEEPIDLE))
+	}
+}
+
+func sockOpt(t *testing.T, conn *net.TCPConn, level, opt int) int {
+	rawConn, err := conn.SyscallConn()
+	require.NoError(t, err)
+
+	var v int
+	var optErr error
+	require.NoError(t, rawConn.Control(func(fd uintptr) {
+		v, optErr = syscall.GetsockoptInt(int(fd), level, opt)
+	}))
+	require.NoError(t, optErr)
+	return v
+}
@@ -7,6 +7,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	"github.com/SkycoinProject/dmsg/cipher"
 )
@@ -15,24 +16,27 @@ import (
 type PKTable interface {
 	Addr(pk cipher.PubKey) (string, bool)
 	PubKey(addr string) (cipher.PubKey, bool)
+	SetAddr(pk cipher.PubKey, addr string)
 	Count() int
 }
 
 type memoryTable struct {
 	entries map[cipher.PubKey]string
 	reverse map[string]cipher.PubKey
+	mx      sync.RWMutex
 }
 
 // NewTable instantiates a memory implementation of PKTable.
 func NewTable(entries map[cipher.PubKey]string) PKTable {
-	reverse := make(map[string]cipher.PubKey, len(entries))
-	for pk, addr := range entries {
-		reverse[addr] = pk
+	mt := &memoryTable{
+		entries: make(map[cipher.PubKey]string, len(entries)),
+		reverse: make(map[string]cipher.PubKey, len(entries)),
 	}
-	return &memoryTable{
-		entries: entries,
-		reverse: reverse,
+	for pk, addr := range entries {
+		mt.entries[pk] = addr
+		mt.reverse[addr] = pk
 	}
+	return mt
 }
 
 // NewTableFromFile is similar to NewTable, but grabs predefined values
@@ -72,17 +76,38 @@ func NewTableFromFile(path string) (PKTable, error) {
 
 // Addr obtains the address associated with the given public key.
 func (mt *memoryTable) Addr(pk cipher.PubKey) (string, bool) {
+	mt.mx.RLock()
+	defer mt.mx.RUnlock()
+
 	addr, ok := mt.entries[pk]
 	return addr, ok
 }
 
 // PubKey obtains the public key associated with the given public key.
 func (mt *memoryTable) PubKey(addr string) (cipher.PubKey, bool) {
+	mt.mx.RLock()
+	defer mt.mx.RUnlock()
+
 	pk, ok := mt.reverse[addr]
 	return pk, ok
 }
 
+// SetAddr associates the given public key with the given address, replacing its previous address.
+func (mt *memoryTable) SetAddr(pk cipher.PubKey, addr string) {
+	mt.mx.Lock()
+	defer mt.mx.Unlock()
+
+	if oldAddr, ok := mt.entries[pk]; ok {
+		delete(mt.reverse, oldAddr)
+	}
+	mt.entries[pk] = addr
+	mt.reverse[addr] = pk
+}
+
 // Count returns the number of entries within the PKTable implementation.
 func (mt *memoryTable) Count() int {
+	mt.mx.RLock()
+	defer mt.mx.RUnlock()
+
 	return len(mt.entries)
 }
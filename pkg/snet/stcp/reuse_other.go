@@ -0,0 +1,13 @@
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package stcp
+
+import (
+	"syscall"
+)
+
+// reuseControl is a no-op on platforms without SO_REUSEPORT.
+func reuseControl(_, _ string, _ syscall.RawConn) error {
+	return nil
+}
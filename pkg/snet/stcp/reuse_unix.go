@@ -0,0 +1,25 @@
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+package stcp
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// reuseControl sets SO_REUSEADDR and SO_REUSEPORT on a listening socket before it is bound.
+func reuseControl(_, _ string, c syscall.RawConn) error {
+	var sErr error
+	err := c.Control(func(fd uintptr) {
+		if sErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEADDR, 1); sErr != nil {
+			return
+		}
+		sErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return sErr
+}
@@ -0,0 +1,127 @@
+package stcp
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/SkycoinProject/dmsg/cipher"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/nettest"
+)
+
+func TestClient_TLS(t *testing.T) {
+	const port = uint16(10)
+
+	tlsConf := selfSignedTLSConfig(t)
+
+	newPair := func(t *testing.T, iConf, rConf *tls.Config) (iC, rC *Client, rPK cipher.PubKey) {
+		l, err := nettest.NewLocalListener("tcp")
+		require.NoError(t, err)
+		rAddr := l.Addr().String()
+		require.NoError(t, l.Close())
+
+		iPK, iSK := cipher.GenerateKeyPair()
+		rPK, rSK := cipher.GenerateKeyPair()
+		table := NewTable(map[cipher.PubKey]string{rPK: rAddr})
+
+		iC = NewClient(nil, iPK, iSK, table)
+		iC.SetTLSConfig(iConf)
+
+		rC = NewClient(nil, rPK, rSK, table)
+		rC.SetTLSConfig(rConf)
+		require.NoError(t, rC.Serve(rAddr))
+
+		return iC, rC, rPK
+	}
+
+	t.Run("tls", func(t *testing.T) {
+		iC, rC, rPK := newPair(t, tlsConf, tlsConf)
+		defer func() { assert.NoError(t, iC.Close()) }()
+		defer func() { assert.NoError(t, rC.Close()) }()
+
+		lis, err := rC.Listen(port)
+		require.NoError(t, err)
+
+		iConn, err := iC.Dial(context.TODO(), rPK, port)
+		require.NoError(t, err)
+		defer func() { assert.NoError(t, iConn.Close()) }()
+
+		rConn, err := lis.Accept()
+		require.NoError(t, err)
+		defer func() { assert.NoError(t, rConn.Close()) }()
+
+		for _, conn := range []*Conn{iConn, rConn.(*Conn)} {
+			_, ok := conn.Conn.(*tls.Conn)
+			assert.True(t, ok)
+
+			_, err := conn.tcpConn()
+			assert.NoError(t, err)
+		}
+
+		msg := []byte("hello over tls")
+		_, err = iConn.Write(msg)
+		require.NoError(t, err)
+		got := make([]byte, len(msg))
+		_, err = io.ReadFull(rConn, got)
+		require.NoError(t, err)
+		assert.Equal(t, msg, got)
+	})
+
+	t.Run("mismatch", func(t *testing.T) {
+		iC, rC, rPK := newPair(t, nil, tlsConf)
+		defer func() { assert.NoError(t, iC.Close()) }()
+		defer func() { assert.NoError(t, rC.Close()) }()
+
+		_, err := rC.Listen(port)
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_, err = iC.Dial(ctx, rPK, port)
+		assert.Error(t, err)
+		assert.True(t, IsHandshakeError(err))
+	})
+}
+
+// selfSignedTLSConfig returns a TLS config with a certificate for 127.0.0.1 which is also its only root CA.
+func selfSignedTLSConfig(t *testing.T) *tls.Config {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "stcp"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1"), net.IPv6loopback},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key, Leaf: cert}},
+		RootCAs:      pool,
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
+	"net"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -27,6 +29,28 @@ var (
 
 	// ErrConnAlreadyExists occurs when an underlying transport connection already exists.
 	ErrConnAlreadyExists = errors.New("underlying transport connection already exists")
+
+	// ErrTruncatedWrite occurs when a packet is only partially written to the underlying connection.
+	ErrTruncatedWrite = errors.New("packet was only partially written to the underlying connection")
+
+	// ErrWriteStalled occurs when a packet write to the underlying connection blocks longer than the write stall timeout.
+	ErrWriteStalled = errors.New("packet write to the underlying connection stalled")
+
+	// ErrTruncatedRead occurs when reading a packet from the underlying connection fails after part of it was read.
+	ErrTruncatedRead = errors.New("packet was only partially read from the underlying connection")
+
+	// ErrPayloadTooLarge occurs on attempt to write a packet of which the payload exceeds MaxPayloadSize.
+	ErrPayloadTooLarge = errors.New("packet payload exceeds the maximum payload size of the transport")
+
+	// ErrPacketTypeUnsupported occurs on attempt to write a packet other than a routing.DataPacket over
+	// an underlying connection which does not carry packet types.
+	ErrPacketTypeUnsupported = errors.New("underlying transport connection does not carry packet types")
+)
+
+// Retry delays of transient read errors of the underlying connection.
+const (
+	minReadRetry = 10 * time.Millisecond
+	maxReadRetry = time.Second
 )
 
 // ManagedTransport manages a direct line of communication between two visor nodes.
@@ -48,6 +72,10 @@ type ManagedTransport struct {
 	connCh chan struct{}
 	connMx sync.Mutex
 
+	writeStallTimeout time.Duration
+	writeGate         writeGate
+	onStateChange     func() // called once the transport goes up or down, may be nil
+
 	done chan struct{}
 	once sync.Once
 	wg   sync.WaitGroup
@@ -72,7 +100,7 @@ func NewManagedTransport(n *snet.Network, dc DiscoveryClient, ls LogStore, rPK c
 }
 
 // Serve serves and manages the transport.
-func (mt *ManagedTransport) Serve(readCh chan<- routing.Packet, done <-chan struct{}) {
+func (mt *ManagedTransport) Serve(readQ *readQueue, done <-chan struct{}) {
 	defer mt.wg.Done()
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -105,6 +133,7 @@ func (mt *ManagedTransport) Serve(readCh chan<- routing.Packet, done <-chan stru
 			mt.conn = nil
 		}
 		mt.connMx.Unlock()
+		mt.stateChanged()
 	}()
 
 	// Read loop.
@@ -113,22 +142,35 @@ func (mt *ManagedTransport) Serve(readCh chan<- routing.Packet, done <-chan stru
 			mt.log.Infof("closed readPacket loop.")
 			mt.wg.Done()
 		}()
+		retry := minReadRetry
 		for {
-			p, err := mt.readPacket()
+			p, ttl, seq, typ, err := mt.readPacket()
 			if err != nil {
 				if err == ErrNotServing {
 					return
 				}
+				// The connection is kept after transient errors, other errors close it so that it is redialed.
+				if isTransientReadErr(err) {
+					mt.log.Warnf("failed to read packet: %v: trying again in %v...", err, retry)
+					select {
+					case <-mt.done:
+						return
+					case <-time.After(retry):
+					}
+					if retry *= 2; retry > maxReadRetry {
+						retry = maxReadRetry
+					}
+					continue
+				}
 				mt.connMx.Lock()
 				mt.clearConn(ctx)
 				mt.connMx.Unlock()
 				mt.log.Warnf("failed to read packet: %v", err)
 				continue
 			}
-			select {
-			case <-done:
+			retry = minReadRetry
+			if !readQ.push(p, ttl, seq, typ, mt.rPK, done) {
 				return
-			case readCh <- p:
 			}
 		}
 	}()
@@ -180,14 +222,31 @@ func (mt *ManagedTransport) Close() {
 func (mt *ManagedTransport) close() (closed bool) {
 	mt.once.Do(func() {
 		close(mt.done)
+		mt.stateChanged()
 		mt.wg.Wait()
 		closed = true
 	})
 	return closed
 }
 
-// Accept accepts a new underlying connection.
+func (mt *ManagedTransport) stateChanged() {
+	if mt.onStateChange != nil {
+		mt.onStateChange()
+	}
+}
+
+// Accept performs the settlement handshake of an accepted connection and sets it as the underlying connection.
 func (mt *ManagedTransport) Accept(ctx context.Context, conn *snet.Conn) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*20)
+	defer cancel()
+	if err := MakeSettlementHS(false).Do(ctx, mt.dc, conn, mt.n.LocalSK()); err != nil {
+		return fmt.Errorf("settlement handshake failed: %v", err)
+	}
+	return mt.accept(ctx, conn)
+}
+
+// accept sets a connection which completed the settlement handshake as the underlying connection.
+func (mt *ManagedTransport) accept(ctx context.Context, conn *snet.Conn) error {
 	mt.connMx.Lock()
 	defer mt.connMx.Unlock()
 
@@ -202,12 +261,6 @@ func (mt *ManagedTransport) Accept(ctx context.Context, conn *snet.Conn) error {
 		return ErrNotServing
 	}
 
-	ctx, cancel := context.WithTimeout(ctx, time.Second*20)
-	defer cancel()
-	if err := MakeSettlementHS(false).Do(ctx, mt.dc, conn, mt.n.LocalSK()); err != nil {
-		return fmt.Errorf("settlement handshake failed: %v", err)
-	}
-
 	return mt.setIfConnNil(ctx, conn)
 }
 
@@ -227,7 +280,7 @@ func (mt *ManagedTransport) Dial(ctx context.Context) error {
 }
 
 func (mt *ManagedTransport) dial(ctx context.Context) error {
-	tp, err := mt.n.Dial(mt.netName, mt.rPK, snet.TransportPort)
+	tp, err := mt.n.DialContext(ctx, mt.netName, mt.rPK, snet.TransportPort)
 	if err != nil {
 		return err
 	}
@@ -269,6 +322,7 @@ func (mt *ManagedTransport) setIfConnNil(ctx context.Context, conn *snet.Conn) e
 	}
 
 	mt.conn = conn
+	mt.stateChanged()
 	select {
 	case mt.connCh <- struct{}{}:
 	default:
@@ -282,6 +336,7 @@ func (mt *ManagedTransport) clearConn(ctx context.Context) {
 			log.WithError(err).Warn("Failed to close connection")
 		}
 		mt.conn = nil
+		mt.stateChanged()
 	}
 	if _, err := mt.dc.UpdateStatuses(ctx, &Status{ID: mt.Entry.ID, IsUp: false}); err != nil {
 		mt.log.Warnf("Failed to update transport status: %s", err)
@@ -289,8 +344,87 @@ func (mt *ManagedTransport) clearConn(ctx context.Context) {
 	mt.log.Infoln("Status updated: DOWN")
 }
 
-// WritePacket writes a packet to the remote.
+// WritePacket writes a data packet to the remote.
 func (mt *ManagedTransport) WritePacket(ctx context.Context, rtID routing.RouteID, payload []byte) error {
+	return mt.WritePacketWithPriority(ctx, PriorityData, rtID, payload)
+}
+
+// WritePacketWithTTL writes a data packet with the given TTL to the remote.
+// The TTL is only sent if both ends of the underlying connection enabled snet.FeaturePacketTTL,
+// otherwise the remote reads the packet with routing.DefaultPacketTTL.
+func (mt *ManagedTransport) WritePacketWithTTL(ctx context.Context, rtID routing.RouteID, ttl uint8, payload []byte) error {
+	return mt.writePacket(ctx, PriorityData, rtID, ttl, 0, routing.DataPacket, payload)
+}
+
+// WritePacketWithSeq is like WritePacketWithTTL, but also writes the sequence number seq of the packet.
+// The sequence number is only sent if both ends of the underlying connection enabled snet.FeaturePacketSeq,
+// otherwise the remote reads the packet with sequence number 0, which means none.
+func (mt *ManagedTransport) WritePacketWithSeq(ctx context.Context, rtID routing.RouteID, ttl uint8, seq uint32, payload []byte) error {
+	return mt.writePacket(ctx, PriorityData, rtID, ttl, seq, routing.DataPacket, payload)
+}
+
+// WriteControlPacket writes a packet of type typ with the given TTL to the remote, ahead of waiting data writes.
+// Types are only sent if both ends of the underlying connection enabled snet.FeaturePacketType, otherwise
+// ErrPacketTypeUnsupported is returned, as the remote would read the packet as data.
+func (mt *ManagedTransport) WriteControlPacket(ctx context.Context, rtID routing.RouteID, ttl uint8, typ routing.PacketType, payload []byte) error {
+	return mt.writePacket(ctx, PriorityControl, rtID, ttl, 0, typ, payload)
+}
+
+// WritePacketWithPriority writes a packet of the given priority class to the remote.
+// Concurrent writes are performed one at a time, waiting writes of a higher priority class go first
+// and waiting data writes are shared among routes, see SetRouteWeight.
+func (mt *ManagedTransport) WritePacketWithPriority(ctx context.Context, prio Priority, rtID routing.RouteID, payload []byte) error {
+	return mt.writePacket(ctx, prio, rtID, routing.DefaultPacketTTL, 0, routing.DataPacket, payload)
+}
+
+// SetRouteWeight sets the share of waiting data writes granted to packets of rtID.
+// Waiting data writes are performed round-robin among routes, a route of weight w gets w writes per turn.
+// A weight below 1 restores the default weight of 1.
+func (mt *ManagedTransport) SetRouteWeight(rtID routing.RouteID, weight int) {
+	mt.writeGate.setWeight(rtID, weight)
+}
+
+// MaxPayloadSize returns the largest payload of a packet that is written to the remote in one piece,
+// see snet.Conn.MaxPayloadSize. While the transport is not connected, the limit of its network type is returned.
+func (mt *ManagedTransport) MaxPayloadSize() int {
+	return maxPayloadSize(mt.getConn(), mt.netName)
+}
+
+// maxPayloadSize returns the largest payload of a packet written to conn, which is nil if not connected.
+func maxPayloadSize(conn *snet.Conn, network string) int {
+	size, header := snet.MaxWriteSize(network), routing.TypedPacketHeaderSize
+	if conn != nil {
+		size, header = conn.MaxPayloadSize(), routing.PacketHeaderSize
+		switch withTTL, withSeq, withType := packetExtensions(conn); {
+		case withType:
+			header = routing.TypedPacketHeaderSize
+		case withSeq:
+			header = routing.SeqPacketHeaderSize
+		case withTTL:
+			header = routing.TTLPacketHeaderSize
+		}
+	}
+	if size > 0 && size-header < math.MaxUint16 {
+		return size - header
+	}
+	return math.MaxUint16
+}
+
+// packetExtensions returns whether packets exchanged over conn carry a TTL, a sequence number and a type.
+// Each extension is only carried along with the ones before it.
+func packetExtensions(conn *snet.Conn) (withTTL, withSeq, withType bool) {
+	withTTL = conn.HasFeature(snet.FeaturePacketTTL)
+	withSeq = withTTL && conn.HasFeature(snet.FeaturePacketSeq)
+	withType = withSeq && conn.HasFeature(snet.FeaturePacketType)
+	return withTTL, withSeq, withType
+}
+
+func (mt *ManagedTransport) writePacket(ctx context.Context, prio Priority, rtID routing.RouteID, ttl uint8, seq uint32, typ routing.PacketType, payload []byte) error {
+	if err := mt.writeGate.acquire(ctx, prio, rtID); err != nil {
+		return err
+	}
+	defer mt.writeGate.release()
+
 	mt.connMx.Lock()
 	defer mt.connMx.Unlock()
 
@@ -304,19 +438,80 @@ func (mt *ManagedTransport) WritePacket(ctx context.Context, rtID routing.RouteI
 		}
 	}
 
-	n, err := mt.conn.Write(routing.MakePacket(rtID, payload))
+	if len(payload) > maxPayloadSize(mt.conn, mt.netName) {
+		return ErrPayloadTooLarge
+	}
+
+	var packet []byte
+	switch withTTL, withSeq, withType := packetExtensions(mt.conn); {
+	case withType:
+		packet = routing.MakeTypedPacket(rtID, ttl, seq, typ, payload)
+	case typ != routing.DataPacket:
+		return ErrPacketTypeUnsupported
+	case withSeq:
+		packet = routing.MakeSeqPacket(rtID, ttl, seq, payload)
+	case withTTL:
+		packet = routing.MakeTTLPacket(rtID, ttl, payload)
+	default:
+		packet = routing.MakePacket(rtID, payload)
+	}
+	n, err := writePacketWithTimeout(mt.conn, packet, mt.writeStallTimeout)
 	if err != nil {
+		if err == ErrWriteStalled {
+			mt.log.Warnf("packet write stalled for %s after %d bytes: rtID(%d)", mt.writeStallTimeout, n, rtID)
+		}
+		if err == ErrTruncatedWrite {
+			mt.log.Warnf("packet truncated after %d bytes: rtID(%d)", n, rtID)
+		}
 		mt.clearConn(ctx)
 		return err
 	}
-	if n > routing.PacketHeaderSize {
-		mt.logSent(uint64(n - routing.PacketHeaderSize))
+	if len(payload) > 0 {
+		mt.logSent(uint64(len(payload)))
 	}
 	return nil
 }
 
+// writePacket writes the whole packet to 'w', retrying on short writes.
+// ErrTruncatedWrite is returned if 'w' fails after part of the packet has been written.
+func writePacket(w io.Writer, packet []byte) (int, error) {
+	var n int
+	for n < len(packet) {
+		nn, err := w.Write(packet[n:])
+		n += nn
+		if err == nil && nn == 0 {
+			err = io.ErrShortWrite
+		}
+		if err != nil {
+			if n > 0 {
+				return n, ErrTruncatedWrite
+			}
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// writePacketWithTimeout writes the packet to 'conn' with writePacket.
+// If the write does not complete within 'timeout', 'conn' is closed to abort it and ErrWriteStalled is returned.
+// A non-positive 'timeout' disables the stall detection.
+func writePacketWithTimeout(conn io.WriteCloser, packet []byte, timeout time.Duration) (int, error) {
+	if timeout <= 0 {
+		return writePacket(conn, packet)
+	}
+
+	timer := time.AfterFunc(timeout, func() {
+		_ = conn.Close() //nolint:errcheck
+	})
+	n, err := writePacket(conn, packet)
+	if !timer.Stop() {
+		return n, ErrWriteStalled
+	}
+	return n, err
+}
+
 // WARNING: Not thread safe.
-func (mt *ManagedTransport) readPacket() (packet routing.Packet, err error) {
+func (mt *ManagedTransport) readPacket() (packet routing.Packet, ttl uint8, seq uint32, typ routing.PacketType, err error) {
 	var conn *snet.Conn
 	for {
 		if conn = mt.getConn(); conn != nil {
@@ -324,25 +519,27 @@ func (mt *ManagedTransport) readPacket() (packet routing.Packet, err error) {
 		}
 		select {
 		case <-mt.done:
-			return nil, ErrNotServing
+			return nil, 0, 0, 0, ErrNotServing
 		case <-mt.connCh:
 		}
 	}
 
-	h := make(routing.Packet, routing.PacketHeaderSize)
-	if _, err = io.ReadFull(conn, h); err != nil {
-		return nil, err
+	withTTL, withSeq, withType := packetExtensions(conn)
+	if packet, ttl, seq, typ, err = readPacketFrom(conn, withTTL, withSeq, withType); err != nil {
+		return nil, 0, 0, 0, err
 	}
-	p := make([]byte, h.Size())
-	if _, err = io.ReadFull(conn, p); err != nil {
-		return nil, err
-	}
-	packet = append(h, p...)
 	if n := len(packet); n > routing.PacketHeaderSize {
 		mt.logRecv(uint64(n - routing.PacketHeaderSize))
 	}
 	mt.log.Infof("recv packet: rtID(%d) size(%d)", packet.RouteID(), packet.Size())
-	return packet, nil
+	return packet, ttl, seq, typ, nil
+}
+
+// isTransientReadErr reports whether reading packets from a connection may succeed after the given error.
+// Errors met within a packet are never transient, as the rest of the packet is lost, see ErrTruncatedRead.
+func isTransientReadErr(err error) bool {
+	netErr, ok := err.(net.Error)
+	return ok && (netErr.Temporary() || netErr.Timeout())
 }
 
 /*
@@ -372,3 +569,6 @@ func (mt *ManagedTransport) Remote() cipher.PubKey { return mt.rPK }
 
 // Type returns the transport type.
 func (mt *ManagedTransport) Type() string { return mt.netName }
+
+// IsUp reports whether the transport is served and has an underlying connection to write packets to.
+func (mt *ManagedTransport) IsUp() bool { return mt.isServing() && mt.getConn() != nil }
@@ -0,0 +1,159 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/SkycoinProject/skywire-mainnet/pkg/routing"
+	"github.com/SkycoinProject/skywire-mainnet/pkg/snet"
+	"github.com/SkycoinProject/skywire-mainnet/pkg/snet/snettest"
+)
+
+// shortWriter writes at most 'max' bytes per call and fails once 'failAfter' bytes are written (if set).
+type shortWriter struct {
+	buf       bytes.Buffer
+	max       int
+	failAfter int
+}
+
+var errWriterBroken = errors.New("writer is broken")
+
+func (w *shortWriter) Write(p []byte) (int, error) {
+	if w.failAfter > 0 && w.buf.Len() >= w.failAfter {
+		return 0, errWriterBroken
+	}
+	if len(p) > w.max {
+		p = p[:w.max]
+	}
+	return w.buf.Write(p)
+}
+
+func TestWritePacket(t *testing.T) {
+	payload := bytes.Repeat([]byte("skywire"), 100)
+	packet := routing.MakePacket(3, payload)
+
+	t.Run("short_writes", func(t *testing.T) {
+		w := &shortWriter{max: 7}
+		n, err := writePacket(w, packet)
+		require.NoError(t, err)
+		assert.Equal(t, len(packet), n)
+
+		h := make(routing.Packet, routing.PacketHeaderSize)
+		_, err = io.ReadFull(&w.buf, h)
+		require.NoError(t, err)
+		p := make([]byte, h.Size())
+		_, err = io.ReadFull(&w.buf, p)
+		require.NoError(t, err)
+		assert.Equal(t, routing.RouteID(3), h.RouteID())
+		assert.Equal(t, payload, p)
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		w := &shortWriter{max: 7, failAfter: 21}
+		n, err := writePacket(w, packet)
+		assert.Equal(t, ErrTruncatedWrite, err)
+		assert.Equal(t, 21, n)
+	})
+
+	t.Run("nothing_written", func(t *testing.T) {
+		_, err := writePacket(&shortWriter{max: 0}, packet)
+		assert.Equal(t, io.ErrShortWrite, err)
+	})
+}
+
+func TestWritePacketWithTimeout(t *testing.T) {
+	packet := routing.MakePacket(3, []byte("skywire"))
+
+	t.Run("stalled", func(t *testing.T) {
+		// The remote end accepts the connection but never drains it.
+		conn, remote := net.Pipe()
+		defer func() { assert.NoError(t, remote.Close()) }()
+
+		start := time.Now()
+		_, err := writePacketWithTimeout(conn, packet, 100*time.Millisecond)
+		assert.Equal(t, ErrWriteStalled, err)
+		assert.True(t, time.Since(start) >= 100*time.Millisecond)
+
+		// The stalled connection is closed.
+		_, err = conn.Write(packet)
+		assert.Equal(t, io.ErrClosedPipe, err)
+	})
+
+	t.Run("drained", func(t *testing.T) {
+		conn, remote := net.Pipe()
+		defer func() { assert.NoError(t, conn.Close()) }()
+		defer func() { assert.NoError(t, remote.Close()) }()
+
+		go func() {
+			_, _ = io.Copy(ioutil.Discard, remote) //nolint:errcheck
+		}()
+
+		n, err := writePacketWithTimeout(conn, packet, time.Second)
+		require.NoError(t, err)
+		assert.Equal(t, len(packet), n)
+	})
+}
+
+// Ensure that a transport keeps its connection on transient read errors and redials it after other errors.
+func TestManagedTransport_ReadErrors(t *testing.T) {
+	keys := snettest.GenKeyPairs(2)
+	nEnv := snettest.NewEnv(t, keys, snet.MemType)
+	defer nEnv.Teardown()
+
+	tpDisc := NewDiscoveryMock()
+	ms := make([]*Manager, len(keys))
+	for i, pair := range keys {
+		m, err := NewManager(nEnv.Nets[i], &ManagerConfig{
+			PubKey:          pair.PK,
+			SecKey:          pair.SK,
+			DiscoveryClient: tpDisc,
+			LogStore:        InMemoryTransportLogStore(),
+		})
+		require.NoError(t, err)
+		go m.Serve(context.TODO())
+		defer func() { require.NoError(t, m.Close()) }()
+		ms[i] = m
+	}
+
+	tp, err := ms[1].SaveTransport(context.TODO(), keys[0].PK, snet.MemType)
+	require.NoError(t, err)
+	var rTp *ManagedTransport
+	for deadline := time.Now().Add(5 * time.Second); rTp == nil; time.Sleep(10 * time.Millisecond) {
+		require.True(t, time.Now().Before(deadline), "transport was not established")
+		rTp = ms[0].Transport(tp.Entry.ID)
+	}
+
+	transfer := func(payload string) {
+		require.NoError(t, tp.WritePacket(context.TODO(), 3, []byte(payload)))
+		packet, err := ms[0].ReadPacket()
+		require.NoError(t, err)
+		assert.Equal(t, []byte(payload), packet.Payload())
+	}
+	transfer("foo")
+
+	// Reads time out while the deadline of the connection is in the past.
+	conn := rTp.getConn()
+	require.NotNil(t, conn)
+	require.NoError(t, conn.SetReadDeadline(time.Now()))
+	time.Sleep(5 * minReadRetry)
+	assert.True(t, rTp.getConn() == conn, "connection was replaced after a timeout")
+
+	require.NoError(t, conn.SetReadDeadline(time.Time{}))
+	transfer("bar")
+	assert.True(t, rTp.getConn() == conn, "connection was replaced after a timeout")
+
+	// Closing the connection makes the transport drop it.
+	require.NoError(t, conn.Close())
+	for deadline := time.Now().Add(5 * time.Second); rTp.getConn() == conn; time.Sleep(10 * time.Millisecond) {
+		require.True(t, time.Now().Before(deadline), "closed connection was kept")
+	}
+}
@@ -3,9 +3,12 @@ package transport
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/SkycoinProject/skywire-mainnet/pkg/routing"
 	"github.com/SkycoinProject/skywire-mainnet/pkg/snet"
@@ -22,8 +25,38 @@ type ManagerConfig struct {
 	DefaultNodes    []cipher.PubKey // Nodes to automatically connect to
 	DiscoveryClient DiscoveryClient
 	LogStore        LogStore
+
+	// WriteStallTimeout is the maximum duration a packet write may block on an underlying connection.
+	// A stalled connection is closed and redialed on the next write. Zero disables the timeout.
+	WriteStallTimeout time.Duration
+
+	// AcceptTimeout is the maximum duration of the settlement handshake of an accepted connection.
+	// Zero uses DefaultAcceptTimeout.
+	AcceptTimeout time.Duration
+
+	// MaxHalfOpen is the maximum number of accepted connections which have not completed the settlement handshake.
+	// Further connections are closed until a handshake completes. Zero uses DefaultMaxHalfOpen.
+	MaxHalfOpen int
+
+	// ReadQueueSize is the number of packets read from transports that are queued for ReadPacket.
+	// Zero uses DefaultReadQueueSize.
+	ReadQueueSize int
+
+	// ReadOverflow is what is done with packets read while the read queue is full.
+	// The default OverflowBlock holds up reading from all transports until ReadPacket catches up.
+	ReadOverflow OverflowPolicy
 }
 
+// Defaults of ManagerConfig.
+const (
+	DefaultAcceptTimeout = 20 * time.Second
+	DefaultMaxHalfOpen   = 64
+)
+
+// ErrTooManyHalfOpen occurs when a connection is accepted while ManagerConfig.MaxHalfOpen connections
+// are in the settlement handshake.
+var ErrTooManyHalfOpen = errors.New("too many half-open transports")
+
 // Manager manages Transports.
 type Manager struct {
 	Logger *logging.Logger
@@ -32,12 +65,15 @@ type Manager struct {
 	tps    map[uuid.UUID]*ManagedTransport
 	n      *snet.Network
 
-	readCh    chan routing.Packet
+	readQ     *readQueue
+	halfOpen  chan struct{} // holds a token per accepted connection in the settlement handshake
 	mx        sync.RWMutex
 	wg        sync.WaitGroup
 	serveOnce sync.Once // ensure we only serve once.
 	closeOnce sync.Once // ensure we only close once.
 	done      chan struct{}
+
+	stateVersion uint64 // see StateVersion, accessed atomically
 }
 
 // NewManager creates a Manager with the provided configuration and transport factories.
@@ -47,14 +83,21 @@ func NewManager(n *snet.Network, config *ManagerConfig) (*Manager, error) {
 	for _, netType := range n.TransportNetworks() {
 		nets[netType] = struct{}{}
 	}
+	if config.AcceptTimeout <= 0 {
+		config.AcceptTimeout = DefaultAcceptTimeout
+	}
+	if config.MaxHalfOpen <= 0 {
+		config.MaxHalfOpen = DefaultMaxHalfOpen
+	}
 	tm := &Manager{
-		Logger: logging.MustGetLogger("tp_manager"),
-		conf:   config,
-		nets:   nets,
-		tps:    make(map[uuid.UUID]*ManagedTransport),
-		n:      n,
-		readCh: make(chan routing.Packet, 20),
-		done:   make(chan struct{}),
+		Logger:   logging.MustGetLogger("tp_manager"),
+		conf:     config,
+		nets:     nets,
+		tps:      make(map[uuid.UUID]*ManagedTransport),
+		n:        n,
+		readQ:    newReadQueue(config.ReadQueueSize, config.ReadOverflow),
+		halfOpen: make(chan struct{}, config.MaxHalfOpen),
+		done:     make(chan struct{}),
 	}
 	return tm, nil
 }
@@ -137,6 +180,8 @@ func (tm *Manager) initTransports(ctx context.Context) {
 	}
 }
 
+// acceptTransport accepts a connection and performs its settlement handshake in the background,
+// so that slow or unresponsive remote visors do not hold up other connections.
 func (tm *Manager) acceptTransport(ctx context.Context, lis *snet.Listener) error {
 	conn, err := lis.AcceptConn() // TODO: tcp panic.
 	if err != nil {
@@ -144,6 +189,44 @@ func (tm *Manager) acceptTransport(ctx context.Context, lis *snet.Listener) erro
 	}
 	tm.Logger.Infof("recv transport connection request: type(%s) remote(%s)", lis.Network(), conn.RemotePK())
 
+	select {
+	case tm.halfOpen <- struct{}{}:
+	default:
+		if err := conn.Close(); err != nil {
+			tm.Logger.WithError(err).Warn("Failed to close connection")
+		}
+		return ErrTooManyHalfOpen
+	}
+
+	go func() {
+		defer func() { <-tm.halfOpen }()
+		if err := tm.settleTransport(ctx, conn); err != nil {
+			tm.Logger.Warnf("Failed to accept transport: type(%s) remote(%s): %v", conn.Network(), conn.RemotePK(), err)
+			if err := conn.Close(); err != nil {
+				tm.Logger.WithError(err).Debug("Failed to close connection")
+			}
+		}
+	}()
+	return nil
+}
+
+// settleTransport performs the settlement handshake of an accepted connection within AcceptTimeout
+// and sets it as the underlying connection of its managed transport.
+func (tm *Manager) settleTransport(ctx context.Context, conn *snet.Conn) error {
+	ctx, cancel := context.WithTimeout(ctx, tm.conf.AcceptTimeout)
+	defer cancel()
+	go func() {
+		select {
+		case <-tm.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	if err := MakeSettlementHS(false).Do(ctx, tm.conf.DiscoveryClient, conn, tm.n.LocalSK()); err != nil {
+		return fmt.Errorf("settlement handshake failed: %v", err)
+	}
+
 	tm.mx.Lock()
 	defer tm.mx.Unlock()
 
@@ -157,24 +240,29 @@ func (tm *Manager) acceptTransport(ctx context.Context, lis *snet.Listener) erro
 
 	mTp, ok := tm.tps[tpID]
 	if !ok {
-		mTp = NewManagedTransport(tm.n, tm.conf.DiscoveryClient, tm.conf.LogStore, conn.RemotePK(), lis.Network())
-		if err := mTp.Accept(ctx, conn); err != nil {
+		mTp = tm.newManagedTransport(conn.RemotePK(), conn.Network())
+		if err := mTp.accept(ctx, conn); err != nil {
 			return err
 		}
-		go mTp.Serve(tm.readCh, tm.done)
+		go mTp.Serve(tm.readQ, tm.done)
 		tm.tps[tpID] = mTp
+		tm.stateChanged()
 
 	} else {
-		if err := mTp.Accept(ctx, conn); err != nil {
+		if err := mTp.accept(ctx, conn); err != nil {
 			return err
 		}
 	}
 
-	tm.Logger.Infof("accepted tp: type(%s) remote(%s) tpID(%s) new(%v)", lis.Network(), conn.RemotePK(), tpID, !ok)
+	tm.Logger.Infof("accepted tp: type(%s) remote(%s) tpID(%s) new(%v)", conn.Network(), conn.RemotePK(), tpID, !ok)
 	return nil
 }
 
 // SaveTransport begins to attempt to establish data transports to the given 'remote' node.
+// It is the way to obtain a ready-to-use transport over a network type of snet: the transport dials the remote
+// on snet.TransportPort, settles the transport with it and is served by tm. The same transport is returned
+// for the same remote and network type. If the first dial fails, the transport is still returned and keeps
+// redialing in the background, see ManagedTransport.Serve.
 func (tm *Manager) SaveTransport(ctx context.Context, remote cipher.PubKey, tpType string) (*ManagedTransport, error) {
 	tm.mx.Lock()
 	defer tm.mx.Unlock()
@@ -203,14 +291,22 @@ func (tm *Manager) saveTransport(remote cipher.PubKey, netName string) (*Managed
 		return tp, nil
 	}
 
-	mTp := NewManagedTransport(tm.n, tm.conf.DiscoveryClient, tm.conf.LogStore, remote, netName)
-	go mTp.Serve(tm.readCh, tm.done)
+	mTp := tm.newManagedTransport(remote, netName)
+	go mTp.Serve(tm.readQ, tm.done)
 	tm.tps[tpID] = mTp
+	tm.stateChanged()
 
 	tm.Logger.Infof("saved transport: remote(%s) type(%s) tpID(%s)", remote, netName, tpID)
 	return mTp, nil
 }
 
+func (tm *Manager) newManagedTransport(remote cipher.PubKey, netName string) *ManagedTransport {
+	mTp := NewManagedTransport(tm.n, tm.conf.DiscoveryClient, tm.conf.LogStore, remote, netName)
+	mTp.writeStallTimeout = tm.conf.WriteStallTimeout
+	mTp.onStateChange = tm.stateChanged
+	return mTp
+}
+
 // DeleteTransport disconnects and removes the Transport of Transport ID.
 func (tm *Manager) DeleteTransport(id uuid.UUID) {
 	tm.mx.Lock()
@@ -222,17 +318,33 @@ func (tm *Manager) DeleteTransport(id uuid.UUID) {
 	if tp, ok := tm.tps[id]; ok {
 		tp.Close()
 		delete(tm.tps, id)
+		tm.stateChanged()
 		tm.Logger.Infof("Unregistered transport %s", id)
 	}
 }
 
 // ReadPacket reads data packets from routes.
+// Once the packet is no longer used, it may be passed to ReleasePacket.
 func (tm *Manager) ReadPacket() (routing.Packet, error) {
-	p, ok := <-tm.readCh
+	p, _, _, _, _, err := tm.ReadPacketFrom()
+	return p, err
+}
+
+// ReadPacketFrom is like ReadPacket, but also returns the TTL, sequence number and type of the packet and the public key
+// of the remote of the transport the packet was read from. Packets of transports which do not carry TTLs have
+// routing.DefaultPacketTTL, packets of transports which do not carry sequence numbers have sequence number 0,
+// packets of transports which do not carry types are routing.DataPackets.
+func (tm *Manager) ReadPacketFrom() (packet routing.Packet, ttl uint8, seq uint32, typ routing.PacketType, from cipher.PubKey, err error) {
+	p, ok := <-tm.readQ.ch
 	if !ok {
-		return nil, ErrNotServing
+		return nil, 0, 0, 0, cipher.PubKey{}, ErrNotServing
 	}
-	return p, nil
+	return p.Packet, p.ttl, p.seq, p.typ, p.from, nil
+}
+
+// ReadQueueStats returns the statistics of the queue of packets read from transports, see ManagerConfig.ReadOverflow.
+func (tm *Manager) ReadQueueStats() ReadQueueStats {
+	return tm.readQ.stats()
 }
 
 /*
@@ -263,6 +375,16 @@ func (tm *Manager) WalkTransports(walk func(tp *ManagedTransport) bool) {
 	tm.mx.RUnlock()
 }
 
+// StateVersion returns a number which changes whenever a transport is added or removed, or goes up or down,
+// so that decisions based on the state of the transports can be cached until it changes.
+func (tm *Manager) StateVersion() uint64 {
+	return atomic.LoadUint64(&tm.stateVersion)
+}
+
+func (tm *Manager) stateChanged() {
+	atomic.AddUint64(&tm.stateVersion, 1)
+}
+
 // Local returns Manager.config.PubKey
 func (tm *Manager) Local() cipher.PubKey {
 	return tm.conf.PubKey
@@ -282,7 +404,6 @@ func (tm *Manager) close() {
 	}
 
 	tm.mx.Lock()
-	defer tm.mx.Unlock()
 
 	close(tm.done)
 
@@ -296,8 +417,12 @@ func (tm *Manager) close() {
 		tm.Logger.Warnf("failed to update transport statuses: %v", err)
 	}
 
+	// The lock is released before waiting, as serve may still need it to finish initTransports
+	// before it gets to close the listeners.
+	tm.mx.Unlock()
+
 	tm.wg.Wait()
-	close(tm.readCh)
+	close(tm.readQ.ch)
 }
 
 func (tm *Manager) isClosing() bool {
@@ -1,23 +1,30 @@
 package transport_test
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"os"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/SkycoinProject/skycoin/src/util/logging"
 
 	"github.com/SkycoinProject/skywire-mainnet/pkg/routing"
+	"github.com/SkycoinProject/skywire-mainnet/pkg/snet"
 	"github.com/SkycoinProject/skywire-mainnet/pkg/snet/snettest"
 	"github.com/SkycoinProject/skywire-mainnet/pkg/transport"
 
 	"github.com/SkycoinProject/dmsg"
 	"github.com/SkycoinProject/dmsg/cipher"
+	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/net/nettest"
 )
 
 func TestMain(m *testing.M) {
@@ -71,8 +78,12 @@ func TestNewManager(t *testing.T) {
 	defer func() { require.NoError(t, m2.Close()) }()
 
 	// Create data transport between manager 1 & manager 2.
+	version := m2.StateVersion()
 	tp2, err := m2.SaveTransport(context.TODO(), pk0, "dmsg")
 	require.NoError(t, err)
+	assert.NotEqual(t, version, m2.StateVersion())
+	// Accepted transports are settled in the background.
+	waitForTransport(t, m0, transport.MakeTransportID(pk0, pk1, "dmsg"))
 	tp1 := m0.Transport(transport.MakeTransportID(pk0, pk1, "dmsg"))
 	require.NotNil(t, tp1)
 
@@ -103,8 +114,9 @@ func TestNewManager(t *testing.T) {
 			payload := cipher.RandByte(i)
 			require.NoError(t, tp1.WritePacket(context.TODO(), rID, payload))
 
-			recv, err := m2.ReadPacket()
+			recv, _, _, _, from, err := m2.ReadPacketFrom()
 			require.NoError(t, err)
+			require.Equal(t, pk0, from)
 			require.Equal(t, rID, recv.RouteID())
 			require.Equal(t, uint16(i), recv.Size())
 			require.Equal(t, payload, recv.Payload())
@@ -140,13 +152,130 @@ func TestNewManager(t *testing.T) {
 		assert.Equal(t, transport.SortEdges(pk0, pk1), entry.Entry.Edges)
 		assert.True(t, entry.IsUp)
 
+		version := m2.StateVersion()
 		m2.DeleteTransport(tp2.Entry.ID)
+		assert.NotEqual(t, version, m2.StateVersion())
 		entry, err = tpDisc.GetTransportByID(context.TODO(), tpID)
 		require.NoError(t, err)
 		assert.False(t, entry.IsUp)
 	})
 }
 
+// Ensure that TTLs and sequence numbers of packets are only exchanged over transports of which both ends enabled them.
+func TestManager_PacketTTL(t *testing.T) {
+	cases := []struct {
+		name       string
+		ttl0, ttl1 bool
+		seq        bool // enabled at both ends
+		typ        bool // enabled at both ends
+		want       uint8
+		wantSeq    uint32
+	}{
+		{name: "both_ends", ttl0: true, ttl1: true, want: 5},
+		{name: "writer_only", ttl1: true, want: routing.DefaultPacketTTL},
+		{name: "reader_only", ttl0: true, want: routing.DefaultPacketTTL},
+		{name: "seq", ttl0: true, ttl1: true, seq: true, want: 5, wantSeq: 7},
+		{name: "seq_without_ttl", ttl0: true, seq: true, want: routing.DefaultPacketTTL},
+		{name: "type", ttl0: true, ttl1: true, seq: true, typ: true, want: 5, wantSeq: 7},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			keys := snettest.GenKeyPairs(2)
+			enabled := map[cipher.PubKey]bool{keys[0].PK: tc.ttl0, keys[1].PK: tc.ttl1}
+			nEnv := snettest.NewEnvWithConfig(t, keys, func(conf *snet.Config) {
+				conf.EnablePacketTTL = enabled[conf.PubKey]
+				conf.EnablePacketSeq = tc.seq
+				conf.EnablePacketType = tc.typ
+			})
+			defer nEnv.Teardown()
+
+			tpDisc := transport.NewDiscoveryMock()
+			ms := make([]*transport.Manager, len(keys))
+			for i, pair := range keys {
+				m, err := transport.NewManager(nEnv.Nets[i], &transport.ManagerConfig{
+					PubKey:          pair.PK,
+					SecKey:          pair.SK,
+					DiscoveryClient: tpDisc,
+					LogStore:        transport.InMemoryTransportLogStore(),
+				})
+				require.NoError(t, err)
+				go m.Serve(context.TODO())
+				defer func() { require.NoError(t, m.Close()) }()
+				ms[i] = m
+			}
+
+			tp, err := ms[1].SaveTransport(context.TODO(), keys[0].PK, snet.DmsgType)
+			require.NoError(t, err)
+			waitForTransport(t, ms[0], tp.Entry.ID)
+
+			require.NoError(t, tp.WritePacketWithSeq(context.TODO(), 3, 5, 7, []byte("foo")))
+			packet, ttl, seq, typ, from, err := ms[0].ReadPacketFrom()
+			require.NoError(t, err)
+			assert.Equal(t, routing.MakePacket(3, []byte("foo")), packet)
+			assert.Equal(t, tc.want, ttl)
+			assert.Equal(t, tc.wantSeq, seq)
+			assert.Equal(t, routing.DataPacket, typ)
+			assert.Equal(t, keys[1].PK, from)
+
+			// Packets of other types are only written if the remote reads their type.
+			err = tp.WriteControlPacket(context.TODO(), 3, 5, routing.LoopStatePacket, routing.MakeLoopStatePayload(true))
+			if !tc.typ {
+				assert.Equal(t, transport.ErrPacketTypeUnsupported, err)
+				return
+			}
+			require.NoError(t, err)
+			packet, _, _, typ, _, err = ms[0].ReadPacketFrom()
+			require.NoError(t, err)
+			assert.Equal(t, routing.MakePacket(3, routing.MakeLoopStatePayload(true)), packet)
+			assert.Equal(t, routing.LoopStatePacket, typ)
+		})
+	}
+}
+
+// The writer learns the limit advertised by the reader from the hello it sends for packet TTLs.
+func TestManager_MaxPayloadSize(t *testing.T) {
+	const limit = 1000
+
+	keys := snettest.GenKeyPairs(2)
+	nEnv := snettest.NewEnvWithConfig(t, keys, func(conf *snet.Config) {
+		conf.EnablePacketTTL = true
+		if conf.PubKey == keys[0].PK {
+			conf.MaxPayloadSize = limit
+		}
+	})
+	defer nEnv.Teardown()
+
+	tpDisc := transport.NewDiscoveryMock()
+	ms := make([]*transport.Manager, len(keys))
+	for i, pair := range keys {
+		m, err := transport.NewManager(nEnv.Nets[i], &transport.ManagerConfig{
+			PubKey:          pair.PK,
+			SecKey:          pair.SK,
+			DiscoveryClient: tpDisc,
+			LogStore:        transport.InMemoryTransportLogStore(),
+		})
+		require.NoError(t, err)
+		go m.Serve(context.TODO())
+		defer func() { require.NoError(t, m.Close()) }()
+		ms[i] = m
+	}
+
+	tp, err := ms[1].SaveTransport(context.TODO(), keys[0].PK, snet.DmsgType)
+	require.NoError(t, err)
+	waitForTransport(t, ms[0], tp.Entry.ID)
+
+	require.Equal(t, limit-routing.TTLPacketHeaderSize, tp.MaxPayloadSize())
+
+	payload := bytes.Repeat([]byte{1}, tp.MaxPayloadSize())
+	require.NoError(t, tp.WritePacket(context.TODO(), 3, payload))
+	packet, err := ms[0].ReadPacket()
+	require.NoError(t, err)
+	assert.Equal(t, payload, packet.Payload())
+
+	payload = append(payload, 1)
+	assert.Equal(t, transport.ErrPayloadTooLarge, tp.WritePacket(context.TODO(), 3, payload))
+}
+
 func TestSortEdges(t *testing.T) {
 	for i := 0; i < 100; i++ {
 		keyA, _ := cipher.GenerateKeyPair()
@@ -170,3 +299,207 @@ func TestMakeTransportID(t *testing.T) {
 		require.NotEqual(t, transport.MakeTransportID(keyA, keyA, "a"), transport.MakeTransportID(keyA, keyA, "b"))
 	})
 }
+
+// tcpProxy forwards TCP connections to a target address until it is closed.
+type tcpProxy struct {
+	l     net.Listener
+	conns []net.Conn
+	mx    sync.Mutex
+}
+
+func newTCPProxy(t *testing.T, target string) *tcpProxy {
+	l, err := nettest.NewLocalListener("tcp")
+	require.NoError(t, err)
+
+	p := &tcpProxy{l: l}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			tConn, err := net.Dial("tcp", target)
+			if err != nil {
+				_ = conn.Close() //nolint:errcheck
+				continue
+			}
+			p.mx.Lock()
+			p.conns = append(p.conns, conn, tConn)
+			p.mx.Unlock()
+			go func() { _, _ = io.Copy(tConn, conn) }() //nolint:errcheck
+			go func() { _, _ = io.Copy(conn, tConn) }() //nolint:errcheck
+		}
+	}()
+	return p
+}
+
+func (p *tcpProxy) Addr() string { return p.l.Addr().String() }
+
+// Close stops the proxy, breaking all forwarded connections.
+func (p *tcpProxy) Close() {
+	_ = p.l.Close() //nolint:errcheck
+	p.mx.Lock()
+	for _, conn := range p.conns {
+		_ = conn.Close() //nolint:errcheck
+	}
+	p.mx.Unlock()
+}
+
+// Ensure that a stcp transport keeps carrying data after the remote visor's address changes.
+func TestManager_STCPAddrChange(t *testing.T) {
+	tpDisc := transport.NewDiscoveryMock()
+
+	keys := snettest.GenKeyPairs(2)
+	nEnv := snettest.NewEnv(t, keys, snet.STcpType)
+	defer nEnv.Teardown()
+
+	ms := make([]*transport.Manager, len(keys))
+	for i, key := range keys {
+		m, err := transport.NewManager(nEnv.Nets[i], &transport.ManagerConfig{
+			PubKey:          key.PK,
+			SecKey:          key.SK,
+			DiscoveryClient: tpDisc,
+			LogStore:        transport.InMemoryTransportLogStore(),
+		})
+		require.NoError(t, err)
+		go m.Serve(context.TODO())
+		ms[i] = m
+	}
+	defer func() {
+		for _, m := range ms {
+			require.NoError(t, m.Close())
+		}
+	}()
+
+	// Visor 0 initially reaches visor 1 through the proxy, which stands for visor 1's old address.
+	var rAddrs []string
+	for _, ns := range nEnv.Nets[1].Snapshot().Networks {
+		if ns.Type == snet.STcpType {
+			rAddrs = ns.LocalAddrs
+		}
+	}
+	require.Len(t, rAddrs, 1)
+	proxy := newTCPProxy(t, rAddrs[0])
+	require.NoError(t, nEnv.Nets[0].SetSTCPAddr(keys[1].PK, proxy.Addr()))
+
+	tp, err := ms[0].SaveTransport(context.TODO(), keys[1].PK, snet.STcpType)
+	require.NoError(t, err)
+	tpID := transport.MakeTransportID(keys[0].PK, keys[1].PK, snet.STcpType)
+	waitForTransport(t, ms[1], tpID)
+
+	recvCh := make(chan routing.Packet, 10)
+	go func() {
+		for {
+			packet, err := ms[1].ReadPacket()
+			if err != nil {
+				close(recvCh)
+				return
+			}
+			recvCh <- packet
+		}
+	}()
+
+	// A packet written while the old connection breaks may be lost, so it is written until it arrives.
+	send := func(rtID routing.RouteID, payload []byte) {
+		deadline := time.After(10 * time.Second)
+		for {
+			_ = tp.WritePacket(context.TODO(), rtID, payload) //nolint:errcheck
+			select {
+			case recv := <-recvCh:
+				assert.Equal(t, rtID, recv.RouteID())
+				assert.Equal(t, payload, recv.Payload())
+				return
+			case <-time.After(200 * time.Millisecond):
+			case <-deadline:
+				t.Fatal("packet was not received")
+			}
+		}
+	}
+
+	send(1, []byte("via old address"))
+
+	// The old address goes away and visor 1 is reachable at its new address.
+	proxy.Close()
+	require.NoError(t, nEnv.Nets[0].SetSTCPAddr(keys[1].PK, rAddrs[0]))
+
+	send(1, []byte("via new address"))
+	assert.Equal(t, tpID, tp.Entry.ID)
+}
+
+func waitForTransport(t *testing.T, m *transport.Manager, tpID uuid.UUID) {
+	for deadline := time.Now().Add(5 * time.Second); m.Transport(tpID) == nil; {
+		require.True(t, time.Now().Before(deadline), "transport %s was not accepted", tpID)
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Ensure that accepted connections which do not complete the settlement handshake are capped and time out.
+func TestManager_HalfOpenLimits(t *testing.T) {
+	const (
+		maxHalfOpen   = 2
+		acceptTimeout = 500 * time.Millisecond
+	)
+
+	keys := snettest.GenKeyPairs(2)
+	nEnv := snettest.NewEnv(t, keys)
+	defer nEnv.Teardown()
+
+	m, err := transport.NewManager(nEnv.Nets[0], &transport.ManagerConfig{
+		PubKey:          keys[0].PK,
+		SecKey:          keys[0].SK,
+		DiscoveryClient: transport.NewDiscoveryMock(),
+		LogStore:        transport.InMemoryTransportLogStore(),
+		AcceptTimeout:   acceptTimeout,
+		MaxHalfOpen:     maxHalfOpen,
+	})
+	require.NoError(t, err)
+	go m.Serve(context.TODO())
+	defer func() { require.NoError(t, m.Close()) }()
+
+	// openHalf dials the manager without starting the settlement handshake.
+	// The returned channel receives the time at which the manager closed the connection.
+	openHalf := func() <-chan time.Time {
+		conn, err := nEnv.Nets[1].Dial(snet.DmsgType, keys[0].PK, snet.TransportPort)
+		require.NoError(t, err)
+		closed := make(chan time.Time, 1)
+		go func() {
+			_, _ = conn.Read(make([]byte, 1)) //nolint:errcheck
+			closed <- time.Now()
+			_ = conn.Close() //nolint:errcheck
+		}()
+		return closed
+	}
+
+	start := time.Now()
+	var halfOpen []<-chan time.Time
+	for i := 0; i < maxHalfOpen; i++ {
+		halfOpen = append(halfOpen, openHalf())
+	}
+
+	// Connections beyond the limit are closed right away.
+	for i := 0; i < 3; i++ {
+		select {
+		case <-openHalf():
+		case <-time.After(acceptTimeout / 2):
+			t.Fatal("connection beyond MaxHalfOpen was not closed")
+		}
+	}
+
+	// Half-open connections are closed once AcceptTimeout passes.
+	for _, closed := range halfOpen {
+		select {
+		case at := <-closed:
+			assert.True(t, at.Sub(start) >= acceptTimeout/2, "closed after %v", at.Sub(start))
+		case <-time.After(5 * time.Second):
+			t.Fatal("half-open connection did not time out")
+		}
+	}
+
+	// Freed slots accept new connections.
+	retried := openHalf()
+	select {
+	case <-retried:
+		t.Fatal("connection was closed before AcceptTimeout")
+	case <-time.After(acceptTimeout / 2):
+	}
+}
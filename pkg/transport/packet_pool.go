@@ -0,0 +1,120 @@
+package transport
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+
+	"github.com/SkycoinProject/skywire-mainnet/pkg/routing"
+)
+
+const maxPacketSize = routing.PacketHeaderSize + math.MaxUint16
+
+// packetClasses are the sizes of pooled packet buffers, from small to large.
+// Each fits a packet with a payload of up to a power of two bytes, the last one a packet of any size,
+// so that small packets do not hold on to a buffer of maxPacketSize.
+var packetClasses = [...]int{
+	routing.PacketHeaderSize + 1<<9,
+	routing.PacketHeaderSize + 1<<12,
+	routing.PacketHeaderSize + 1<<15,
+	maxPacketSize,
+}
+
+// packetPools holds buffers for packets read from transports, one pool per packet class.
+var packetPools [len(packetClasses)]sync.Pool
+
+// getPacketBuf returns a buffer of the smallest packet class that fits size bytes.
+func getPacketBuf(size int) []byte {
+	for i, class := range packetClasses {
+		if size > class {
+			continue
+		}
+		if b, ok := packetPools[i].Get().(*[]byte); ok {
+			return *b
+		}
+		return make([]byte, class)
+	}
+	panic(fmt.Sprintf("packet of %d bytes exceeds the maximum packet size", size))
+}
+
+// ReleasePacket returns the buffer of a packet obtained from Manager.ReadPacket to be reused by later reads.
+// Neither the packet nor its payload may be used after it is released.
+// Releasing packets is optional, packets that are not released are garbage collected.
+func ReleasePacket(packet routing.Packet) {
+	for i, class := range packetClasses {
+		if cap(packet) == class {
+			b := []byte(packet[:class])
+			packetPools[i].Put(&b)
+			return
+		}
+	}
+}
+
+// readPacketFrom reads a whole packet from r into a pooled buffer of the smallest packet class it fits.
+// If withTTL is set, the packet is read with a TTL, see routing.MakeTTLPacket,
+// otherwise routing.DefaultPacketTTL is returned as its TTL.
+// If withSeq is set as well, the packet is read with a sequence number, see routing.MakeSeqPacket,
+// otherwise 0 is returned as its sequence number.
+// If withType is set as well, the packet is read with a type, see routing.MakeTypedPacket,
+// otherwise it is returned as a routing.DataPacket.
+func readPacketFrom(r io.Reader, withTTL, withSeq, withType bool) (routing.Packet, uint8, uint32, routing.PacketType, error) {
+	// The header is read into the smallest buffer, which is replaced once the payload size is known.
+	b := getPacketBuf(routing.TypedPacketHeaderSize)
+	packet := routing.Packet(b[:routing.PacketHeaderSize])
+	if n, err := io.ReadFull(r, packet); err != nil {
+		ReleasePacket(packet)
+		if n > 0 {
+			return nil, 0, 0, 0, truncatedReadErr(err)
+		}
+		return nil, 0, 0, 0, err
+	}
+	ttl, seq, typ := routing.DefaultPacketTTL, uint32(0), routing.DataPacket
+	if withTTL {
+		headerSize := routing.TTLPacketHeaderSize
+		if withSeq {
+			headerSize = routing.SeqPacketHeaderSize
+			if withType {
+				headerSize = routing.TypedPacketHeaderSize
+			}
+		}
+		// The TTL, sequence number and type are read into the first bytes of the payload, which is read over them.
+		if _, err := io.ReadFull(r, b[routing.PacketHeaderSize:headerSize]); err != nil {
+			ReleasePacket(packet)
+			return nil, 0, 0, 0, truncatedReadErr(err)
+		}
+		ttl = b[routing.PacketHeaderSize]
+		if withSeq {
+			seq = binary.BigEndian.Uint32(b[routing.TTLPacketHeaderSize:])
+			if withType {
+				typ = routing.PacketType(b[routing.SeqPacketHeaderSize])
+			}
+		}
+	}
+	size := routing.PacketHeaderSize + int(packet.Size())
+	if size > len(b) {
+		large := getPacketBuf(size)
+		copy(large, packet)
+		ReleasePacket(packet)
+		b = large
+	}
+	packet = routing.Packet(b[:size])
+	if _, err := io.ReadFull(r, packet[routing.PacketHeaderSize:]); err != nil {
+		ReleasePacket(packet)
+		return nil, 0, 0, 0, truncatedReadErr(err)
+	}
+	return packet, ttl, seq, typ, nil
+}
+
+// truncatedReadErr converts an error met after part of a packet was read: io.EOF to io.ErrUnexpectedEOF
+// and transient errors to ErrTruncatedRead, as reading cannot resume within the packet.
+func truncatedReadErr(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	if isTransientReadErr(err) {
+		return ErrTruncatedRead
+	}
+	return err
+}
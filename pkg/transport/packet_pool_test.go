@@ -0,0 +1,170 @@
+package transport
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/SkycoinProject/skywire-mainnet/pkg/routing"
+)
+
+func TestReadPacketFrom(t *testing.T) {
+	first := routing.MakePacket(1, []byte("first payload"))
+	second := routing.MakePacket(2, []byte("second"))
+	r := bytes.NewReader(append(append([]byte{}, first...), second...))
+
+	packet, ttl, _, _, err := readPacketFrom(r, false, false, false)
+	require.NoError(t, err)
+	assert.Equal(t, first, packet)
+	assert.Equal(t, routing.DefaultPacketTTL, ttl)
+	ReleasePacket(packet)
+
+	packet, _, _, _, err = readPacketFrom(r, false, false, false)
+	require.NoError(t, err)
+	assert.Equal(t, second, packet)
+	assert.Equal(t, routing.RouteID(2), packet.RouteID())
+	assert.Equal(t, []byte("second"), packet.Payload())
+	ReleasePacket(packet)
+
+	_, _, _, _, err = readPacketFrom(r, false, false, false)
+	assert.Equal(t, io.EOF, err)
+
+	_, _, _, _, err = readPacketFrom(bytes.NewReader(first[:len(first)-1]), false, false, false)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+
+	// Packets not obtained from the pool are ignored.
+	ReleasePacket(routing.MakePacket(3, []byte("foo")))
+}
+
+func TestReadPacketFrom_TTL(t *testing.T) {
+	r := bytes.NewReader(routing.MakeTTLPacket(1, 5, []byte("payload")))
+
+	packet, ttl, _, _, err := readPacketFrom(r, true, false, false)
+	require.NoError(t, err)
+	assert.Equal(t, routing.MakePacket(1, []byte("payload")), packet)
+	assert.Equal(t, uint8(5), ttl)
+	ReleasePacket(packet)
+
+	_, _, _, _, err = readPacketFrom(r, true, false, false)
+	assert.Equal(t, io.EOF, err)
+
+	_, _, _, _, err = readPacketFrom(bytes.NewReader(routing.MakePacket(1, nil)), true, false, false)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+}
+
+func TestReadPacketFrom_Seq(t *testing.T) {
+	r := bytes.NewReader(routing.MakeSeqPacket(1, 5, 7, []byte("payload")))
+
+	packet, ttl, seq, _, err := readPacketFrom(r, true, true, false)
+	require.NoError(t, err)
+	assert.Equal(t, routing.MakePacket(1, []byte("payload")), packet)
+	assert.Equal(t, uint8(5), ttl)
+	assert.Equal(t, uint32(7), seq)
+	ReleasePacket(packet)
+
+	_, _, _, _, err = readPacketFrom(bytes.NewReader(routing.MakeTTLPacket(1, 5, nil)), true, true, false)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+}
+
+func TestReadPacketFrom_Type(t *testing.T) {
+	r := bytes.NewReader(routing.MakeTypedPacket(1, 5, 7, routing.LoopStatePacket, []byte("payload")))
+
+	packet, ttl, seq, typ, err := readPacketFrom(r, true, true, true)
+	require.NoError(t, err)
+	assert.Equal(t, routing.MakePacket(1, []byte("payload")), packet)
+	assert.Equal(t, uint8(5), ttl)
+	assert.Equal(t, uint32(7), seq)
+	assert.Equal(t, routing.LoopStatePacket, typ)
+	ReleasePacket(packet)
+
+	// Without types, packets are data packets.
+	_, _, _, typ, err = readPacketFrom(bytes.NewReader(routing.MakeSeqPacket(1, 5, 7, nil)), true, true, false)
+	require.NoError(t, err)
+	assert.Equal(t, routing.DataPacket, typ)
+
+	_, _, _, _, err = readPacketFrom(bytes.NewReader(routing.MakeSeqPacket(1, 5, 7, nil)), true, true, true)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+}
+
+type timeoutErr struct{}
+
+func (timeoutErr) Error() string   { return "i/o timeout" }
+func (timeoutErr) Timeout() bool   { return true }
+func (timeoutErr) Temporary() bool { return true }
+
+func TestReadPacketFrom_TransientErrors(t *testing.T) {
+	packet := routing.MakePacket(1, []byte("payload"))
+
+	// A transient error before a packet is returned as is, reading may resume.
+	_, _, _, _, err := readPacketFrom(errReader{timeoutErr{}}, false, false, false)
+	assert.Equal(t, timeoutErr{}, err)
+
+	// Within a packet, the rest of the packet is lost.
+	for _, n := range []int{3, routing.PacketHeaderSize + 2} {
+		r := io.MultiReader(bytes.NewReader(packet[:n]), errReader{timeoutErr{}})
+		_, _, _, _, err = readPacketFrom(r, false, false, false)
+		assert.Equal(t, ErrTruncatedRead, err)
+	}
+}
+
+// errReader fails every read with err.
+type errReader struct{ err error }
+
+func (r errReader) Read([]byte) (int, error) { return 0, r.err }
+
+func TestReadPacketFrom_SizeClasses(t *testing.T) {
+	for _, size := range []int{0, 1 << 9, 1<<9 + 1, 1 << 12, 1 << 15, 1<<15 + 1, math.MaxUint16} {
+		payload := bytes.Repeat([]byte{1}, size)
+		r := bytes.NewReader(routing.MakeSeqPacket(1, 5, 7, payload))
+
+		packet, ttl, seq, _, err := readPacketFrom(r, true, true, false)
+		require.NoError(t, err)
+		assert.Equal(t, routing.MakePacket(1, payload), packet)
+		assert.Equal(t, uint8(5), ttl)
+		assert.Equal(t, uint32(7), seq)
+
+		// The packet is read into the smallest buffer it fits.
+		class := 0
+		for routing.PacketHeaderSize+size > packetClasses[class] {
+			class++
+		}
+		assert.Equal(t, packetClasses[class], cap(packet), "payload of %d bytes", size)
+		ReleasePacket(packet)
+	}
+}
+
+// BenchmarkReadPacketFrom reports the memory held by packets of different sizes.
+// Packets that are not released allocate a buffer of their size class, rather than one that fits any packet.
+func BenchmarkReadPacketFrom(b *testing.B) {
+	for _, size := range []int{64, 1024, 32 * 1024} {
+		packet := routing.MakePacket(1, bytes.Repeat([]byte{1}, size))
+		r := bytes.NewReader(packet)
+
+		b.Run(fmt.Sprintf("%d/released", size), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				r.Reset(packet)
+				p, _, _, _, err := readPacketFrom(r, false, false, false)
+				if err != nil {
+					b.Fatal(err)
+				}
+				ReleasePacket(p)
+			}
+		})
+
+		b.Run(fmt.Sprintf("%d/not_released", size), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				r.Reset(packet)
+				if _, _, _, _, err := readPacketFrom(r, false, false, false); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package transport
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/SkycoinProject/dmsg/cipher"
+
+	"github.com/SkycoinProject/skywire-mainnet/pkg/routing"
+)
+
+// DefaultReadQueueSize is the default number of packets read from transports that are queued for Manager.ReadPacket.
+const DefaultReadQueueSize = 20
+
+// OverflowPolicy is what is done with a packet read from a transport while the read queue of the Manager is full.
+type OverflowPolicy int
+
+// Overflow policies.
+const (
+	// OverflowBlock stops reading from the transport until the queue has room.
+	// No packet is lost, but a slow reader of the queue holds up all transports.
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropOldest discards the oldest queued packet to make room for the packet.
+	OverflowDropOldest
+	// OverflowDropNewest discards the packet, queued packets are kept.
+	OverflowDropNewest
+)
+
+var overflowPolicyNames = map[OverflowPolicy]string{
+	OverflowBlock:      "block",
+	OverflowDropOldest: "drop-oldest",
+	OverflowDropNewest: "drop-newest",
+}
+
+func (p OverflowPolicy) String() string {
+	if name, ok := overflowPolicyNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("OverflowPolicy(%d)", int(p))
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (p OverflowPolicy) MarshalText() ([]byte, error) {
+	if _, ok := overflowPolicyNames[p]; !ok {
+		return nil, fmt.Errorf("unknown overflow policy %d", int(p))
+	}
+	return []byte(p.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (p *OverflowPolicy) UnmarshalText(text []byte) error {
+	policy, err := ParseOverflowPolicy(string(text))
+	if err != nil {
+		return err
+	}
+	*p = policy
+	return nil
+}
+
+// ParseOverflowPolicy returns the OverflowPolicy of name, one of "block", "drop-oldest" and "drop-newest".
+// An empty name is OverflowBlock.
+func ParseOverflowPolicy(name string) (OverflowPolicy, error) {
+	if name == "" {
+		return OverflowBlock, nil
+	}
+	for p, n := range overflowPolicyNames {
+		if n == name {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown overflow policy %q", name)
+}
+
+// ReadQueueStats are the statistics of the read queue of a Manager.
+type ReadQueueStats struct {
+	Depth    int            `json:"depth"`    // packets currently queued
+	Capacity int            `json:"capacity"` // packets the queue holds before it overflows
+	Policy   OverflowPolicy `json:"policy"`
+	Dropped  uint64         `json:"dropped"` // packets discarded on overflow
+}
+
+// inboundPacket is a packet with the given TTL, sequence number and type read from the transport to the remote from.
+type inboundPacket struct {
+	routing.Packet
+	ttl  uint8
+	seq  uint32
+	typ  routing.PacketType
+	from cipher.PubKey
+}
+
+// readQueue queues packets read from transports for Manager.ReadPacket.
+type readQueue struct {
+	ch      chan inboundPacket
+	policy  OverflowPolicy
+	dropped uint64
+}
+
+func newReadQueue(size int, policy OverflowPolicy) *readQueue {
+	if size <= 0 {
+		size = DefaultReadQueueSize
+	}
+	return &readQueue{ch: make(chan inboundPacket, size), policy: policy}
+}
+
+// push queues the packet with the given TTL, sequence number and type, read from the transport to the remote from,
+// according to the overflow policy of q.
+// It returns false if done is closed before the packet could be queued under OverflowBlock.
+func (q *readQueue) push(packet routing.Packet, ttl uint8, seq uint32, typ routing.PacketType, from cipher.PubKey, done <-chan struct{}) bool {
+	p := inboundPacket{Packet: packet, ttl: ttl, seq: seq, typ: typ, from: from}
+	switch q.policy {
+	case OverflowDropNewest:
+		select {
+		case q.ch <- p:
+		default:
+			q.drop(p)
+		}
+		return true
+
+	case OverflowDropOldest:
+		for {
+			select {
+			case q.ch <- p:
+				return true
+			default:
+			}
+			select {
+			case old := <-q.ch:
+				q.drop(old)
+			default:
+			}
+		}
+
+	default:
+		select {
+		case <-done:
+			return false
+		case q.ch <- p:
+			return true
+		}
+	}
+}
+
+func (q *readQueue) drop(p inboundPacket) {
+	atomic.AddUint64(&q.dropped, 1)
+	ReleasePacket(p.Packet)
+}
+
+func (q *readQueue) stats() ReadQueueStats {
+	return ReadQueueStats{
+		Depth:    len(q.ch),
+		Capacity: cap(q.ch),
+		Policy:   q.policy,
+		Dropped:  atomic.LoadUint64(&q.dropped),
+	}
+}
@@ -0,0 +1,95 @@
+package transport
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/SkycoinProject/dmsg/cipher"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/SkycoinProject/skywire-mainnet/pkg/routing"
+)
+
+func TestReadQueue(t *testing.T) {
+	// fill pushes packets of route IDs 1 to n into q.
+	fill := func(t *testing.T, q *readQueue, n int) {
+		for i := 1; i <= n; i++ {
+			require.True(t, q.push(routing.MakePacket(routing.RouteID(i), []byte("foo")), routing.DefaultPacketTTL, 0, routing.DataPacket, cipher.PubKey{}, nil))
+		}
+	}
+
+	queued := func(q *readQueue) []routing.RouteID {
+		var ids []routing.RouteID
+		for len(q.ch) > 0 {
+			ids = append(ids, (<-q.ch).RouteID())
+		}
+		return ids
+	}
+
+	t.Run("block", func(t *testing.T) {
+		q := newReadQueue(2, OverflowBlock)
+		fill(t, q, 2)
+		assert.Equal(t, ReadQueueStats{Depth: 2, Capacity: 2, Policy: OverflowBlock}, q.stats())
+
+		pushed := make(chan bool, 1)
+		go func() {
+			pushed <- q.push(routing.MakePacket(3, []byte("foo")), routing.DefaultPacketTTL, 0, routing.DataPacket, cipher.PubKey{}, nil)
+		}()
+
+		select {
+		case <-pushed:
+			t.Fatal("push to a full queue should block")
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		assert.Equal(t, routing.RouteID(1), (<-q.ch).RouteID())
+		select {
+		case ok := <-pushed:
+			assert.True(t, ok)
+		case <-time.After(time.Second):
+			t.Fatal("push should complete once the queue has room")
+		}
+		assert.Equal(t, []routing.RouteID{2, 3}, queued(q))
+
+		// A blocked push is abandoned once done is closed.
+		fill(t, q, 2)
+		done := make(chan struct{})
+		close(done)
+		assert.False(t, q.push(routing.MakePacket(3, []byte("foo")), routing.DefaultPacketTTL, 0, routing.DataPacket, cipher.PubKey{}, done))
+		assert.Equal(t, uint64(0), q.stats().Dropped)
+	})
+
+	t.Run("drop_oldest", func(t *testing.T) {
+		q := newReadQueue(2, OverflowDropOldest)
+		fill(t, q, 4)
+		assert.Equal(t, ReadQueueStats{Depth: 2, Capacity: 2, Policy: OverflowDropOldest, Dropped: 2}, q.stats())
+		assert.Equal(t, []routing.RouteID{3, 4}, queued(q))
+	})
+
+	t.Run("drop_newest", func(t *testing.T) {
+		q := newReadQueue(2, OverflowDropNewest)
+		fill(t, q, 4)
+		assert.Equal(t, ReadQueueStats{Depth: 2, Capacity: 2, Policy: OverflowDropNewest, Dropped: 2}, q.stats())
+		assert.Equal(t, []routing.RouteID{1, 2}, queued(q))
+	})
+
+	t.Run("default_size", func(t *testing.T) {
+		assert.Equal(t, DefaultReadQueueSize, newReadQueue(0, OverflowBlock).stats().Capacity)
+	})
+}
+
+func TestOverflowPolicy_JSON(t *testing.T) {
+	for _, p := range []OverflowPolicy{OverflowBlock, OverflowDropOldest, OverflowDropNewest} {
+		raw, err := json.Marshal(p)
+		require.NoError(t, err)
+
+		var got OverflowPolicy
+		require.NoError(t, json.Unmarshal(raw, &got))
+		assert.Equal(t, p, got)
+	}
+
+	var p OverflowPolicy
+	assert.Error(t, json.Unmarshal([]byte(`"drop-all"`), &p))
+}
@@ -0,0 +1,189 @@
+package transport
+
+import (
+	"context"
+	"sync"
+
+	"github.com/SkycoinProject/skywire-mainnet/pkg/routing"
+)
+
+// Priority is the priority class of a packet write.
+type Priority int
+
+// Priority classes, in order of precedence.
+const (
+	// PriorityControl is for packets that keep routes working, such as close and keep-alive packets.
+	// Waiting control writes are performed before any waiting data write.
+	PriorityControl Priority = iota
+	// PriorityData is for app data.
+	PriorityData
+
+	numPriorities
+)
+
+// writeGate serializes packet writes.
+// When a write completes, the next write is granted to the oldest waiter of the highest priority class,
+// so that a flood of data writes does not starve control writes.
+// Waiting data writes are granted round-robin among their routes, a route gets as many writes in a row
+// as its weight (1 unless set with setWeight), so that a flooding route does not starve the others.
+type writeGate struct {
+	mx      sync.Mutex
+	busy    bool
+	control []chan struct{}
+
+	routes  map[routing.RouteID][]chan struct{} // waiting data writes per route
+	ring    []routing.RouteID                   // routes with waiting data writes, in round-robin order
+	next    int                                 // index in ring of the route to grant next
+	credit  int                                 // writes left to the route at ring[next] in its current turn
+	weights map[routing.RouteID]int
+}
+
+// setWeight sets the weight of a route, a weight below 1 restores the default.
+func (g *writeGate) setWeight(rtID routing.RouteID, weight int) {
+	g.mx.Lock()
+	defer g.mx.Unlock()
+
+	if weight < 1 {
+		delete(g.weights, rtID)
+		return
+	}
+	if g.weights == nil {
+		g.weights = make(map[routing.RouteID]int)
+	}
+	g.weights[rtID] = weight
+}
+
+func (g *writeGate) weight(rtID routing.RouteID) int {
+	if w, ok := g.weights[rtID]; ok {
+		return w
+	}
+	return 1
+}
+
+// acquire blocks until the caller may write a packet of rtID or ctx is done.
+func (g *writeGate) acquire(ctx context.Context, prio Priority, rtID routing.RouteID) error {
+	if prio < 0 || prio >= numPriorities {
+		prio = PriorityData
+	}
+
+	g.mx.Lock()
+	if !g.busy {
+		g.busy = true
+		g.mx.Unlock()
+		return nil
+	}
+	ch := make(chan struct{})
+	if prio == PriorityControl {
+		g.control = append(g.control, ch)
+	} else {
+		g.enqueue(rtID, ch)
+	}
+	g.mx.Unlock()
+
+	select {
+	case <-ch:
+		return nil
+	case <-ctx.Done():
+		g.mx.Lock()
+		defer g.mx.Unlock()
+
+		if g.remove(prio, rtID, ch) {
+			return ctx.Err()
+		}
+		// The gate was granted concurrently, pass it on.
+		g.grantNext()
+		return ctx.Err()
+	}
+}
+
+// release passes the gate to the next waiter, if any.
+func (g *writeGate) release() {
+	g.mx.Lock()
+	defer g.mx.Unlock()
+	g.grantNext()
+}
+
+func (g *writeGate) enqueue(rtID routing.RouteID, ch chan struct{}) {
+	if g.routes == nil {
+		g.routes = make(map[routing.RouteID][]chan struct{})
+	}
+	if len(g.routes[rtID]) == 0 {
+		g.ring = append(g.ring, rtID)
+	}
+	g.routes[rtID] = append(g.routes[rtID], ch)
+}
+
+// remove removes a waiter which has not been granted the gate, it reports whether the waiter was found.
+func (g *writeGate) remove(prio Priority, rtID routing.RouteID, ch chan struct{}) bool {
+	if prio == PriorityControl {
+		for i, w := range g.control {
+			if w == ch {
+				g.control = append(g.control[:i], g.control[i+1:]...)
+				return true
+			}
+		}
+		return false
+	}
+
+	q := g.routes[rtID]
+	for i, w := range q {
+		if w == ch {
+			g.routes[rtID] = append(q[:i], q[i+1:]...)
+			if len(g.routes[rtID]) == 0 {
+				g.dropRoute(rtID)
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// dropRoute removes a route without waiting data writes from the round-robin.
+func (g *writeGate) dropRoute(rtID routing.RouteID) {
+	delete(g.routes, rtID)
+	for i, id := range g.ring {
+		if id != rtID {
+			continue
+		}
+		g.ring = append(g.ring[:i], g.ring[i+1:]...)
+		switch {
+		case i < g.next:
+			g.next--
+		case i == g.next:
+			g.credit = 0
+		}
+		break
+	}
+	if g.next >= len(g.ring) {
+		g.next = 0
+	}
+}
+
+func (g *writeGate) grantNext() {
+	if len(g.control) > 0 {
+		ch := g.control[0]
+		g.control = g.control[1:]
+		close(ch)
+		return
+	}
+
+	if len(g.ring) > 0 {
+		rtID := g.ring[g.next]
+		if g.credit <= 0 {
+			g.credit = g.weight(rtID)
+		}
+		q := g.routes[rtID]
+		ch := q[0]
+		g.routes[rtID] = q[1:]
+		g.credit--
+
+		if len(g.routes[rtID]) == 0 {
+			g.dropRoute(rtID)
+		} else if g.credit == 0 {
+			g.next = (g.next + 1) % len(g.ring)
+		}
+		close(ch)
+		return
+	}
+	g.busy = false
+}
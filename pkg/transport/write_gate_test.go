@@ -0,0 +1,169 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/SkycoinProject/skywire-mainnet/pkg/routing"
+)
+
+func TestWriteGate(t *testing.T) {
+	t.Run("control_before_data", func(t *testing.T) {
+		var g writeGate
+		require.NoError(t, g.acquire(context.TODO(), PriorityData, 1))
+
+		var order []string
+		var orderMx sync.Mutex
+		var wg sync.WaitGroup
+
+		enqueue := func(name string, prio Priority) {
+			queued := g.queueLen()
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if !assert.NoError(t, g.acquire(context.TODO(), prio, 1)) {
+					return
+				}
+				orderMx.Lock()
+				order = append(order, name)
+				orderMx.Unlock()
+				g.release()
+			}()
+			waitForQueueLen(t, &g, queued+1)
+		}
+
+		// Flood of data writes, then a control write.
+		for i := 0; i < 5; i++ {
+			enqueue(fmt.Sprintf("data%d", i), PriorityData)
+		}
+		enqueue("control", PriorityControl)
+
+		g.release()
+		wg.Wait()
+		assert.Equal(t, []string{"control", "data0", "data1", "data2", "data3", "data4"}, order)
+	})
+
+	t.Run("cancelled_waiter", func(t *testing.T) {
+		var g writeGate
+		require.NoError(t, g.acquire(context.TODO(), PriorityData, 1))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		assert.Equal(t, context.DeadlineExceeded, g.acquire(ctx, PriorityControl, 1))
+		assert.Equal(t, 0, g.queueLen())
+
+		g.release()
+		require.NoError(t, g.acquire(context.TODO(), PriorityData, 1))
+		g.release()
+	})
+
+	t.Run("weighted_round_robin", func(t *testing.T) {
+		var g writeGate
+		g.setWeight(2, 2)
+		require.NoError(t, g.acquire(context.TODO(), PriorityData, 1))
+
+		var order []routing.RouteID
+		var orderMx sync.Mutex
+		var wg sync.WaitGroup
+
+		enqueue := func(rtID routing.RouteID) {
+			queued := g.queueLen()
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if !assert.NoError(t, g.acquire(context.TODO(), PriorityData, rtID)) {
+					return
+				}
+				orderMx.Lock()
+				order = append(order, rtID)
+				orderMx.Unlock()
+				g.release()
+			}()
+			waitForQueueLen(t, &g, queued+1)
+		}
+
+		for i := 0; i < 4; i++ {
+			enqueue(1)
+		}
+		for i := 0; i < 4; i++ {
+			enqueue(2)
+		}
+		enqueue(3)
+
+		g.release()
+		wg.Wait()
+		assert.Equal(t, []routing.RouteID{1, 2, 2, 3, 1, 2, 2, 1, 1}, order)
+	})
+
+	t.Run("flooding_route", func(t *testing.T) {
+		var g writeGate
+		const flooders = 8
+
+		done := make(chan struct{})
+		var wg sync.WaitGroup
+		var flooded uint64
+
+		// Route 1 floods the gate from several writers, every write holds the gate for a while.
+		for i := 0; i < flooders; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					select {
+					case <-done:
+						return
+					default:
+					}
+					if !assert.NoError(t, g.acquire(context.TODO(), PriorityData, 1)) {
+						return
+					}
+					time.Sleep(time.Millisecond)
+					atomic.AddUint64(&flooded, 1)
+					g.release()
+				}
+			}()
+		}
+		defer func() {
+			close(done)
+			wg.Wait()
+		}()
+
+		// Route 2 writes lightly, it waits for the write in progress and at most one more write of route 1,
+		// instead of all the writes route 1 has queued.
+		for i := 0; i < 10; i++ {
+			before := atomic.LoadUint64(&flooded)
+			require.NoError(t, g.acquire(context.TODO(), PriorityData, 2))
+			waited := atomic.LoadUint64(&flooded) - before
+			g.release()
+			assert.True(t, waited <= 2, "light route waited for %d flooding writes", waited)
+		}
+	})
+}
+
+func (g *writeGate) queueLen() int {
+	g.mx.Lock()
+	defer g.mx.Unlock()
+
+	n := len(g.control)
+	for _, waiters := range g.routes {
+		n += len(waiters)
+	}
+	return n
+}
+
+func waitForQueueLen(t *testing.T, g *writeGate, n int) {
+	deadline := time.Now().Add(time.Second)
+	for g.queueLen() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("queue length is %d instead of %d", g.queueLen(), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
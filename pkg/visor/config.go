@@ -1,9 +1,12 @@
 package visor
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"time"
@@ -12,6 +15,7 @@ import (
 	"github.com/SkycoinProject/dmsg/disc"
 
 	"github.com/SkycoinProject/skywire-mainnet/pkg/routing"
+	"github.com/SkycoinProject/skywire-mainnet/pkg/snet"
 	"github.com/SkycoinProject/skywire-mainnet/pkg/transport"
 	trClient "github.com/SkycoinProject/skywire-mainnet/pkg/transport-discovery/client"
 )
@@ -27,12 +31,28 @@ type Config struct {
 
 	TCPTransport struct {
 		PubKeyTable map[cipher.PubKey]string `json:"pk_table"`
-		LocalAddr   string                   `json:"local_address"`
+		LocalAddr   Addrs                    `json:"local_address"`        // a single address or a list of addresses to listen on
+		KeepAlive   Duration                 `json:"keep_alive,omitempty"` // 0 uses the system default, negative disables keep-alives
+		TLS         *TLSConfig               `json:"tls,omitempty"`        // if set, stcp connections are wrapped in TLS
+		ReuseAddr   bool                     `json:"reuse_addr,omitempty"` // set SO_REUSEADDR and SO_REUSEPORT on listeners
+
+		ResolveAttempts int      `json:"resolve_attempts,omitempty"` // lookups of a remote address per dial, 1 or less disables retries
+		ResolveTimeout  Duration `json:"resolve_timeout,omitempty"`  // total time of lookups of a remote address per dial, 0 means no limit
 	} `json:"stcp"`
 
 	Messaging struct {
-		Discovery   string `json:"discovery"`
-		ServerCount int    `json:"server_count"`
+		Discovery   Addrs `json:"discovery"`
+		ServerCount int   `json:"server_count"`
+
+		InitAttempts int      `json:"init_attempts,omitempty"` // attempts to connect to dmsg servers on start, 0 means no limit
+		InitTimeout  Duration `json:"init_timeout,omitempty"`  // total time of attempts to connect to dmsg servers on start, 0 means no limit
+
+		DiscCacheTTL  Duration `json:"disc_cache_ttl,omitempty"`  // cache discovery entries of remotes for it, 0 disables the cache
+		DiscCacheSize int      `json:"disc_cache_size,omitempty"` // entries kept by the cache, 0 uses the default
+
+		ServeProbes bool            `json:"serve_probes,omitempty"` // answer dmsg probes of remote visors, see snet.Network.ProbeDmsg
+		MaxProbes   int             `json:"max_probes,omitempty"`   // probes answered at once, 0 uses the default
+		ProbeAllow  []cipher.PubKey `json:"probe_allow,omitempty"`  // visors whose probes are answered, all if empty
 	} `json:"messaging"`
 
 	Transport struct {
@@ -41,15 +61,40 @@ type Config struct {
 			Type     string `json:"type"`
 			Location string `json:"location"`
 		} `json:"log_store"`
+		AcceptTimeout Duration `json:"accept_timeout,omitempty"` // settlement handshake timeout of accepted transports, 0 uses the default
+		MaxHalfOpen   int      `json:"max_half_open,omitempty"`  // accepted transports in the settlement handshake at once, 0 uses the default
+
+		ReadQueueSize int                      `json:"read_queue_size,omitempty"` // packets read from transports queued for routing, 0 uses the default
+		ReadOverflow  transport.OverflowPolicy `json:"read_overflow,omitempty"`   // "block" (default), "drop-oldest" or "drop-newest"
 	} `json:"transport"`
 
 	Routing struct {
-		SetupNodes         []cipher.PubKey `json:"setup_nodes"`
-		RouteFinder        string          `json:"route_finder"`
-		RouteFinderTimeout Duration        `json:"route_finder_timeout"`
-		Table              struct {
+		SetupNodes         []cipher.PubKey                 `json:"setup_nodes"`
+		FallbackSetupNodes [][]cipher.PubKey               `json:"fallback_setup_nodes,omitempty"` // tiers of setup nodes, used in order once all setup_nodes fail
+		RouteFinder        string                          `json:"route_finder"`
+		RouteFinderTimeout Duration                        `json:"route_finder_timeout"`
+		FirstHops          map[cipher.PubKey]cipher.PubKey `json:"first_hops,omitempty"`    // key: destination, value: pinned first hop
+		SetupTimeout       Duration                        `json:"setup_timeout,omitempty"` // time budget of loop setups, 0 uses the setup node's default
+		CloseTimeout       Duration                        `json:"close_timeout,omitempty"` // time budget of notifying setup nodes of closed and paused loops
+		PacketTTL          uint8                           `json:"packet_ttl,omitempty"`    // hop limit of packets sent by apps, 0 uses the default
+
+		TransportPreference []string `json:"transport_preference,omitempty"` // network types of transports to forward over, most preferred first
+		SourceGuard         bool     `json:"source_guard,omitempty"`         // drop packets of loops not read from a transport to the last hop of their route
+		EnablePacketTTL     bool     `json:"enable_packet_ttl,omitempty"`    // exchange packet TTLs over transports to nodes which enable them too
+		EnablePacketSeq     bool     `json:"enable_packet_seq,omitempty"`    // also exchange packet sequence numbers, requires enable_packet_ttl
+		EnablePacketType    bool     `json:"enable_packet_type,omitempty"`   // also exchange packet types to pause loops in-band, requires enable_packet_seq
+		DedupWindow         int      `json:"dedup_window,omitempty"`         // latest sequence numbers remembered per loop to drop duplicate packets, 0 disables it
+		MaxPayloadSize      int      `json:"max_payload_size,omitempty"`     // largest packet write accepted from transports, advertised to their remotes, 0 for no limit
+
+		// Consecutive failed writes after which a transport is skipped for transport_failure_cooldown
+		// in favour of the next transport of transport_preference, 0 disables it.
+		TransportFailureThreshold int      `json:"transport_failure_threshold,omitempty"`
+		TransportFailureCooldown  Duration `json:"transport_failure_cooldown,omitempty"`
+
+		Table struct {
 			Type     string `json:"type"`
 			Location string `json:"location"`
+			Encrypt  bool   `json:"encrypt,omitempty"` // encrypt rules of a boltdb table with a key derived from the node's secret key
 		} `json:"table"`
 	} `json:"routing"`
 
@@ -75,14 +120,14 @@ type Config struct {
 func (c *Config) MessagingConfig() (*DmsgConfig, error) {
 	msgConfig := c.Messaging
 
-	if msgConfig.Discovery == "" {
+	if len(msgConfig.Discovery) == 0 {
 		return nil, errors.New("empty discovery")
 	}
 
 	return &DmsgConfig{
 		PubKey:     c.Node.StaticPubKey,
 		SecKey:     c.Node.StaticSecKey,
-		Discovery:  disc.NewHTTP(msgConfig.Discovery),
+		Discovery:  snet.NewDmsgDiscovery(msgConfig.Discovery...),
 		Retries:    5,
 		RetryDelay: time.Second,
 	}, nil
@@ -106,9 +151,21 @@ func (c *Config) TransportLogStore() (transport.LogStore, error) {
 	return transport.InMemoryTransportLogStore(), nil
 }
 
+// STCPTLSConfig returns TLS config for stcp connections, or nil if TLS is disabled.
+func (c *Config) STCPTLSConfig() (*tls.Config, error) {
+	if c.TCPTransport.TLS == nil {
+		return nil, nil
+	}
+
+	return c.TCPTransport.TLS.TLSConfig()
+}
+
 // RoutingTable returns configure routing.Table.
 func (c *Config) RoutingTable() (routing.Table, error) {
 	if c.Routing.Table.Type == "boltdb" {
+		if c.Routing.Table.Encrypt {
+			return routing.EncryptedBoltDBRoutingTable(c.Routing.Table.Location, c.Node.StaticSecKey)
+		}
 		return routing.BoltDBRoutingTable(c.Routing.Table.Location)
 	}
 
@@ -165,6 +222,47 @@ func ensureDir(path string) (string, error) {
 	return absPath, nil
 }
 
+// TLSConfig represents TLS configuration of stcp connections.
+// The same certificate is presented when dialing and accepting connections.
+type TLSConfig struct {
+	CertFile   string `json:"cert_file"`
+	KeyFile    string `json:"key_file"`
+	RootCAFile string `json:"root_ca_file,omitempty"` // if empty, remote certificates are not verified
+}
+
+// TLSConfig loads the certificates and returns the corresponding tls.Config.
+// Without a root CA, remote certificates are not verified as peers are authenticated by the stcp handshake.
+func (c *TLSConfig) TLSConfig() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %s", err)
+	}
+
+	conf := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if c.RootCAFile == "" {
+		conf.InsecureSkipVerify = true // nolint:gosec
+		return conf, nil
+	}
+
+	pem, err := ioutil.ReadFile(c.RootCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read TLS root CA: %s", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("failed to parse TLS root CA")
+	}
+	conf.RootCAs = pool
+	conf.ClientCAs = pool
+	conf.ClientAuth = tls.RequireAndVerifyClientCert
+
+	return conf, nil
+}
+
 // HypervisorConfig represents hypervisor configuration.
 type HypervisorConfig struct {
 	PubKey cipher.PubKey `json:"public_key"`
@@ -223,3 +321,48 @@ func (d *Duration) UnmarshalJSON(b []byte) error {
 		return errors.New("invalid duration")
 	}
 }
+
+// Addrs is a list of addresses ordered by preference.
+// For backward compatibility, it can be unmarshaled from either a single JSON string or a JSON array.
+type Addrs []string
+
+// MarshalJSON implements json marshaling
+func (a Addrs) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+	return json.Marshal([]string(a))
+}
+
+// UnmarshalJSON implements unmarshal from json
+func (a *Addrs) UnmarshalJSON(b []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	switch value := v.(type) {
+	case nil:
+		*a = nil
+		return nil
+	case string:
+		if value == "" {
+			*a = nil
+			return nil
+		}
+		*a = Addrs{value}
+		return nil
+	case []interface{}:
+		addrs := make(Addrs, 0, len(value))
+		for _, addr := range value {
+			s, ok := addr.(string)
+			if !ok {
+				return errors.New("invalid address")
+			}
+			addrs = append(addrs, s)
+		}
+		*a = addrs
+		return nil
+	default:
+		return errors.New("invalid addresses")
+	}
+}
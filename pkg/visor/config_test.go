@@ -1,8 +1,15 @@
 package visor
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"encoding/pem"
 	"io/ioutil"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -23,7 +30,7 @@ func TestMessagingDiscovery(t *testing.T) {
 	conf := Config{}
 	conf.Node.StaticPubKey = pk
 	conf.Node.StaticSecKey = sk
-	conf.Messaging.Discovery = "skywire.skycoin.net:8001"
+	conf.Messaging.Discovery = Addrs{"skywire.skycoin.net:8001"}
 	conf.Messaging.ServerCount = 10
 
 	c, err := conf.MessagingConfig()
@@ -36,6 +43,35 @@ func TestMessagingDiscovery(t *testing.T) {
 	assert.Equal(t, time.Second, c.RetryDelay)
 }
 
+func TestAddrsUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want Addrs
+	}{
+		{"single", `"http://disc-a"`, Addrs{"http://disc-a"}},
+		{"list", `["http://disc-a","http://disc-b"]`, Addrs{"http://disc-a", "http://disc-b"}},
+		{"empty", `""`, nil},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var addrs Addrs
+			require.NoError(t, json.Unmarshal([]byte(tc.data), &addrs))
+			assert.Equal(t, tc.want, addrs)
+
+			if len(tc.want) == 0 {
+				return
+			}
+			b, err := json.Marshal(addrs)
+			require.NoError(t, err)
+			assert.JSONEq(t, tc.data, string(b))
+		})
+	}
+
+	var addrs Addrs
+	assert.Error(t, json.Unmarshal([]byte(`[1, 2]`), &addrs))
+}
+
 func TestTransportDiscovery(t *testing.T) {
 	pk, _ := cipher.GenerateKeyPair()
 	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -91,6 +127,58 @@ func TestRoutingTable(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestSTCPTLSConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stcp_tls")
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, os.RemoveAll(dir))
+	}()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IsCA:         true,
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	require.NoError(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), 0600))
+	require.NoError(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+
+	conf := Config{}
+	tlsConf, err := conf.STCPTLSConfig()
+	require.NoError(t, err)
+	assert.Nil(t, tlsConf)
+
+	conf.TCPTransport.TLS = &TLSConfig{CertFile: certFile, KeyFile: keyFile}
+	tlsConf, err = conf.STCPTLSConfig()
+	require.NoError(t, err)
+	assert.Len(t, tlsConf.Certificates, 1)
+	assert.True(t, tlsConf.InsecureSkipVerify)
+
+	conf.TCPTransport.TLS.RootCAFile = certFile
+	tlsConf, err = conf.STCPTLSConfig()
+	require.NoError(t, err)
+	assert.False(t, tlsConf.InsecureSkipVerify)
+	assert.NotNil(t, tlsConf.RootCAs)
+	assert.Equal(t, tls.RequireAndVerifyClientCert, tlsConf.ClientAuth)
+
+	conf.TCPTransport.TLS.RootCAFile = keyFile
+	_, err = conf.STCPTLSConfig()
+	assert.Error(t, err)
+
+	conf.TCPTransport.TLS = &TLSConfig{CertFile: filepath.Join(dir, "missing.pem"), KeyFile: keyFile}
+	_, err = conf.STCPTLSConfig()
+	assert.Error(t, err)
+}
+
 func TestAppsConfig(t *testing.T) {
 	conf := Config{Version: "1.0"}
 	conf.Apps = []AppConfig{
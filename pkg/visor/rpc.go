@@ -145,6 +145,8 @@ type Summary struct {
 	Apps            []*AppState         `json:"apps"`
 	Transports      []*TransportSummary `json:"transports"`
 	RoutesCount     int                 `json:"routes_count"`
+
+	ReadQueue transport.ReadQueueStats `json:"read_queue"` // queue of packets read from transports
 }
 
 // Summary provides a summary of the AppNode.
@@ -162,6 +164,7 @@ func (r *RPC) Summary(_ *struct{}, out *Summary) error {
 		Apps:            r.node.Apps(),
 		Transports:      summaries,
 		RoutesCount:     r.node.rt.Count(),
+		ReadQueue:       r.node.tm.ReadQueueStats(),
 	}
 	return nil
 }
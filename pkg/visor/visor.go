@@ -74,6 +74,7 @@ type appExecuter interface {
 type appBind struct {
 	conn net.Conn
 	pid  int
+	port routing.Port
 }
 
 // PacketRouter performs routing of the skywire packets.
@@ -82,6 +83,7 @@ type PacketRouter interface {
 	Serve(ctx context.Context) error
 	ServeApp(conn net.Conn, port routing.Port, appConf *app.Config) error
 	SetupIsTrusted(sPK cipher.PubKey) bool
+	AppTraffic() map[routing.Port]router.AppTraffic
 }
 
 // Node provides messaging runtime for Apps by setting up all
@@ -110,6 +112,8 @@ type Node struct {
 
 	rpcListener net.Listener
 	rpcDialers  []*noise.RPCClientDialer
+
+	probeListener *snet.Listener // nil unless the node serves probes
 }
 
 // NewNode constructs new Node.
@@ -128,18 +132,53 @@ func NewNode(config *Config, masterLogger *logging.MasterLogger) (*Node, error)
 	pk := config.Node.StaticPubKey
 	sk := config.Node.StaticSecKey
 
+	stcpTLS, err := config.STCPTLSConfig()
+	if err != nil {
+		return nil, fmt.Errorf("invalid stcp TLS config: %s", err)
+	}
+
 	fmt.Println("min servers:", config.Messaging.ServerCount)
 	node.n = snet.New(snet.Config{
-		PubKey:        pk,
-		SecKey:        sk,
-		TpNetworks:    []string{dmsg.Type, snet.STcpType}, // TODO: Have some way to configure this.
-		DmsgDiscAddr:  config.Messaging.Discovery,
-		DmsgMinSrvs:   config.Messaging.ServerCount,
-		STCPLocalAddr: config.TCPTransport.LocalAddr,
-		STCPTable:     config.TCPTransport.PubKeyTable,
+		PubKey:         pk,
+		SecKey:         sk,
+		TpNetworks:     []string{dmsg.Type, snet.STcpType}, // TODO: Have some way to configure this.
+		DmsgDiscAddrs:  config.Messaging.Discovery,
+		DmsgMinSrvs:    config.Messaging.ServerCount,
+		STCPLocalAddrs: config.TCPTransport.LocalAddr,
+		STCPTable:      config.TCPTransport.PubKeyTable,
+		STCPKeepAlive:  time.Duration(config.TCPTransport.KeepAlive),
+		STCPTLSConfig:  stcpTLS,
+		STCPReuseAddr:  config.TCPTransport.ReuseAddr,
+
+		STCPResolveAttempts: config.TCPTransport.ResolveAttempts,
+		STCPResolveTimeout:  time.Duration(config.TCPTransport.ResolveTimeout),
+
+		DmsgInitAttempts: config.Messaging.InitAttempts,
+		DmsgInitTimeout:  time.Duration(config.Messaging.InitTimeout),
+
+		DmsgDiscCacheTTL:  time.Duration(config.Messaging.DiscCacheTTL),
+		DmsgDiscCacheSize: config.Messaging.DiscCacheSize,
+
+		EnablePacketTTL:  config.Routing.EnablePacketTTL,
+		EnablePacketSeq:  config.Routing.EnablePacketSeq,
+		EnablePacketType: config.Routing.EnablePacketType,
+		MaxPayloadSize:   config.Routing.MaxPayloadSize,
+
+		Logger: masterLogger,
 	})
 	if err := node.n.Init(ctx); err != nil {
-		return nil, fmt.Errorf("failed to init network: %v", err)
+		// The visor can run without stcp, but not without dmsg.
+		initErr, ok := err.(*snet.InitError)
+		if !ok || initErr.Errs[snet.DmsgType] != nil {
+			return nil, fmt.Errorf("failed to init network: %v", err)
+		}
+		node.logger.Warnf("Failed to init some network types: %v", err)
+	}
+	if config.Messaging.ServeProbes {
+		probeConf := snet.ProbeConfig{MaxConcurrent: config.Messaging.MaxProbes, Allowed: config.Messaging.ProbeAllow}
+		if node.probeListener, err = node.n.ServeProbes(snet.DmsgType, probeConf); err != nil {
+			return nil, fmt.Errorf("failed to serve probes: %v", err)
+		}
 	}
 
 	trDiscovery, err := config.TransportDiscovery()
@@ -156,6 +195,10 @@ func NewNode(config *Config, masterLogger *logging.MasterLogger) (*Node, error)
 		DefaultNodes:    config.TrustedNodes,
 		DiscoveryClient: trDiscovery,
 		LogStore:        logStore,
+		AcceptTimeout:   time.Duration(config.Transport.AcceptTimeout),
+		MaxHalfOpen:     config.Transport.MaxHalfOpen,
+		ReadQueueSize:   config.Transport.ReadQueueSize,
+		ReadOverflow:    config.Transport.ReadOverflow,
 	}
 	node.tm, err = transport.NewManager(node.n, tmConfig)
 	if err != nil {
@@ -167,13 +210,25 @@ func NewNode(config *Config, masterLogger *logging.MasterLogger) (*Node, error)
 		return nil, fmt.Errorf("routing table: %s", err)
 	}
 	rConfig := &router.Config{
-		Logger:           node.Logger.PackageLogger("router"),
-		PubKey:           pk,
-		SecKey:           sk,
-		TransportManager: node.tm,
-		RoutingTable:     node.rt,
-		RouteFinder:      routeFinder.NewHTTP(config.Routing.RouteFinder, time.Duration(config.Routing.RouteFinderTimeout)),
-		SetupNodes:       config.Routing.SetupNodes,
+		Logger:             node.Logger.PackageLogger("router"),
+		PubKey:             pk,
+		SecKey:             sk,
+		TransportManager:   node.tm,
+		RoutingTable:       node.rt,
+		RouteFinder:        routeFinder.NewHTTP(config.Routing.RouteFinder, time.Duration(config.Routing.RouteFinderTimeout)),
+		SetupNodes:         config.Routing.SetupNodes,
+		FallbackSetupNodes: config.Routing.FallbackSetupNodes,
+		FirstHops:          config.Routing.FirstHops,
+		SetupTimeout:       time.Duration(config.Routing.SetupTimeout),
+		CloseTimeout:       time.Duration(config.Routing.CloseTimeout),
+		PacketTTL:          config.Routing.PacketTTL,
+
+		TransportPreference: config.Routing.TransportPreference,
+		SourceGuard:         config.Routing.SourceGuard,
+		DedupWindow:         config.Routing.DedupWindow,
+
+		TransportFailureThreshold: config.Routing.TransportFailureThreshold,
+		TransportFailureCooldown:  time.Duration(config.Routing.TransportFailureCooldown),
 	}
 	r, err := router.New(node.n, rConfig)
 	if err != nil {
@@ -341,6 +396,13 @@ func (node *Node) Close() (err error) {
 			node.logger.Infof("(%d) RPC dialer closed successfully", i)
 		}
 	}
+	if node.probeListener != nil {
+		if err = node.probeListener.Close(); err != nil {
+			node.logger.WithError(err).Error("failed to stop serving probes")
+		} else {
+			node.logger.Info("probes stopped successfully")
+		}
+	}
 	node.startedMu.Lock()
 	for a, bind := range node.startedApps {
 		if err = node.stopApp(a, bind); err != nil {
@@ -382,6 +444,23 @@ func (node *Node) Apps() []*AppState {
 	return res
 }
 
+// AppTraffic returns the loop payload exchanged by each running app, keyed by the app's PID.
+// The traffic is counted by the router, apps which have not been started by the executer yet are omitted.
+func (node *Node) AppTraffic() map[int]router.AppTraffic {
+	traffic := node.router.AppTraffic()
+
+	node.startedMu.RLock()
+	defer node.startedMu.RUnlock()
+
+	res := make(map[int]router.AppTraffic, len(node.startedApps))
+	for _, bind := range node.startedApps {
+		if t, ok := traffic[bind.port]; ok && bind.pid > 0 {
+			res[bind.pid] = t
+		}
+	}
+	return res
+}
+
 // StartApp starts registered App.
 func (node *Node) StartApp(appName string) error {
 	for _, app := range node.appsConf {
@@ -414,7 +493,7 @@ func (node *Node) SpawnApp(config *AppConfig, startCh chan<- struct{}) (err erro
 		return fmt.Errorf("failed to initialize App server: %s", err)
 	}
 
-	bind := &appBind{conn, -1}
+	bind := &appBind{conn, -1, config.Port}
 	if app, ok := reservedPorts[config.Port]; ok && app != config.App {
 		return fmt.Errorf("can't bind to reserved port %d", config.Port)
 	}
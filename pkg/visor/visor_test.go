@@ -19,6 +19,7 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/SkycoinProject/skywire-mainnet/pkg/app"
+	"github.com/SkycoinProject/skywire-mainnet/pkg/router"
 	"github.com/SkycoinProject/skywire-mainnet/pkg/routing"
 	"github.com/SkycoinProject/skywire-mainnet/pkg/snet"
 	"github.com/SkycoinProject/skywire-mainnet/pkg/transport"
@@ -93,11 +94,11 @@ func TestNodeStartClose(t *testing.T) {
 
 	dmsgC := dmsg.NewClient(cipher.PubKey{}, cipher.SecKey{}, disc.NewMock())
 	netConf := snet.Config{
-		PubKey:       cipher.PubKey{},
-		SecKey:       cipher.SecKey{},
-		TpNetworks:   nil,
-		DmsgDiscAddr: "",
-		DmsgMinSrvs:  0,
+		PubKey:        cipher.PubKey{},
+		SecKey:        cipher.SecKey{},
+		TpNetworks:    nil,
+		DmsgDiscAddrs: nil,
+		DmsgMinSrvs:   0,
 	}
 
 	network := snet.NewRaw(netConf, dmsgC, nil)
@@ -169,7 +170,7 @@ func TestNodeSpawnAppValidations(t *testing.T) {
 	c := &Config{}
 	c.Node.StaticPubKey = pk
 	node := &Node{router: r, executer: executer,
-		startedApps: map[string]*appBind{"skychat": {conn, 10}},
+		startedApps: map[string]*appBind{"skychat": {conn, 10, 0}},
 		logger:      logging.MustGetLogger("test"),
 		config:      c,
 	}
@@ -201,6 +202,25 @@ func TestNodeSpawnAppValidations(t *testing.T) {
 	}
 }
 
+func TestNodeAppTraffic(t *testing.T) {
+	r := &mockRouter{traffic: map[routing.Port]router.AppTraffic{
+		10: {Sent: 1, Received: 2},
+		11: {Sent: 3, Received: 4},
+		12: {Sent: 5, Received: 6},
+	}}
+	node := &Node{router: r, startedApps: map[string]*appBind{
+		"foo": {pid: 100, port: 10},
+		"bar": {pid: 101, port: 11},
+		"baz": {pid: -1, port: 12},  // not started by the executer yet
+		"qux": {pid: 102, port: 13}, // not served by the router
+	}}
+
+	assert.Equal(t, map[int]router.AppTraffic{
+		100: {Sent: 1, Received: 2},
+		101: {Sent: 3, Received: 4},
+	}, node.AppTraffic())
+}
+
 type MockExecuter struct {
 	sync.Mutex
 	err    error
@@ -251,7 +271,8 @@ func (exc *MockExecuter) Wait(cmd *exec.Cmd) error {
 type mockRouter struct {
 	sync.Mutex
 
-	ports []routing.Port
+	ports   []routing.Port
+	traffic map[routing.Port]router.AppTraffic
 
 	didStart bool
 	didClose bool
@@ -309,3 +330,7 @@ func (r *mockRouter) IsSetupTransport(*transport.ManagedTransport) bool {
 func (r *mockRouter) SetupIsTrusted(cipher.PubKey) bool {
 	return true
 }
+
+func (r *mockRouter) AppTraffic() map[routing.Port]router.AppTraffic {
+	return r.traffic
+}